@@ -2,19 +2,26 @@ package main
 
 import (
 	"context"
+	"crypto"
+	"crypto/tls"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/robohub/auth-service/internal/config"
 	"github.com/robohub/auth-service/internal/httpapi"
+	"github.com/robohub/auth-service/internal/kms"
 	"github.com/robohub/auth-service/internal/oidc"
 	"github.com/robohub/auth-service/internal/policy"
 	"github.com/robohub/auth-service/internal/ratelimit"
+	"github.com/robohub/auth-service/internal/replay"
+	"github.com/robohub/auth-service/internal/secrets"
 	"github.com/robohub/auth-service/internal/token"
 )
 
@@ -27,9 +34,9 @@ func main() {
 
 func run() error {
 	// Setup logger
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+	logger := slog.New(httpapi.NewLogHandler(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		Level: slog.LevelInfo,
-	}))
+	})))
 	slog.SetDefault(logger)
 
 	logger.Info("starting robohub-auth service")
@@ -42,36 +49,297 @@ func run() error {
 
 	logger.Info("configuration loaded",
 		"port", cfg.Port,
-		"oidc_issuer", cfg.OIDCIssuer,
-		"oidc_audience", cfg.OIDCAudience,
+		"oidc_providers", providerNames(cfg.Providers),
+		"oidc_max_token_age", cfg.MaxTokenAge,
+		"oidc_discovery", cfg.OIDCDiscovery,
 		"default_branch_only", cfg.DefaultBranchOnly,
-		"default_branch", cfg.DefaultBranch,
+		"allowed_branches", cfg.AllowedBranches,
 		"token_ttl", cfg.TokenTTL,
 		"rate_limit_rps", cfg.RateLimitRPS,
 		"rate_limit_burst", cfg.RateLimitBurst,
 	)
 
+	var jwksTLS *tls.Config
+	if cfg.JWKSRootCAs != nil || cfg.JWKSInsecureSkipVerify {
+		jwksTLS = &tls.Config{RootCAs: cfg.JWKSRootCAs, InsecureSkipVerify: cfg.JWKSInsecureSkipVerify}
+	}
+	if cfg.JWKSInsecureSkipVerify {
+		logger.Warn("TLS certificate verification is DISABLED for JWKS and discovery requests; " +
+			"anyone able to intercept them can forge OIDC tokens. Unset ROBOHUB_JWKS_INSECURE_SKIP_VERIFY outside of testing")
+	}
+
 	// Initialize components
-	verifier := oidc.NewGitHubVerifier(
-		cfg.OIDCIssuer,
-		cfg.OIDCAudience,
-		cfg.ClockSkew,
-		time.Duration(cfg.JWKSTTLSeconds)*time.Second,
-	)
+	verifiers := oidc.NewVerifierRegistry()
+	for _, provider := range cfg.Providers {
+		verifierOpts := []oidc.Option{
+			oidc.WithLogger(logger.With("provider", provider.Name)),
+			oidc.WithMaxTokenAge(cfg.MaxTokenAge),
+			oidc.WithMaxTokenLifetime(provider.MaxTokenLifetime),
+			oidc.WithRequiredClaims(provider.RequiredClaims...),
+			oidc.WithSigningAlgorithms(cfg.SigningAlgorithms...),
+			oidc.WithMaxTokenSize(cfg.MaxOIDCTokenBytes),
+			oidc.WithJWKSRetries(cfg.JWKSRetries),
+			oidc.WithJWKSMaxStale(cfg.JWKSMaxStale),
+		}
+		if cfg.HTTPProxy != nil {
+			verifierOpts = append(verifierOpts, oidc.WithProxy(cfg.HTTPProxy))
+		}
+		if jwksTLS != nil {
+			verifierOpts = append(verifierOpts, oidc.WithTLSConfig(jwksTLS))
+		}
+		if cfg.OIDCDiscovery {
+			verifierOpts = append(verifierOpts, oidc.WithDiscovery(time.Duration(cfg.OIDCDiscoveryTTLSeconds)*time.Second))
+		}
+		verifiers.Register(provider.Name, newVerifier(cfg, provider, verifierOpts))
+	}
 
-	policyEnforcer := policy.NewEnforcer(
-		cfg.DefaultBranchOnly,
-		cfg.DefaultBranch,
-		cfg.RepoAllowList,
-		cfg.RepoDenyList,
-	)
+	discoveryCtx, cancelDiscovery := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := verifiers.Discover(discoveryCtx); err != nil {
+		logger.Warn("OIDC discovery failed at startup, will retry on first verification", "error", err)
+	}
+	cancelDiscovery()
 
-	limiter := ratelimit.NewLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst)
+	// Fetch keys before serving so the first exchange doesn't pay for it;
+	// /readyz holds off until they are loaded
+	if cfg.JWKSWarmup {
+		warmupCtx, cancelWarmup := context.WithTimeout(context.Background(), cfg.JWKSWarmupTimeout)
+		if err := verifiers.Warm(warmupCtx); err != nil {
+			logger.Warn("JWKS warm-up failed, not ready until keys are loaded", "error", err)
+		}
+		cancelWarmup()
+	}
+
+	// Refresh JWKS keys ahead of expiry so requests don't pay for the fetch
+	verifiers.Start()
+	defer verifiers.Stop()
+
+	policyOpts := []policy.Option{
+		policy.WithAllowedBranches(cfg.AllowedBranches),
+		policy.WithRefPatterns(cfg.RefPatterns),
+		policy.WithTagPatterns(cfg.TagPatterns),
+		policy.WithAllowedRefTypes(cfg.AllowedRefTypes),
+		policy.WithOwnerAllowlist(cfg.OwnerAllowList),
+		policy.WithOwnerDenylist(cfg.OwnerDenyList),
+		policy.WithRepoListFiles(cfg.RepoAllowListFile, cfg.RepoDenyListFile),
+		policy.WithDenyBots(cfg.DenyBots),
+		policy.WithAllowedBots(cfg.AllowedBots),
+		policy.WithRequireSHA(cfg.RequireSHA),
+		policy.WithHostedRunnersOnly(cfg.HostedRunnerRepos),
+		policy.WithRequireHostedRunners(cfg.RequireHostedRunners),
+		policy.WithDenyMissingRunnerEnvironment(cfg.DenyMissingRunnerEnv),
+		policy.WithEnterpriseAllowlist(cfg.EnterpriseAllowList),
+		policy.WithAllowedVisibilities(cfg.AllowedVisibilities),
+		policy.WithDenyMissingVisibility(cfg.DenyMissingVisibility),
+		policy.WithWorkflowAllowlist(cfg.WorkflowAllowList),
+		policy.WithWorkflowPins(cfg.WorkflowPins),
+		policy.WithRequiredEnvironments(cfg.RequiredEnvironments),
+		policy.WithEventRule(policy.EventRule{Allow: cfg.AllowedEvents, Deny: cfg.DeniedEvents}),
+		policy.WithRepoEventRules(repoEventRules(cfg.RepoEventRules)),
+		policy.WithDenyMissingEventName(cfg.DenyMissingEventName),
+		policy.WithDenyForkPRs(cfg.DenyForkPRs),
+		policy.WithRepoScopes(cfg.RepoScopes),
+		policy.WithDefaultScopes(cfg.DefaultScopes),
+		policy.WithReducedScopes(cfg.ReducedScopes),
+		policy.WithRepoTTLs(cfg.RepoTokenTTLs),
+		policy.WithMaxTokenTTL(cfg.MaxTokenTTL),
+		policy.WithDryRun(cfg.PolicyDryRun),
+		policy.WithLogger(logger),
+	}
+	if cfg.PolicyDryRun {
+		logger.Warn("policy dry run enabled, denials are logged but not enforced")
+	}
+	if cfg.OPAURL != "" {
+		logger.Info("using OPA policy decisions", "url", cfg.OPAURL, "timeout", cfg.OPATimeout, "fail_open", cfg.OPAFailOpen)
+		policyOpts = append(policyOpts, policy.WithOPA(policy.NewOPAClient(cfg.OPAURL, cfg.OPATimeout), cfg.OPAFailOpen))
+	}
+	// The checks share one client, so a repository is looked up once for both
+	github := policy.NewGitHubClient(cfg.GitHubAPIURL, cfg.GitHubAPIToken, cfg.GitHubAPITimeout, cfg.GitHubAPICacheTTL)
+	if cfg.DenyArchivedRepos {
+		logger.Info("denying archived repositories", "api_url", cfg.GitHubAPIURL, "cache_ttl", cfg.GitHubAPICacheTTL, "fail_open", cfg.GitHubAPIFailOpen)
+		policyOpts = append(policyOpts, policy.WithArchivedCheck(github, cfg.GitHubAPIFailOpen))
+	}
+	if cfg.DefaultBranchLookup {
+		logger.Info("looking up default branches", "api_url", cfg.GitHubAPIURL, "cache_ttl", cfg.GitHubAPICacheTTL)
+		policyOpts = append(policyOpts, policy.WithDefaultBranchLookup(github))
+	}
+	// The policy file is applied last so it wins over the environment
+	if cfg.PolicyFile != "" {
+		policyFile, err := policy.LoadFile(cfg.PolicyFile)
+		if err != nil {
+			return err
+		}
+		logger.Info("loaded policy file", "path", cfg.PolicyFile, "rules", len(policyFile.Rules), "hash", policyFile.Hash)
+		policyOpts = append(policyOpts, policy.WithFile(policyFile))
+	}
+	policyOpts = append(policyOpts, policy.WithPolicyVersion(cfg.PolicyVersion))
+	policyEnforcer := policy.NewEnforcer(cfg.DefaultBranchOnly, cfg.DefaultBranch, cfg.RepoAllowList, cfg.RepoDenyList, policyOpts...)
+	logger.Info("policy version", "version", policyEnforcer.Status().PolicyVersion)
+
+	repoListFiles := cfg.RepoAllowListFile != "" || cfg.RepoDenyListFile != ""
+	if repoListFiles {
+		lists, err := policyEnforcer.ReloadRepoLists()
+		if err != nil {
+			return err
+		}
+		logger.Info("loaded repository lists", "allowlist_file", cfg.RepoAllowListFile, "allowlist", len(lists.Allow),
+			"denylist_file", cfg.RepoDenyListFile, "denylist", len(lists.Deny))
+		if cfg.RepoListReloadInterval > 0 {
+			watchCtx, stopWatching := context.WithCancel(context.Background())
+			defer stopWatching()
+			go policyEnforcer.WatchRepoLists(watchCtx, cfg.RepoListReloadInterval)
+		}
+	}
+
+	// Read the signing secret or key from Vault, refusing to start without it
+	var vault *secrets.Vault
+	if cfg.Vault != nil {
+		vault = secrets.NewVault(secrets.VaultConfig{
+			Address:             cfg.Vault.Address,
+			Path:                cfg.Vault.Path,
+			Field:               cfg.Vault.Field,
+			Namespace:           cfg.Vault.Namespace,
+			Token:               cfg.Vault.Token,
+			KubernetesRole:      cfg.Vault.KubernetesRole,
+			KubernetesMount:     cfg.Vault.KubernetesMount,
+			KubernetesTokenFile: cfg.Vault.KubernetesTokenFile,
+		})
+		fetchCtx, cancelFetch := context.WithTimeout(context.Background(), cfg.Vault.Timeout)
+		value, err := vault.Fetch(fetchCtx)
+		cancelFetch()
+		if err != nil {
+			return fmt.Errorf("failed to read signing key from Vault: %w", err)
+		}
+		if cfg.JWTSigningAlgorithm == "HS256" {
+			cfg.JWTSecret = strings.TrimSpace(string(value))
+		} else if cfg.JWTPrivateKey, err = config.ParsePrivateKey(value, cfg.JWTSigningAlgorithm); err != nil {
+			return fmt.Errorf("invalid signing key in Vault secret %s: %w", cfg.Vault.Path, err)
+		}
+		logger.Info("read signing key from Vault", "address", cfg.Vault.Address, "path", cfg.Vault.Path,
+			"auth_method", cfg.Vault.AuthMethod)
+	}
+
+	// Sign with a Cloud KMS key, whose public key is fetched once here
+	if cfg.GCPKMSKey != "" {
+		kmsCtx, cancelKMS := context.WithTimeout(context.Background(), cfg.GCPKMSTimeout)
+		signer, err := kms.NewGCPSigner(kmsCtx, kms.NewGCPClient(), cfg.GCPKMSKey, cfg.GCPKMSTimeout)
+		cancelKMS()
+		if err != nil {
+			return err
+		}
+		if method := token.SigningMethod(signer); method == nil || method.Alg() != cfg.JWTSigningAlgorithm {
+			return fmt.Errorf("Cloud KMS key %s does not suit ROBOHUB_JWT_SIGNING_ALGORITHM %s", cfg.GCPKMSKey, cfg.JWTSigningAlgorithm)
+		}
+		cfg.JWTPrivateKey = signer
+	}
+
+	// Replaced keys validate tokens for as long as the policy can grant them
+	minterOpts := []token.Option{
+		token.WithKeyRetention(max(cfg.TokenTTL, cfg.MaxTokenTTL)),
+		token.WithIssuer(cfg.TokenIssuer),
+		token.WithAudience(cfg.TokenAudience),
+		token.WithDefaultScopes(cfg.DefaultScopes...),
+	}
+	if cfg.JWTPrivateKey != nil {
+		minterOpts = append(minterOpts, token.WithSigningKey(cfg.JWTPrivateKey, cfg.JWTKeyID))
+		kid := cfg.JWTKeyID
+		if kid == "" {
+			kid = token.KeyID(cfg.JWTPrivateKey.Public())
+		}
+		logger.Info("signing access tokens", "algorithm", cfg.JWTSigningAlgorithm, "kid", kid)
+	}
+	minter := token.NewMinter(cfg.JWTSecret, cfg.TokenTTL, minterOpts...)
+	var signingKeySource func() (crypto.Signer, error)
+	if cfg.JWTPrivateKeyFile != "" {
+		signingKeySource = func() (crypto.Signer, error) {
+			return config.LoadPrivateKeyFile(cfg.JWTPrivateKeyFile, cfg.JWTSigningAlgorithm)
+		}
+	}
+
+	rotateSecret := cfg.JWTSecretFile != "" && cfg.JWTPrivateKey == nil
+
+	// Read Vault again to pick up rotations there
+	var vaultWatcher *secrets.Watcher
+	if vault != nil && cfg.Vault.Refresh > 0 {
+		vaultWatcher = secrets.NewWatcher(vault, func(value []byte) error {
+			return applySigningMaterial(logger, minter, cfg.JWTSigningAlgorithm, value)
+		}, cfg.Vault.Timeout, logger)
+		watchCtx, stopWatching := context.WithCancel(context.Background())
+		defer stopWatching()
+		go vaultWatcher.Run(watchCtx, cfg.Vault.Refresh)
+	}
+
+	// Reload the policy file and repository lists and rotate to a new
+	// signing key or secret on SIGHUP, keeping the current ones when the new
+	// files are broken
+	if cfg.PolicyFile != "" || repoListFiles || signingKeySource != nil || rotateSecret {
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, syscall.SIGHUP)
+		defer signal.Stop(reload)
+		go func() {
+			for range reload {
+				if signingKeySource != nil {
+					rotateSigningKey(logger, minter, signingKeySource)
+				}
+				if rotateSecret {
+					rotateJWTSecret(logger, minter, cfg.JWTSecretFile)
+				}
+				if repoListFiles {
+					if lists, err := policyEnforcer.ReloadRepoLists(); err != nil {
+						logger.Error("failed to reload repository lists, keeping the current lists", "error", err)
+					} else {
+						logger.Info("reloaded repository lists", "allowlist", len(lists.Allow), "denylist", len(lists.Deny))
+					}
+				}
+				if cfg.PolicyFile == "" {
+					continue
+				}
+				policyFile, err := policyEnforcer.Reload(cfg.PolicyFile)
+				if err != nil {
+					logger.Error("failed to reload policy file, keeping the current policy",
+						"path", cfg.PolicyFile, "hash", policyEnforcer.Status().Hash, "error", err)
+					continue
+				}
+				logger.Info("reloaded policy file", "path", cfg.PolicyFile, "rules", len(policyFile.Rules), "hash", policyFile.Hash,
+					"version", policyEnforcer.Status().PolicyVersion)
+			}
+		}()
+	}
+
+	auditSink, err := openAuditLog(cfg.AuditLog)
+	if err != nil {
+		return err
+	}
+	serverOpts := []httpapi.Option{
+		httpapi.WithRequireKeys(cfg.JWKSWarmup),
+		httpapi.WithAdminToken(cfg.AdminToken),
+		httpapi.WithDropUnpermittedScopes(cfg.DropUnpermittedScopes),
+	}
+	if signingKeySource != nil {
+		serverOpts = append(serverOpts, httpapi.WithSigningKeySource(signingKeySource))
+	}
+	if vaultWatcher != nil {
+		serverOpts = append(serverOpts, httpapi.WithSecretWatcher(vaultWatcher))
+	}
+	if cfg.SingleUseOIDCTokens {
+		// Remember tokens as long as any verifier would still accept them
+		leeway := cfg.ClockSkew
+		for _, ghes := range cfg.GitHubEnterpriseIssuers {
+			leeway = max(leeway, time.Duration(ghes.ClockSkewSeconds)*time.Second)
+		}
+		serverOpts = append(serverOpts, httpapi.WithReplayStore(replay.NewMemoryStore(), leeway))
+	}
+	if auditSink != nil {
+		defer auditSink.Close()
+		auditLogger := slog.New(httpapi.NewLogHandler(slog.NewJSONHandler(auditSink, nil))).With("log", "audit")
+		serverOpts = append(serverOpts, httpapi.WithAuditLogger(auditLogger))
+	} else {
+		logger.Warn("policy decision audit log disabled")
+	}
 
-	minter := token.NewMinter(cfg.JWTSecret, cfg.TokenTTL)
+	limiter := ratelimit.NewLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst)
 
 	// Create HTTP server
-	apiServer := httpapi.NewServer(logger, verifier, policyEnforcer, limiter, minter)
+	apiServer := httpapi.NewServer(logger, verifiers, policyEnforcer, limiter, minter, serverOpts...)
 
 	server := &http.Server{
 		Addr:         ":" + cfg.Port,
@@ -115,3 +383,152 @@ func run() error {
 
 	return nil
 }
+
+// newVerifier creates the verifier for a configured provider, falling back to
+// the provider's default issuer when none is configured. The github provider
+// also trusts any configured GitHub Enterprise Server issuers.
+func newVerifier(cfg *config.Config, provider config.ProviderConfig, opts []oidc.Option) oidc.Verifier {
+	jwksTTL := time.Duration(provider.JWKSTTLSeconds) * time.Second
+	if provider.JWKSURL != "" && provider.Name != "github" {
+		opts = append(opts, oidc.WithJWKSURL(provider.JWKSURL))
+	}
+	issuer := func(defaultIssuer string) string {
+		if provider.Issuer != "" {
+			return provider.Issuer
+		}
+		return defaultIssuer
+	}
+
+	switch provider.Name {
+	case "bitbucket":
+		return oidc.NewBitbucketVerifier(issuer(oidc.BitbucketIssuer(cfg.BitbucketWorkspace)), provider.Audiences[0], cfg.ClockSkew, jwksTTL, opts...)
+	case "azure_devops":
+		return oidc.NewAzureDevOpsVerifier(issuer(oidc.AzureDevOpsIssuer(cfg.AzureDevOpsOrganizationID)), provider.Audiences[0], cfg.ClockSkew, jwksTTL, opts...)
+	case "google":
+		return oidc.NewGoogleVerifier(issuer(oidc.GoogleIssuer), provider.Audiences[0], cfg.GoogleServiceAccounts, cfg.GoogleRepository, cfg.ClockSkew, jwksTTL, opts...)
+	case "buildkite":
+		return oidc.NewBuildkiteVerifier(issuer(oidc.BuildkiteIssuer), provider.Audiences[0], cfg.ClockSkew, jwksTTL, opts...)
+	default:
+		issuers := []oidc.GitHubIssuer{{Issuer: provider.Issuer, Audiences: provider.Audiences, JWKSURL: provider.JWKSURL}}
+		for _, ghes := range cfg.GitHubEnterpriseIssuers {
+			audiences := provider.Audiences
+			if ghes.Audience != "" {
+				audiences = []string{ghes.Audience}
+			}
+			issuers = append(issuers, oidc.GitHubIssuer{
+				Issuer:           ghes.Issuer,
+				Audiences:        audiences,
+				JWKSURL:          ghes.JWKSURL,
+				ClockSkew:        time.Duration(ghes.ClockSkewSeconds) * time.Second,
+				JWKSTTL:          time.Duration(ghes.JWKSTTLSeconds) * time.Second,
+				MaxTokenLifetime: time.Duration(ghes.MaxTokenLifetimeSeconds) * time.Second,
+			})
+		}
+		if len(cfg.GitHubSubjectTemplates) > 0 {
+			opts = append(opts, oidc.WithGitHubSubjectTemplates(cfg.GitHubSubjectTemplates))
+		}
+		return oidc.NewGitHubVerifierForIssuers(issuers, cfg.ClockSkew, jwksTTL, opts...)
+	}
+}
+
+// rotateSigningKey rotates minter to the key source reads, keeping the
+// current key when the new one cannot be read or used
+func rotateSigningKey(logger *slog.Logger, minter *token.Minter, source func() (crypto.Signer, error)) {
+	key, err := source()
+	if err != nil {
+		logger.Error("failed to read signing key, keeping the current key", "error", err)
+		return
+	}
+	rotated, err := minter.Rotate(key, "")
+	if err != nil {
+		logger.Error("failed to rotate signing key, keeping the current key", "error", err)
+		return
+	}
+	if rotated {
+		keys := minter.Keys()
+		logger.Info("rotated signing key", "kid", keys[0].ID, "previous_keys", len(keys)-1)
+	}
+}
+
+// applySigningMaterial rotates minter to the secret or PEM private key read
+// from a secret store
+func applySigningMaterial(logger *slog.Logger, minter *token.Minter, algorithm string, value []byte) error {
+	if algorithm == "HS256" {
+		rotated, err := minter.RotateSecret(strings.TrimSpace(string(value)))
+		if rotated {
+			logger.Info("rotated JWT secret")
+		}
+		return err
+	}
+	key, err := config.ParsePrivateKey(value, algorithm)
+	if err != nil {
+		return err
+	}
+	rotated, err := minter.Rotate(key, "")
+	if rotated {
+		keys := minter.Keys()
+		logger.Info("rotated signing key", "kid", keys[0].ID, "previous_keys", len(keys)-1)
+	}
+	return err
+}
+
+// rotateJWTSecret rotates minter to the secret in the file at name, keeping
+// the current secret when the file cannot be read
+func rotateJWTSecret(logger *slog.Logger, minter *token.Minter, name string) {
+	secret, err := config.LoadSecretFile(name)
+	if err != nil {
+		logger.Error("failed to read JWT secret, keeping the current secret", "error", err)
+		return
+	}
+	rotated, err := minter.RotateSecret(secret)
+	if err != nil {
+		logger.Error("failed to rotate JWT secret, keeping the current secret", "error", err)
+		return
+	}
+	if rotated {
+		logger.Info("rotated JWT secret", "path", name)
+	}
+}
+
+// openAuditLog opens the audit log destination: stdout, stderr, a file
+// path to append to, or nil when the audit log is off
+func openAuditLog(dest string) (io.WriteCloser, error) {
+	switch dest {
+	case "stdout":
+		return nopCloser{os.Stdout}, nil
+	case "stderr":
+		return nopCloser{os.Stderr}, nil
+	case "off":
+		return nil, nil
+	}
+	f, err := os.OpenFile(dest, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return f, nil
+}
+
+// nopCloser keeps the standard streams open when the audit log is closed
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// repoEventRules converts the configured per-repository event rules to
+// policy rules
+func repoEventRules(configured map[string]config.EventRuleConfig) map[string]policy.EventRule {
+	rules := make(map[string]policy.EventRule, len(configured))
+	for repo, rule := range configured {
+		rules[repo] = policy.EventRule{Allow: rule.Allow, Deny: rule.Deny}
+	}
+	return rules
+}
+
+func providerNames(providers []config.ProviderConfig) []string {
+	names := make([]string, 0, len(providers))
+	for _, provider := range providers {
+		names = append(names, provider.Name)
+	}
+	return names
+}
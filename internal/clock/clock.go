@@ -0,0 +1,17 @@
+package clock
+
+import "time"
+
+// Clock abstracts the current time so time-dependent logic can be tested
+// without sleeping
+type Clock interface {
+	Now() time.Time
+}
+
+// Real is a Clock backed by the system time
+type Real struct{}
+
+// Now returns the current system time
+func (Real) Now() time.Time {
+	return time.Now()
+}
@@ -1,8 +1,22 @@
 package config
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"net/url"
 	"os"
+	"path"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -16,17 +30,207 @@ type Config struct {
 	// JWT Secret for signing RoboHub tokens
 	JWTSecret string
 
-	// OIDC Configuration
+	// JWTSecretFile is where JWTSecret was read from, taking precedence over
+	// ROBOHUB_JWT_SECRET. A new secret written there is rotated in on SIGHUP.
+	JWTSecretFile string
+
+	// Algorithm access tokens are signed with: HS256 with JWTSecret, or
+	// RS256, ES256 or EdDSA with JWTPrivateKey, named by JWTKeyID in the kid
+	// header (the key's thumbprint when empty)
+	JWTSigningAlgorithm string
+	JWTPrivateKey       crypto.Signer
+	JWTKeyID            string
+
+	// JWTPrivateKeyFile is where JWTPrivateKey was read from, empty when
+	// it was given inline. A new key written there is rotated in on
+	// SIGHUP or by the admin endpoint.
+	JWTPrivateKeyFile string
+
+	// Vault holds the JWT secret or private key instead of the environment
+	// when set, leaving JWTSecret and JWTPrivateKey to be read from it
+	Vault *VaultConfig
+
+	// GCPKMSKey is the Cloud KMS key version that signs access tokens in
+	// place of JWTPrivateKey, allowing each signature GCPKMSTimeout
+	GCPKMSKey     string
+	GCPKMSTimeout time.Duration
+
+	// OIDC Configuration. OIDCIssuer is the GitHub issuer and OIDCAudiences
+	// the audiences for providers that do not override them.
+	Providers      []ProviderConfig
 	OIDCIssuer     string
-	OIDCAudience   string
+	OIDCAudiences  []string
 	ClockSkew      time.Duration
 	JWKSTTLSeconds int
+	JWKSRetries    int
+	JWKSMaxStale   time.Duration
+	MaxTokenAge    time.Duration
+
+	// Exchange each OIDC token only once, rejecting repeats
+	SingleUseOIDCTokens bool
+
+	// Fetch every provider's keys at startup, waiting up to
+	// JWKSWarmupTimeout, and hold readiness until they are loaded
+	JWKSWarmup        bool
+	JWKSWarmupTimeout time.Duration
+
+	// Maximum exp - iat of GitHub tokens; other providers only check it when
+	// their own override is set
+	MaxTokenLifetime time.Duration
+
+	// Claims GitHub tokens must carry with a non-empty value
+	RequiredClaims []string
+
+	// JWS algorithms accepted in OIDC token headers
+	SigningAlgorithms []string
+
+	// Longest OIDC token, in bytes, the verifiers will decode
+	MaxOIDCTokenBytes int
+
+	// OIDC discovery of the JWKS URL; disable to use issuer + "/.well-known/jwks"
+	OIDCDiscovery           bool
+	OIDCDiscoveryTTLSeconds int
+
+	// Proxy for JWKS and discovery requests, overriding HTTPS_PROXY; nil
+	// uses the environment
+	HTTPProxy *url.URL
+
+	// Extra roots trusted for JWKS and discovery TLS, loaded from a PEM
+	// bundle, and whether to skip certificate verification altogether
+	JWKSRootCAs            *x509.CertPool
+	JWKSInsecureSkipVerify bool
+
+	// Additional GitHub Enterprise Server issuers trusted by the github
+	// provider alongside its primary issuer
+	GitHubEnterpriseIssuers []GitHubIssuerConfig
+
+	// Customized GitHub sub claim templates, such as
+	// repo:context:job_workflow_ref, keyed by owner or owner/repo
+	GitHubSubjectTemplates map[string][]string
+
+	// Bitbucket Pipelines workspace, used to derive the issuer when
+	// OIDCProvider is "bitbucket"
+	BitbucketWorkspace string
+
+	// Azure DevOps organization ID, used to derive the issuer when
+	// OIDCProvider is "azure_devops"
+	AzureDevOpsOrganizationID string
+
+	// Google service accounts whose ID tokens are accepted, and the logical
+	// repository they are attributed to, when OIDCProvider is "google"
+	GoogleServiceAccounts []string
+	GoogleRepository      string
 
 	// Policy Configuration
 	DefaultBranchOnly bool
 	DefaultBranch     string
+	AllowedBranches   []string
+	RefPatterns       []string
+	TagPatterns       []string
+	AllowedRefTypes   []string
 	RepoDenyList      []string
 	RepoAllowList     []string
+	OwnerDenyList     []string
+	OwnerAllowList    []string
+	RequireSHA        bool
+
+	// Newline-delimited files adding to the repository lists, reloaded on
+	// SIGHUP and checked for changes every RepoListReloadInterval
+	RepoAllowListFile      string
+	RepoDenyListFile       string
+	RepoListReloadInterval time.Duration
+
+	// Deny tokens whose actor is a bot, except the listed bots
+	DenyBots    bool
+	AllowedBots []string
+
+	// Repositories whose tokens must come from GitHub-hosted runners, whether
+	// every repository's must, and whether tokens without a
+	// runner_environment claim are denied where it is required
+	HostedRunnerRepos    []string
+	RequireHostedRunners bool
+	DenyMissingRunnerEnv bool
+
+	// GitHub enterprises whose tokens are accepted; empty accepts any
+	// enterprise and tokens without one
+	EnterpriseAllowList []string
+
+	// Workflows, matched against workflow_ref or job_workflow_ref, whose
+	// tokens are accepted; empty accepts any workflow
+	WorkflowAllowList []string
+
+	// Workflow paths, without @ref, each listed repository is restricted to
+	WorkflowPins map[string][]string
+
+	// GitHub environments tokens must come from, keyed by repository glob
+	RequiredEnvironments map[string][]string
+
+	// Events tokens may be issued for, globally and per repository, and
+	// whether tokens without an event_name are denied when a rule applies
+	AllowedEvents        []string
+	DeniedEvents         []string
+	RepoEventRules       map[string]EventRuleConfig
+	DenyMissingEventName bool
+
+	// Repository visibilities tokens must come from, and whether tokens
+	// without a repository_visibility claim are denied
+	AllowedVisibilities   []string
+	DenyMissingVisibility bool
+
+	// Deny tokens that may come from pull requests from forks
+	DenyForkPRs bool
+
+	// Scopes granted to minted tokens, by repository glob and by default
+	RepoScopes    map[string][]string
+	DefaultScopes []string
+
+	// Scopes tokens from branches the branch check would deny keep instead
+	// of being denied
+	ReducedScopes []string
+
+	// Drop scopes a client requests beyond those allowed instead of denying
+	// the exchange
+	DropUnpermittedScopes bool
+
+	// Log and report policy denials instead of enforcing them
+	PolicyDryRun bool
+
+	// JSON policy file with per-repository rules, overriding the policy
+	// settings above where they overlap
+	PolicyFile string
+
+	// PolicyVersion identifies the policy the settings make up in
+	// decisions, audit events and minted tokens while no policy file is
+	// loaded. It defaults to a hash of the ROBOHUB_ settings other than
+	// secrets, so that it changes with them.
+	PolicyVersion string
+
+	// OPA decision endpoint that replaces the built-in policy, how long each
+	// decision may take, and whether to fall back to the built-in policy
+	// when OPA cannot decide
+	OPAURL      string
+	OPATimeout  time.Duration
+	OPAFailOpen bool
+
+	// Deny archived repositories, and look up each repository's default
+	// branch, in the GitHub REST API with GitHubAPIToken. Each lookup may
+	// take GitHubAPITimeout and is cached for GitHubAPICacheTTL;
+	// GitHubAPIFailOpen skips the archived check when the API cannot be
+	// reached.
+	DenyArchivedRepos   bool
+	DefaultBranchLookup bool
+	GitHubAPIURL        string
+	GitHubAPIToken      string
+	GitHubAPITimeout    time.Duration
+	GitHubAPICacheTTL   time.Duration
+	GitHubAPIFailOpen   bool
+
+	// Where policy decision audit events are written: stdout, stderr, off
+	// or a file path
+	AuditLog string
+
+	// Bearer token for the admin endpoints; empty disables them
+	AdminToken string
 
 	// Rate Limiting
 	RateLimitRPS   float64
@@ -34,34 +238,768 @@ type Config struct {
 
 	// Token Configuration
 	TokenTTL time.Duration
+
+	// iss and aud claims of minted tokens, which /auth/validate requires
+	TokenIssuer   string
+	TokenAudience string
+
+	// Token lifetimes by repository glob, and the cap on lifetimes granted
+	// by policy
+	RepoTokenTTLs map[string]time.Duration
+	MaxTokenTTL   time.Duration
+}
+
+// ProviderConfig configures one trusted CI provider. Empty fields fall back
+// to the provider's defaults.
+type ProviderConfig struct {
+	// Name is the provider clients select in exchange requests
+	Name string
+
+	// Issuer overrides the provider's default issuer
+	Issuer string
+
+	// Audiences are accepted interchangeably; only the github provider
+	// supports more than one
+	Audiences      []string
+	JWKSURL        string
+	JWKSTTLSeconds int
+
+	// MaxTokenLifetime bounds exp - iat; zero disables the check
+	MaxTokenLifetime time.Duration
+
+	// RequiredClaims must be present and non-empty in every token
+	RequiredClaims []string
+}
+
+// GitHubIssuerConfig is a trusted GitHub Enterprise Server issuer. An empty
+// audience falls back to the github provider's audience, and zero durations
+// to the github provider's settings.
+type GitHubIssuerConfig struct {
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+	JWKSURL  string `json:"jwks_url"`
+
+	ClockSkewSeconds        int `json:"clock_skew_seconds"`
+	JWKSTTLSeconds          int `json:"jwks_ttl_seconds"`
+	MaxTokenLifetimeSeconds int `json:"max_token_lifetime_seconds"`
+}
+
+// EventRuleConfig allows or denies the events of one repository, replacing
+// the global event lists for it
+type EventRuleConfig struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// knownProviders are the provider names with a verifier implementation
+var knownProviders = map[string]bool{
+	"github":       true,
+	"bitbucket":    true,
+	"buildkite":    true,
+	"azure_devops": true,
+	"google":       true,
+}
+
+// supportedSigningAlgorithms are the algorithms whose keys the JWKS cache can
+// parse
+var supportedSigningAlgorithms = map[string]bool{
+	"RS256": true,
+	"RS384": true,
+	"RS512": true,
+	"ES256": true,
+	"ES384": true,
 }
 
 // LoadFromEnv loads configuration from environment variables
 func LoadFromEnv() (*Config, error) {
 	cfg := &Config{
-		Port:              getEnv("PORT", "8080"),
-		JWTSecret:         os.Getenv("ROBOHUB_JWT_SECRET"),
-		OIDCIssuer:        getEnv("ROBOHUB_OIDC_ISSUER", "https://token.actions.githubusercontent.com"),
-		OIDCAudience:      getEnv("ROBOHUB_OIDC_AUDIENCE", "robohub"),
-		ClockSkew:         time.Duration(getEnvInt("ROBOHUB_CLOCK_SKEW_SECONDS", 60)) * time.Second,
-		JWKSTTLSeconds:    getEnvInt("ROBOHUB_JWKS_TTL_SECONDS", 3600),
-		DefaultBranchOnly: getEnvBool("ROBOHUB_DEFAULT_BRANCH_ONLY", false),
-		DefaultBranch:     getEnv("ROBOHUB_DEFAULT_BRANCH", "main"),
-		RepoDenyList:      parseCommaSeparated(getEnv("ROBOHUB_REPO_DENYLIST", "")),
-		RepoAllowList:     parseCommaSeparated(getEnv("ROBOHUB_REPO_ALLOWLIST", "")),
-		RateLimitRPS:      getEnvFloat("ROBOHUB_RATE_LIMIT_RPS", 1.0),
-		RateLimitBurst:    getEnvInt("ROBOHUB_RATE_LIMIT_BURST", 5),
-		TokenTTL:          time.Duration(getEnvInt("ROBOHUB_TOKEN_TTL_SECONDS", 600)) * time.Second,
+		Port:                      getEnv("PORT", "8080"),
+		JWTSecret:                 os.Getenv("ROBOHUB_JWT_SECRET"),
+		JWTSigningAlgorithm:       getEnv("ROBOHUB_JWT_SIGNING_ALGORITHM", "HS256"),
+		JWTKeyID:                  os.Getenv("ROBOHUB_JWT_KEY_ID"),
+		JWTPrivateKeyFile:         os.Getenv("ROBOHUB_JWT_PRIVATE_KEY_FILE"),
+		GCPKMSKey:                 os.Getenv("ROBOHUB_GCP_KMS_KEY"),
+		GCPKMSTimeout:             time.Duration(getEnvInt("ROBOHUB_GCP_KMS_TIMEOUT_MS", 5000)) * time.Millisecond,
+		JWTSecretFile:             os.Getenv("ROBOHUB_JWT_SECRET_FILE"),
+		OIDCIssuer:                getEnv("ROBOHUB_OIDC_ISSUER", "https://token.actions.githubusercontent.com"),
+		OIDCAudiences:             parseCommaSeparated(getEnv("ROBOHUB_OIDC_AUDIENCE", "robohub")),
+		ClockSkew:                 time.Duration(getEnvInt("ROBOHUB_CLOCK_SKEW_SECONDS", 60)) * time.Second,
+		JWKSTTLSeconds:            getEnvInt("ROBOHUB_JWKS_TTL_SECONDS", 3600),
+		JWKSRetries:               getEnvInt("ROBOHUB_JWKS_FETCH_RETRIES", 2),
+		JWKSMaxStale:              time.Duration(getEnvInt("ROBOHUB_JWKS_MAX_STALE_SECONDS", 3600)) * time.Second,
+		SingleUseOIDCTokens:       getEnvBool("ROBOHUB_SINGLE_USE_OIDC_TOKENS", false),
+		JWKSWarmup:                getEnvBool("ROBOHUB_JWKS_WARMUP", true),
+		JWKSWarmupTimeout:         time.Duration(getEnvInt("ROBOHUB_JWKS_WARMUP_TIMEOUT_SECONDS", 30)) * time.Second,
+		MaxTokenAge:               time.Duration(getEnvInt("ROBOHUB_OIDC_MAX_TOKEN_AGE_SECONDS", 0)) * time.Second,
+		MaxTokenLifetime:          time.Duration(getEnvInt("ROBOHUB_OIDC_MAX_TOKEN_LIFETIME_SECONDS", 900)) * time.Second,
+		RequiredClaims:            parseCommaSeparated(getEnv("ROBOHUB_OIDC_REQUIRED_CLAIMS", "")),
+		SigningAlgorithms:         parseCommaSeparated(getEnv("ROBOHUB_OIDC_SIGNING_ALGORITHMS", "RS256")),
+		MaxOIDCTokenBytes:         getEnvInt("ROBOHUB_OIDC_MAX_TOKEN_BYTES", 16384),
+		OIDCDiscovery:             getEnvBool("ROBOHUB_OIDC_DISCOVERY", true),
+		OIDCDiscoveryTTLSeconds:   getEnvInt("ROBOHUB_OIDC_DISCOVERY_TTL_SECONDS", 3600),
+		BitbucketWorkspace:        os.Getenv("ROBOHUB_BITBUCKET_WORKSPACE"),
+		AzureDevOpsOrganizationID: os.Getenv("ROBOHUB_AZURE_DEVOPS_ORGANIZATION_ID"),
+		GoogleServiceAccounts:     parseCommaSeparated(getEnv("ROBOHUB_GOOGLE_SERVICE_ACCOUNTS", "")),
+		GoogleRepository:          os.Getenv("ROBOHUB_GOOGLE_REPOSITORY"),
+		DefaultBranchOnly:         getEnvBool("ROBOHUB_DEFAULT_BRANCH_ONLY", false),
+		DefaultBranch:             getEnv("ROBOHUB_DEFAULT_BRANCH", "main"),
+		AllowedBranches:           parseCommaSeparated(getEnv("ROBOHUB_ALLOWED_BRANCHES", "")),
+		RefPatterns:               parseCommaSeparated(getEnv("ROBOHUB_ALLOWED_REF_PATTERNS", "")),
+		TagPatterns:               parseCommaSeparated(getEnv("ROBOHUB_ALLOWED_TAG_PATTERNS", "")),
+		AllowedRefTypes:           parseCommaSeparated(getEnv("ROBOHUB_ALLOWED_REF_TYPES", "")),
+		RepoDenyList:              parseCommaSeparated(getEnv("ROBOHUB_REPO_DENYLIST", "")),
+		RepoAllowList:             parseCommaSeparated(getEnv("ROBOHUB_REPO_ALLOWLIST", "")),
+		OwnerDenyList:             parseCommaSeparated(getEnv("ROBOHUB_OWNER_DENYLIST", "")),
+		RepoAllowListFile:         os.Getenv("ROBOHUB_REPO_ALLOWLIST_FILE"),
+		RepoDenyListFile:          os.Getenv("ROBOHUB_REPO_DENYLIST_FILE"),
+		RepoListReloadInterval:    time.Duration(getEnvInt("ROBOHUB_REPO_LIST_RELOAD_SECONDS", 10)) * time.Second,
+		OwnerAllowList:            parseCommaSeparated(getEnv("ROBOHUB_OWNER_ALLOWLIST", "")),
+		RequireSHA:                getEnvBool("ROBOHUB_REQUIRE_SHA", false),
+		DenyBots:                  getEnvBool("ROBOHUB_DENY_BOTS", false),
+		AllowedBots:               parseCommaSeparated(getEnv("ROBOHUB_ALLOWED_BOTS", "")),
+		HostedRunnerRepos:         parseCommaSeparated(getEnv("ROBOHUB_HOSTED_RUNNER_REPOS", "")),
+		RequireHostedRunners:      getEnvBool("ROBOHUB_REQUIRE_HOSTED_RUNNERS", false),
+		DenyMissingRunnerEnv:      getEnvBool("ROBOHUB_DENY_MISSING_RUNNER_ENVIRONMENT", true),
+		EnterpriseAllowList:       parseCommaSeparated(getEnv("ROBOHUB_ENTERPRISE_ALLOWLIST", "")),
+		WorkflowAllowList:         parseCommaSeparated(getEnv("ROBOHUB_WORKFLOW_ALLOWLIST", "")),
+		AllowedEvents:             parseCommaSeparated(getEnv("ROBOHUB_ALLOWED_EVENTS", "")),
+		DeniedEvents:              parseCommaSeparated(getEnv("ROBOHUB_DENIED_EVENTS", "")),
+		DenyMissingEventName:      getEnvBool("ROBOHUB_DENY_MISSING_EVENT_NAME", false),
+		AllowedVisibilities:       parseCommaSeparated(getEnv("ROBOHUB_ALLOWED_VISIBILITIES", "")),
+		DenyMissingVisibility:     getEnvBool("ROBOHUB_DENY_MISSING_VISIBILITY", false),
+		DenyForkPRs:               getEnvBool("ROBOHUB_DENY_FORK_PRS", false),
+		DefaultScopes:             parseCommaSeparated(getEnv("ROBOHUB_DEFAULT_SCOPES", "ingest:build")),
+		ReducedScopes:             parseCommaSeparated(getEnv("ROBOHUB_REDUCED_SCOPES", "")),
+		DropUnpermittedScopes:     getEnvBool("ROBOHUB_DROP_UNPERMITTED_SCOPES", false),
+		PolicyDryRun:              getEnvBool("ROBOHUB_POLICY_DRY_RUN", false),
+		PolicyFile:                os.Getenv("ROBOHUB_POLICY_FILE"),
+		PolicyVersion:             getEnv("ROBOHUB_POLICY_VERSION", settingsHash()),
+		OPAURL:                    os.Getenv("ROBOHUB_OPA_URL"),
+		OPATimeout:                time.Duration(getEnvInt("ROBOHUB_OPA_TIMEOUT_MS", 2000)) * time.Millisecond,
+		OPAFailOpen:               getEnvBool("ROBOHUB_OPA_FAIL_OPEN", false),
+		DenyArchivedRepos:         getEnvBool("ROBOHUB_DENY_ARCHIVED_REPOS", false),
+		DefaultBranchLookup:       getEnvBool("ROBOHUB_DEFAULT_BRANCH_LOOKUP", false),
+		GitHubAPIURL:              getEnv("ROBOHUB_GITHUB_API_URL", "https://api.github.com"),
+		GitHubAPIToken:            os.Getenv("ROBOHUB_GITHUB_API_TOKEN"),
+		GitHubAPITimeout:          time.Duration(getEnvInt("ROBOHUB_GITHUB_API_TIMEOUT_MS", 2000)) * time.Millisecond,
+		GitHubAPICacheTTL:         time.Duration(getEnvInt("ROBOHUB_GITHUB_API_CACHE_SECONDS", 300)) * time.Second,
+		GitHubAPIFailOpen:         getEnvBool("ROBOHUB_GITHUB_API_FAIL_OPEN", false),
+		AuditLog:                  getEnv("ROBOHUB_AUDIT_LOG", "stdout"),
+		AdminToken:                os.Getenv("ROBOHUB_ADMIN_TOKEN"),
+		RateLimitRPS:              getEnvFloat("ROBOHUB_RATE_LIMIT_RPS", 1.0),
+		RateLimitBurst:            getEnvInt("ROBOHUB_RATE_LIMIT_BURST", 5),
+		TokenTTL:                  time.Duration(getEnvInt("ROBOHUB_TOKEN_TTL_SECONDS", 600)) * time.Second,
+		MaxTokenTTL:               time.Duration(getEnvInt("ROBOHUB_MAX_TOKEN_TTL_SECONDS", 3600)) * time.Second,
+		TokenIssuer:               getEnv("ROBOHUB_TOKEN_ISSUER", "robohub-auth"),
+		TokenAudience:             getEnv("ROBOHUB_TOKEN_AUDIENCE", "robohub-api"),
+	}
+
+	vault, err := loadVault(cfg.JWTSigningAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Vault = vault
+
+	if cfg.JWTSecretFile != "" {
+		secret, err := LoadSecretFile(cfg.JWTSecretFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.JWTSecret = secret
 	}
 
 	// Validate required fields
-	if cfg.JWTSecret == "" {
-		return nil, fmt.Errorf("ROBOHUB_JWT_SECRET is required")
+	switch cfg.JWTSigningAlgorithm {
+	case "HS256":
+		if cfg.JWTSecret == "" && cfg.Vault == nil {
+			return nil, fmt.Errorf("ROBOHUB_JWT_SECRET_FILE or ROBOHUB_JWT_SECRET is required")
+		}
+		if cfg.GCPKMSKey != "" {
+			return nil, fmt.Errorf("ROBOHUB_GCP_KMS_KEY needs ROBOHUB_JWT_SIGNING_ALGORITHM RS256, ES256 or EdDSA")
+		}
+	case "RS256", "ES256", "EdDSA":
+		if cfg.GCPKMSKey != "" {
+			if err := validateGCPKMSKey(cfg); err != nil {
+				return nil, err
+			}
+			break
+		}
+		if cfg.Vault != nil {
+			break
+		}
+		key, err := loadPrivateKey(cfg.JWTSigningAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		cfg.JWTPrivateKey = key
+	default:
+		return nil, fmt.Errorf("invalid ROBOHUB_JWT_SIGNING_ALGORITHM %q: must be HS256, RS256, ES256 or EdDSA", cfg.JWTSigningAlgorithm)
+	}
+
+	if value := os.Getenv("ROBOHUB_HTTP_PROXY_URL"); value != "" {
+		proxyURL, err := url.Parse(value)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid ROBOHUB_HTTP_PROXY_URL %q", value)
+		}
+		if username := os.Getenv("ROBOHUB_HTTP_PROXY_USERNAME"); username != "" {
+			proxyURL.User = url.UserPassword(username, os.Getenv("ROBOHUB_HTTP_PROXY_PASSWORD"))
+		}
+		cfg.HTTPProxy = proxyURL
+	}
+
+	if cfg.AdminToken != "" && len(cfg.AdminToken) < 32 {
+		return nil, fmt.Errorf("ROBOHUB_ADMIN_TOKEN must be at least 32 characters")
+	}
+
+	if cfg.OPAURL != "" {
+		opaURL, err := url.Parse(cfg.OPAURL)
+		if err != nil || (opaURL.Scheme != "http" && opaURL.Scheme != "https") || opaURL.Host == "" {
+			return nil, fmt.Errorf("invalid ROBOHUB_OPA_URL %q", cfg.OPAURL)
+		}
+	}
+	if cfg.OPATimeout <= 0 {
+		return nil, fmt.Errorf("ROBOHUB_OPA_TIMEOUT_MS must be positive")
+	}
+
+	if cfg.DenyArchivedRepos || cfg.DefaultBranchLookup {
+		apiURL, err := url.Parse(cfg.GitHubAPIURL)
+		if err != nil || (apiURL.Scheme != "http" && apiURL.Scheme != "https") || apiURL.Host == "" {
+			return nil, fmt.Errorf("invalid ROBOHUB_GITHUB_API_URL %q", cfg.GitHubAPIURL)
+		}
+		if cfg.GitHubAPIToken == "" {
+			return nil, fmt.Errorf("ROBOHUB_GITHUB_API_TOKEN is required when ROBOHUB_DENY_ARCHIVED_REPOS or ROBOHUB_DEFAULT_BRANCH_LOOKUP is set")
+		}
+		if cfg.GitHubAPITimeout <= 0 {
+			return nil, fmt.Errorf("ROBOHUB_GITHUB_API_TIMEOUT_MS must be positive")
+		}
+		if cfg.GitHubAPICacheTTL < 0 {
+			return nil, fmt.Errorf("ROBOHUB_GITHUB_API_CACHE_SECONDS must not be negative")
+		}
+	}
+
+	if cfg.RepoListReloadInterval < 0 {
+		return nil, fmt.Errorf("ROBOHUB_REPO_LIST_RELOAD_SECONDS must not be negative")
+	}
+
+	if len(cfg.SigningAlgorithms) == 0 {
+		return nil, fmt.Errorf("ROBOHUB_OIDC_SIGNING_ALGORITHMS must name at least one algorithm")
+	}
+	for _, alg := range cfg.SigningAlgorithms {
+		if !supportedSigningAlgorithms[alg] {
+			return nil, fmt.Errorf("unsupported algorithm %q in ROBOHUB_OIDC_SIGNING_ALGORITHMS", alg)
+		}
+	}
+
+	for _, visibility := range cfg.AllowedVisibilities {
+		if visibility != "public" && visibility != "private" && visibility != "internal" {
+			return nil, fmt.Errorf("invalid visibility %q in ROBOHUB_ALLOWED_VISIBILITIES: must be public, private or internal", visibility)
+		}
+	}
+
+	// The default branch is the only allowed branch unless others are listed
+	if len(cfg.AllowedBranches) == 0 {
+		cfg.AllowedBranches = []string{cfg.DefaultBranch}
+	}
+	for _, branch := range cfg.AllowedBranches {
+		if strings.HasPrefix(branch, "refs/") {
+			return nil, fmt.Errorf("invalid branch %q in ROBOHUB_ALLOWED_BRANCHES: use the branch name without refs/heads/", branch)
+		}
+	}
+
+	for _, pattern := range cfg.RefPatterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q in ROBOHUB_ALLOWED_REF_PATTERNS: %w", pattern, err)
+		}
+	}
+	for _, pattern := range cfg.TagPatterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q in ROBOHUB_ALLOWED_TAG_PATTERNS: %w", pattern, err)
+		}
+		if !strings.HasPrefix(pattern, "refs/tags/") {
+			return nil, fmt.Errorf("invalid pattern %q in ROBOHUB_ALLOWED_TAG_PATTERNS: must start with refs/tags/", pattern)
+		}
+	}
+	for _, refType := range cfg.AllowedRefTypes {
+		if refType != "branch" && refType != "tag" {
+			return nil, fmt.Errorf("invalid ref type %q in ROBOHUB_ALLOWED_REF_TYPES: must be branch or tag", refType)
+		}
+	}
+
+	if cfg.MaxOIDCTokenBytes <= 0 {
+		return nil, fmt.Errorf("ROBOHUB_OIDC_MAX_TOKEN_BYTES must be positive")
+	}
+
+	if path := os.Getenv("ROBOHUB_JWKS_CA_BUNDLE"); path != "" {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ROBOHUB_JWKS_CA_BUNDLE: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("invalid ROBOHUB_JWKS_CA_BUNDLE %q: no PEM certificates found", path)
+		}
+		cfg.JWKSRootCAs = pool
+	}
+	cfg.JWKSInsecureSkipVerify = getEnvBool("ROBOHUB_JWKS_INSECURE_SKIP_VERIFY", false)
+
+	if value := os.Getenv("ROBOHUB_GITHUB_ENTERPRISE_ISSUERS"); value != "" {
+		if err := json.Unmarshal([]byte(value), &cfg.GitHubEnterpriseIssuers); err != nil {
+			return nil, fmt.Errorf("invalid ROBOHUB_GITHUB_ENTERPRISE_ISSUERS: %w", err)
+		}
+		for _, issuer := range cfg.GitHubEnterpriseIssuers {
+			if issuer.Issuer == "" {
+				return nil, fmt.Errorf("invalid ROBOHUB_GITHUB_ENTERPRISE_ISSUERS: every entry needs an issuer")
+			}
+			if issuer.ClockSkewSeconds < 0 || issuer.JWKSTTLSeconds < 0 || issuer.MaxTokenLifetimeSeconds < 0 {
+				return nil, fmt.Errorf("invalid ROBOHUB_GITHUB_ENTERPRISE_ISSUERS: negative duration for %s", issuer.Issuer)
+			}
+		}
+	}
+
+	if value := os.Getenv("ROBOHUB_GITHUB_SUB_TEMPLATES"); value != "" {
+		if err := json.Unmarshal([]byte(value), &cfg.GitHubSubjectTemplates); err != nil {
+			return nil, fmt.Errorf("invalid ROBOHUB_GITHUB_SUB_TEMPLATES: %w", err)
+		}
+		for scope, templates := range cfg.GitHubSubjectTemplates {
+			if len(templates) == 0 {
+				return nil, fmt.Errorf("invalid ROBOHUB_GITHUB_SUB_TEMPLATES: no templates for %q", scope)
+			}
+			for _, template := range templates {
+				if slices.Contains(strings.Split(template, ":"), "") {
+					return nil, fmt.Errorf("invalid ROBOHUB_GITHUB_SUB_TEMPLATES: template %q for %q has an empty claim key", template, scope)
+				}
+			}
+		}
+	}
+
+	if value := os.Getenv("ROBOHUB_WORKFLOW_PINS"); value != "" {
+		if err := json.Unmarshal([]byte(value), &cfg.WorkflowPins); err != nil {
+			return nil, fmt.Errorf("invalid ROBOHUB_WORKFLOW_PINS: %w", err)
+		}
+		for repo, workflows := range cfg.WorkflowPins {
+			if len(workflows) == 0 {
+				return nil, fmt.Errorf("invalid ROBOHUB_WORKFLOW_PINS: no workflows for %q", repo)
+			}
+		}
+	}
+
+	if value := os.Getenv("ROBOHUB_REQUIRED_ENVIRONMENTS"); value != "" {
+		if err := json.Unmarshal([]byte(value), &cfg.RequiredEnvironments); err != nil {
+			return nil, fmt.Errorf("invalid ROBOHUB_REQUIRED_ENVIRONMENTS: %w", err)
+		}
+		for pattern, environments := range cfg.RequiredEnvironments {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid ROBOHUB_REQUIRED_ENVIRONMENTS: pattern %q: %w", pattern, err)
+			}
+			if len(environments) == 0 {
+				return nil, fmt.Errorf("invalid ROBOHUB_REQUIRED_ENVIRONMENTS: no environments for %q", pattern)
+			}
+		}
+	}
+
+	if value := os.Getenv("ROBOHUB_REPO_SCOPES"); value != "" {
+		if err := json.Unmarshal([]byte(value), &cfg.RepoScopes); err != nil {
+			return nil, fmt.Errorf("invalid ROBOHUB_REPO_SCOPES: %w", err)
+		}
+		for pattern, scopes := range cfg.RepoScopes {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid ROBOHUB_REPO_SCOPES: pattern %q: %w", pattern, err)
+			}
+			if len(scopes) == 0 || slices.Contains(scopes, "") {
+				return nil, fmt.Errorf("invalid ROBOHUB_REPO_SCOPES: missing or empty scopes for %q", pattern)
+			}
+			if err := validateScopes(scopes); err != nil {
+				return nil, fmt.Errorf("invalid ROBOHUB_REPO_SCOPES: %q: %w", pattern, err)
+			}
+		}
+	}
+	if len(cfg.DefaultScopes) == 0 {
+		return nil, fmt.Errorf("ROBOHUB_DEFAULT_SCOPES must not be empty")
+	}
+	if err := validateScopes(cfg.DefaultScopes); err != nil {
+		return nil, fmt.Errorf("invalid ROBOHUB_DEFAULT_SCOPES: %w", err)
+	}
+	if err := validateScopes(cfg.ReducedScopes); err != nil {
+		return nil, fmt.Errorf("invalid ROBOHUB_REDUCED_SCOPES: %w", err)
+	}
+
+	if value := os.Getenv("ROBOHUB_REPO_TOKEN_TTLS"); value != "" {
+		var seconds map[string]int
+		if err := json.Unmarshal([]byte(value), &seconds); err != nil {
+			return nil, fmt.Errorf("invalid ROBOHUB_REPO_TOKEN_TTLS: %w", err)
+		}
+		cfg.RepoTokenTTLs = make(map[string]time.Duration, len(seconds))
+		for pattern, ttl := range seconds {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid ROBOHUB_REPO_TOKEN_TTLS: pattern %q: %w", pattern, err)
+			}
+			if ttl <= 0 {
+				return nil, fmt.Errorf("invalid ROBOHUB_REPO_TOKEN_TTLS: TTL for %q must be positive", pattern)
+			}
+			cfg.RepoTokenTTLs[pattern] = time.Duration(ttl) * time.Second
+		}
+	}
+	if cfg.MaxTokenTTL < 0 {
+		return nil, fmt.Errorf("ROBOHUB_MAX_TOKEN_TTL_SECONDS must not be negative")
+	}
+
+	if value := os.Getenv("ROBOHUB_REPO_EVENT_RULES"); value != "" {
+		if err := json.Unmarshal([]byte(value), &cfg.RepoEventRules); err != nil {
+			return nil, fmt.Errorf("invalid ROBOHUB_REPO_EVENT_RULES: %w", err)
+		}
+		for repo, rule := range cfg.RepoEventRules {
+			if len(rule.Allow) == 0 && len(rule.Deny) == 0 {
+				return nil, fmt.Errorf("invalid ROBOHUB_REPO_EVENT_RULES: no allowed or denied events for %q", repo)
+			}
+		}
+	}
+
+	// ROBOHUB_OIDC_PROVIDER predates support for multiple providers
+	names := parseCommaSeparated(getEnv("ROBOHUB_OIDC_PROVIDERS", getEnv("ROBOHUB_OIDC_PROVIDER", "github")))
+	if len(names) == 0 {
+		return nil, fmt.Errorf("ROBOHUB_OIDC_PROVIDERS must list at least one provider")
+	}
+
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			return nil, fmt.Errorf("provider %q is listed twice in ROBOHUB_OIDC_PROVIDERS", name)
+		}
+		seen[name] = true
+
+		provider, err := loadProvider(cfg, name)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Providers = append(cfg.Providers, provider)
 	}
 
 	return cfg, nil
 }
 
+// loadProvider reads the ROBOHUB_OIDC_<NAME>_* overrides for a provider and
+// checks the settings it requires
+func loadProvider(cfg *Config, name string) (ProviderConfig, error) {
+	if !knownProviders[name] {
+		return ProviderConfig{}, fmt.Errorf("unknown OIDC provider %q", name)
+	}
+
+	prefix := "ROBOHUB_OIDC_" + strings.ToUpper(name) + "_"
+	// Token lifetimes and claim sets differ between providers (Google ID
+	// tokens last an hour), so the global limits only apply to GitHub
+	defaultIssuer := ""
+	defaultLifetimeSeconds := 0
+	defaultRequiredClaims := ""
+	if name == "github" {
+		defaultIssuer = cfg.OIDCIssuer
+		defaultLifetimeSeconds = int(cfg.MaxTokenLifetime / time.Second)
+		defaultRequiredClaims = strings.Join(cfg.RequiredClaims, ",")
+	}
+
+	provider := ProviderConfig{
+		Name:           name,
+		Issuer:         getEnv(prefix+"ISSUER", defaultIssuer),
+		Audiences:      parseCommaSeparated(getEnv(prefix+"AUDIENCE", strings.Join(cfg.OIDCAudiences, ","))),
+		JWKSURL:        os.Getenv(prefix + "JWKS_URL"),
+		JWKSTTLSeconds: getEnvInt(prefix+"JWKS_TTL_SECONDS", cfg.JWKSTTLSeconds),
+
+		MaxTokenLifetime: time.Duration(getEnvInt(prefix+"MAX_TOKEN_LIFETIME_SECONDS", defaultLifetimeSeconds)) * time.Second,
+		RequiredClaims:   parseCommaSeparated(getEnv(prefix+"REQUIRED_CLAIMS", defaultRequiredClaims)),
+	}
+
+	if len(provider.Audiences) == 0 {
+		return ProviderConfig{}, fmt.Errorf("%sAUDIENCE must not be empty", prefix)
+	}
+	if len(provider.Audiences) > 1 && name != "github" {
+		return ProviderConfig{}, fmt.Errorf("the %s provider accepts a single audience; set %sAUDIENCE", name, prefix)
+	}
+
+	switch name {
+	case "bitbucket":
+		if cfg.BitbucketWorkspace == "" {
+			return ProviderConfig{}, fmt.Errorf("ROBOHUB_BITBUCKET_WORKSPACE is required for the bitbucket provider")
+		}
+	case "azure_devops":
+		if cfg.AzureDevOpsOrganizationID == "" {
+			return ProviderConfig{}, fmt.Errorf("ROBOHUB_AZURE_DEVOPS_ORGANIZATION_ID is required for the azure_devops provider")
+		}
+	case "google":
+		if len(cfg.GoogleServiceAccounts) == 0 {
+			return ProviderConfig{}, fmt.Errorf("ROBOHUB_GOOGLE_SERVICE_ACCOUNTS is required for the google provider")
+		}
+		if cfg.GoogleRepository == "" {
+			return ProviderConfig{}, fmt.Errorf("ROBOHUB_GOOGLE_REPOSITORY is required for the google provider")
+		}
+	}
+
+	return provider, nil
+}
+
+// loadPrivateKey reads the PEM private key of ROBOHUB_JWT_PRIVATE_KEY_FILE or
+// ROBOHUB_JWT_PRIVATE_KEY, as PKCS #8, PKCS #1 for RSA or SEC 1 for ECDSA,
+// and checks that it suits algorithm
+func loadPrivateKey(algorithm string) (crypto.Signer, error) {
+	name, data := os.Getenv("ROBOHUB_JWT_PRIVATE_KEY_FILE"), []byte(os.Getenv("ROBOHUB_JWT_PRIVATE_KEY"))
+	switch {
+	case name != "" && len(data) > 0:
+		return nil, fmt.Errorf("ROBOHUB_JWT_PRIVATE_KEY_FILE and ROBOHUB_JWT_PRIVATE_KEY cannot both be set")
+	case name != "":
+		return LoadPrivateKeyFile(name, algorithm)
+	case len(data) == 0:
+		return nil, fmt.Errorf("ROBOHUB_JWT_PRIVATE_KEY_FILE or ROBOHUB_JWT_PRIVATE_KEY is required for %s", algorithm)
+	}
+	return ParsePrivateKey(data, algorithm)
+}
+
+// gcpKMSKeyVersion matches the resource name of a Cloud KMS key version
+var gcpKMSKeyVersion = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+/cryptoKeyVersions/[0-9]+$`)
+
+// validateGCPKMSKey checks the Cloud KMS settings, which replace the other
+// sources of the private key
+func validateGCPKMSKey(cfg *Config) error {
+	for _, key := range []string{"ROBOHUB_JWT_PRIVATE_KEY", "ROBOHUB_JWT_PRIVATE_KEY_FILE", "ROBOHUB_VAULT_ADDR"} {
+		if os.Getenv(key) != "" {
+			return fmt.Errorf("ROBOHUB_GCP_KMS_KEY and %s cannot both be set", key)
+		}
+	}
+	if !gcpKMSKeyVersion.MatchString(cfg.GCPKMSKey) {
+		return fmt.Errorf("invalid ROBOHUB_GCP_KMS_KEY %q: must be a key version, projects/*/locations/*/keyRings/*/cryptoKeys/*/cryptoKeyVersions/*", cfg.GCPKMSKey)
+	}
+	if cfg.GCPKMSTimeout <= 0 {
+		return fmt.Errorf("ROBOHUB_GCP_KMS_TIMEOUT_MS must be positive")
+	}
+	return nil
+}
+
+// VaultConfig locates the JWT secret or PEM private key in a HashiCorp Vault
+// KV secret and how to log in to Vault
+type VaultConfig struct {
+	Address   string
+	Path      string
+	Field     string
+	Namespace string
+
+	// AuthMethod is token, with Token, or kubernetes, logging in as
+	// KubernetesRole with the service account token at KubernetesTokenFile
+	AuthMethod          string
+	Token               string
+	KubernetesRole      string
+	KubernetesMount     string
+	KubernetesTokenFile string
+
+	// Refresh is how often the secret is read again to pick up rotations,
+	// never when zero, and Timeout bounds each read
+	Refresh time.Duration
+	Timeout time.Duration
+}
+
+// loadVault reads the ROBOHUB_VAULT_* settings, nil when ROBOHUB_VAULT_ADDR
+// is unset
+func loadVault(algorithm string) (*VaultConfig, error) {
+	address := os.Getenv("ROBOHUB_VAULT_ADDR")
+	if address == "" {
+		return nil, nil
+	}
+	for _, key := range []string{"ROBOHUB_JWT_SECRET", "ROBOHUB_JWT_SECRET_FILE", "ROBOHUB_JWT_PRIVATE_KEY", "ROBOHUB_JWT_PRIVATE_KEY_FILE"} {
+		if os.Getenv(key) != "" {
+			return nil, fmt.Errorf("ROBOHUB_VAULT_ADDR and %s cannot both be set", key)
+		}
+	}
+
+	defaultField := "private_key"
+	if algorithm == "HS256" {
+		defaultField = "jwt_secret"
+	}
+	vault := &VaultConfig{
+		Address:             address,
+		Path:                os.Getenv("ROBOHUB_VAULT_PATH"),
+		Field:               getEnv("ROBOHUB_VAULT_FIELD", defaultField),
+		Namespace:           os.Getenv("ROBOHUB_VAULT_NAMESPACE"),
+		AuthMethod:          getEnv("ROBOHUB_VAULT_AUTH_METHOD", "token"),
+		Token:               os.Getenv("ROBOHUB_VAULT_TOKEN"),
+		KubernetesRole:      os.Getenv("ROBOHUB_VAULT_KUBERNETES_ROLE"),
+		KubernetesMount:     getEnv("ROBOHUB_VAULT_KUBERNETES_MOUNT", "kubernetes"),
+		KubernetesTokenFile: getEnv("ROBOHUB_VAULT_KUBERNETES_TOKEN_FILE", "/var/run/secrets/kubernetes.io/serviceaccount/token"),
+		Refresh:             time.Duration(getEnvInt("ROBOHUB_VAULT_REFRESH_SECONDS", 300)) * time.Second,
+		Timeout:             time.Duration(getEnvInt("ROBOHUB_VAULT_TIMEOUT_MS", 5000)) * time.Millisecond,
+	}
+
+	if u, err := url.Parse(address); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid ROBOHUB_VAULT_ADDR %q", address)
+	}
+	if vault.Path == "" {
+		return nil, fmt.Errorf("ROBOHUB_VAULT_PATH is required when ROBOHUB_VAULT_ADDR is set")
+	}
+	switch vault.AuthMethod {
+	case "token":
+		if vault.Token == "" {
+			return nil, fmt.Errorf("ROBOHUB_VAULT_TOKEN is required for ROBOHUB_VAULT_AUTH_METHOD token")
+		}
+	case "kubernetes":
+		if vault.KubernetesRole == "" {
+			return nil, fmt.Errorf("ROBOHUB_VAULT_KUBERNETES_ROLE is required for ROBOHUB_VAULT_AUTH_METHOD kubernetes")
+		}
+		// A token would take precedence over the login
+		vault.Token = ""
+	default:
+		return nil, fmt.Errorf("invalid ROBOHUB_VAULT_AUTH_METHOD %q: must be token or kubernetes", vault.AuthMethod)
+	}
+	if vault.Refresh < 0 {
+		return nil, fmt.Errorf("ROBOHUB_VAULT_REFRESH_SECONDS must not be negative")
+	}
+	if vault.Timeout <= 0 {
+		return nil, fmt.Errorf("ROBOHUB_VAULT_TIMEOUT_MS must be positive")
+	}
+	return vault, nil
+}
+
+// LoadSecretFile reads the JWT secret from the file at name, such as a
+// mounted Kubernetes or Docker secret, trimming surrounding whitespace
+func LoadSecretFile(name string) (string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to read ROBOHUB_JWT_SECRET_FILE: %w", err)
+	}
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return "", fmt.Errorf("invalid ROBOHUB_JWT_SECRET_FILE %s: file is empty", name)
+	}
+	return secret, nil
+}
+
+// LoadPrivateKeyFile reads the PEM private key at name, as
+// ROBOHUB_JWT_PRIVATE_KEY_FILE is at startup, so that a key written there
+// later can be rotated in
+func LoadPrivateKeyFile(name, algorithm string) (crypto.Signer, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ROBOHUB_JWT_PRIVATE_KEY_FILE: %w", err)
+	}
+	return ParsePrivateKey(data, algorithm)
+}
+
+// ParsePrivateKey parses a PEM private key for algorithm, as
+// ROBOHUB_JWT_PRIVATE_KEY is
+func ParsePrivateKey(data []byte, algorithm string) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid %s private key: no PEM block found", algorithm)
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s private key: %w", algorithm, err)
+	}
+
+	// A key that does not suit the algorithm would only fail at the first
+	// token exchange
+	var ok bool
+	switch algorithm {
+	case "RS256":
+		_, ok = key.(*rsa.PrivateKey)
+	case "ES256":
+		ecKey, isEC := key.(*ecdsa.PrivateKey)
+		ok = isEC && ecKey.Curve == elliptic.P256()
+	case "EdDSA":
+		_, ok = key.(ed25519.PrivateKey)
+	}
+	if !ok {
+		return nil, fmt.Errorf("invalid %s private key: %s needs %s, got %s", algorithm, algorithm, keyRequirement[algorithm], describeKey(key))
+	}
+	return key.(crypto.Signer), nil
+}
+
+// keyRequirement names the key type each signing algorithm needs
+var keyRequirement = map[string]string{
+	"RS256": "an RSA key",
+	"ES256": "a P-256 ECDSA key",
+	"EdDSA": "an Ed25519 key",
+}
+
+// describeKey names the type of a parsed private key for errors
+func describeKey(key interface{}) string {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return "an RSA key"
+	case *ecdsa.PrivateKey:
+		return "a " + key.Curve.Params().Name + " ECDSA key"
+	case ed25519.PrivateKey:
+		return "an Ed25519 key"
+	default:
+		return fmt.Sprintf("a %T", key)
+	}
+}
+
+// policySettings are the environment variables that feed policy.NewEnforcer,
+// and so the only ones hashed into the default policy version
+var policySettings = []string{
+	"ROBOHUB_DEFAULT_BRANCH_ONLY",
+	"ROBOHUB_DEFAULT_BRANCH",
+	"ROBOHUB_DEFAULT_BRANCH_LOOKUP",
+	"ROBOHUB_ALLOWED_BRANCHES",
+	"ROBOHUB_ALLOWED_REF_PATTERNS",
+	"ROBOHUB_ALLOWED_TAG_PATTERNS",
+	"ROBOHUB_ALLOWED_REF_TYPES",
+	"ROBOHUB_REPO_ALLOWLIST",
+	"ROBOHUB_REPO_DENYLIST",
+	"ROBOHUB_REPO_ALLOWLIST_FILE",
+	"ROBOHUB_REPO_DENYLIST_FILE",
+	"ROBOHUB_OWNER_ALLOWLIST",
+	"ROBOHUB_OWNER_DENYLIST",
+	"ROBOHUB_DENY_ARCHIVED_REPOS",
+	"ROBOHUB_DENY_BOTS",
+	"ROBOHUB_ALLOWED_BOTS",
+	"ROBOHUB_REQUIRE_SHA",
+	"ROBOHUB_HOSTED_RUNNER_REPOS",
+	"ROBOHUB_REQUIRE_HOSTED_RUNNERS",
+	"ROBOHUB_DENY_MISSING_RUNNER_ENVIRONMENT",
+	"ROBOHUB_ENTERPRISE_ALLOWLIST",
+	"ROBOHUB_ALLOWED_VISIBILITIES",
+	"ROBOHUB_DENY_MISSING_VISIBILITY",
+	"ROBOHUB_WORKFLOW_ALLOWLIST",
+	"ROBOHUB_WORKFLOW_PINS",
+	"ROBOHUB_REQUIRED_ENVIRONMENTS",
+	"ROBOHUB_ALLOWED_EVENTS",
+	"ROBOHUB_DENIED_EVENTS",
+	"ROBOHUB_REPO_EVENT_RULES",
+	"ROBOHUB_DENY_MISSING_EVENT_NAME",
+	"ROBOHUB_DENY_FORK_PRS",
+	"ROBOHUB_REPO_SCOPES",
+	"ROBOHUB_DEFAULT_SCOPES",
+	"ROBOHUB_REDUCED_SCOPES",
+	"ROBOHUB_REPO_TOKEN_TTLS",
+	"ROBOHUB_MAX_TOKEN_TTL_SECONDS",
+	"ROBOHUB_POLICY_DRY_RUN",
+	"ROBOHUB_OPA_URL",
+	"ROBOHUB_OPA_FAIL_OPEN",
+	"ROBOHUB_GITHUB_API_FAIL_OPEN",
+}
+
+// settingsHash returns env: and the first 12 hex digits of the SHA-256 of the
+// policy settings. Key material and other settings never reach the hash.
+func settingsHash() string {
+	var settings []string
+	for _, key := range policySettings {
+		if value, ok := os.LookupEnv(key); ok {
+			settings = append(settings, key+"="+value)
+		}
+	}
+	slices.Sort(settings)
+	sum := sha256.Sum256([]byte(strings.Join(settings, "\n")))
+	return "env:" + hex.EncodeToString(sum[:6])
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -96,6 +1034,20 @@ func getEnvBool(key string, defaultValue bool) bool {
 	return defaultValue
 }
 
+// scopeName matches a scope name: lowercase words separated by colons, such
+// as ingest:build
+var scopeName = regexp.MustCompile(`^[a-z][a-z0-9_.-]*(:[a-z0-9_.-]+)*$`)
+
+// validateScopes checks that scopes are valid scope names
+func validateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if !scopeName.MatchString(scope) {
+			return fmt.Errorf("scope %q must be lowercase letters, digits, '.', '_' and '-' in colon-separated parts", scope)
+		}
+	}
+	return nil
+}
+
 func parseCommaSeparated(value string) []string {
 	if value == "" {
 		return []string{}
@@ -0,0 +1,54 @@
+package correlation
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		want bool
+	}{
+		{"uuid", "3f2b8c1e-9a4d-4b7e-8f0a-1c2d3e4f5a6b", true},
+		{"pipeline style", "ci:build.42_attempt-1", true},
+		{"empty", "", false},
+		{"too long", strings.Repeat("a", 129), false},
+		{"max length", strings.Repeat("a", 128), true},
+		{"whitespace", "abc def", false},
+		{"newline injection", "abc\nlevel=ERROR", false},
+		{"non-ascii", "idé", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Valid(tt.id); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestContext(t *testing.T) {
+	ctx := context.Background()
+	if id := FromContext(ctx); id != "" {
+		t.Errorf("expected empty ID, got %q", id)
+	}
+
+	ctx = NewContext(ctx, "abc-123")
+	if id := FromContext(ctx); id != "abc-123" {
+		t.Errorf("expected abc-123, got %q", id)
+	}
+}
+
+func TestNew(t *testing.T) {
+	id := New()
+	if !Valid(id) {
+		t.Errorf("generated ID %q is not valid", id)
+	}
+	if New() == id {
+		t.Error("expected distinct generated IDs")
+	}
+}
@@ -0,0 +1,122 @@
+package httpapi
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/robohub/auth-service/internal/policy"
+	"github.com/robohub/auth-service/internal/types"
+)
+
+// WithAdminToken serves the /admin endpoints to requests presenting token as
+// a bearer token. Without one the endpoints are not served.
+func WithAdminToken(token string) Option {
+	return func(s *Server) {
+		s.adminToken = token
+	}
+}
+
+// adminAuth rejects requests without the admin bearer token
+func (s *Server) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString, ok := bearerToken(r)
+		if !ok || subtle.ConstantTimeCompare([]byte(tokenString), []byte(s.adminToken)) != 1 {
+			s.logger.WarnContext(r.Context(), "rejected admin request", "path", r.URL.Path)
+			s.respondError(w, http.StatusUnauthorized, "unauthorized", "missing or invalid admin token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handlePolicyCheck reports whether the live policy would allow a token with
+// the given claims, without minting one or counting against the rate limit.
+// OPA is not consulted.
+func (s *Server) handlePolicyCheck(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req types.PolicyCheckRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid_request", "invalid JSON in request body")
+		return
+	}
+	if req.Repository == "" {
+		s.respondError(w, http.StatusBadRequest, "invalid_request", "missing repository field")
+		return
+	}
+
+	// The check is for a token issued now
+	now := s.clock.Now()
+	claims := &types.VerifiedClaims{
+		Provider:          req.Provider,
+		Repository:        req.Repository,
+		Owner:             req.Owner,
+		RepositoryID:      req.RepositoryID,
+		Visibility:        req.Visibility,
+		Enterprise:        req.Enterprise,
+		Ref:               req.Ref,
+		RefType:           req.RefType,
+		BaseRef:           req.BaseRef,
+		HeadRef:           req.HeadRef,
+		SHA:               req.SHA,
+		Actor:             req.Actor,
+		EventName:         req.EventName,
+		Environment:       req.Environment,
+		RunnerEnvironment: req.RunnerEnvironment,
+		Workflow:          req.WorkflowRef,
+		WorkflowRef:       req.WorkflowRef,
+		JobWorkflowRef:    req.JobWorkflowRef,
+		ReusableWorkflow:  req.JobWorkflowRef != "" && req.JobWorkflowRef != req.WorkflowRef,
+		IssuedAt:          now,
+		ExpiresAt:         now.Add(5 * time.Minute),
+		Raw:               req.Claims,
+	}
+
+	decision := s.policy.CheckContext(ctx, claims)
+	resp := types.PolicyCheckResponse{
+		Allowed:       decision.Allowed,
+		Reason:        string(decision.Reason),
+		RuleID:        decision.RuleID,
+		Message:       decision.Message,
+		PolicyHash:    s.policy.Status().Hash,
+		PolicyVersion: decision.PolicyVersion,
+	}
+	if decision.Allowed {
+		grant, err := s.policy.GrantFor(claims, decision)
+		if err != nil {
+			// Reduced to no scopes at all or frozen, the token is denied after all
+			var denied *policy.DeniedError
+			if !errors.As(err, &denied) {
+				s.logger.ErrorContext(ctx, "policy check failed", "repository", claims.Repository, "error", err)
+				s.respondError(w, http.StatusInternalServerError, "internal_error", "failed to check policy")
+				return
+			}
+			decision = denied.Decision
+			resp = types.PolicyCheckResponse{
+				Reason:        string(decision.Reason),
+				RuleID:        decision.RuleID,
+				Message:       decision.Message,
+				PolicyHash:    resp.PolicyHash,
+				PolicyVersion: decision.PolicyVersion,
+			}
+		} else {
+			resp.Scopes = grant.Scopes
+			resp.TTLSeconds = int(grant.TTL.Seconds())
+			if grant.Reduced != nil {
+				resp.ScopeReduction = scopeReduction(grant)
+			}
+		}
+	}
+
+	s.logger.InfoContext(ctx, "policy check",
+		"repository", claims.Repository,
+		"ref", claims.Ref,
+		"allowed", decision.Allowed,
+		"reason", decision.Reason,
+		"rule_id", decision.RuleID,
+	)
+	s.respondJSON(w, http.StatusOK, resp)
+}
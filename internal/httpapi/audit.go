@@ -0,0 +1,88 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/robohub/auth-service/internal/policy"
+	"github.com/robohub/auth-service/internal/types"
+)
+
+// Policy outcomes recorded in audit events
+const (
+	auditAllow       = policy.OutcomeAllow
+	auditDeny        = policy.OutcomeDeny
+	auditWouldDeny   = policy.OutcomeWouldDeny
+	auditReduced     = policy.OutcomeReduced
+	auditUnavailable = policy.OutcomeUnavailable
+)
+
+// WithAuditLogger records a "policy decision" audit event on logger for
+// every exchange that reaches policy evaluation. Keep it apart from the
+// service log so the audit trail can be shipped and retained on its own.
+func WithAuditLogger(logger *slog.Logger) Option {
+	return func(s *Server) {
+		s.audit = logger
+	}
+}
+
+// auditDecision records who asked for a token, with the raw claims of their
+// OIDC token, and what the policy decided.
+// err is the denial, the dry-run denial for would_deny, or the branch denial
+// for reduced.
+func (s *Server) auditDecision(ctx context.Context, claims *types.VerifiedClaims, outcome string, grant policy.Grant, err error) {
+	if s.audit == nil {
+		return
+	}
+
+	attrs := []interface{}{
+		"outcome", outcome,
+		"provider", claims.Provider,
+		"issuer", claims.Issuer,
+		"repository", claims.Repository,
+		"ref", claims.Ref,
+		"sha", claims.SHA,
+		"actor", claims.Actor,
+		"run_id", claims.RunID,
+		"run_attempt", claims.RunAttempt,
+		"event_name", claims.EventName,
+		"workflow_ref", claims.WorkflowRef,
+		"job_workflow_ref", claims.JobWorkflowRef,
+		"environment", claims.Environment,
+		"policy_version", s.policyVersion(grant, err),
+		"claims", claims.Raw,
+	}
+	if err != nil {
+		details := policyErrorDetails(err)
+		if outcome == auditUnavailable {
+			details = &types.ErrorDetails{Reason: "unavailable"}
+		}
+		attrs = append(attrs, "reason", details.Reason, "rule_id", details.RuleID, "message", err.Error())
+	} else {
+		attrs = append(attrs, "reason", policy.ReasonAllowed)
+	}
+	if outcome == auditAllow || outcome == auditWouldDeny || outcome == auditReduced {
+		attrs = append(attrs, "scopes", grant.Scopes, "ttl", grant.TTL)
+		if outcome == auditReduced {
+			attrs = append(attrs, "withheld_scopes", grant.Withheld)
+		}
+		if len(grant.Elevations) > 0 {
+			attrs = append(attrs, "elevated_scopes", grant.Elevations)
+		}
+	}
+	s.audit.InfoContext(ctx, "policy decision", attrs...)
+}
+
+// policyVersion returns the version of the policy that made a decision, or
+// of the loaded policy when it could not be made
+func (s *Server) policyVersion(grant policy.Grant, err error) string {
+	if grant.PolicyVersion != "" {
+		return grant.PolicyVersion
+	}
+	var denied *policy.DeniedError
+	if errors.As(err, &denied) && denied.Decision.PolicyVersion != "" {
+		return denied.Decision.PolicyVersion
+	}
+	return s.policy.Status().PolicyVersion
+}
@@ -0,0 +1,61 @@
+package httpapi
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/robohub/auth-service/internal/correlation"
+)
+
+// correlationMiddleware accepts or generates an X-Correlation-ID, stores it
+// in the request context and echoes it on the response
+func (s *Server) correlationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(correlation.Header)
+		if id != "" && !correlation.Valid(id) {
+			s.logger.WarnContext(r.Context(), "ignoring invalid correlation ID", "length", len(id))
+			id = ""
+		}
+		if id == "" {
+			id = correlation.New()
+		}
+
+		w.Header().Set(correlation.Header, id)
+		next.ServeHTTP(w, r.WithContext(correlation.NewContext(r.Context(), id)))
+	})
+}
+
+// logHandler adds the chi request ID and the correlation ID from the
+// context to every record
+type logHandler struct {
+	slog.Handler
+}
+
+// NewLogHandler wraps h so records logged with a request context carry
+// request_id and correlation_id attributes
+func NewLogHandler(h slog.Handler) slog.Handler {
+	return &logHandler{Handler: h}
+}
+
+// Handle implements slog.Handler
+func (h *logHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := middleware.GetReqID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if id := correlation.FromContext(ctx); id != "" {
+		record.AddAttrs(slog.String("correlation_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs implements slog.Handler
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &logHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup implements slog.Handler
+func (h *logHandler) WithGroup(name string) slog.Handler {
+	return &logHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,67 @@
+package httpapi
+
+import (
+	"crypto"
+	"net/http"
+
+	"github.com/robohub/auth-service/internal/secrets"
+	"github.com/robohub/auth-service/internal/types"
+)
+
+// WithSigningKeySource serves /admin/keys/rotate, which rotates the minter to
+// the key source returns, such as a new key written to the key file
+func WithSigningKeySource(source func() (crypto.Signer, error)) Option {
+	return func(s *Server) {
+		s.signingKeySource = source
+	}
+}
+
+// WithSecretWatcher reports the refreshes of the signing key from a secret
+// store in the metrics
+func WithSecretWatcher(watcher *secrets.Watcher) Option {
+	return func(s *Server) {
+		s.secretWatcher = watcher
+	}
+}
+
+// handleJWKS serves the public keys RoboHub access tokens are validated with:
+// the signing key and the previous keys that have not yet retired. A minter
+// using a shared secret publishes none.
+func (s *Server) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	s.respondJSON(w, http.StatusOK, s.minter.JWKS())
+}
+
+// handleRotateKey rotates the minter to the signing key source's current key,
+// keeping the replaced key for validation until its tokens have expired
+func (s *Server) handleRotateKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	key, err := s.signingKeySource()
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to read signing key, keeping the current key", "error", err)
+		s.respondError(w, http.StatusInternalServerError, "key_unavailable", "failed to read signing key")
+		return
+	}
+	rotated, err := s.minter.Rotate(key, "")
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to rotate signing key", "error", err)
+		s.respondError(w, http.StatusConflict, "rotation_failed", err.Error())
+		return
+	}
+
+	resp := types.KeyRotationResponse{Rotated: rotated, Keys: []types.SigningKeyInfo{}}
+	for _, key := range s.minter.Keys() {
+		info := types.SigningKeyInfo{KeyID: key.ID, Algorithm: key.Algorithm, Active: key.Active}
+		if key.Active {
+			resp.KeyID = key.ID
+		} else {
+			retiresAt := key.RetiresAt
+			info.RetiresAt = &retiresAt
+		}
+		resp.Keys = append(resp.Keys, info)
+	}
+	if rotated {
+		s.logger.InfoContext(ctx, "rotated signing key", "kid", resp.KeyID, "keys", len(resp.Keys))
+	}
+	s.respondJSON(w, http.StatusOK, resp)
+}
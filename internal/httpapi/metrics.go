@@ -0,0 +1,109 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/robohub/auth-service/internal/oidc"
+	"github.com/robohub/auth-service/internal/policy"
+)
+
+// jwksMetric is a metric family derived from JWKS cache stats. value reports
+// false when the metric has no sample for a cache.
+type jwksMetric struct {
+	name  string
+	kind  string
+	help  string
+	value func(oidc.JWKSStats) (float64, bool)
+}
+
+var jwksMetrics = []jwksMetric{
+	{"robohub_jwks_cache_hits_total", "counter", "Key lookups answered from the JWKS cache.",
+		func(s oidc.JWKSStats) (float64, bool) { return float64(s.Hits), true }},
+	{"robohub_jwks_cache_misses_total", "counter", "Key lookups that had to refresh the JWKS cache.",
+		func(s oidc.JWKSStats) (float64, bool) { return float64(s.Misses), true }},
+	{"robohub_jwks_fetch_attempts_total", "counter", "Requests to the JWKS endpoint, including retries.",
+		func(s oidc.JWKSStats) (float64, bool) { return float64(s.FetchAttempts), true }},
+	{"robohub_jwks_fetch_failures_total", "counter", "Failed requests to the JWKS endpoint.",
+		func(s oidc.JWKSStats) (float64, bool) { return float64(s.FetchFailures), true }},
+	{"robohub_jwks_keys_loaded", "gauge", "Keys in the current JWKS snapshot.",
+		func(s oidc.JWKSStats) (float64, bool) { return float64(s.KeysLoaded), true }},
+	{"robohub_jwks_seconds_since_last_fetch", "gauge", "Seconds since the JWKS was last fetched or revalidated.",
+		func(s oidc.JWKSStats) (float64, bool) { return s.SinceLastFetch.Seconds(), s.Fetched }},
+}
+
+// handleMetrics serves the JWKS cache and policy metrics in the Prometheus
+// text format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	stats := s.verifiers.JWKSStats()
+	providers := s.verifiers.Providers()
+
+	var b strings.Builder
+	for _, m := range jwksMetrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, provider := range providers {
+			for _, cache := range stats[provider] {
+				value, ok := m.value(cache)
+				if !ok {
+					continue
+				}
+				fmt.Fprintf(&b, "%s{provider=%s,issuer=%s} %g\n", m.name, labelValue(provider), labelValue(cache.Issuer), value)
+			}
+		}
+	}
+
+	status := s.policy.Status()
+	fmt.Fprintf(&b, "# HELP robohub_policy_file_info The loaded policy, by version and policy file content hash.\n# TYPE robohub_policy_file_info gauge\n")
+	fmt.Fprintf(&b, "robohub_policy_file_info{version=%s,hash=%s} 1\n", labelValue(status.PolicyVersion), labelValue(status.Hash))
+	fmt.Fprintf(&b, "# HELP robohub_policy_reloads_total Policy file reloads, by result.\n# TYPE robohub_policy_reloads_total counter\n")
+	fmt.Fprintf(&b, "robohub_policy_reloads_total{result=\"success\"} %d\n", status.Reloads)
+	fmt.Fprintf(&b, "robohub_policy_reloads_total{result=\"failure\"} %d\n", status.ReloadFailures)
+	fmt.Fprintf(&b, "# HELP robohub_policy_dry_run_denials_total Denials let through by policy dry-run mode.\n# TYPE robohub_policy_dry_run_denials_total counter\n")
+	fmt.Fprintf(&b, "robohub_policy_dry_run_denials_total %d\n", status.DryRunDenials)
+
+	fmt.Fprintf(&b, "# HELP robohub_github_api_lookups_total GitHub API repository lookups, by result.\n# TYPE robohub_github_api_lookups_total counter\n")
+	fmt.Fprintf(&b, "robohub_github_api_lookups_total{result=\"cache_hit\"} %d\n", status.GitHubLookups.CacheHits)
+	fmt.Fprintf(&b, "robohub_github_api_lookups_total{result=\"success\"} %d\n", status.GitHubLookups.CacheMisses-status.GitHubLookups.Failures)
+	fmt.Fprintf(&b, "robohub_github_api_lookups_total{result=\"failure\"} %d\n", status.GitHubLookups.Failures)
+
+	if s.secretWatcher != nil {
+		stats := s.secretWatcher.Stats()
+		fmt.Fprintf(&b, "# HELP robohub_signing_key_refreshes_total Signing key refreshes from the secret store, by result.\n# TYPE robohub_signing_key_refreshes_total counter\n")
+		fmt.Fprintf(&b, "robohub_signing_key_refreshes_total{result=\"success\"} %d\n", stats.Refreshes-stats.Failures)
+		fmt.Fprintf(&b, "robohub_signing_key_refreshes_total{result=\"failure\"} %d\n", stats.Failures)
+		fmt.Fprintf(&b, "# HELP robohub_signing_key_seconds_since_last_refresh Seconds since the signing key was last read from the secret store.\n# TYPE robohub_signing_key_seconds_since_last_refresh gauge\n")
+		fmt.Fprintf(&b, "robohub_signing_key_seconds_since_last_refresh %g\n", stats.SinceLastSuccess.Seconds())
+	}
+
+	keys := make([]policy.DecisionKey, 0, len(status.Decisions))
+	for key := range status.Decisions {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.Outcome != b.Outcome {
+			return a.Outcome < b.Outcome
+		}
+		if a.Reason != b.Reason {
+			return a.Reason < b.Reason
+		}
+		return a.RuleID < b.RuleID
+	})
+	fmt.Fprintf(&b, "# HELP robohub_policy_decisions_total Token exchange policy decisions, by outcome, reason and rule.\n# TYPE robohub_policy_decisions_total counter\n")
+	for _, key := range keys {
+		fmt.Fprintf(&b, "robohub_policy_decisions_total{outcome=%s,reason=%s,rule_id=%s} %d\n",
+			labelValue(key.Outcome), labelValue(string(key.Reason)), labelValue(key.RuleID), status.Decisions[key])
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// labelValue quotes a label value, escaping as the text format requires
+func labelValue(v string) string {
+	v = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+	return `"` + v + `"`
+}
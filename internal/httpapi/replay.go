@@ -0,0 +1,37 @@
+package httpapi
+
+import (
+	"context"
+	"time"
+
+	"github.com/robohub/auth-service/internal/replay"
+	"github.com/robohub/auth-service/internal/types"
+)
+
+// defaultReplayWindow is how long an OIDC token without an expiry is
+// remembered
+const defaultReplayWindow = time.Hour
+
+// WithReplayStore lets each OIDC token be exchanged only once, rejecting
+// repeats with token_already_used. Tokens are remembered until they expire
+// plus leeway, the verifiers' clock skew allowance.
+func WithReplayStore(store replay.Store, leeway time.Duration) Option {
+	return func(s *Server) {
+		s.replay = store
+		s.replayLeeway = leeway
+	}
+}
+
+// firstExchange records the OIDC token as exchanged and reports whether it
+// had been before. Without a replay store every exchange is a first.
+func (s *Server) firstExchange(ctx context.Context, oidcToken string, claims *types.VerifiedClaims) (bool, error) {
+	if s.replay == nil {
+		return true, nil
+	}
+	jti, _ := claims.Raw["jti"].(string)
+	expiresAt := claims.ExpiresAt
+	if expiresAt.IsZero() {
+		expiresAt = s.clock.Now().Add(defaultReplayWindow)
+	}
+	return s.replay.MarkUsed(ctx, replay.Key(claims.Issuer, jti, oidcToken), expiresAt.Add(s.replayLeeway))
+}
@@ -1,44 +1,116 @@
 package httpapi
 
 import (
+	"crypto"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/robohub/auth-service/internal/clock"
 	"github.com/robohub/auth-service/internal/oidc"
 	"github.com/robohub/auth-service/internal/policy"
 	"github.com/robohub/auth-service/internal/ratelimit"
+	"github.com/robohub/auth-service/internal/replay"
+	"github.com/robohub/auth-service/internal/secrets"
 	"github.com/robohub/auth-service/internal/token"
 	"github.com/robohub/auth-service/internal/types"
 )
 
+// minRequestedTTL is the shortest lifetime a client can ask for; shorter
+// requests get this instead
+const minRequestedTTL = 30 * time.Second
+
 // Server holds the HTTP API server
 type Server struct {
-	router   chi.Router
-	logger   *slog.Logger
-	verifier oidc.Verifier
-	policy   *policy.Enforcer
-	limiter  *ratelimit.Limiter
-	minter   *token.Minter
+	router    chi.Router
+	logger    *slog.Logger
+	verifiers *oidc.VerifierRegistry
+	policy    policy.Evaluator
+	limiter   *ratelimit.Limiter
+	minter    *token.Minter
+
+	// Audit log of policy decisions, disabled when nil
+	audit *slog.Logger
+
+	// Bearer token for the /admin endpoints, which are off when empty
+	adminToken string
+
+	// Whether /readyz waits for every provider's keys to be loaded
+	requireKeys bool
+
+	// Reads the key /admin/keys/rotate rotates to, which is off when nil
+	signingKeySource func() (crypto.Signer, error)
+	secretWatcher    *secrets.Watcher
+
+	// Whether requested scopes the policy does not allow are dropped
+	// instead of denying the exchange
+	dropUnpermittedScopes bool
+
+	// Records the exchanged OIDC tokens, which may then not be exchanged
+	// again; any number of exchanges are allowed when nil
+	replay       replay.Store
+	replayLeeway time.Duration
+
+	// Time source for the claims of policy checks and replay expiry, which
+	// should be the policy enforcer's
+	clock clock.Clock
+}
+
+// Option configures optional Server behavior
+type Option func(*Server)
+
+// WithClock sets the time source policy checks and replay records are timed
+// with. Give the policy enforcer the same clock.
+func WithClock(c clock.Clock) Option {
+	return func(s *Server) {
+		s.clock = c
+	}
 }
 
-// NewServer creates a new HTTP API server
+// WithRequireKeys makes /readyz report not ready until every provider's
+// JWKS cache holds keys, for deployments that warm the caches at startup
+func WithRequireKeys(require bool) Option {
+	return func(s *Server) {
+		s.requireKeys = require
+	}
+}
+
+// WithDropUnpermittedScopes drops the requested scopes the policy does not
+// allow from the token instead of denying the exchange with
+// scope_not_permitted, as long as one requested scope is allowed
+func WithDropUnpermittedScopes(drop bool) Option {
+	return func(s *Server) {
+		s.dropUnpermittedScopes = drop
+	}
+}
+
+// NewServer creates a new HTTP API server, deciding token exchanges with
+// policyEvaluator, usually a *policy.Enforcer
 func NewServer(
 	logger *slog.Logger,
-	verifier oidc.Verifier,
-	policyEnforcer *policy.Enforcer,
+	verifiers *oidc.VerifierRegistry,
+	policyEvaluator policy.Evaluator,
 	limiter *ratelimit.Limiter,
 	minter *token.Minter,
+	opts ...Option,
 ) *Server {
 	s := &Server{
-		logger:   logger,
-		verifier: verifier,
-		policy:   policyEnforcer,
-		limiter:  limiter,
-		minter:   minter,
+		logger:    logger,
+		verifiers: verifiers,
+		policy:    policyEvaluator,
+		limiter:   limiter,
+		minter:    minter,
+		clock:     clock.Real{},
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
 
 	s.router = s.setupRouter()
@@ -50,6 +122,7 @@ func (s *Server) setupRouter() chi.Router {
 
 	// Middleware
 	r.Use(middleware.RequestID)
+	r.Use(s.correlationMiddleware)
 	r.Use(middleware.RealIP)
 	r.Use(s.loggingMiddleware)
 	r.Use(middleware.Recoverer)
@@ -58,7 +131,20 @@ func (s *Server) setupRouter() chi.Router {
 	// Routes
 	r.Get("/healthz", s.handleHealthz)
 	r.Get("/readyz", s.handleReadyz)
+	r.Get("/metrics", s.handleMetrics)
 	r.Post("/auth/github-oidc", s.handleGitHubOIDC)
+	r.Post("/auth/validate", s.handleValidate)
+	r.Get("/.well-known/jwks.json", s.handleJWKS)
+
+	if s.adminToken != "" {
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(s.adminAuth)
+			r.Post("/policy/check", s.handlePolicyCheck)
+			if s.signingKeySource != nil {
+				r.Post("/keys/rotate", s.handleRotateKey)
+			}
+		})
+	}
 
 	return r
 }
@@ -74,8 +160,34 @@ func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write([]byte("ok"))
 }
 
-// handleReadyz handles readiness check requests
+// handleReadyz handles readiness check requests. The service is not ready
+// while a provider's signing keys are stale beyond the max-stale bound, since
+// its tokens can't be verified, and with WithRequireKeys until every
+// provider's keys are loaded.
 func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	var expired, cold []string
+	statuses := s.verifiers.KeyStatus()
+	for _, provider := range s.verifiers.Providers() {
+		if statuses[provider].Expired {
+			expired = append(expired, provider)
+		}
+		if s.requireKeys && statuses[provider].Cold {
+			cold = append(cold, provider)
+		}
+	}
+	if len(cold) > 0 {
+		s.logger.WarnContext(r.Context(), "not ready: JWKS keys not loaded", "providers", cold)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("jwks keys not loaded: " + strings.Join(cold, ", ")))
+		return
+	}
+	if len(expired) > 0 {
+		s.logger.WarnContext(r.Context(), "not ready: JWKS keys expired", "providers", expired)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("jwks keys expired: " + strings.Join(expired, ", ")))
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte("ok"))
 }
@@ -97,20 +209,46 @@ func (s *Server) handleGitHubOIDC(w http.ResponseWriter, r *http.Request) {
 		s.respondError(w, http.StatusBadRequest, "invalid_request", "missing oidc_token field")
 		return
 	}
+	if req.TTLSeconds != nil && *req.TTLSeconds <= 0 {
+		s.logger.WarnContext(ctx, "invalid ttl_seconds", "ttl_seconds", *req.TTLSeconds)
+		s.respondError(w, http.StatusBadRequest, "invalid_request", "ttl_seconds must be positive")
+		return
+	}
+
+	provider := req.Provider
+	if provider == "" {
+		provider = oidc.DefaultProvider
+	}
+	verifier, ok := s.verifiers.Lookup(provider)
+	if !ok {
+		s.logger.WarnContext(ctx, "unknown provider", "provider", provider)
+		s.respondError(w, http.StatusBadRequest, "unknown_provider", fmt.Sprintf("provider %q is not configured", provider))
+		return
+	}
 
 	// Verify OIDC token
-	claims, err := s.verifier.Verify(ctx, req.OIDCToken)
+	claims, err := verifier.Verify(ctx, req.OIDCToken)
 	if err != nil {
-		s.logger.WarnContext(ctx, "failed to verify OIDC token", "error", err)
-		s.respondError(w, http.StatusUnauthorized, "invalid_token", "failed to verify OIDC token")
+		s.logger.WarnContext(ctx, "failed to verify OIDC token", "provider", provider, "error", err)
+		code, message := verificationError(err)
+		s.respondError(w, http.StatusUnauthorized, code, message)
 		return
 	}
 
 	s.logger.InfoContext(ctx, "verified OIDC token",
+		"provider", provider,
+		"issuer", claims.Issuer,
+		"audience", claims.Audience,
 		"repository", claims.Repository,
 		"ref", claims.Ref,
 		"actor", claims.Actor,
 		"run_id", claims.RunID,
+		"event_name", claims.EventName,
+		"base_ref", claims.BaseRef,
+		"head_ref", claims.HeadRef,
+		"runner_environment", claims.RunnerEnvironment,
+		"reusable_workflow", claims.ReusableWorkflow,
+		"sub_template", claims.SubjectTemplate,
 	)
 
 	// Check rate limit
@@ -122,19 +260,139 @@ func (s *Server) handleGitHubOIDC(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check policy
-	if policyErr := s.policy.Evaluate(claims.Repository, claims.Ref); policyErr != nil {
-		s.logger.WarnContext(ctx, "policy violation",
+	// Check policy, which decides the token's scopes and may grant a
+	// different lifetime
+	grant, policyErr := s.policy.Decide(ctx, claims)
+	if policyErr != nil {
+		if policy.IsUnavailable(policyErr) {
+			s.logger.ErrorContext(ctx, "policy decision unavailable",
+				"repository", claims.Repository,
+				"ref", claims.Ref,
+				"error", policyErr,
+			)
+			s.auditDecision(ctx, claims, auditUnavailable, grant, policyErr)
+			s.respondError(w, http.StatusServiceUnavailable, "policy_unavailable", "policy decision is unavailable, try again later")
+			return
+		}
+		level := slog.LevelWarn
+		if errors.Is(policyErr, policy.ErrConditionEvaluation) {
+			level = slog.LevelError
+		}
+		details := policyErrorDetails(policyErr)
+		s.logger.Log(ctx, level, "policy violation",
 			"repository", claims.Repository,
 			"ref", claims.Ref,
+			"reason", details.Reason,
+			"rule_id", details.RuleID,
 			"error", policyErr,
 		)
-		s.respondError(w, http.StatusForbidden, "policy_violation", policyErr.Error())
+		s.auditDecision(ctx, claims, auditDeny, grant, policyErr)
+		s.respondJSON(w, http.StatusForbidden, types.ErrorResponse{
+			Error:   "policy_violation",
+			Message: policyErr.Error(),
+			Details: details,
+		})
+		return
+	}
+
+	var warnings []string
+	if len(req.Scopes) > 0 {
+		allowedScopes := grant.Scopes
+		if len(allowedScopes) == 0 {
+			allowedScopes = s.minter.DefaultScopes()
+		}
+		scopes, unpermitted := narrowScopes(allowedScopes, req.Scopes)
+		if len(unpermitted) > 0 && (!s.dropUnpermittedScopes || len(scopes) == 0) {
+			scopeErr := &policy.DeniedError{Decision: policy.Decision{
+				Reason:        policy.ReasonScopeNotPermitted,
+				Message:       fmt.Sprintf("scopes %s are not permitted for %s", strings.Join(unpermitted, ", "), claims.Repository),
+				PolicyVersion: grant.PolicyVersion,
+			}}
+			s.logger.WarnContext(ctx, "requested scopes not permitted",
+				"repository", claims.Repository,
+				"ref", claims.Ref,
+				"requested_scopes", req.Scopes,
+				"allowed_scopes", allowedScopes,
+			)
+			s.auditDecision(ctx, claims, auditDeny, grant, scopeErr)
+			s.respondJSON(w, http.StatusForbidden, types.ErrorResponse{
+				Error:   "policy_violation",
+				Message: scopeErr.Error(),
+				Details: policyErrorDetails(scopeErr),
+			})
+			return
+		}
+		if len(unpermitted) > 0 {
+			warnings = append(warnings, "requested scopes not permitted and dropped: "+strings.Join(unpermitted, ", "))
+		}
+		grant.Scopes = scopes
+	}
+	if req.TTLSeconds != nil {
+		grant.TTL = shortenTTL(grant.TTL, s.minter.TTL(), *req.TTLSeconds)
+	}
+
+	// Only now that the exchange would succeed does the OIDC token count as
+	// used, so that a request the client can correct does not burn it
+	first, err := s.firstExchange(ctx, req.OIDCToken, claims)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to record OIDC token use",
+			"repository", claims.Repository,
+			"run_id", claims.RunID,
+			"error", err,
+		)
+		s.respondError(w, http.StatusServiceUnavailable, "replay_check_unavailable", "cannot check whether the OIDC token was already used, try again later")
+		return
+	}
+	if !first {
+		s.logger.WarnContext(ctx, "OIDC token already exchanged",
+			"repository", claims.Repository,
+			"ref", claims.Ref,
+			"actor", claims.Actor,
+			"run_id", claims.RunID,
+		)
+		s.respondError(w, http.StatusConflict, "token_already_used", "this OIDC token has already been exchanged; request a new one")
 		return
 	}
 
+	outcome := auditAllow
+	if grant.WouldDeny != nil {
+		outcome = auditWouldDeny
+		warnings = append(warnings, "policy dry run: this token would be denied: "+grant.WouldDeny.Error())
+		details := policyErrorDetails(grant.WouldDeny)
+		s.logger.WarnContext(ctx, "policy violation allowed by dry run",
+			"reason", details.Reason,
+			"rule_id", details.RuleID,
+			"repository", claims.Repository,
+			"ref", claims.Ref,
+			"actor", claims.Actor,
+			"run_id", claims.RunID,
+			"event_name", claims.EventName,
+			"workflow_ref", claims.WorkflowRef,
+			"environment", claims.Environment,
+			"error", grant.WouldDeny,
+		)
+	}
+	auditErr := grant.WouldDeny
+	var reduction *types.ScopeReduction
+	if grant.Reduced != nil {
+		outcome, auditErr = auditReduced, grant.Reduced
+		reduction = scopeReduction(grant)
+		warnings = append(warnings, "token scopes reduced to "+strings.Join(grant.Scopes, ", ")+": "+grant.Reduced.Error())
+		s.logger.InfoContext(ctx, "policy reduced token scopes",
+			"reason", reduction.Reason,
+			"rule_id", reduction.RuleID,
+			"repository", claims.Repository,
+			"ref", claims.Ref,
+			"scopes", grant.Scopes,
+			"withheld_scopes", grant.Withheld,
+		)
+	}
+	s.auditDecision(ctx, claims, outcome, grant, auditErr)
+
 	// Mint access token
-	accessToken, expiresAt, err := s.minter.Mint(claims)
+	policyVersion := s.policyVersion(grant, nil)
+	mintOpts, scopes := s.mintOptions(grant, policyVersion)
+	accessToken, expiresAt, err := s.minter.Mint(claims, mintOpts...)
 	if err != nil {
 		s.logger.ErrorContext(ctx, "failed to mint token", "error", err)
 		s.respondError(w, http.StatusInternalServerError, "internal_error", "failed to create access token")
@@ -147,25 +405,208 @@ func (s *Server) handleGitHubOIDC(w http.ResponseWriter, r *http.Request) {
 		AccessToken: accessToken,
 		ExpiresIn:   expiresIn,
 		TokenType:   "Bearer",
+		Scope:       strings.Join(scopes, " "),
 		IssuedAt:    time.Now().Format(time.RFC3339),
 		Subject: types.SubjectDetails{
-			Provider:   "github_actions",
-			Repository: claims.Repository,
-			Ref:        claims.Ref,
-			Workflow:   claims.Workflow,
-			RunID:      claims.RunID,
-			Actor:      claims.Actor,
+			Provider:       claims.Provider,
+			Repository:     claims.Repository,
+			Ref:            claims.Ref,
+			RefType:        claims.RefType,
+			BaseRef:        claims.BaseRef,
+			HeadRef:        claims.HeadRef,
+			Workflow:       claims.Workflow,
+			WorkflowRef:    claims.WorkflowRef,
+			JobWorkflowRef: claims.JobWorkflowRef,
+			RunID:          claims.RunID,
+			Actor:          claims.Actor,
+			Environment:    claims.Environment,
+			EventName:      claims.EventName,
+			SHA:            claims.SHA,
 		},
+		Warnings:       warnings,
+		ScopeReduction: reduction,
 	}
 
 	s.logger.InfoContext(ctx, "issued access token",
+		"issuer", claims.Issuer,
+		"audience", claims.Audience,
 		"repository", claims.Repository,
+		"run_id", claims.RunID,
+		"run_attempt", claims.RunAttempt,
+		"event_name", claims.EventName,
+		"workflow_ref", claims.WorkflowRef,
+		"job_workflow_ref", claims.JobWorkflowRef,
+		"reusable_workflow", claims.ReusableWorkflow,
+		"sub_template", claims.SubjectTemplate,
+		"policy_outcome", outcome,
+		"policy_version", policyVersion,
 		"expires_in", expiresIn,
 	)
 
 	s.respondJSON(w, http.StatusOK, resp)
 }
 
+// policyErrorDetails returns the reason code and rule of a policy denial
+func policyErrorDetails(err error) *types.ErrorDetails {
+	var denied *policy.DeniedError
+	if !errors.As(err, &denied) {
+		return &types.ErrorDetails{Reason: "unknown"}
+	}
+	return &types.ErrorDetails{Reason: string(denied.Decision.Reason), RuleID: denied.Decision.RuleID}
+}
+
+// mintOptions builds the options of the token grant allows, which take
+// precedence over the minter's defaults, and returns its scopes
+func (s *Server) mintOptions(grant policy.Grant, policyVersion string) ([]token.MintOption, []string) {
+	scopes := grant.Scopes
+	if len(scopes) == 0 {
+		scopes = s.minter.DefaultScopes()
+	}
+	opts := []token.MintOption{token.WithScopes(scopes...)}
+	if grant.TTL > 0 {
+		opts = append(opts, token.WithTTL(grant.TTL))
+	}
+	if policyVersion != "" {
+		opts = append(opts, token.WithPolicyVersion(policyVersion))
+	}
+	return opts, scopes
+}
+
+// narrowScopes returns the requested scopes allowed holds, in the order
+// requested and without duplicates, and those it does not
+func narrowScopes(allowed, requested []string) (scopes, unpermitted []string) {
+	for _, scope := range requested {
+		switch {
+		case slices.Contains(scopes, scope) || slices.Contains(unpermitted, scope):
+		case slices.Contains(allowed, scope):
+			scopes = append(scopes, scope)
+		default:
+			unpermitted = append(unpermitted, scope)
+		}
+	}
+	return scopes, unpermitted
+}
+
+// shortenTTL returns the lifetime of a token the client asked to live
+// seconds: no longer than the policy's TTL, or the minter's default without
+// one, and no shorter than minRequestedTTL unless that is
+func shortenTTL(policyTTL, defaultTTL time.Duration, seconds int) time.Duration {
+	allowed := policyTTL
+	if allowed <= 0 {
+		allowed = defaultTTL
+	}
+	// Compare in seconds, which cannot overflow
+	if int64(seconds) >= int64(allowed/time.Second) {
+		return allowed
+	}
+	return min(allowed, max(minRequestedTTL, time.Duration(seconds)*time.Second))
+}
+
+// scopeReduction describes the grant's reduced scopes to the caller
+func scopeReduction(grant policy.Grant) *types.ScopeReduction {
+	details := policyErrorDetails(grant.Reduced)
+	return &types.ScopeReduction{
+		Scopes:   grant.Scopes,
+		Withheld: grant.Withheld,
+		Reason:   details.Reason,
+		RuleID:   details.RuleID,
+		Message:  grant.Reduced.Error(),
+	}
+}
+
+// verificationError maps a token verification failure to a stable error code
+// and a message that is safe to return to the client. The wrapped error can
+// name expected issuers and audiences, so only the log carries it.
+func verificationError(err error) (code, message string) {
+	var subErr *oidc.GitHubSubjectError
+	var workflowErr *oidc.GitHubWorkflowError
+	var claimErr *oidc.MissingClaimError
+	switch {
+	case errors.Is(err, oidc.ErrUntrustedIssuer):
+		return "untrusted_issuer", "OIDC token was not issued by a trusted issuer"
+	case errors.As(err, &subErr):
+		return "subject_mismatch", "OIDC token sub claim does not match its repository, ref or environment"
+	case errors.As(err, &workflowErr):
+		return "workflow_mismatch", "OIDC token workflow_ref does not belong to its repository"
+	case errors.Is(err, oidc.ErrTokenNotYetValid):
+		return "token_not_yet_valid", "OIDC token is not valid yet; check the clock of the machine that requested it"
+	case errors.Is(err, oidc.ErrTokenLifetimeTooLong):
+		return "token_lifetime_too_long", "OIDC token is valid for longer than allowed"
+	case errors.Is(err, oidc.ErrTokenTooOld):
+		return "token_too_old", "OIDC token is too old; request a fresh token for each job"
+	case errors.Is(err, oidc.ErrExpired):
+		return "token_expired", "OIDC token has expired"
+	case errors.Is(err, oidc.ErrSignature):
+		return "invalid_signature", "OIDC token signature is invalid"
+	case errors.Is(err, oidc.ErrIssuerMismatch):
+		return "issuer_mismatch", "OIDC token was issued for a different issuer"
+	case errors.Is(err, oidc.ErrAudienceMismatch):
+		return "audience_mismatch", "OIDC token audience is not accepted"
+	case errors.As(err, &claimErr):
+		return "missing_claim", fmt.Sprintf("OIDC token is missing the %s claim", claimErr.Name)
+	default:
+		return "invalid_token", "failed to verify OIDC token"
+	}
+}
+
+// validationError maps an access token validation error to the reason in the
+// response details and a message for the client
+func validationError(err error) (reason, message string) {
+	reason = token.Reason(err)
+	switch reason {
+	case "token_expired":
+		return reason, "access token has expired; exchange a new OIDC token"
+	case "token_revoked":
+		return reason, "access token has been revoked"
+	case "invalid_signature":
+		return reason, "access token signature is invalid"
+	case "malformed_token":
+		return reason, "access token is malformed or was not issued for this service"
+	default:
+		return "invalid_token", "access token is invalid"
+	}
+}
+
+// handleValidate validates a RoboHub access token presented as a Bearer token
+// and returns its claims
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	tokenString, ok := bearerToken(r)
+	if !ok {
+		s.respondError(w, http.StatusUnauthorized, "invalid_request", "missing bearer token")
+		return
+	}
+
+	claims, err := s.minter.Validate(tokenString)
+	if err != nil {
+		reason, message := validationError(err)
+		level := slog.LevelWarn
+		if reason == "invalid_signature" || reason == "malformed_token" {
+			// Not a token this service issued, or one tampered with
+			level = slog.LevelError
+		}
+		s.logger.Log(ctx, level, "failed to validate access token", "reason", reason, "error", err)
+		s.respondJSON(w, http.StatusUnauthorized, types.ErrorResponse{
+			Error:   "invalid_token",
+			Message: message,
+			Details: &types.ErrorDetails{Reason: reason},
+		})
+		return
+	}
+
+	s.respondJSON(w, http.StatusOK, claims)
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, tokenString, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || tokenString == "" {
+		return "", false
+	}
+	return tokenString, true
+}
+
 func (s *Server) respondJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
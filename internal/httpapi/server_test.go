@@ -3,18 +3,31 @@ package httpapi
 import (
 	"bytes"
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/robohub/auth-service/internal/clock"
+	"github.com/robohub/auth-service/internal/correlation"
 	"github.com/robohub/auth-service/internal/oidc"
 	"github.com/robohub/auth-service/internal/policy"
 	"github.com/robohub/auth-service/internal/ratelimit"
+	"github.com/robohub/auth-service/internal/replay"
+	"github.com/robohub/auth-service/internal/secrets"
+	"github.com/robohub/auth-service/internal/testutil"
 	"github.com/robohub/auth-service/internal/token"
 	"github.com/robohub/auth-service/internal/types"
 )
@@ -53,6 +66,237 @@ func TestHandleReadyz(t *testing.T) {
 	}
 }
 
+// staleVerifier reports fixed key staleness
+type staleVerifier struct {
+	oidc.FakeVerifier
+	status oidc.KeyStatus
+}
+
+func (v *staleVerifier) KeyStatus() oidc.KeyStatus { return v.status }
+
+func TestHandleReadyz_StaleKeys(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     oidc.KeyStatus
+		wantStatus int
+	}{
+		{"fresh keys", oidc.KeyStatus{}, http.StatusOK},
+		{"stale within bound", oidc.KeyStatus{Staleness: 10 * time.Minute}, http.StatusOK},
+		{"expired keys", oidc.KeyStatus{Staleness: 2 * time.Hour, Expired: true}, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer()
+			server.verifiers.Register("buildkite", &staleVerifier{status: tt.status})
+
+			req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+			w := httptest.NewRecorder()
+
+			server.Handler().ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+// statsVerifier reports fixed JWKS cache stats
+type statsVerifier struct {
+	oidc.FakeVerifier
+	stats []oidc.JWKSStats
+}
+
+func (v *statsVerifier) JWKSStats() []oidc.JWKSStats { return v.stats }
+
+func TestHandleReadyz_ColdKeys(t *testing.T) {
+	tests := []struct {
+		name        string
+		requireKeys bool
+		status      oidc.KeyStatus
+		wantStatus  int
+	}{
+		{"warm-up succeeded", true, oidc.KeyStatus{}, http.StatusOK},
+		{"keys not loaded yet", true, oidc.KeyStatus{Cold: true}, http.StatusServiceUnavailable},
+		{"warm-up disabled", false, oidc.KeyStatus{Cold: true}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer()
+			WithRequireKeys(tt.requireKeys)(server)
+			server.verifiers.Register("buildkite", &staleVerifier{status: tt.status})
+
+			req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+			w := httptest.NewRecorder()
+
+			server.Handler().ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandleMetrics(t *testing.T) {
+	server := newTestServer()
+	enforcer := policy.NewEnforcer(false, "main", nil, nil, policy.WithPolicyVersion("env:0123456789ab"))
+	server.policy = enforcer
+	server.verifiers.Register("github", &statsVerifier{stats: []oidc.JWKSStats{
+		{
+			Issuer:         "https://token.actions.githubusercontent.com",
+			Hits:           42,
+			Misses:         3,
+			FetchAttempts:  4,
+			FetchFailures:  1,
+			KeysLoaded:     2,
+			SinceLastFetch: 90 * time.Second,
+			Fetched:        true,
+		},
+		{Issuer: "https://ghes.example.com/_services/token", Misses: 1, FetchAttempts: 1, FetchFailures: 1},
+	}})
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+
+	server.Handler().ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	body := w.Body.String()
+	for _, want := range []string{
+		"# TYPE robohub_jwks_cache_hits_total counter",
+		`robohub_jwks_cache_hits_total{provider="github",issuer="https://token.actions.githubusercontent.com"} 42`,
+		`robohub_jwks_cache_misses_total{provider="github",issuer="https://token.actions.githubusercontent.com"} 3`,
+		`robohub_jwks_fetch_attempts_total{provider="github",issuer="https://token.actions.githubusercontent.com"} 4`,
+		`robohub_jwks_fetch_failures_total{provider="github",issuer="https://ghes.example.com/_services/token"} 1`,
+		`robohub_jwks_keys_loaded{provider="github",issuer="https://token.actions.githubusercontent.com"} 2`,
+		"# TYPE robohub_jwks_seconds_since_last_fetch gauge",
+		`robohub_jwks_seconds_since_last_fetch{provider="github",issuer="https://token.actions.githubusercontent.com"} 90`,
+		"# TYPE robohub_policy_reloads_total counter",
+		`robohub_policy_reloads_total{result="success"} 0`,
+		`robohub_policy_reloads_total{result="failure"} 0`,
+		"# TYPE robohub_policy_dry_run_denials_total counter",
+		"robohub_policy_dry_run_denials_total 0",
+		"# TYPE robohub_policy_decisions_total counter",
+		`robohub_github_api_lookups_total{result="cache_hit"} 0`,
+		`robohub_github_api_lookups_total{result="failure"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+
+	// Without a policy file the version is the settings hash and there is no
+	// policy file hash
+	if want := `robohub_policy_file_info{version="env:0123456789ab",hash=""} 1`; !strings.Contains(body, want) {
+		t.Errorf("expected metrics to contain %q, got:\n%s", want, body)
+	}
+
+	// Keys never fetched have no age
+	if strings.Contains(body, `robohub_jwks_seconds_since_last_fetch{provider="github",issuer="https://ghes.example.com/_services/token"}`) {
+		t.Errorf("expected no last fetch age for an issuer never fetched, got:\n%s", body)
+	}
+
+	t.Run("policy file", func(t *testing.T) {
+		policyPath := filepath.Join(t.TempDir(), "policy.json")
+		if err := os.WriteFile(policyPath, []byte(`{"version": "2026.10.3"}`), 0600); err != nil {
+			t.Fatalf("failed to write policy file: %v", err)
+		}
+		f, err := enforcer.Reload(policyPath)
+		if err != nil {
+			t.Fatalf("failed to load policy file: %v", err)
+		}
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if want := `robohub_policy_file_info{version="2026.10.3",hash="` + f.Hash + `"} 1`; !strings.Contains(w.Body.String(), want) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", want, w.Body.String())
+		}
+	})
+}
+
+// failingProvider is a secret store that cannot be reached
+type failingProvider struct{}
+
+func (failingProvider) Fetch(context.Context) ([]byte, error) {
+	return nil, fmt.Errorf("vault sealed")
+}
+
+func TestHandleMetrics_SigningKeyRefreshes(t *testing.T) {
+	watcher := secrets.NewWatcher(failingProvider{}, func([]byte) error { return nil }, time.Second, slog.Default())
+	for i := 0; i < 2; i++ {
+		_ = watcher.Refresh(context.Background())
+	}
+
+	server := newTestServer()
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if strings.Contains(w.Body.String(), "robohub_signing_key_refreshes_total") {
+		t.Errorf("expected no refresh metrics without a secret store, got:\n%s", w.Body.String())
+	}
+
+	WithSecretWatcher(watcher)(server)
+	w = httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		"# TYPE robohub_signing_key_refreshes_total counter",
+		`robohub_signing_key_refreshes_total{result="success"} 0`,
+		`robohub_signing_key_refreshes_total{result="failure"} 2`,
+		"# TYPE robohub_signing_key_seconds_since_last_refresh gauge",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestHandleMetrics_PolicyDecisions(t *testing.T) {
+	server := newTestServer()
+	server.policy = policy.NewEnforcer(false, "main", nil, nil, policy.WithRepoEventRules(map[string]policy.EventRule{
+		"test/repo": {Deny: []string{"schedule"}},
+	}))
+	server.router = server.setupRouter()
+
+	exchange := func(eventName string) {
+		t.Helper()
+		server.verifiers = newTestRegistry(&oidc.FakeVerifier{VerifyFunc: func(ctx context.Context, token string) (*types.VerifiedClaims, error) {
+			return &types.VerifiedClaims{Repository: "test/repo", Ref: "refs/heads/main", EventName: eventName}, nil
+		}})
+		req := httptest.NewRequest(http.MethodPost, "/auth/github-oidc", bytes.NewBufferString(`{"oidc_token": "valid-token"}`))
+		server.Handler().ServeHTTP(httptest.NewRecorder(), req)
+	}
+	metrics := func() string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return w.Body.String()
+	}
+
+	exchange("push")
+	exchange("schedule")
+	exchange("push")
+
+	body := metrics()
+	for _, want := range []string{
+		`robohub_policy_decisions_total{outcome="allow",reason="allowed",rule_id=""} 2`,
+		`robohub_policy_decisions_total{outcome="deny",reason="event_not_allowed",rule_id="event_rule:test/repo"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+
+	exchange("schedule")
+	if want := `robohub_policy_decisions_total{outcome="deny",reason="event_not_allowed",rule_id="event_rule:test/repo"} 2`; !strings.Contains(metrics(), want) {
+		t.Errorf("expected the denial counter to move to %q", want)
+	}
+}
+
 func TestHandleGitHubOIDC(t *testing.T) {
 	t.Run("missing oidc_token", func(t *testing.T) {
 		server := newTestServer()
@@ -130,15 +374,200 @@ func TestHandleGitHubOIDC(t *testing.T) {
 		}
 	})
 
+	t.Run("provenance claims", func(t *testing.T) {
+		server := newTestServer()
+		server.verifiers = newTestRegistry(&oidc.FakeVerifier{
+			VerifyFunc: func(ctx context.Context, token string) (*types.VerifiedClaims, error) {
+				return &types.VerifiedClaims{
+					Provider:   oidc.ProviderGitHub,
+					Repository: "test/repo",
+					Ref:        "refs/heads/main",
+					SHA:        "ffac537e6cbbf934b08745a378932722df287a53",
+					Actor:      "testuser",
+					RunID:      "123456789",
+					Workflow:   "test/repo/.github/workflows/release.yml@refs/heads/main",
+					EventName:  "workflow_dispatch",
+					IssuedAt:   time.Now(),
+					ExpiresAt:  time.Now().Add(time.Hour),
+				}, nil
+			},
+		})
+		server.router = server.setupRouter()
+
+		body := bytes.NewBufferString(`{"oidc_token": "valid-token"}`)
+		req := httptest.NewRequest(http.MethodPost, "/auth/github-oidc", body)
+		w := httptest.NewRecorder()
+
+		server.Handler().ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp types.AuthResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		claims, err := server.minter.Validate(resp.AccessToken)
+		if err != nil {
+			t.Fatalf("failed to validate access token: %v", err)
+		}
+		if claims.Workflow != "test/repo/.github/workflows/release.yml@refs/heads/main" {
+			t.Errorf("unexpected workflow claim: %s", claims.Workflow)
+		}
+		if claims.SHA != "ffac537e6cbbf934b08745a378932722df287a53" {
+			t.Errorf("unexpected sha claim: %s", claims.SHA)
+		}
+		if claims.EventName != "workflow_dispatch" {
+			t.Errorf("expected event_name workflow_dispatch, got %s", claims.EventName)
+		}
+	})
+
+	t.Run("pull request refs in subject", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			baseRef string
+			headRef string
+		}{
+			{"pull request", "main", "feature/login"},
+			{"push", "", ""},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				server := newTestServer()
+				server.verifiers = newTestRegistry(&oidc.FakeVerifier{
+					VerifyFunc: func(ctx context.Context, token string) (*types.VerifiedClaims, error) {
+						return &types.VerifiedClaims{
+							Provider:   oidc.ProviderGitHub,
+							Repository: "test/repo",
+							Ref:        "refs/pull/42/merge",
+							BaseRef:    tt.baseRef,
+							HeadRef:    tt.headRef,
+						}, nil
+					},
+				})
+
+				body := bytes.NewBufferString(`{"oidc_token": "valid-token"}`)
+				req := httptest.NewRequest(http.MethodPost, "/auth/github-oidc", body)
+				w := httptest.NewRecorder()
+
+				server.Handler().ServeHTTP(w, req)
+
+				var resp struct {
+					Subject map[string]interface{} `json:"subject"`
+				}
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				for key, want := range map[string]string{"base_ref": tt.baseRef, "head_ref": tt.headRef} {
+					got, ok := resp.Subject[key]
+					if want == "" && ok {
+						t.Errorf("expected %s to be omitted, got %v", key, got)
+					}
+					if want != "" && got != want {
+						t.Errorf("expected %s %q, got %v", key, want, got)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("workflow refs in subject", func(t *testing.T) {
+		server := newTestServer()
+		server.verifiers = newTestRegistry(&oidc.FakeVerifier{
+			VerifyFunc: func(ctx context.Context, token string) (*types.VerifiedClaims, error) {
+				return &types.VerifiedClaims{
+					Provider:       oidc.ProviderGitHub,
+					Repository:     "test/repo",
+					Ref:            "refs/heads/main",
+					Workflow:       "test/repo/.github/workflows/ci.yml@refs/heads/main",
+					WorkflowRef:    "test/repo/.github/workflows/ci.yml@refs/heads/main",
+					JobWorkflowRef: "shared/workflows/.github/workflows/deploy.yml@refs/tags/v1",
+				}, nil
+			},
+		})
+
+		body := bytes.NewBufferString(`{"oidc_token": "valid-token"}`)
+		req := httptest.NewRequest(http.MethodPost, "/auth/github-oidc", body)
+		w := httptest.NewRecorder()
+
+		server.Handler().ServeHTTP(w, req)
+
+		var resp types.AuthResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		// workflow keeps its pre-existing value for older clients
+		if resp.Subject.Workflow != "test/repo/.github/workflows/ci.yml@refs/heads/main" {
+			t.Errorf("unexpected workflow: %s", resp.Subject.Workflow)
+		}
+		if resp.Subject.WorkflowRef != "test/repo/.github/workflows/ci.yml@refs/heads/main" {
+			t.Errorf("unexpected workflow_ref: %s", resp.Subject.WorkflowRef)
+		}
+		if resp.Subject.JobWorkflowRef != "shared/workflows/.github/workflows/deploy.yml@refs/tags/v1" {
+			t.Errorf("unexpected job_workflow_ref: %s", resp.Subject.JobWorkflowRef)
+		}
+	})
+
 	t.Run("policy denied", func(t *testing.T) {
 		// Create server with deny policy
+		var logs bytes.Buffer
 		policyEnforcer := policy.NewEnforcer(false, "main", nil, []string{"test/repo"})
 		server := &Server{
-			logger:   slog.New(slog.NewTextHandler(os.Stderr, nil)),
-			verifier: &oidc.FakeVerifier{},
-			policy:   policyEnforcer,
-			limiter:  ratelimit.NewLimiter(10.0, 10),
-			minter:   token.NewMinter("test-secret", 10*time.Minute),
+			logger:    slog.New(slog.NewJSONHandler(&logs, nil)),
+			verifiers: newTestRegistry(&oidc.FakeVerifier{}),
+			policy:    policyEnforcer,
+			limiter:   ratelimit.NewLimiter(10.0, 10),
+			minter:    token.NewMinter("test-secret", 10*time.Minute),
+		}
+		server.router = server.setupRouter()
+
+		body := bytes.NewBufferString(`{"oidc_token": "valid-token"}`)
+		req := httptest.NewRequest(http.MethodPost, "/auth/github-oidc", body)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		server.Handler().ServeHTTP(w, req)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("expected status 403, got %d", w.Code)
+		}
+
+		var errResp types.ErrorResponse
+		json.NewDecoder(w.Body).Decode(&errResp)
+		if errResp.Error != "policy_violation" {
+			t.Errorf("expected error 'policy_violation', got %s", errResp.Error)
+		}
+		want := &types.ErrorDetails{Reason: "repo_denied", RuleID: "repo_denylist"}
+		if !reflect.DeepEqual(errResp.Details, want) {
+			t.Errorf("expected details %+v, got %+v", want, errResp.Details)
+		}
+		for _, want := range []string{`"reason":"repo_denied"`, `"rule_id":"repo_denylist"`} {
+			if !strings.Contains(logs.String(), want) {
+				t.Errorf("expected logs to contain %s, got:\n%s", want, logs.String())
+			}
+		}
+	})
+
+	t.Run("pull_request event denied", func(t *testing.T) {
+		server := &Server{
+			logger: slog.New(slog.NewTextHandler(os.Stderr, nil)),
+			verifiers: newTestRegistry(&oidc.FakeVerifier{
+				VerifyFunc: func(ctx context.Context, token string) (*types.VerifiedClaims, error) {
+					return &types.VerifiedClaims{
+						Repository: "test/repo",
+						Ref:        "refs/pull/42/merge",
+						Actor:      "testuser",
+						RunID:      "123456789",
+						EventName:  "pull_request",
+						IssuedAt:   time.Now(),
+						ExpiresAt:  time.Now().Add(1 * time.Hour),
+					}, nil
+				},
+			}),
+			policy:  policy.NewEnforcer(false, "main", nil, nil, policy.WithEventRule(policy.EventRule{Deny: []string{"pull_request", "schedule"}})),
+			limiter: ratelimit.NewLimiter(10.0, 10),
+			minter:  token.NewMinter("test-secret", 10*time.Minute),
 		}
 		server.router = server.setupRouter()
 
@@ -158,17 +587,291 @@ func TestHandleGitHubOIDC(t *testing.T) {
 		if errResp.Error != "policy_violation" {
 			t.Errorf("expected error 'policy_violation', got %s", errResp.Error)
 		}
+		want := &types.ErrorDetails{Reason: "event_not_allowed", RuleID: "event_rule"}
+		if !reflect.DeepEqual(errResp.Details, want) {
+			t.Errorf("expected details %+v, got %+v", want, errResp.Details)
+		}
+	})
+
+	t.Run("repository visibility", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			visibility string
+			denyNone   bool
+			wantStatus int
+		}{
+			{"public repository denied", "public", false, http.StatusForbidden},
+			{"internal repository allowed", "internal", false, http.StatusOK},
+			{"missing claim skipped", "", false, http.StatusOK},
+			{"missing claim denied", "", true, http.StatusForbidden},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				server := newTestServer()
+				server.verifiers = newTestRegistry(&oidc.FakeVerifier{
+					VerifyFunc: func(ctx context.Context, token string) (*types.VerifiedClaims, error) {
+						return &types.VerifiedClaims{
+							Repository: "test/repo",
+							Ref:        "refs/heads/main",
+							Visibility: tt.visibility,
+							IssuedAt:   time.Now(),
+							ExpiresAt:  time.Now().Add(1 * time.Hour),
+						}, nil
+					},
+				})
+				// The allowlist matches by name, which a public fork can share
+				server.policy = policy.NewEnforcer(false, "main", []string{"test/repo"}, nil,
+					policy.WithAllowedVisibilities([]string{"private", "internal"}),
+					policy.WithDenyMissingVisibility(tt.denyNone),
+				)
+				server.router = server.setupRouter()
+
+				body := bytes.NewBufferString(`{"oidc_token": "valid-token"}`)
+				req := httptest.NewRequest(http.MethodPost, "/auth/github-oidc", body)
+				w := httptest.NewRecorder()
+
+				server.Handler().ServeHTTP(w, req)
+
+				if w.Code != tt.wantStatus {
+					t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+				}
+				if tt.wantStatus != http.StatusForbidden {
+					return
+				}
+				var errResp types.ErrorResponse
+				json.NewDecoder(w.Body).Decode(&errResp)
+				want := &types.ErrorDetails{Reason: "visibility_not_allowed", RuleID: "visibility"}
+				if errResp.Error != "policy_violation" || !reflect.DeepEqual(errResp.Details, want) {
+					t.Errorf("expected a visibility_not_allowed policy_violation, got %+v", errResp)
+				}
+			})
+		}
+	})
+
+	t.Run("policy dry run", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			dryRun     bool
+			wantStatus int
+		}{
+			{"enforced", false, http.StatusForbidden},
+			{"dry run", true, http.StatusOK},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				var logs bytes.Buffer
+				server := newTestServer()
+				server.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+				server.policy = policy.NewEnforcer(false, "main", []string{"other/repo"}, nil, policy.WithDryRun(tt.dryRun))
+				server.router = server.setupRouter()
+
+				body := bytes.NewBufferString(`{"oidc_token": "valid-token"}`)
+				req := httptest.NewRequest(http.MethodPost, "/auth/github-oidc", body)
+				req.Header.Set("Content-Type", "application/json")
+				w := httptest.NewRecorder()
+
+				server.Handler().ServeHTTP(w, req)
+
+				if w.Code != tt.wantStatus {
+					t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+				}
+				if !tt.dryRun {
+					var errResp types.ErrorResponse
+					json.NewDecoder(w.Body).Decode(&errResp)
+					if errResp.Error != "policy_violation" || errResp.Details == nil || errResp.Details.Reason != "not_in_allowlist" {
+						t.Errorf("expected a not_in_allowlist policy_violation, got %+v", errResp)
+					}
+					return
+				}
+
+				var resp types.AuthResponse
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				if resp.AccessToken == "" {
+					t.Error("expected non-empty access_token")
+				}
+				if len(resp.Warnings) != 1 {
+					t.Errorf("expected a dry-run warning, got %v", resp.Warnings)
+				}
+				for _, want := range []string{`"msg":"policy violation allowed by dry run"`, `"reason":"not_in_allowlist"`, `"policy_outcome":"would_deny"`} {
+					if !strings.Contains(logs.String(), want) {
+						t.Errorf("expected logs to contain %s, got:\n%s", want, logs.String())
+					}
+				}
+				if got := server.policy.Status().DryRunDenials; got != 1 {
+					t.Errorf("expected 1 dry-run denial, got %d", got)
+				}
+			})
+		}
+	})
+
+	t.Run("token TTL by repository", func(t *testing.T) {
+		repoTTLs := map[string]time.Duration{
+			"myorg/signing": 2 * time.Minute,
+			"myorg/docs-*":  30 * time.Minute,
+			"myorg/slow":    3 * time.Hour,
+		}
+		tests := []struct {
+			repository string
+			want       time.Duration
+		}{
+			{"myorg/signing", 2 * time.Minute},
+			{"myorg/docs-site", 30 * time.Minute},
+			{"myorg/slow", time.Hour},
+			{"myorg/robot", 10 * time.Minute},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.repository, func(t *testing.T) {
+				now := time.Now()
+				server := newTestServer()
+				server.verifiers = newTestRegistry(&oidc.FakeVerifier{
+					VerifyFunc: func(ctx context.Context, token string) (*types.VerifiedClaims, error) {
+						return &types.VerifiedClaims{
+							Repository: tt.repository,
+							Ref:        "refs/heads/main",
+							IssuedAt:   now,
+							ExpiresAt:  now.Add(1 * time.Hour),
+						}, nil
+					},
+				})
+				server.policy = policy.NewEnforcer(false, "main", nil, nil, policy.WithRepoTTLs(repoTTLs), policy.WithMaxTokenTTL(time.Hour))
+				server.minter = token.NewMinter("test-secret", 10*time.Minute, token.WithClock(testutil.NewFakeClock(now)))
+				server.router = server.setupRouter()
+
+				body := bytes.NewBufferString(`{"oidc_token": "valid-token"}`)
+				req := httptest.NewRequest(http.MethodPost, "/auth/github-oidc", body)
+				w := httptest.NewRecorder()
+
+				server.Handler().ServeHTTP(w, req)
+
+				if w.Code != http.StatusOK {
+					t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+				}
+				var resp types.AuthResponse
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				claims, err := server.minter.Validate(resp.AccessToken)
+				if err != nil {
+					t.Fatalf("failed to validate access token: %v", err)
+				}
+				if got := time.Duration(claims.ExpiresAt-claims.IssuedAt) * time.Second; got != tt.want {
+					t.Errorf("expected exp %s after iat, got %s", tt.want, got)
+				}
+				// expires_in counts down from the real clock, so allow a second
+				if diff := tt.want - time.Duration(resp.ExpiresIn)*time.Second; diff < 0 || diff > 2*time.Second {
+					t.Errorf("expected expires_in of about %s, got %ds", tt.want, resp.ExpiresIn)
+				}
+			})
+		}
+	})
+
+	t.Run("OPA unavailable", func(t *testing.T) {
+		opa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer opa.Close()
+
+		server := newTestServer()
+		server.policy = policy.NewEnforcer(false, "main", nil, nil, policy.WithOPA(policy.NewOPAClient(opa.URL, time.Second), false))
+		server.router = server.setupRouter()
+
+		body := bytes.NewBufferString(`{"oidc_token": "valid-token"}`)
+		req := httptest.NewRequest(http.MethodPost, "/auth/github-oidc", body)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		server.Handler().ServeHTTP(w, req)
+
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("expected status 503, got %d", w.Code)
+		}
+
+		var errResp types.ErrorResponse
+		json.NewDecoder(w.Body).Decode(&errResp)
+		if errResp.Error != "policy_unavailable" {
+			t.Errorf("expected error 'policy_unavailable', got %s", errResp.Error)
+		}
+	})
+
+	t.Run("scopes by repository", func(t *testing.T) {
+		repoScopes := map[string][]string{
+			"myorg/docs-*":   {"ingest:docs"},
+			"myorg/firmware": {"ingest:build", "artifact:sign"},
+		}
+		tests := []struct {
+			repository string
+			want       []string
+		}{
+			{"myorg/docs-site", []string{"ingest:docs"}},
+			{"myorg/firmware", []string{"ingest:build", "artifact:sign"}},
+			{"myorg/robot", []string{"ingest:build"}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.repository, func(t *testing.T) {
+				server := &Server{
+					logger: slog.New(slog.NewTextHandler(os.Stderr, nil)),
+					verifiers: newTestRegistry(&oidc.FakeVerifier{
+						VerifyFunc: func(ctx context.Context, token string) (*types.VerifiedClaims, error) {
+							return &types.VerifiedClaims{
+								Repository: tt.repository,
+								Ref:        "refs/heads/main",
+								Actor:      "testuser",
+								RunID:      "123456789",
+								IssuedAt:   time.Now(),
+								ExpiresAt:  time.Now().Add(1 * time.Hour),
+							}, nil
+						},
+					}),
+					policy:  policy.NewEnforcer(false, "main", nil, nil, policy.WithRepoScopes(repoScopes)),
+					limiter: ratelimit.NewLimiter(10.0, 10),
+					minter:  token.NewMinter("test-secret", 10*time.Minute),
+				}
+				server.router = server.setupRouter()
+
+				body := bytes.NewBufferString(`{"oidc_token": "valid-token"}`)
+				req := httptest.NewRequest(http.MethodPost, "/auth/github-oidc", body)
+				req.Header.Set("Content-Type", "application/json")
+				w := httptest.NewRecorder()
+
+				server.Handler().ServeHTTP(w, req)
+
+				if w.Code != http.StatusOK {
+					t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+				}
+
+				var resp types.AuthResponse
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				claims, err := server.minter.Validate(resp.AccessToken)
+				if err != nil {
+					t.Fatalf("failed to validate access token: %v", err)
+				}
+				if !reflect.DeepEqual(claims.Scopes, tt.want) {
+					t.Errorf("expected scopes %v, got %v", tt.want, claims.Scopes)
+				}
+				if want := strings.Join(tt.want, " "); resp.Scope != want {
+					t.Errorf("expected scope %q, got %q", want, resp.Scope)
+				}
+			})
+		}
 	})
 
 	t.Run("rate limited", func(t *testing.T) {
 		// Create server with very restrictive rate limit
 		limiter := ratelimit.NewLimiter(1.0, 1)
 		server := &Server{
-			logger:   slog.New(slog.NewTextHandler(os.Stderr, nil)),
-			verifier: &oidc.FakeVerifier{},
-			policy:   policy.NewEnforcer(false, "main", nil, nil),
-			limiter:  limiter,
-			minter:   token.NewMinter("test-secret", 10*time.Minute),
+			logger:    slog.New(slog.NewTextHandler(os.Stderr, nil)),
+			verifiers: newTestRegistry(&oidc.FakeVerifier{}),
+			policy:    policy.NewEnforcer(false, "main", nil, nil),
+			limiter:   limiter,
+			minter:    token.NewMinter("test-secret", 10*time.Minute),
 		}
 		server.router = server.setupRouter()
 
@@ -209,11 +912,11 @@ func TestHandleGitHubOIDC(t *testing.T) {
 			},
 		}
 		server := &Server{
-			logger:   slog.New(slog.NewTextHandler(os.Stderr, nil)),
-			verifier: failingVerifier,
-			policy:   policy.NewEnforcer(false, "main", nil, nil),
-			limiter:  ratelimit.NewLimiter(10.0, 10),
-			minter:   token.NewMinter("test-secret", 10*time.Minute),
+			logger:    slog.New(slog.NewTextHandler(os.Stderr, nil)),
+			verifiers: newTestRegistry(failingVerifier),
+			policy:    policy.NewEnforcer(false, "main", nil, nil),
+			limiter:   ratelimit.NewLimiter(10.0, 10),
+			minter:    token.NewMinter("test-secret", 10*time.Minute),
 		}
 		server.router = server.setupRouter()
 
@@ -235,50 +938,1447 @@ func TestHandleGitHubOIDC(t *testing.T) {
 		}
 	})
 
-	t.Run("default branch enforcement", func(t *testing.T) {
-		// Create server with default branch enforcement
-		policyEnforcer := policy.NewEnforcer(true, "main", nil, nil)
-		server := &Server{
-			logger: slog.New(slog.NewTextHandler(os.Stderr, nil)),
-			verifier: &oidc.FakeVerifier{
-				VerifyFunc: func(ctx context.Context, token string) (*types.VerifiedClaims, error) {
-					return &types.VerifiedClaims{
-						Repository: "test/repo",
-						Ref:        "refs/heads/develop", // Not the default branch
-						Actor:      "testuser",
-						RunID:      "123456789",
-						Workflow:   ".github/workflows/test.yml@refs/heads/develop",
-						IssuedAt:   time.Now(),
-						ExpiresAt:  time.Now().Add(1 * time.Hour),
-					}, nil
-				},
+	t.Run("token too old", func(t *testing.T) {
+		server := newTestServer()
+		server.verifiers = newTestRegistry(&oidc.FakeVerifier{
+			VerifyFunc: func(ctx context.Context, token string) (*types.VerifiedClaims, error) {
+				return nil, fmt.Errorf("%w: issued 10m0s ago, maximum is 5m0s", oidc.ErrTokenTooOld)
 			},
-			policy:  policyEnforcer,
-			limiter: ratelimit.NewLimiter(10.0, 10),
-			minter:  token.NewMinter("test-secret", 10*time.Minute),
-		}
-		server.router = server.setupRouter()
+		})
 
-		body := bytes.NewBufferString(`{"oidc_token": "valid-token"}`)
+		body := bytes.NewBufferString(`{"oidc_token": "old-token"}`)
 		req := httptest.NewRequest(http.MethodPost, "/auth/github-oidc", body)
-		req.Header.Set("Content-Type", "application/json")
 		w := httptest.NewRecorder()
 
 		server.Handler().ServeHTTP(w, req)
 
-		if w.Code != http.StatusForbidden {
-			t.Errorf("expected status 403, got %d", w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("expected status 401, got %d", w.Code)
+		}
+
+		var errResp types.ErrorResponse
+		json.NewDecoder(w.Body).Decode(&errResp)
+		if errResp.Error != "token_too_old" {
+			t.Errorf("expected error 'token_too_old', got %s", errResp.Error)
 		}
 	})
+
+	t.Run("token lifetime too long", func(t *testing.T) {
+		server := newTestServer()
+		server.verifiers = newTestRegistry(&oidc.FakeVerifier{
+			VerifyFunc: func(ctx context.Context, token string) (*types.VerifiedClaims, error) {
+				return nil, fmt.Errorf("%w: valid for 24h0m0s, maximum is 15m0s", oidc.ErrTokenLifetimeTooLong)
+			},
+		})
+
+		body := bytes.NewBufferString(`{"oidc_token": "long-lived-token"}`)
+		req := httptest.NewRequest(http.MethodPost, "/auth/github-oidc", body)
+		w := httptest.NewRecorder()
+
+		server.Handler().ServeHTTP(w, req)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("expected status 401, got %d", w.Code)
+		}
+
+		var errResp types.ErrorResponse
+		json.NewDecoder(w.Body).Decode(&errResp)
+		if errResp.Error != "token_lifetime_too_long" {
+			t.Errorf("expected error 'token_lifetime_too_long', got %s", errResp.Error)
+		}
+	})
+
+	t.Run("untrusted issuer", func(t *testing.T) {
+		server := newTestServer()
+		server.verifiers = newTestRegistry(&oidc.FakeVerifier{
+			VerifyFunc: func(ctx context.Context, token string) (*types.VerifiedClaims, error) {
+				return nil, fmt.Errorf("%w %q", oidc.ErrUntrustedIssuer, "https://github.evil.example/_services/token")
+			},
+		})
+
+		body := bytes.NewBufferString(`{"oidc_token": "foreign-token"}`)
+		req := httptest.NewRequest(http.MethodPost, "/auth/github-oidc", body)
+		w := httptest.NewRecorder()
+
+		server.Handler().ServeHTTP(w, req)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("expected status 401, got %d", w.Code)
+		}
+
+		var errResp types.ErrorResponse
+		json.NewDecoder(w.Body).Decode(&errResp)
+		if errResp.Error != "untrusted_issuer" {
+			t.Errorf("expected error 'untrusted_issuer', got %s", errResp.Error)
+		}
+	})
+
+	t.Run("token not yet valid", func(t *testing.T) {
+		server := newTestServer()
+		server.verifiers = newTestRegistry(&oidc.FakeVerifier{
+			VerifyFunc: func(ctx context.Context, token string) (*types.VerifiedClaims, error) {
+				return nil, fmt.Errorf("%w: nbf is 1h0m0s in the future", oidc.ErrTokenNotYetValid)
+			},
+		})
+
+		body := bytes.NewBufferString(`{"oidc_token": "future-token"}`)
+		req := httptest.NewRequest(http.MethodPost, "/auth/github-oidc", body)
+		w := httptest.NewRecorder()
+
+		server.Handler().ServeHTTP(w, req)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("expected status 401, got %d", w.Code)
+		}
+
+		var errResp types.ErrorResponse
+		json.NewDecoder(w.Body).Decode(&errResp)
+		if errResp.Error != "token_not_yet_valid" {
+			t.Errorf("expected error 'token_not_yet_valid', got %s", errResp.Error)
+		}
+	})
+
+	t.Run("subject mismatch", func(t *testing.T) {
+		server := newTestServer()
+		server.verifiers = newTestRegistry(&oidc.FakeVerifier{
+			VerifyFunc: func(ctx context.Context, token string) (*types.VerifiedClaims, error) {
+				return nil, &oidc.GitHubSubjectError{Subject: "repo:owner/other:ref:refs/heads/main", Reason: "does not match repository"}
+			},
+		})
+
+		body := bytes.NewBufferString(`{"oidc_token": "inconsistent-token"}`)
+		req := httptest.NewRequest(http.MethodPost, "/auth/github-oidc", body)
+		w := httptest.NewRecorder()
+
+		server.Handler().ServeHTTP(w, req)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("expected status 401, got %d", w.Code)
+		}
+
+		var errResp types.ErrorResponse
+		json.NewDecoder(w.Body).Decode(&errResp)
+		if errResp.Error != "subject_mismatch" {
+			t.Errorf("expected error 'subject_mismatch', got %s", errResp.Error)
+		}
+	})
+
+	t.Run("provider selection", func(t *testing.T) {
+		server := newTestServer()
+		server.verifiers.Register("buildkite", &oidc.FakeVerifier{
+			VerifyFunc: func(ctx context.Context, token string) (*types.VerifiedClaims, error) {
+				return &types.VerifiedClaims{
+					Provider:   oidc.ProviderBuildkite,
+					Repository: "robohub/firmware",
+					Ref:        "refs/heads/main",
+					RunID:      "42",
+				}, nil
+			},
+		})
+
+		tests := []struct {
+			name         string
+			body         string
+			wantStatus   int
+			wantProvider string
+			wantError    string
+		}{
+			{"defaults to github", `{"oidc_token": "valid-token"}`, http.StatusOK, oidc.ProviderGitHub, ""},
+			{"explicit github", `{"oidc_token": "valid-token", "provider": "github"}`, http.StatusOK, oidc.ProviderGitHub, ""},
+			{"buildkite", `{"oidc_token": "valid-token", "provider": "buildkite"}`, http.StatusOK, oidc.ProviderBuildkite, ""},
+			{"unregistered provider", `{"oidc_token": "valid-token", "provider": "gitlab"}`, http.StatusBadRequest, "", "unknown_provider"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodPost, "/auth/github-oidc", bytes.NewBufferString(tt.body))
+				w := httptest.NewRecorder()
+
+				server.Handler().ServeHTTP(w, req)
+
+				if w.Code != tt.wantStatus {
+					t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+				}
+
+				if tt.wantError != "" {
+					var errResp types.ErrorResponse
+					json.NewDecoder(w.Body).Decode(&errResp)
+					if errResp.Error != tt.wantError {
+						t.Errorf("expected error %q, got %q", tt.wantError, errResp.Error)
+					}
+					return
+				}
+
+				var resp types.AuthResponse
+				json.NewDecoder(w.Body).Decode(&resp)
+				if resp.Subject.Provider != tt.wantProvider {
+					t.Errorf("expected provider %s, got %s", tt.wantProvider, resp.Subject.Provider)
+				}
+			})
+		}
+	})
+
+	t.Run("default branch enforcement", func(t *testing.T) {
+		// Create server with default branch enforcement
+		policyEnforcer := policy.NewEnforcer(true, "main", nil, nil)
+		server := &Server{
+			logger: slog.New(slog.NewTextHandler(os.Stderr, nil)),
+			verifiers: newTestRegistry(&oidc.FakeVerifier{
+				VerifyFunc: func(ctx context.Context, token string) (*types.VerifiedClaims, error) {
+					return &types.VerifiedClaims{
+						Repository: "test/repo",
+						Ref:        "refs/heads/develop", // Not the default branch
+						Actor:      "testuser",
+						RunID:      "123456789",
+						Workflow:   ".github/workflows/test.yml@refs/heads/develop",
+						IssuedAt:   time.Now(),
+						ExpiresAt:  time.Now().Add(1 * time.Hour),
+					}, nil
+				},
+			}),
+			policy:  policyEnforcer,
+			limiter: ratelimit.NewLimiter(10.0, 10),
+			minter:  token.NewMinter("test-secret", 10*time.Minute),
+		}
+		server.router = server.setupRouter()
+
+		body := bytes.NewBufferString(`{"oidc_token": "valid-token"}`)
+		req := httptest.NewRequest(http.MethodPost, "/auth/github-oidc", body)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		server.Handler().ServeHTTP(w, req)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("expected status 403, got %d", w.Code)
+		}
+	})
+}
+
+func TestHandleGitHubOIDC_VerificationErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode string
+	}{
+		{"expired", fmt.Errorf("%w: token is expired", oidc.ErrExpired), "token_expired"},
+		{"signature", fmt.Errorf("%w: crypto/rsa: verification error", oidc.ErrSignature), "invalid_signature"},
+		{"issuer", fmt.Errorf("%w: expected https://a.example, got https://b.example", oidc.ErrIssuerMismatch), "issuer_mismatch"},
+		{"untrusted issuer", fmt.Errorf("%w: %w %q", oidc.ErrIssuerMismatch, oidc.ErrUntrustedIssuer, "https://b.example"), "untrusted_issuer"},
+		{"audience", fmt.Errorf("%w: expected one of robohub", oidc.ErrAudienceMismatch), "audience_mismatch"},
+		{"missing claim", &oidc.MissingClaimError{Name: "repository"}, "missing_claim"},
+		{"workflow", &oidc.GitHubWorkflowError{WorkflowRef: "other/repo/.github/workflows/ci.yml@refs/heads/main", Reason: "does not match repository"}, "workflow_mismatch"},
+		{"other", fmt.Errorf("failed to fetch JWKS"), "invalid_token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer()
+			server.verifiers = newTestRegistry(&oidc.FakeVerifier{
+				VerifyFunc: func(ctx context.Context, token string) (*types.VerifiedClaims, error) {
+					return nil, tt.err
+				},
+			})
+
+			body := bytes.NewBufferString(`{"oidc_token": "bad-token"}`)
+			req := httptest.NewRequest(http.MethodPost, "/auth/github-oidc", body)
+			w := httptest.NewRecorder()
+
+			server.Handler().ServeHTTP(w, req)
+
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("expected status 401, got %d", w.Code)
+			}
+
+			var errResp types.ErrorResponse
+			json.NewDecoder(w.Body).Decode(&errResp)
+			if errResp.Error != tt.wantCode {
+				t.Errorf("expected error %q, got %q", tt.wantCode, errResp.Error)
+			}
+			// Expected issuers and audiences stay in the log
+			if strings.Contains(errResp.Message, "example") || strings.Contains(errResp.Message, "robohub") {
+				t.Errorf("expected message without verifier configuration, got %q", errResp.Message)
+			}
+		})
+	}
+}
+
+func TestHandleValidate(t *testing.T) {
+	server := newTestServer()
+
+	accessToken, _, err := server.minter.Mint(&types.VerifiedClaims{
+		Repository: "test/repo",
+		Ref:        "refs/heads/main",
+		Actor:      "testuser",
+		RunID:      "123456789",
+	})
+	if err != nil {
+		t.Fatalf("failed to mint token: %v", err)
+	}
+
+	mintWith := func(t *testing.T, minter *token.Minter) string {
+		t.Helper()
+		tokenString, _, err := minter.Mint(&types.VerifiedClaims{Repository: "test/repo", Ref: "refs/heads/main"})
+		if err != nil {
+			t.Fatalf("failed to mint token: %v", err)
+		}
+		return tokenString
+	}
+	expired := mintWith(t, token.NewMinter("test-secret", time.Minute, token.WithClock(testutil.NewFakeClock(time.Now().Add(-time.Hour)))))
+	forged := mintWith(t, token.NewMinter("other-secret", 10*time.Minute))
+	foreign := mintWith(t, token.NewMinter("test-secret", 10*time.Minute, token.WithIssuer("someone-else")))
+	revoked := mintWith(t, server.minter)
+	revokedClaims, err := server.minter.Validate(revoked)
+	if err != nil {
+		t.Fatalf("failed to validate token: %v", err)
+	}
+	server.minter = token.NewMinter("test-secret", 10*time.Minute,
+		token.WithRevocationCheck(func(jti string) bool { return jti == revokedClaims.JTI }))
+	server.router = server.setupRouter()
+
+	tests := []struct {
+		name       string
+		header     string
+		wantStatus int
+		wantError  string
+		wantReason string
+	}{
+		{"valid token", "Bearer " + accessToken, http.StatusOK, "", ""},
+		{"missing header", "", http.StatusUnauthorized, "invalid_request", ""},
+		{"wrong scheme", "Basic " + accessToken, http.StatusUnauthorized, "invalid_request", ""},
+		{"invalid token", "Bearer not-a-token", http.StatusUnauthorized, "invalid_token", "malformed_token"},
+		{"expired", "Bearer " + expired, http.StatusUnauthorized, "invalid_token", "token_expired"},
+		{"forged", "Bearer " + forged, http.StatusUnauthorized, "invalid_token", "invalid_signature"},
+		{"foreign issuer", "Bearer " + foreign, http.StatusUnauthorized, "invalid_token", "malformed_token"},
+		{"revoked", "Bearer " + revoked, http.StatusUnauthorized, "invalid_token", "token_revoked"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/auth/validate", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+
+			server.Handler().ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+
+			if tt.wantError != "" {
+				var errResp types.ErrorResponse
+				json.NewDecoder(w.Body).Decode(&errResp)
+				if errResp.Error != tt.wantError {
+					t.Errorf("expected error %q, got %q", tt.wantError, errResp.Error)
+				}
+				var reason string
+				if errResp.Details != nil {
+					reason = errResp.Details.Reason
+				}
+				if reason != tt.wantReason {
+					t.Errorf("expected reason %q, got %q", tt.wantReason, reason)
+				}
+				return
+			}
+
+			var claims types.RoboHubClaims
+			if err := json.NewDecoder(w.Body).Decode(&claims); err != nil {
+				t.Fatalf("failed to decode claims: %v", err)
+			}
+			if claims.Repo != "test/repo" {
+				t.Errorf("expected repo test/repo, got %s", claims.Repo)
+			}
+		})
+	}
+}
+
+func TestCorrelationID(t *testing.T) {
+	t.Run("propagates inbound ID to logs and response", func(t *testing.T) {
+		var logs bytes.Buffer
+		server := newTestServer()
+		server.logger = slog.New(NewLogHandler(slog.NewJSONHandler(&logs, nil)))
+		server.router = server.setupRouter()
+
+		body := bytes.NewBufferString(`{"oidc_token": "valid-token"}`)
+		req := httptest.NewRequest(http.MethodPost, "/auth/github-oidc", body)
+		req.Header.Set(correlation.Header, "pipeline-42")
+		w := httptest.NewRecorder()
+
+		server.Handler().ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if got := w.Header().Get(correlation.Header); got != "pipeline-42" {
+			t.Errorf("expected response header pipeline-42, got %q", got)
+		}
+
+		records := decodeLogRecords(t, &logs)
+		for _, msg := range []string{"request", "issued access token"} {
+			record, ok := records[msg]
+			if !ok {
+				t.Errorf("missing %q log record", msg)
+				continue
+			}
+			if record["correlation_id"] != "pipeline-42" {
+				t.Errorf("expected correlation_id pipeline-42 in %q record, got %v", msg, record["correlation_id"])
+			}
+			if id, _ := record["request_id"].(string); id == "" {
+				t.Errorf("expected request_id in %q record", msg)
+			}
+		}
+	})
+
+	t.Run("generates ID when absent", func(t *testing.T) {
+		server := newTestServer()
+
+		req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+		w := httptest.NewRecorder()
+
+		server.Handler().ServeHTTP(w, req)
+
+		if got := w.Header().Get(correlation.Header); !correlation.Valid(got) {
+			t.Errorf("expected generated correlation ID, got %q", got)
+		}
+	})
+
+	t.Run("replaces invalid ID", func(t *testing.T) {
+		server := newTestServer()
+
+		req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+		req.Header.Set(correlation.Header, strings.Repeat("x", 200))
+		w := httptest.NewRecorder()
+
+		server.Handler().ServeHTTP(w, req)
+
+		got := w.Header().Get(correlation.Header)
+		if !correlation.Valid(got) || len(got) == 200 {
+			t.Errorf("expected invalid ID to be replaced, got %q", got)
+		}
+	})
+}
+
+func TestHandleGitHubOIDC_Evaluator(t *testing.T) {
+	tests := []struct {
+		name       string
+		decide     func(ctx context.Context, claims *types.VerifiedClaims) (policy.Grant, error)
+		wantStatus int
+		wantError  string
+		wantScopes []string
+	}{
+		{"default", nil, http.StatusOK, "", []string{"ingest:build"}},
+		{"grant", func(ctx context.Context, claims *types.VerifiedClaims) (policy.Grant, error) {
+			return policy.Grant{Scopes: []string{"custom:" + claims.Repository}}, nil
+		}, http.StatusOK, "", []string{"custom:test/repo"}},
+		{"denied", func(ctx context.Context, claims *types.VerifiedClaims) (policy.Grant, error) {
+			return policy.Grant{}, &policy.DeniedError{Decision: policy.Decision{RuleID: "custom", Reason: policy.ReasonRepoDenied, Message: "denied by custom policy"}}
+		}, http.StatusForbidden, "policy_violation", nil},
+		{"unavailable", func(ctx context.Context, claims *types.VerifiedClaims) (policy.Grant, error) {
+			return policy.Grant{}, fmt.Errorf("%w: custom backend down", policy.ErrOPAUnavailable)
+		}, http.StatusServiceUnavailable, "policy_unavailable", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer()
+			server.policy = &policy.FakeEvaluator{DecideFunc: tt.decide}
+			server.router = server.setupRouter()
+
+			w := httptest.NewRecorder()
+			server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/github-oidc", bytes.NewBufferString(`{"oidc_token": "valid-token"}`)))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if tt.wantError != "" {
+				var errResp types.ErrorResponse
+				if err := json.NewDecoder(w.Body).Decode(&errResp); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				if errResp.Error != tt.wantError {
+					t.Errorf("expected error %q, got %q", tt.wantError, errResp.Error)
+				}
+				if tt.wantStatus == http.StatusForbidden && (errResp.Details == nil || errResp.Details.RuleID != "custom") {
+					t.Errorf("expected rule custom in details, got %+v", errResp.Details)
+				}
+				return
+			}
+
+			var resp types.AuthResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			claims, err := server.minter.Validate(resp.AccessToken)
+			if err != nil {
+				t.Fatalf("failed to validate access token: %v", err)
+			}
+			if !reflect.DeepEqual(claims.Scopes, tt.wantScopes) {
+				t.Errorf("expected scopes %v, got %v", tt.wantScopes, claims.Scopes)
+			}
+		})
+	}
+}
+
+func TestHandleGitHubOIDC_AuditLog(t *testing.T) {
+	opa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer opa.Close()
+
+	tests := []struct {
+		name        string
+		policy      *policy.Enforcer
+		wantStatus  int
+		wantOutcome string
+		wantReason  string
+		wantRuleID  string
+	}{
+		{"allow", policy.NewEnforcer(false, "main", nil, nil), http.StatusOK, "allow", "allowed", ""},
+		{"deny", policy.NewEnforcer(false, "main", nil, []string{"test/repo"}), http.StatusForbidden, "deny", "repo_denied", "repo_denylist"},
+		{"dry run", policy.NewEnforcer(false, "main", []string{"other/repo"}, nil, policy.WithDryRun(true)), http.StatusOK, "would_deny", "not_in_allowlist", "allowlist"},
+		{"OPA unavailable", policy.NewEnforcer(false, "main", nil, nil, policy.WithOPA(policy.NewOPAClient(opa.URL, time.Second), false)),
+			http.StatusServiceUnavailable, "unavailable", "unavailable", ""},
+		{"GitHub API unavailable", policy.NewEnforcer(false, "main", nil, nil, policy.WithArchivedCheck(policy.NewGitHubClient(opa.URL, "test-token", time.Second, time.Minute), false)),
+			http.StatusServiceUnavailable, "unavailable", "unavailable", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var audit, logs bytes.Buffer
+			server := newTestServer()
+			server.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+			server.audit = slog.New(NewLogHandler(slog.NewJSONHandler(&audit, nil)))
+			server.policy = tt.policy
+			server.verifiers = newTestRegistry(&oidc.FakeVerifier{VerifyFunc: func(ctx context.Context, token string) (*types.VerifiedClaims, error) {
+				claims, err := (&oidc.FakeVerifier{}).Verify(ctx, token)
+				if err != nil {
+					return nil, err
+				}
+				claims.Raw = map[string]interface{}{"repository": "test/repo", "actor": "testuser"}
+				return claims, nil
+			}})
+			server.router = server.setupRouter()
+
+			req := httptest.NewRequest(http.MethodPost, "/auth/github-oidc", bytes.NewBufferString(`{"oidc_token": "valid-token"}`))
+			req.Header.Set(correlation.Header, "pipeline-42")
+			w := httptest.NewRecorder()
+
+			server.Handler().ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+
+			records := decodeLogRecords(t, &audit)
+			if len(records) != 1 {
+				t.Fatalf("expected only the policy decision on the audit log, got %v", records)
+			}
+			record, ok := records["policy decision"]
+			if !ok {
+				t.Fatalf("missing policy decision audit event, got %v", records)
+			}
+			want := map[string]interface{}{
+				"outcome":        tt.wantOutcome,
+				"reason":         tt.wantReason,
+				"repository":     "test/repo",
+				"ref":            "refs/heads/main",
+				"actor":          "testuser",
+				"run_id":         "123456789",
+				"correlation_id": "pipeline-42",
+			}
+			for key, value := range want {
+				if record[key] != value {
+					t.Errorf("expected %s %v, got %v", key, value, record[key])
+				}
+			}
+			if ruleID, _ := record["rule_id"].(string); ruleID != tt.wantRuleID {
+				t.Errorf("expected rule_id %q, got %q", tt.wantRuleID, ruleID)
+			}
+			if id, _ := record["request_id"].(string); id == "" {
+				t.Error("expected request_id in audit event")
+			}
+			if raw, _ := record["claims"].(map[string]interface{}); raw["repository"] != "test/repo" || raw["actor"] != "testuser" {
+				t.Errorf("expected the raw claims in the audit event, got %v", record["claims"])
+			}
+			if strings.Contains(logs.String(), `"msg":"policy decision"`) {
+				t.Error("expected audit events to stay off the service log")
+			}
+			if verified, ok := decodeLogRecords(t, &logs)["verified OIDC token"]; !ok {
+				t.Error("expected the verified OIDC token on the service log")
+			} else if _, ok := verified["claims"]; ok {
+				t.Errorf("expected the raw claims to stay off the service log, got %v", verified["claims"])
+			}
+		})
+	}
+}
+
+func TestHandleGitHubOIDC_ScopeGrants(t *testing.T) {
+	policyPath := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(policyPath, []byte(`{"defaults": {"scopes": ["ingest:build"], "scope_grants": [{"environments": ["production"], "scopes": ["deploy:prod"]}]}}`), 0600); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+
+	tests := []struct {
+		environment string
+		want        []string
+		wantAudit   []interface{}
+	}{
+		{"production", []string{"ingest:build", "deploy:prod"}, []interface{}{
+			map[string]interface{}{"scope": "deploy:prod", "rule_id": "file:defaults", "condition": "environment in [production]"},
+		}},
+		{"staging", []string{"ingest:build"}, nil},
+		{"", []string{"ingest:build"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run("environment "+tt.environment, func(t *testing.T) {
+			enforcer := policy.NewEnforcer(false, "main", nil, nil)
+			if _, err := enforcer.Reload(policyPath); err != nil {
+				t.Fatalf("failed to load policy file: %v", err)
+			}
+			var audit bytes.Buffer
+			server := newTestServer()
+			server.audit = slog.New(slog.NewJSONHandler(&audit, nil))
+			server.policy = enforcer
+			server.verifiers = newTestRegistry(&oidc.FakeVerifier{VerifyFunc: func(ctx context.Context, token string) (*types.VerifiedClaims, error) {
+				return &types.VerifiedClaims{Repository: "myorg/deploy", Ref: "refs/heads/main", Environment: tt.environment}, nil
+			}})
+			server.router = server.setupRouter()
+
+			w := httptest.NewRecorder()
+			server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/github-oidc", bytes.NewBufferString(`{"oidc_token": "valid-token"}`)))
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			var resp types.AuthResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			claims, err := server.minter.Validate(resp.AccessToken)
+			if err != nil {
+				t.Fatalf("failed to validate access token: %v", err)
+			}
+			if !reflect.DeepEqual(claims.Scopes, tt.want) {
+				t.Errorf("expected scopes %v, got %v", tt.want, claims.Scopes)
+			}
+
+			record := decodeLogRecords(t, &audit)["policy decision"]
+			if got, _ := record["elevated_scopes"].([]interface{}); !reflect.DeepEqual(got, tt.wantAudit) {
+				t.Errorf("expected elevated_scopes %v, got %v", tt.wantAudit, record["elevated_scopes"])
+			}
+		})
+	}
+}
+
+func TestHandleGitHubOIDC_RequestedScopes(t *testing.T) {
+	allowed := map[string][]string{"myorg/firmware": {"ingest:build", "ingest:docs", "artifact:sign"}}
+
+	tests := []struct {
+		name       string
+		body       string
+		drop       bool
+		wantStatus int
+		wantScopes []string
+		wantReason string
+	}{
+		{"none requested", `{"oidc_token": "valid-token"}`, false, http.StatusOK, []string{"ingest:build", "ingest:docs", "artifact:sign"}, ""},
+		{"empty request", `{"oidc_token": "valid-token", "scopes": []}`, false, http.StatusOK, []string{"ingest:build", "ingest:docs", "artifact:sign"}, ""},
+		{"subset", `{"oidc_token": "valid-token", "scopes": ["ingest:docs", "ingest:docs"]}`, false, http.StatusOK, []string{"ingest:docs"}, ""},
+		{"superset", `{"oidc_token": "valid-token", "scopes": ["ingest:docs", "deploy:prod"]}`, false, http.StatusForbidden, nil, "scope_not_permitted"},
+		{"superset dropped", `{"oidc_token": "valid-token", "scopes": ["ingest:docs", "deploy:prod"]}`, true, http.StatusOK, []string{"ingest:docs"}, ""},
+		{"nothing permitted", `{"oidc_token": "valid-token", "scopes": ["deploy:prod"]}`, true, http.StatusForbidden, nil, "scope_not_permitted"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var audit bytes.Buffer
+			server := newTestServer()
+			server.audit = slog.New(slog.NewJSONHandler(&audit, nil))
+			server.policy = policy.NewEnforcer(false, "main", nil, nil, policy.WithRepoScopes(allowed))
+			server.dropUnpermittedScopes = tt.drop
+			server.verifiers = newTestRegistry(&oidc.FakeVerifier{VerifyFunc: func(ctx context.Context, token string) (*types.VerifiedClaims, error) {
+				return &types.VerifiedClaims{Repository: "myorg/firmware", Ref: "refs/heads/main"}, nil
+			}})
+			server.router = server.setupRouter()
+
+			w := httptest.NewRecorder()
+			server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/github-oidc", bytes.NewBufferString(tt.body)))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+
+			if tt.wantReason != "" {
+				var resp types.ErrorResponse
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				if resp.Error != "policy_violation" || resp.Details == nil || resp.Details.Reason != tt.wantReason ||
+					!strings.Contains(resp.Message, "deploy:prod") {
+					t.Errorf("expected a %s denial naming deploy:prod, got %+v", tt.wantReason, resp)
+				}
+				record := decodeLogRecords(t, &audit)["policy decision"]
+				if record["outcome"] != auditDeny || record["reason"] != tt.wantReason {
+					t.Errorf("expected the denial to be audited, got %v", record)
+				}
+				return
+			}
+
+			var resp types.AuthResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			claims, err := server.minter.Validate(resp.AccessToken)
+			if err != nil {
+				t.Fatalf("failed to validate access token: %v", err)
+			}
+			if !reflect.DeepEqual(claims.Scopes, tt.wantScopes) || resp.Scope != strings.Join(tt.wantScopes, " ") {
+				t.Errorf("expected scopes %v, got %v and scope %q", tt.wantScopes, claims.Scopes, resp.Scope)
+			}
+			if tt.drop && (len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "deploy:prod")) {
+				t.Errorf("expected a warning about the dropped scope, got %v", resp.Warnings)
+			}
+		})
+	}
+}
+
+func TestHandleGitHubOIDC_RequestedTTL(t *testing.T) {
+	tests := []struct {
+		name       string
+		repository string
+		ttl        string
+		wantStatus int
+		wantTTL    time.Duration
+	}{
+		{"unset", "myorg/firmware", "", http.StatusOK, 10 * time.Minute},
+		{"shorter", "myorg/firmware", `, "ttl_seconds": 60`, http.StatusOK, time.Minute},
+		{"under the floor", "myorg/firmware", `, "ttl_seconds": 5`, http.StatusOK, 30 * time.Second},
+		{"over the default", "myorg/firmware", `, "ttl_seconds": 7200`, http.StatusOK, 10 * time.Minute},
+		{"over the policy TTL", "myorg/signing", `, "ttl_seconds": 300`, http.StatusOK, 2 * time.Minute},
+		{"huge", "myorg/firmware", `, "ttl_seconds": 9223372036854775807`, http.StatusOK, 10 * time.Minute},
+		{"zero", "myorg/firmware", `, "ttl_seconds": 0`, http.StatusBadRequest, 0},
+		{"negative", "myorg/firmware", `, "ttl_seconds": -60`, http.StatusBadRequest, 0},
+		{"not a number", "myorg/firmware", `, "ttl_seconds": "60"`, http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer()
+			server.policy = policy.NewEnforcer(false, "main", nil, nil,
+				policy.WithRepoTTLs(map[string]time.Duration{"myorg/signing": 2 * time.Minute}))
+			server.verifiers = newTestRegistry(&oidc.FakeVerifier{VerifyFunc: func(ctx context.Context, token string) (*types.VerifiedClaims, error) {
+				return &types.VerifiedClaims{Repository: tt.repository, Ref: "refs/heads/main"}, nil
+			}})
+			server.router = server.setupRouter()
+
+			body := bytes.NewBufferString(`{"oidc_token": "valid-token"` + tt.ttl + `}`)
+			w := httptest.NewRecorder()
+			server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/github-oidc", body))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp types.AuthResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			claims, err := server.minter.Validate(resp.AccessToken)
+			if err != nil {
+				t.Fatalf("failed to validate access token: %v", err)
+			}
+			if got := time.Duration(claims.ExpiresAt-claims.IssuedAt) * time.Second; got != tt.wantTTL {
+				t.Errorf("expected a %v token, got %v", tt.wantTTL, got)
+			}
+			if want := int(tt.wantTTL.Seconds()); resp.ExpiresIn < want-1 || resp.ExpiresIn > want {
+				t.Errorf("expected expires_in %d, got %d", want, resp.ExpiresIn)
+			}
+		})
+	}
+}
+
+// failingReplayStore fails every check, as an unreachable Redis would
+type failingReplayStore struct{}
+
+func (failingReplayStore) MarkUsed(context.Context, string, time.Time) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func TestHandleGitHubOIDC_Replay(t *testing.T) {
+	newServer := func(store replay.Store) *Server {
+		server := newTestServer()
+		server.policy = policy.NewEnforcer(false, "main", nil, nil,
+			policy.WithRepoScopes(map[string][]string{"test/repo": {"ingest:build"}}))
+		server.verifiers = newTestRegistry(&oidc.FakeVerifier{VerifyFunc: func(ctx context.Context, token string) (*types.VerifiedClaims, error) {
+			claims := &types.VerifiedClaims{
+				Issuer:     "https://token.actions.githubusercontent.com",
+				Repository: "test/repo",
+				Ref:        "refs/heads/main",
+				RunID:      "123456789",
+				ExpiresAt:  time.Now().Add(5 * time.Minute),
+				Raw:        map[string]interface{}{},
+			}
+			// Tokens named jti-* carry that jti, others none
+			if strings.HasPrefix(token, "jti-") {
+				claims.Raw["jti"] = token
+			}
+			return claims, nil
+		}})
+		server.replay = store
+		server.router = server.setupRouter()
+		return server
+	}
+	exchange := func(server *Server, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/github-oidc", bytes.NewBufferString(body)))
+		return w
+	}
+
+	type step struct {
+		body       string
+		wantStatus int
+		wantError  string
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"same token twice", []step{
+			{`{"oidc_token": "jti-1"}`, http.StatusOK, ""},
+			{`{"oidc_token": "jti-1"}`, http.StatusConflict, "token_already_used"},
+		}},
+		{"another token from the same run", []step{
+			{`{"oidc_token": "jti-1"}`, http.StatusOK, ""},
+			{`{"oidc_token": "jti-2"}`, http.StatusOK, ""},
+		}},
+		{"tokens without a jti", []step{
+			{`{"oidc_token": "token-1"}`, http.StatusOK, ""},
+			{`{"oidc_token": "token-2"}`, http.StatusOK, ""},
+			{`{"oidc_token": "token-1"}`, http.StatusConflict, "token_already_used"},
+		}},
+		{"denied request does not use the token", []step{
+			{`{"oidc_token": "jti-1", "scopes": ["ingest:docs"]}`, http.StatusForbidden, "policy_violation"},
+			{`{"oidc_token": "jti-1"}`, http.StatusOK, ""},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newServer(replay.NewMemoryStore())
+			for i, step := range tt.steps {
+				w := exchange(server, step.body)
+				if w.Code != step.wantStatus {
+					t.Fatalf("exchange %d: expected status %d, got %d: %s", i+1, step.wantStatus, w.Code, w.Body.String())
+				}
+				if step.wantError == "" {
+					continue
+				}
+				var errResp types.ErrorResponse
+				if err := json.NewDecoder(w.Body).Decode(&errResp); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				if errResp.Error != step.wantError {
+					t.Errorf("exchange %d: expected error %s, got %s", i+1, step.wantError, errResp.Error)
+				}
+			}
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		server := newServer(nil)
+		for i := 0; i < 2; i++ {
+			if w := exchange(server, `{"oidc_token": "jti-1"}`); w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+		}
+	})
+
+	t.Run("store unavailable", func(t *testing.T) {
+		w := exchange(newServer(failingReplayStore{}), `{"oidc_token": "jti-1"}`)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("expected status 503, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("token without an expiry", func(t *testing.T) {
+		clk := testutil.NewFakeClock(time.Date(2100, 1, 1, 12, 0, 0, 0, time.UTC))
+		server := newServer(replay.NewMemoryStore(replay.WithClock(clk)))
+		server.clock = clk
+		server.verifiers = newTestRegistry(&oidc.FakeVerifier{VerifyFunc: func(ctx context.Context, token string) (*types.VerifiedClaims, error) {
+			return &types.VerifiedClaims{Repository: "test/repo", Ref: "refs/heads/main", Raw: map[string]interface{}{"jti": token}}, nil
+		}})
+		server.router = server.setupRouter()
+
+		if w := exchange(server, `{"oidc_token": "jti-1"}`); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if w := exchange(server, `{"oidc_token": "jti-1"}`); w.Code != http.StatusConflict {
+			t.Fatalf("expected the token to be remembered for the default window, got %d: %s", w.Code, w.Body.String())
+		}
+		clk.Advance(defaultReplayWindow)
+		if w := exchange(server, `{"oidc_token": "jti-1"}`); w.Code != http.StatusOK {
+			t.Fatalf("expected the token to be forgotten after the default window, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestHandleGitHubOIDC_ReducedScopes(t *testing.T) {
+	tests := []struct {
+		ref           string
+		want          []string
+		wantReduction *types.ScopeReduction
+		wantOutcome   string
+	}{
+		{"refs/heads/main", []string{"ingest:build", "artifact:publish"}, nil, "allow"},
+		{"refs/heads/feature", []string{"ingest:build"}, &types.ScopeReduction{
+			Scopes:   []string{"ingest:build"},
+			Withheld: []string{"artifact:publish"},
+			Reason:   "branch_not_allowed",
+			RuleID:   "default_branch",
+			Message:  "only branches refs/heads/main are allowed, got refs/heads/feature",
+		}, "reduced"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			var audit bytes.Buffer
+			server := newTestServer()
+			server.audit = slog.New(slog.NewJSONHandler(&audit, nil))
+			server.policy = policy.NewEnforcer(true, "main", nil, nil,
+				policy.WithDefaultScopes([]string{"ingest:build", "artifact:publish"}),
+				policy.WithReducedScopes([]string{"ingest:build"}),
+			)
+			server.verifiers = newTestRegistry(&oidc.FakeVerifier{VerifyFunc: func(ctx context.Context, token string) (*types.VerifiedClaims, error) {
+				return &types.VerifiedClaims{Repository: "test/repo", Ref: tt.ref}, nil
+			}})
+			server.router = server.setupRouter()
+
+			w := httptest.NewRecorder()
+			server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/github-oidc", bytes.NewBufferString(`{"oidc_token": "valid-token"}`)))
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			var resp types.AuthResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			claims, err := server.minter.Validate(resp.AccessToken)
+			if err != nil {
+				t.Fatalf("failed to validate access token: %v", err)
+			}
+			if !reflect.DeepEqual(claims.Scopes, tt.want) {
+				t.Errorf("expected scopes %v, got %v", tt.want, claims.Scopes)
+			}
+			if !reflect.DeepEqual(resp.ScopeReduction, tt.wantReduction) {
+				t.Errorf("expected scope reduction %+v, got %+v", tt.wantReduction, resp.ScopeReduction)
+			}
+			if (len(resp.Warnings) > 0) != (tt.wantReduction != nil) {
+				t.Errorf("expected a warning only for reduced scopes, got %v", resp.Warnings)
+			}
+
+			record := decodeLogRecords(t, &audit)["policy decision"]
+			if record["outcome"] != tt.wantOutcome {
+				t.Errorf("expected outcome %s, got %v", tt.wantOutcome, record["outcome"])
+			}
+			if tt.wantReduction != nil {
+				if record["reason"] != "branch_not_allowed" || record["rule_id"] != "default_branch" {
+					t.Errorf("expected the branch denial in the audit event, got %v", record)
+				}
+				if withheld, _ := record["withheld_scopes"].([]interface{}); len(withheld) != 1 || withheld[0] != "artifact:publish" {
+					t.Errorf("expected withheld_scopes [artifact:publish], got %v", record["withheld_scopes"])
+				}
+			}
+		})
+	}
+}
+
+func TestHandleGitHubOIDC_PolicyVersion(t *testing.T) {
+	policyPath := filepath.Join(t.TempDir(), "policy.json")
+	writePolicy := func(t *testing.T, content string) {
+		t.Helper()
+		if err := os.WriteFile(policyPath, []byte(content), 0600); err != nil {
+			t.Fatalf("failed to write policy file: %v", err)
+		}
+	}
+
+	var audit bytes.Buffer
+	server := newTestServer()
+	server.audit = slog.New(slog.NewJSONHandler(&audit, nil))
+	enforcer := policy.NewEnforcer(false, "main", nil, nil, policy.WithPolicyVersion("env:0123456789ab"))
+	server.policy = enforcer
+	server.verifiers = newTestRegistry(&oidc.FakeVerifier{VerifyFunc: func(ctx context.Context, token string) (*types.VerifiedClaims, error) {
+		return &types.VerifiedClaims{Repository: "test/repo", Ref: "refs/heads/main"}, nil
+	}})
+	server.router = server.setupRouter()
+
+	exchange := func(t *testing.T) (*types.RoboHubClaims, map[string]interface{}) {
+		t.Helper()
+		audit.Reset()
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/github-oidc", bytes.NewBufferString(`{"oidc_token": "valid-token"}`)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp types.AuthResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		claims, err := server.minter.Validate(resp.AccessToken)
+		if err != nil {
+			t.Fatalf("failed to validate access token: %v", err)
+		}
+		return claims, decodeLogRecords(t, &audit)["policy decision"]
+	}
+
+	t.Run("environment settings", func(t *testing.T) {
+		claims, record := exchange(t)
+		if claims.PolicyVersion != "env:0123456789ab" || record["policy_version"] != "env:0123456789ab" {
+			t.Errorf("expected policy version env:0123456789ab, got claim %q and audit %v", claims.PolicyVersion, record["policy_version"])
+		}
+	})
+
+	t.Run("policy file hash", func(t *testing.T) {
+		writePolicy(t, `{"defaults": {"scopes": ["ingest:build"]}}`)
+		f, err := enforcer.Reload(policyPath)
+		if err != nil {
+			t.Fatalf("failed to load policy file: %v", err)
+		}
+		claims, record := exchange(t)
+		if claims.PolicyVersion != f.Hash || record["policy_version"] != f.Hash {
+			t.Errorf("expected policy version %s, got claim %q and audit %v", f.Hash, claims.PolicyVersion, record["policy_version"])
+		}
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		writePolicy(t, `{"defaults": {"scopes": ["ingest:telemetry"]}}`)
+		f, err := enforcer.Reload(policyPath)
+		if err != nil {
+			t.Fatalf("failed to reload policy file: %v", err)
+		}
+		claims, _ := exchange(t)
+		if claims.PolicyVersion != f.Hash {
+			t.Errorf("expected the reloaded file's hash %s, got %q", f.Hash, claims.PolicyVersion)
+		}
+	})
+
+	t.Run("explicit version", func(t *testing.T) {
+		writePolicy(t, `{"version": "2026.10.1", "defaults": {"scopes": ["ingest:build"]}}`)
+		if _, err := enforcer.Reload(policyPath); err != nil {
+			t.Fatalf("failed to reload policy file: %v", err)
+		}
+		claims, _ := exchange(t)
+		if claims.PolicyVersion != "2026.10.1" {
+			t.Errorf("expected policy version 2026.10.1, got %q", claims.PolicyVersion)
+		}
+	})
+
+	t.Run("denial", func(t *testing.T) {
+		writePolicy(t, `{"version": "2026.10.2", "rules": [{"repository": "test/*", "deny": true}]}`)
+		if _, err := enforcer.Reload(policyPath); err != nil {
+			t.Fatalf("failed to reload policy file: %v", err)
+		}
+		audit.Reset()
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/github-oidc", bytes.NewBufferString(`{"oidc_token": "valid-token"}`)))
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("expected status 403, got %d", w.Code)
+		}
+		if record := decodeLogRecords(t, &audit)["policy decision"]; record["policy_version"] != "2026.10.2" {
+			t.Errorf("expected policy version 2026.10.2 in the audit event, got %v", record["policy_version"])
+		}
+	})
+}
+
+func TestHandlePolicyCheck(t *testing.T) {
+	const adminToken = "0123456789abcdef0123456789abcdef"
+
+	policyPath := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(policyPath, []byte(`{"rules": [{"repository": "myorg/firmware", "scopes": ["artifact:sign"], "token_ttl_seconds": 300}]}`), 0600); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+	enforcer := policy.NewEnforcer(true, "main", nil, []string{"myorg/legacy"},
+		policy.WithDefaultScopes([]string{"ingest:build"}))
+	if _, err := enforcer.Reload(policyPath); err != nil {
+		t.Fatalf("failed to load policy file: %v", err)
+	}
+
+	limiter := ratelimit.NewLimiter(1.0, 1)
+	server := newTestServer()
+	server.policy = enforcer
+	server.limiter = limiter
+	server.adminToken = adminToken
+	server.router = server.setupRouter()
+
+	check := func(t *testing.T, token, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/admin/policy/check", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want types.PolicyCheckResponse
+	}{
+		{"allowed", `{"repository": "myorg/firmware", "ref": "refs/heads/main", "actor": "octocat"}`,
+			types.PolicyCheckResponse{Allowed: true, Reason: "allowed", Scopes: []string{"artifact:sign"}, TTLSeconds: 300}},
+		{"default grant", `{"repository": "myorg/robot", "ref": "refs/heads/main"}`,
+			types.PolicyCheckResponse{Allowed: true, Reason: "allowed", Scopes: []string{"ingest:build"}}},
+		{"branch denied", `{"repository": "myorg/firmware", "ref": "refs/heads/feature"}`,
+			types.PolicyCheckResponse{Reason: "branch_not_allowed", RuleID: "default_branch",
+				Message: "only branches refs/heads/main are allowed, got refs/heads/feature"}},
+		{"repository denied", `{"repository": "myorg/legacy", "ref": "refs/heads/main"}`,
+			types.PolicyCheckResponse{Reason: "repo_denied", RuleID: "repo_denylist",
+				Message: "repository myorg/legacy is denied by policy"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := check(t, adminToken, tt.body)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			var resp types.PolicyCheckResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			tt.want.PolicyHash = enforcer.Status().Hash
+			tt.want.PolicyVersion = enforcer.Status().Hash
+			if !reflect.DeepEqual(resp, tt.want) {
+				t.Errorf("expected %+v, got %+v", tt.want, resp)
+			}
+		})
+	}
+
+	t.Run("does not consume rate limit", func(t *testing.T) {
+		if !limiter.Allow("myorg/firmware") {
+			t.Error("expected policy checks to leave the rate limit untouched")
+		}
+	})
+
+	t.Run("reflects reloaded policy", func(t *testing.T) {
+		if err := os.WriteFile(policyPath, []byte(`{"rules": [{"repository": "myorg/firmware", "deny": true}]}`), 0600); err != nil {
+			t.Fatalf("failed to write policy file: %v", err)
+		}
+		if _, err := enforcer.Reload(policyPath); err != nil {
+			t.Fatalf("failed to reload policy file: %v", err)
+		}
+
+		w := check(t, adminToken, `{"repository": "myorg/firmware", "ref": "refs/heads/main"}`)
+		var resp types.PolicyCheckResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if resp.Allowed || resp.RuleID != "file:myorg/firmware" || resp.PolicyHash != enforcer.Status().Hash {
+			t.Errorf("expected denial by the reloaded policy, got %+v", resp)
+		}
+	})
+
+	t.Run("freeze window", func(t *testing.T) {
+		if err := os.WriteFile(policyPath, []byte(`{"freeze_windows": [{"name": "all", "days": ["mon", "tue", "wed", "thu", "fri", "sat", "sun"]}]}`), 0600); err != nil {
+			t.Fatalf("failed to write policy file: %v", err)
+		}
+		if _, err := enforcer.Reload(policyPath); err != nil {
+			t.Fatalf("failed to reload policy file: %v", err)
+		}
+		w := check(t, adminToken, `{"repository": "myorg/robot", "ref": "refs/heads/main"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp types.PolicyCheckResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if resp.Allowed || resp.Reason != "freeze_window" || resp.RuleID != "file:freeze:all" || len(resp.Scopes) != 0 {
+			t.Errorf("expected denial by freeze window all, got %+v", resp)
+		}
+	})
+
+	errorTests := []struct {
+		name       string
+		token      string
+		body       string
+		wantStatus int
+		wantError  string
+	}{
+		{"missing token", "", `{"repository": "myorg/robot"}`, http.StatusUnauthorized, "unauthorized"},
+		{"wrong token", "not-the-admin-token", `{"repository": "myorg/robot"}`, http.StatusUnauthorized, "unauthorized"},
+		{"invalid JSON", adminToken, `{`, http.StatusBadRequest, "invalid_request"},
+		{"missing repository", adminToken, `{"ref": "refs/heads/main"}`, http.StatusBadRequest, "invalid_request"},
+	}
+
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := check(t, tt.token, tt.body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+			var errResp types.ErrorResponse
+			json.NewDecoder(w.Body).Decode(&errResp)
+			if errResp.Error != tt.wantError {
+				t.Errorf("expected error %q, got %q", tt.wantError, errResp.Error)
+			}
+		})
+	}
+
+	t.Run("not served without an admin token", func(t *testing.T) {
+		server := newTestServer()
+		req := httptest.NewRequest(http.MethodPost, "/admin/policy/check", strings.NewReader(`{"repository": "myorg/robot"}`))
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, req)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+	})
+}
+
+func TestHandlePolicyCheck_Clock(t *testing.T) {
+	const adminToken = "0123456789abcdef0123456789abcdef"
+
+	policyPath := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(policyPath, []byte(`{"rules": [{"repository": "myorg/robot", "max_token_age_seconds": 300}]}`), 0600); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+	clk := testutil.NewFakeClock(time.Date(2100, 1, 1, 12, 0, 0, 0, time.UTC))
+	enforcer := policy.NewEnforcer(false, "main", nil, nil, policy.WithClock(clk))
+	if _, err := enforcer.Reload(policyPath); err != nil {
+		t.Fatalf("failed to load policy file: %v", err)
+	}
+
+	server := newTestServer()
+	server.policy = enforcer
+	server.clock = clk
+	server.adminToken = adminToken
+	server.router = server.setupRouter()
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/policy/check", strings.NewReader(`{"repository": "myorg/robot", "ref": "refs/heads/main"}`))
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+
+	var resp types.PolicyCheckResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !resp.Allowed {
+		t.Errorf("expected a token issued by the enforcer's clock to be allowed, got %+v", resp)
+	}
+}
+
+func TestHandlePolicyCheck_DefaultBranchLookup(t *testing.T) {
+	const adminToken = "0123456789abcdef0123456789abcdef"
+
+	github := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/myorg/robot" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"archived": false, "default_branch": "trunk"}`))
+	}))
+	defer github.Close()
+
+	server := newTestServer()
+	server.policy = policy.NewEnforcer(true, "main", nil, nil,
+		policy.WithDefaultBranchLookup(policy.NewGitHubClient(github.URL, "test-token", time.Second, time.Minute)))
+	server.adminToken = adminToken
+	server.router = server.setupRouter()
+
+	tests := []struct {
+		ref        string
+		wantReason string
+	}{
+		{"refs/heads/trunk", "allowed"},
+		{"refs/heads/main", "branch_not_allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/admin/policy/check", strings.NewReader(`{"repository": "myorg/robot", "ref": "`+tt.ref+`"}`))
+			req.Header.Set("Authorization", "Bearer "+adminToken)
+			w := httptest.NewRecorder()
+			server.Handler().ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			var resp types.PolicyCheckResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if resp.Reason != tt.wantReason {
+				t.Errorf("expected reason %s, got %+v", tt.wantReason, resp)
+			}
+		})
+	}
+}
+
+func TestHandleRotateKey(t *testing.T) {
+	const adminToken = "0123456789abcdef0123456789abcdef"
+
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate EC key: %v", err)
+	}
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate EC key: %v", err)
+	}
+	var sourceKey crypto.Signer = oldKey
+	var sourceErr error
+
+	server := newTestServer()
+	server.minter = token.NewMinter("", 10*time.Minute, token.WithSigningKey(oldKey, ""))
+	server.adminToken = adminToken
+	server.signingKeySource = func() (crypto.Signer, error) { return sourceKey, sourceErr }
+	server.router = server.setupRouter()
+
+	before, _, err := server.minter.Mint(&types.VerifiedClaims{Repository: "test/repo", Ref: "refs/heads/main"})
+	if err != nil {
+		t.Fatalf("failed to mint token: %v", err)
+	}
+
+	serve := func(t *testing.T, method, path, header string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, req)
+		return w
+	}
+	rotate := func(t *testing.T) types.KeyRotationResponse {
+		t.Helper()
+		w := serve(t, http.MethodPost, "/admin/keys/rotate", "Bearer "+adminToken)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp types.KeyRotationResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return resp
+	}
+	jwks := func(t *testing.T) []string {
+		t.Helper()
+		w := serve(t, http.MethodGet, "/.well-known/jwks.json", "")
+		var resp token.JWKS
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode JWKS: %v", err)
+		}
+		var kids []string
+		for _, key := range resp.Keys {
+			kids = append(kids, key.Kid)
+		}
+		return kids
+	}
+
+	if got := jwks(t); !reflect.DeepEqual(got, []string{token.KeyID(&oldKey.PublicKey)}) {
+		t.Errorf("expected the JWKS to hold the signing key, got %v", got)
+	}
+
+	t.Run("unchanged key", func(t *testing.T) {
+		if resp := rotate(t); resp.Rotated || len(resp.Keys) != 1 {
+			t.Errorf("expected nothing to change, got %+v", resp)
+		}
+	})
+
+	t.Run("rotate", func(t *testing.T) {
+		sourceKey = newKey
+		resp := rotate(t)
+		if !resp.Rotated || resp.KeyID != token.KeyID(&newKey.PublicKey) || len(resp.Keys) != 2 || resp.Keys[1].RetiresAt == nil {
+			t.Fatalf("expected the new key to sign and the old one to be kept, got %+v", resp)
+		}
+		want := []string{token.KeyID(&newKey.PublicKey), token.KeyID(&oldKey.PublicKey)}
+		if got := jwks(t); !reflect.DeepEqual(got, want) {
+			t.Errorf("expected the JWKS to hold %v, got %v", want, got)
+		}
+		if w := serve(t, http.MethodPost, "/auth/validate", "Bearer "+before); w.Code != http.StatusOK {
+			t.Errorf("expected the token minted before the rotation to validate, got status %d", w.Code)
+		}
+	})
+
+	t.Run("unreadable key keeps the current key", func(t *testing.T) {
+		sourceErr = fmt.Errorf("no such file")
+		defer func() { sourceErr = nil }()
+		w := serve(t, http.MethodPost, "/admin/keys/rotate", "Bearer "+adminToken)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("expected status 500, got %d", w.Code)
+		}
+		if keys := server.minter.Keys(); keys[0].ID != token.KeyID(&newKey.PublicKey) {
+			t.Errorf("expected the signing key to stay, got %+v", keys)
+		}
+	})
+
+	t.Run("requires the admin token", func(t *testing.T) {
+		if w := serve(t, http.MethodPost, "/admin/keys/rotate", ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("expected status 401, got %d", w.Code)
+		}
+	})
+
+	t.Run("not served without a key source", func(t *testing.T) {
+		server := newTestServer()
+		server.adminToken = adminToken
+		server.router = server.setupRouter()
+		req := httptest.NewRequest(http.MethodPost, "/admin/keys/rotate", nil)
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, req)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("shared secret publishes no keys", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		newTestServer().Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+		if strings.TrimSpace(w.Body.String()) != `{"keys":[]}` {
+			t.Errorf("expected an empty key set, got %s", w.Body.String())
+		}
+	})
+}
+
+// decodeLogRecords parses JSON log lines keyed by message
+func decodeLogRecords(t *testing.T, buf *bytes.Buffer) map[string]map[string]interface{} {
+	t.Helper()
+
+	records := make(map[string]map[string]interface{})
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("failed to decode log line %q: %v", line, err)
+		}
+		msg, _ := record["msg"].(string)
+		records[msg] = record
+	}
+	return records
+}
+
+// newTestRegistry registers v as the default provider's verifier
+func newTestRegistry(v oidc.Verifier) *oidc.VerifierRegistry {
+	r := oidc.NewVerifierRegistry()
+	r.Register(oidc.DefaultProvider, v)
+	return r
 }
 
 func newTestServer() *Server {
 	s := &Server{
-		logger:   slog.New(slog.NewTextHandler(os.Stderr, nil)),
-		verifier: &oidc.FakeVerifier{},
-		policy:   policy.NewEnforcer(false, "main", nil, nil),
-		limiter:  ratelimit.NewLimiter(10.0, 10),
-		minter:   token.NewMinter("test-secret", 10*time.Minute),
+		logger:    slog.New(slog.NewTextHandler(os.Stderr, nil)),
+		verifiers: newTestRegistry(&oidc.FakeVerifier{}),
+		policy:    policy.NewEnforcer(false, "main", nil, nil),
+		limiter:   ratelimit.NewLimiter(10.0, 10),
+		minter:    token.NewMinter("test-secret", 10*time.Minute),
+		clock:     clock.Real{},
 	}
 	s.router = s.setupRouter()
 	return s
@@ -0,0 +1,267 @@
+// Package kms signs access tokens with keys that never leave a cloud key
+// management service
+package kms
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robohub/auth-service/internal/clock"
+)
+
+// GCPClient is the part of the Cloud KMS API the signer needs
+type GCPClient interface {
+	// GetPublicKey returns the PEM public key of a key version and its
+	// algorithm, e.g. EC_SIGN_P256_SHA256
+	GetPublicKey(ctx context.Context, name string) (pemKey, algorithm string, err error)
+
+	// AsymmetricSign signs a SHA-256 digest, or for Ed25519 keys the data
+	// itself
+	AsymmetricSign(ctx context.Context, name string, digest, data []byte) ([]byte, error)
+}
+
+// GCPSigner is a crypto.Signer backed by a Cloud KMS asymmetric signing key
+// version. Its public key is fetched once and cached.
+type GCPSigner struct {
+	client  GCPClient
+	name    string
+	public  crypto.PublicKey
+	timeout time.Duration
+}
+
+// NewGCPSigner creates a signer for the key version name, e.g.
+// projects/p/locations/l/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1,
+// allowing each signature timeout. Only RSA PKCS #1 SHA-256, P-256 SHA-256
+// and Ed25519 keys are supported, matching RS256, ES256 and EdDSA.
+func NewGCPSigner(ctx context.Context, client GCPClient, name string, timeout time.Duration) (*GCPSigner, error) {
+	pemKey, algorithm, err := client.GetPublicKey(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Cloud KMS public key of %s: %w", name, err)
+	}
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, fmt.Errorf("invalid Cloud KMS public key of %s: no PEM block", name)
+	}
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Cloud KMS public key of %s: %w", name, err)
+	}
+
+	supported := false
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		supported = strings.HasPrefix(algorithm, "RSA_SIGN_PKCS1_") && strings.HasSuffix(algorithm, "_SHA256")
+	case *ecdsa.PublicKey:
+		supported = algorithm == "EC_SIGN_P256_SHA256" && key.Curve == elliptic.P256()
+	case ed25519.PublicKey:
+		supported = algorithm == "EC_SIGN_ED25519"
+	}
+	if !supported {
+		return nil, fmt.Errorf("unsupported Cloud KMS key algorithm %s of %s: must be RSA_SIGN_PKCS1_*_SHA256, EC_SIGN_P256_SHA256 or EC_SIGN_ED25519",
+			algorithm, name)
+	}
+	return &GCPSigner{client: client, name: name, public: public, timeout: timeout}, nil
+}
+
+// Public returns the key version's public key
+func (s *GCPSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Sign signs a SHA-256 digest, or an Ed25519 message, with the key version.
+// ECDSA signatures are ASN.1 encoded, as crypto.Signer requires.
+func (s *GCPSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var data []byte
+	if _, ok := s.public.(ed25519.PublicKey); ok {
+		if opts.HashFunc() != crypto.Hash(0) {
+			return nil, fmt.Errorf("Cloud KMS Ed25519 keys sign messages, not %v digests", opts.HashFunc())
+		}
+		digest, data = nil, digest
+	} else if opts.HashFunc() != crypto.SHA256 || len(digest) != crypto.SHA256.Size() {
+		return nil, fmt.Errorf("Cloud KMS key %s signs SHA-256 digests only", s.name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	sig, err := s.client.AsymmetricSign(ctx, s.name, digest, data)
+	if err != nil {
+		return nil, fmt.Errorf("Cloud KMS signing with %s failed: %w", s.name, err)
+	}
+	return sig, nil
+}
+
+// gcpEndpoint is the Cloud KMS REST API
+const gcpEndpoint = "https://cloudkms.googleapis.com/v1/"
+
+// maxGCPResponseSize bounds the API and metadata server responses read
+const maxGCPResponseSize = 1 << 20
+
+// castagnoli is the CRC32C table of the Cloud KMS integrity checksums
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// gcpClient calls the Cloud KMS REST API with the access tokens of the
+// workload's service account, from the GKE or GCE metadata server
+type gcpClient struct {
+	endpoint    string
+	metadataURL string
+	httpClient  *http.Client
+	clock       clock.Clock
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewGCPClient creates a Cloud KMS client authenticating as the service
+// account of the GKE workload or GCE instance. GCE_METADATA_HOST overrides
+// the metadata server's address, as in Google's client libraries.
+func NewGCPClient() GCPClient {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = "metadata.google.internal"
+	}
+	return &gcpClient{
+		endpoint:    gcpEndpoint,
+		metadataURL: "http://" + host + "/computeMetadata/v1/instance/service-accounts/default/token",
+		httpClient:  &http.Client{},
+		clock:       clock.Real{},
+	}
+}
+
+func (c *gcpClient) GetPublicKey(ctx context.Context, name string) (string, string, error) {
+	var resp struct {
+		PEM       string `json:"pem"`
+		Algorithm string `json:"algorithm"`
+	}
+	if err := c.do(ctx, http.MethodGet, name+"/publicKey", nil, &resp); err != nil {
+		return "", "", err
+	}
+	return resp.PEM, resp.Algorithm, nil
+}
+
+func (c *gcpClient) AsymmetricSign(ctx context.Context, name string, digest, data []byte) ([]byte, error) {
+	encode := base64.StdEncoding.EncodeToString
+	req := map[string]interface{}{}
+	if data != nil {
+		req["data"], req["dataCrc32c"] = encode(data), fmt.Sprint(crc32.Checksum(data, castagnoli))
+	} else {
+		req["digest"], req["digestCrc32c"] = map[string]string{"sha256": encode(digest)}, fmt.Sprint(crc32.Checksum(digest, castagnoli))
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Signature            []byte `json:"signature"`
+		SignatureCrc32c      string `json:"signatureCrc32c"`
+		VerifiedDigestCrc32c bool   `json:"verifiedDigestCrc32c"`
+		VerifiedDataCrc32c   bool   `json:"verifiedDataCrc32c"`
+	}
+	if err := c.do(ctx, http.MethodPost, name+":asymmetricSign", body, &resp); err != nil {
+		return nil, err
+	}
+
+	// Detect corruption on the way in and out, as Google recommends
+	if (data != nil && !resp.VerifiedDataCrc32c) || (data == nil && !resp.VerifiedDigestCrc32c) {
+		return nil, errors.New("request corrupted in transit")
+	}
+	if fmt.Sprint(crc32.Checksum(resp.Signature, castagnoli)) != resp.SignatureCrc32c {
+		return nil, errors.New("response corrupted in transit")
+	}
+	return resp.Signature, nil
+}
+
+// do sends a Cloud KMS API request and decodes the response into out
+func (c *gcpClient) do(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Cloud KMS request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxGCPResponseSize))
+	if err != nil {
+		return fmt.Errorf("failed to read Cloud KMS response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Message != "" {
+			return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, apiErr.Error.Message)
+		}
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("invalid Cloud KMS response: %w", err)
+	}
+	return nil
+}
+
+// accessToken returns the service account's access token, fetching a new
+// one from the metadata server a minute before the last one expires
+func (c *gcpClient) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && c.clock.Now().Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.metadataURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get access token from the metadata server: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get access token from the metadata server: unexpected status %d", resp.StatusCode)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxGCPResponseSize)).Decode(&token); err != nil || token.AccessToken == "" {
+		return "", fmt.Errorf("invalid access token from the metadata server")
+	}
+
+	c.token = token.AccessToken
+	c.tokenExpiry = c.clock.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return c.token, nil
+}
@@ -0,0 +1,269 @@
+package kms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/robohub/auth-service/internal/testutil"
+	"github.com/robohub/auth-service/internal/token"
+	"github.com/robohub/auth-service/internal/types"
+)
+
+const keyVersion = "projects/robohub/locations/global/keyRings/auth/cryptoKeys/tokens/cryptoKeyVersions/1"
+
+// mockGCPClient signs with a local private key, as Cloud KMS would
+type mockGCPClient struct {
+	key       crypto.Signer
+	algorithm string
+	signErr   error
+
+	publicKeyCalls atomic.Int32
+	lastDigest     []byte
+	lastData       []byte
+}
+
+func (c *mockGCPClient) GetPublicKey(_ context.Context, name string) (string, string, error) {
+	c.publicKeyCalls.Add(1)
+	if name != keyVersion {
+		return "", "", fmt.Errorf("unexpected status 404: key version %s not found", name)
+	}
+	der, err := x509.MarshalPKIXPublicKey(c.key.Public())
+	if err != nil {
+		return "", "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), c.algorithm, nil
+}
+
+func (c *mockGCPClient) AsymmetricSign(_ context.Context, _ string, digest, data []byte) ([]byte, error) {
+	if c.signErr != nil {
+		return nil, c.signErr
+	}
+	c.lastDigest, c.lastData = digest, data
+	if data != nil {
+		return c.key.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+	return c.key.Sign(rand.Reader, digest, crypto.SHA256)
+}
+
+func TestGCPSigner(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate EC key: %v", err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate EC key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %v", err)
+	}
+
+	tests := []struct {
+		alg    string
+		client *mockGCPClient
+	}{
+		{"RS256", &mockGCPClient{key: rsaKey, algorithm: "RSA_SIGN_PKCS1_2048_SHA256"}},
+		{"ES256", &mockGCPClient{key: ecKey, algorithm: "EC_SIGN_P256_SHA256"}},
+		{"EdDSA", &mockGCPClient{key: edKey, algorithm: "EC_SIGN_ED25519"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.alg, func(t *testing.T) {
+			signer, err := NewGCPSigner(context.Background(), tt.client, keyVersion, time.Second)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			minter := token.NewMinter("", 10*time.Minute, token.WithSigningKey(signer, ""))
+			if _, _, err := minter.Mint(&types.VerifiedClaims{Repository: "owner/repo"}); err != nil {
+				t.Fatalf("failed to mint token: %v", err)
+			}
+			tokenString, _, err := minter.Mint(&types.VerifiedClaims{Repository: "owner/repo", Ref: "refs/heads/main"})
+			if err != nil {
+				t.Fatalf("failed to mint token: %v", err)
+			}
+			if got := tt.client.publicKeyCalls.Load(); got != 1 {
+				t.Errorf("expected the public key to be fetched once, got %d", got)
+			}
+
+			// Only Ed25519 keys are handed the message rather than its digest
+			signingString := tokenString[:strings.LastIndex(tokenString, ".")]
+			if tt.alg == "EdDSA" {
+				if string(tt.client.lastData) != signingString || tt.client.lastDigest != nil {
+					t.Errorf("expected the signing string to be signed, got digest %x and data %q", tt.client.lastDigest, tt.client.lastData)
+				}
+			} else if digest := sha256.Sum256([]byte(signingString)); string(tt.client.lastDigest) != string(digest[:]) || tt.client.lastData != nil {
+				t.Errorf("expected the SHA-256 digest to be signed, got digest %x and data %q", tt.client.lastDigest, tt.client.lastData)
+			}
+
+			// Resource servers validate with the public key from the JWKS
+			_, err = jwt.Parse(tokenString, func(*jwt.Token) (interface{}, error) {
+				return minter.Keys()[0].PublicKey, nil
+			}, jwt.WithValidMethods([]string{tt.alg}))
+			if err != nil {
+				t.Errorf("failed to validate with the public key: %v", err)
+			}
+			if jwks := minter.JWKS(); len(jwks.Keys) != 1 || jwks.Keys[0].Alg != tt.alg || jwks.Keys[0].Kid != token.KeyID(tt.client.key.Public()) {
+				t.Errorf("expected the JWKS to hold the KMS key, got %+v", jwks.Keys)
+			}
+		})
+	}
+
+	t.Run("signing errors", func(t *testing.T) {
+		client := &mockGCPClient{key: ecKey, algorithm: "EC_SIGN_P256_SHA256"}
+		signer, err := NewGCPSigner(context.Background(), client, keyVersion, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		client.signErr = errors.New("unexpected status 403: permission denied")
+		minter := token.NewMinter("", 10*time.Minute, token.WithSigningKey(signer, ""))
+		if _, _, err := minter.Mint(&types.VerifiedClaims{Repository: "owner/repo"}); err == nil || !strings.Contains(err.Error(), "permission denied") {
+			t.Errorf("expected the KMS error, got %v", err)
+		}
+		if _, err := signer.Sign(rand.Reader, make([]byte, 48), crypto.SHA384); err == nil {
+			t.Error("expected error for a SHA-384 digest")
+		}
+	})
+
+	t.Run("unsupported keys", func(t *testing.T) {
+		for _, client := range []*mockGCPClient{
+			{key: p384Key, algorithm: "EC_SIGN_P384_SHA384"},
+			{key: rsaKey, algorithm: "RSA_SIGN_PSS_2048_SHA256"},
+			{key: ecKey, algorithm: "RSA_SIGN_PKCS1_2048_SHA256"},
+		} {
+			if _, err := NewGCPSigner(context.Background(), client, keyVersion, time.Second); err == nil || !strings.Contains(err.Error(), "unsupported Cloud KMS key algorithm") {
+				t.Errorf("expected %s to be unsupported, got %v", client.algorithm, err)
+			}
+		}
+		client := &mockGCPClient{key: ecKey, algorithm: "EC_SIGN_P256_SHA256"}
+		if _, err := NewGCPSigner(context.Background(), client, keyVersion+"0", time.Second); err == nil || !strings.Contains(err.Error(), "not found") {
+			t.Errorf("expected the public key error, got %v", err)
+		}
+	})
+}
+
+func TestGCPClient(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate EC key: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %v", err)
+	}
+	checksum := func(data []byte) string { return fmt.Sprint(crc32.Checksum(data, castagnoli)) }
+
+	var tokenFetches atomic.Int32
+	var corrupt atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/computeMetadata/v1/instance/service-accounts/default/token" {
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			tokenFetches.Add(1)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "sa-token", "expires_in": 3600})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer sa-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error": {"message": "missing credentials"}}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/" + keyVersion + "/publicKey":
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"pem": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), "algorithm": "EC_SIGN_P256_SHA256",
+			})
+		case "/v1/" + keyVersion + ":asymmetricSign":
+			var req struct {
+				Digest       struct{ SHA256 []byte } `json:"digest"`
+				DigestCrc32c string                  `json:"digestCrc32c"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			sig, err := key.Sign(rand.Reader, req.Digest.SHA256, crypto.SHA256)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			sigChecksum := checksum(sig)
+			if corrupt.Load() {
+				sigChecksum = "0"
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"signature":            base64.StdEncoding.EncodeToString(sig),
+				"signatureCrc32c":      sigChecksum,
+				"verifiedDigestCrc32c": req.DigestCrc32c == checksum(req.Digest.SHA256),
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error": {"message": "key version not found"}}`))
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(server.URL, "http://"))
+	client := NewGCPClient().(*gcpClient)
+	client.endpoint = server.URL + "/v1/"
+	clk := testutil.NewFakeClock(time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC))
+	client.clock = clk
+
+	signer, err := NewGCPSigner(context.Background(), client, keyVersion, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	digest := sha256.Sum256([]byte("header.payload"))
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig) {
+		t.Error("expected a valid ASN.1 ECDSA signature")
+	}
+	if got := tokenFetches.Load(); got != 1 {
+		t.Errorf("expected the access token to be reused, got %d fetches", got)
+	}
+
+	clk.Advance(time.Hour)
+	if _, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := tokenFetches.Load(); got != 2 {
+		t.Errorf("expected a new access token once the last expired, got %d fetches", got)
+	}
+
+	corrupt.Store(true)
+	if _, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256); err == nil || !strings.Contains(err.Error(), "corrupted") {
+		t.Errorf("expected a checksum error, got %v", err)
+	}
+	if _, err := NewGCPSigner(context.Background(), client, keyVersion+"0", time.Second); err == nil || !strings.Contains(err.Error(), "key version not found") {
+		t.Errorf("expected the API error, got %v", err)
+	}
+}
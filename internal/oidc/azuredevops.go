@@ -0,0 +1,99 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robohub/auth-service/internal/types"
+)
+
+const (
+	// ProviderAzureDevOps identifies Azure DevOps Pipelines in subject details
+	ProviderAzureDevOps = "azure_devops"
+
+	// AzureDevOpsAudience is the audience Azure DevOps uses for workload
+	// identity federation tokens
+	AzureDevOpsAudience = "api://AzureADTokenExchange"
+)
+
+// AzureDevOpsIssuer returns the OIDC issuer URL for an Azure DevOps
+// organization ID
+func AzureDevOpsIssuer(organizationID string) string {
+	return "https://vstoken.dev.azure.com/" + organizationID
+}
+
+// AzureSubjectError reports an Azure DevOps sub claim that is not of the form
+// sc://<organization>/<project>/<service-connection>, which usually means the
+// service connection is misconfigured
+type AzureSubjectError struct {
+	Subject string
+	Reason  string
+}
+
+func (e *AzureSubjectError) Error() string {
+	return fmt.Sprintf("unexpected Azure DevOps sub %q: %s", e.Subject, e.Reason)
+}
+
+// AzureDevOpsVerifier verifies Azure DevOps service connection OIDC tokens
+type AzureDevOpsVerifier struct {
+	*verifierCore
+}
+
+// NewAzureDevOpsVerifier creates a new Azure DevOps OIDC verifier
+func NewAzureDevOpsVerifier(issuer, audience string, clockSkew time.Duration, jwksTTL time.Duration, opts ...Option) *AzureDevOpsVerifier {
+	return &AzureDevOpsVerifier{
+		verifierCore: newVerifierCore(issuer, []string{audience}, issuer+"/.well-known/jwks", clockSkew, jwksTTL, opts),
+	}
+}
+
+// Verify verifies an Azure DevOps OIDC token. The repository is
+// <organization>/<project> from the sub claim so that policy can allowlist
+// specific pairs, and the service connection name becomes the workflow.
+func (v *AzureDevOpsVerifier) Verify(ctx context.Context, tokenString string) (*types.VerifiedClaims, error) {
+	claims, err := v.verify(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	sub, _ := claims["sub"].(string)
+	organization, project, connection, err := parseAzureSubject(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.VerifiedClaims{
+		Provider:   ProviderAzureDevOps,
+		Issuer:     v.issuer,
+		Repository: organization + "/" + project,
+		Workflow:   connection,
+		IssuedAt:   v.extractTimestamp(claims, "iat"),
+		ExpiresAt:  v.extractTimestamp(claims, "exp"),
+		Raw:        claims,
+	}, nil
+}
+
+// parseAzureSubject splits sc://<organization>/<project>/<connection>
+func parseAzureSubject(sub string) (organization, project, connection string, err error) {
+	if sub == "" {
+		return "", "", "", &AzureSubjectError{Subject: sub, Reason: "missing sub claim"}
+	}
+
+	rest, ok := strings.CutPrefix(sub, "sc://")
+	if !ok {
+		return "", "", "", &AzureSubjectError{Subject: sub, Reason: `expected "sc://" prefix`}
+	}
+
+	parts := strings.Split(rest, "/")
+	if len(parts) != 3 {
+		return "", "", "", &AzureSubjectError{Subject: sub, Reason: "expected sc://<organization>/<project>/<service-connection>"}
+	}
+	for _, part := range parts {
+		if part == "" {
+			return "", "", "", &AzureSubjectError{Subject: sub, Reason: "empty organization, project or service connection"}
+		}
+	}
+
+	return parts[0], parts[1], parts[2], nil
+}
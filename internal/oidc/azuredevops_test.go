@@ -0,0 +1,95 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/robohub/auth-service/internal/testutil"
+)
+
+func TestAzureDevOpsVerifier_Verify(t *testing.T) {
+	ti := newTestIssuer(t)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	v := NewAzureDevOpsVerifier(ti.server.URL, AzureDevOpsAudience, time.Minute, time.Hour,
+		WithClock(testutil.NewFakeClock(now)),
+	)
+
+	sign := func(sub string) string {
+		claims := jwt.MapClaims{
+			"iss": ti.server.URL,
+			"aud": AzureDevOpsAudience,
+			"iat": now.Unix(),
+			"exp": now.Add(10 * time.Minute).Unix(),
+		}
+		if sub != "" {
+			claims["sub"] = sub
+		}
+		return ti.sign(t, claims)
+	}
+
+	t.Run("valid service connection", func(t *testing.T) {
+		verified, err := v.Verify(context.Background(), sign("sc://robohub/firmware/deploy-connection"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if verified.Provider != ProviderAzureDevOps {
+			t.Errorf("expected provider %s, got %s", ProviderAzureDevOps, verified.Provider)
+		}
+		if verified.Repository != "robohub/firmware" {
+			t.Errorf("expected repository robohub/firmware, got %s", verified.Repository)
+		}
+		if verified.Workflow != "deploy-connection" {
+			t.Errorf("expected workflow deploy-connection, got %s", verified.Workflow)
+		}
+	})
+
+	t.Run("unexpected sub", func(t *testing.T) {
+		_, err := v.Verify(context.Background(), sign("repo:robohub/firmware:ref:refs/heads/main"))
+		var subErr *AzureSubjectError
+		if !errors.As(err, &subErr) {
+			t.Fatalf("expected AzureSubjectError, got %v", err)
+		}
+	})
+}
+
+func TestParseAzureSubject(t *testing.T) {
+	tests := []struct {
+		name           string
+		sub            string
+		wantOrg        string
+		wantProject    string
+		wantConnection string
+		wantError      bool
+	}{
+		{"valid", "sc://org/project/connection", "org", "project", "connection", false},
+		{"connection with spaces", "sc://org/project/My Connection", "org", "project", "My Connection", false},
+		{"missing", "", "", "", "", true},
+		{"wrong scheme", "https://org/project/connection", "", "", "", true},
+		{"too few segments", "sc://org/project", "", "", "", true},
+		{"too many segments", "sc://org/project/connection/extra", "", "", "", true},
+		{"empty project", "sc://org//connection", "", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			org, project, connection, err := parseAzureSubject(tt.sub)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("expected error=%v, got error=%v", tt.wantError, err)
+			}
+			if tt.wantError {
+				var subErr *AzureSubjectError
+				if !errors.As(err, &subErr) || subErr.Subject != tt.sub {
+					t.Errorf("expected AzureSubjectError for %q, got %v", tt.sub, err)
+				}
+				return
+			}
+			if org != tt.wantOrg || project != tt.wantProject || connection != tt.wantConnection {
+				t.Errorf("expected %s/%s/%s, got %s/%s/%s", tt.wantOrg, tt.wantProject, tt.wantConnection, org, project, connection)
+			}
+		})
+	}
+}
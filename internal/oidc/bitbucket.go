@@ -0,0 +1,66 @@
+package oidc
+
+import (
+	"context"
+	"time"
+
+	"github.com/robohub/auth-service/internal/types"
+)
+
+// ProviderBitbucket identifies Bitbucket Pipelines in subject details
+const ProviderBitbucket = "bitbucket_pipelines"
+
+// BitbucketIssuer returns the OIDC issuer URL for a Bitbucket workspace
+func BitbucketIssuer(workspace string) string {
+	return "https://api.bitbucket.org/2.0/workspaces/" + workspace + "/pipelines-config/identity/oidc"
+}
+
+// BitbucketVerifier verifies Bitbucket Pipelines OIDC tokens
+type BitbucketVerifier struct {
+	*verifierCore
+}
+
+// NewBitbucketVerifier creates a verifier for tokens issued by issuer (see
+// BitbucketIssuer). Bitbucket's audience is typically
+// ari:cloud:bitbucket::workspace/<workspace-uuid>.
+func NewBitbucketVerifier(issuer, audience string, clockSkew time.Duration, jwksTTL time.Duration, opts ...Option) *BitbucketVerifier {
+	return &BitbucketVerifier{
+		verifierCore: newVerifierCore(issuer, []string{audience}, issuer+"/keys.json", clockSkew, jwksTTL, opts),
+	}
+}
+
+// Verify verifies a Bitbucket Pipelines OIDC token. The repository UUID
+// becomes the repository and the step UUID the run ID. Tag and custom
+// pipelines carry no branchName, leaving Ref empty.
+func (v *BitbucketVerifier) Verify(ctx context.Context, tokenString string) (*types.VerifiedClaims, error) {
+	claims, err := v.verify(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	repositoryUUID, ok := claims["repositoryUuid"].(string)
+	if !ok || repositoryUUID == "" {
+		return nil, &MissingClaimError{Name: "repositoryUuid"}
+	}
+
+	stepUUID, ok := claims["stepUuid"].(string)
+	if !ok || stepUUID == "" {
+		return nil, &MissingClaimError{Name: "stepUuid"}
+	}
+
+	ref := ""
+	if branch, ok := claims["branchName"].(string); ok && branch != "" {
+		ref = "refs/heads/" + branch
+	}
+
+	return &types.VerifiedClaims{
+		Provider:   ProviderBitbucket,
+		Issuer:     v.issuer,
+		Repository: repositoryUUID,
+		Ref:        ref,
+		RunID:      stepUUID,
+		IssuedAt:   v.extractTimestamp(claims, "iat"),
+		ExpiresAt:  v.extractTimestamp(claims, "exp"),
+		Raw:        claims,
+	}, nil
+}
@@ -0,0 +1,109 @@
+package oidc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/robohub/auth-service/internal/testutil"
+)
+
+func TestBitbucketVerifier_Verify(t *testing.T) {
+	ti := newTestIssuer(t)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	audience := "ari:cloud:bitbucket::workspace/{8b5d6c8e-1f2a-4a3b-9c4d-5e6f7a8b9c0d}"
+
+	v := NewBitbucketVerifier(ti.server.URL, audience, time.Minute, time.Hour,
+		WithClock(testutil.NewFakeClock(now)),
+		WithJWKSURL(ti.server.URL+"/.well-known/jwks"),
+	)
+
+	baseClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":            ti.server.URL,
+			"aud":            audience,
+			"sub":            "{repo-uuid}:{step-uuid}",
+			"iat":            now.Unix(),
+			"exp":            now.Add(time.Hour).Unix(),
+			"repositoryUuid": "{repo-uuid}",
+			"stepUuid":       "{step-uuid}",
+			"pipelineUuid":   "{pipeline-uuid}",
+			"workspaceUuid":  "{workspace-uuid}",
+			"branchName":     "main",
+		}
+	}
+
+	tests := []struct {
+		name      string
+		mutate    func(jwt.MapClaims)
+		wantRef   string
+		wantError bool
+	}{
+		{
+			name:    "branch build",
+			mutate:  func(c jwt.MapClaims) {},
+			wantRef: "refs/heads/main",
+		},
+		{
+			name:    "tag build without branchName",
+			mutate:  func(c jwt.MapClaims) { delete(c, "branchName") },
+			wantRef: "",
+		},
+		{
+			name:    "empty branchName",
+			mutate:  func(c jwt.MapClaims) { c["branchName"] = "" },
+			wantRef: "",
+		},
+		{
+			name:      "missing repositoryUuid",
+			mutate:    func(c jwt.MapClaims) { delete(c, "repositoryUuid") },
+			wantError: true,
+		},
+		{
+			name:      "missing stepUuid",
+			mutate:    func(c jwt.MapClaims) { delete(c, "stepUuid") },
+			wantError: true,
+		},
+		{
+			name:      "wrong audience",
+			mutate:    func(c jwt.MapClaims) { c["aud"] = "robohub" },
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := baseClaims()
+			tt.mutate(claims)
+
+			verified, err := v.Verify(context.Background(), ti.sign(t, claims))
+			if (err != nil) != tt.wantError {
+				t.Fatalf("expected error=%v, got error=%v", tt.wantError, err)
+			}
+			if tt.wantError {
+				return
+			}
+
+			if verified.Provider != ProviderBitbucket {
+				t.Errorf("expected provider %s, got %s", ProviderBitbucket, verified.Provider)
+			}
+			if verified.Repository != "{repo-uuid}" {
+				t.Errorf("expected repository {repo-uuid}, got %s", verified.Repository)
+			}
+			if verified.RunID != "{step-uuid}" {
+				t.Errorf("expected run ID {step-uuid}, got %s", verified.RunID)
+			}
+			if verified.Ref != tt.wantRef {
+				t.Errorf("expected ref %q, got %q", tt.wantRef, verified.Ref)
+			}
+		})
+	}
+}
+
+func TestBitbucketIssuer(t *testing.T) {
+	want := "https://api.bitbucket.org/2.0/workspaces/robohub/pipelines-config/identity/oidc"
+	if got := BitbucketIssuer("robohub"); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,75 @@
+package oidc
+
+import (
+	"context"
+	"time"
+
+	"github.com/robohub/auth-service/internal/types"
+)
+
+const (
+	// ProviderBuildkite identifies Buildkite in subject details
+	ProviderBuildkite = "buildkite"
+
+	// BuildkiteIssuer is the issuer of Buildkite agent OIDC tokens
+	BuildkiteIssuer = "https://agent.buildkite.com"
+)
+
+// BuildkiteVerifier verifies Buildkite agent OIDC tokens
+type BuildkiteVerifier struct {
+	*verifierCore
+}
+
+// NewBuildkiteVerifier creates a new Buildkite OIDC verifier
+func NewBuildkiteVerifier(issuer, audience string, clockSkew time.Duration, jwksTTL time.Duration, opts ...Option) *BuildkiteVerifier {
+	return &BuildkiteVerifier{
+		verifierCore: newVerifierCore(issuer, []string{audience}, issuer+"/.well-known/jwks", clockSkew, jwksTTL, opts),
+	}
+}
+
+// Verify verifies a Buildkite agent OIDC token. The repository is
+// organization_slug/pipeline_slug so that policy and rate limiting apply per
+// pipeline, and the build number becomes the run ID.
+func (v *BuildkiteVerifier) Verify(ctx context.Context, tokenString string) (*types.VerifiedClaims, error) {
+	claims, err := v.verify(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	organization, ok := claims["organization_slug"].(string)
+	if !ok || organization == "" {
+		return nil, &MissingClaimError{Name: "organization_slug"}
+	}
+
+	pipeline, ok := claims["pipeline_slug"].(string)
+	if !ok || pipeline == "" {
+		return nil, &MissingClaimError{Name: "pipeline_slug"}
+	}
+
+	buildNumber := v.extractStringOrNumber(claims, "build_number")
+	if buildNumber == "" {
+		return nil, &MissingClaimError{Name: "build_number"}
+	}
+
+	// Tag builds carry build_tag alongside the branch the tag was cut from
+	ref := ""
+	if tag, ok := claims["build_tag"].(string); ok && tag != "" {
+		ref = "refs/tags/" + tag
+	} else if branch, ok := claims["build_branch"].(string); ok && branch != "" {
+		ref = "refs/heads/" + branch
+	}
+	if ref == "" {
+		return nil, &MissingClaimError{Name: "build_branch or build_tag"}
+	}
+
+	return &types.VerifiedClaims{
+		Provider:   ProviderBuildkite,
+		Issuer:     v.issuer,
+		Repository: organization + "/" + pipeline,
+		Ref:        ref,
+		RunID:      buildNumber,
+		IssuedAt:   v.extractTimestamp(claims, "iat"),
+		ExpiresAt:  v.extractTimestamp(claims, "exp"),
+		Raw:        claims,
+	}, nil
+}
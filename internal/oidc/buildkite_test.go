@@ -0,0 +1,112 @@
+package oidc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/robohub/auth-service/internal/testutil"
+)
+
+func TestBuildkiteVerifier_Verify(t *testing.T) {
+	ti := newTestIssuer(t)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	v := NewBuildkiteVerifier(ti.server.URL, "robohub", time.Minute, time.Hour,
+		WithClock(testutil.NewFakeClock(now)),
+	)
+
+	baseClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":               ti.server.URL,
+			"aud":               "robohub",
+			"sub":               "organization:robohub:pipeline:firmware:ref:refs/heads/main:commit:abc123:step:build",
+			"iat":               now.Unix(),
+			"exp":               now.Add(5 * time.Minute).Unix(),
+			"organization_slug": "robohub",
+			"pipeline_slug":     "firmware",
+			"build_number":      float64(1234),
+			"build_branch":      "main",
+			"build_commit":      "abc123",
+			"step_key":          "build",
+		}
+	}
+
+	tests := []struct {
+		name      string
+		mutate    func(jwt.MapClaims)
+		wantRef   string
+		wantRunID string
+		wantError bool
+	}{
+		{
+			name:      "branch build",
+			mutate:    func(c jwt.MapClaims) {},
+			wantRef:   "refs/heads/main",
+			wantRunID: "1234",
+		},
+		{
+			name: "tag build",
+			mutate: func(c jwt.MapClaims) {
+				c["build_tag"] = "v1.2.3"
+			},
+			wantRef:   "refs/tags/v1.2.3",
+			wantRunID: "1234",
+		},
+		{
+			name:      "string build_number",
+			mutate:    func(c jwt.MapClaims) { c["build_number"] = "42" },
+			wantRef:   "refs/heads/main",
+			wantRunID: "42",
+		},
+		{
+			name:      "missing organization_slug",
+			mutate:    func(c jwt.MapClaims) { delete(c, "organization_slug") },
+			wantError: true,
+		},
+		{
+			name:      "missing pipeline_slug",
+			mutate:    func(c jwt.MapClaims) { delete(c, "pipeline_slug") },
+			wantError: true,
+		},
+		{
+			name:      "missing build_number",
+			mutate:    func(c jwt.MapClaims) { delete(c, "build_number") },
+			wantError: true,
+		},
+		{
+			name:      "missing branch and tag",
+			mutate:    func(c jwt.MapClaims) { delete(c, "build_branch") },
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := baseClaims()
+			tt.mutate(claims)
+
+			verified, err := v.Verify(context.Background(), ti.sign(t, claims))
+			if (err != nil) != tt.wantError {
+				t.Fatalf("expected error=%v, got error=%v", tt.wantError, err)
+			}
+			if tt.wantError {
+				return
+			}
+
+			if verified.Provider != ProviderBuildkite {
+				t.Errorf("expected provider %s, got %s", ProviderBuildkite, verified.Provider)
+			}
+			if verified.Repository != "robohub/firmware" {
+				t.Errorf("expected repository robohub/firmware, got %s", verified.Repository)
+			}
+			if verified.Ref != tt.wantRef {
+				t.Errorf("expected ref %q, got %q", tt.wantRef, verified.Ref)
+			}
+			if verified.RunID != tt.wantRunID {
+				t.Errorf("expected run ID %q, got %q", tt.wantRunID, verified.RunID)
+			}
+		})
+	}
+}
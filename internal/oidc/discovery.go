@@ -0,0 +1,107 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robohub/auth-service/internal/clock"
+	"github.com/robohub/auth-service/internal/correlation"
+)
+
+// Discovery resolves an issuer's jwks_uri from its OpenID Provider
+// configuration document and caches it. When a refresh fails the last known
+// jwks_uri keeps being used.
+type Discovery struct {
+	issuer     string
+	ttl        time.Duration
+	clock      clock.Clock
+	logger     *slog.Logger
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	jwksURI   string
+	fetchedAt time.Time
+}
+
+// NewDiscovery creates a discovery client for issuer whose result is cached
+// for ttl
+func NewDiscovery(issuer string, ttl time.Duration) *Discovery {
+	return &Discovery{
+		issuer:     strings.TrimSuffix(issuer, "/"),
+		ttl:        ttl,
+		clock:      clock.Real{},
+		logger:     slog.Default(),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// JWKSURI returns the issuer's jwks_uri, fetching the configuration
+// document when the cached value has expired
+func (d *Discovery) JWKSURI(ctx context.Context) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.jwksURI != "" && d.clock.Now().Sub(d.fetchedAt) < d.ttl {
+		return d.jwksURI, nil
+	}
+
+	jwksURI, err := d.fetch(ctx)
+	if err != nil {
+		if d.jwksURI == "" {
+			return "", fmt.Errorf("OIDC discovery failed: %w", err)
+		}
+		d.logger.WarnContext(ctx, "OIDC discovery failed, using last known jwks_uri",
+			"issuer", d.issuer,
+			"jwks_uri", d.jwksURI,
+			"error", err,
+		)
+		return d.jwksURI, nil
+	}
+
+	d.jwksURI = jwksURI
+	d.fetchedAt = d.clock.Now()
+	return jwksURI, nil
+}
+
+func (d *Discovery) fetch(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	if id := correlation.FromContext(ctx); id != "" {
+		req.Header.Set(correlation.Header, id)
+	}
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch configuration: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var doc struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return "", fmt.Errorf("failed to decode configuration: %w", err)
+	}
+
+	if strings.TrimSuffix(doc.Issuer, "/") != d.issuer {
+		return "", fmt.Errorf("configuration issuer %q does not match %q", doc.Issuer, d.issuer)
+	}
+	if doc.JWKSURI == "" {
+		return "", fmt.Errorf("configuration has no jwks_uri")
+	}
+
+	return doc.JWKSURI, nil
+}
@@ -0,0 +1,158 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/robohub/auth-service/internal/testutil"
+)
+
+// newDiscoveryServer serves an OpenID configuration whose jwks_uri points at
+// a non-default path, proxying that path to the test issuer's JWKS
+func newDiscoveryServer(t *testing.T, ti *testIssuer) (*httptest.Server, *atomic.Int32, *atomic.Bool) {
+	t.Helper()
+
+	var requests atomic.Int32
+	var fail atomic.Bool
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			requests.Add(1)
+			if fail.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"issuer":   server.URL,
+				"jwks_uri": server.URL + "/oauth/keys",
+			})
+		case "/oauth/keys":
+			r.URL.Path = "/.well-known/jwks"
+			ti.server.Config.Handler.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &requests, &fail
+}
+
+func TestDiscovery_JWKSURI(t *testing.T) {
+	ti := newTestIssuer(t)
+	server, requests, fail := newDiscoveryServer(t, ti)
+	clk := testutil.NewFakeClock(time.Now())
+	ctx := context.Background()
+
+	d := NewDiscovery(server.URL, time.Hour)
+	d.clock = clk
+
+	uri, err := d.JWKSURI(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if uri != server.URL+"/oauth/keys" {
+		t.Errorf("unexpected jwks_uri: %s", uri)
+	}
+
+	t.Run("cached within TTL", func(t *testing.T) {
+		if _, err := d.JWKSURI(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if n := requests.Load(); n != 1 {
+			t.Errorf("expected 1 discovery request, got %d", n)
+		}
+	})
+
+	t.Run("refreshed after TTL", func(t *testing.T) {
+		clk.Advance(time.Hour)
+		if _, err := d.JWKSURI(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if n := requests.Load(); n != 2 {
+			t.Errorf("expected 2 discovery requests, got %d", n)
+		}
+	})
+
+	t.Run("failure falls back to last known", func(t *testing.T) {
+		fail.Store(true)
+		clk.Advance(time.Hour)
+
+		uri, err := d.JWKSURI(ctx)
+		if err != nil {
+			t.Fatalf("expected fallback, got error: %v", err)
+		}
+		if uri != server.URL+"/oauth/keys" {
+			t.Errorf("unexpected jwks_uri: %s", uri)
+		}
+	})
+}
+
+func TestDiscovery_Errors(t *testing.T) {
+	ti := newTestIssuer(t)
+	server, _, fail := newDiscoveryServer(t, ti)
+	ctx := context.Background()
+
+	t.Run("failure without last known", func(t *testing.T) {
+		fail.Store(true)
+		defer fail.Store(false)
+
+		if _, err := NewDiscovery(server.URL, time.Hour).JWKSURI(ctx); err == nil {
+			t.Error("expected error when discovery fails with nothing cached")
+		}
+	})
+
+	t.Run("issuer mismatch", func(t *testing.T) {
+		mismatched := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"issuer":   "https://evil.example.com",
+				"jwks_uri": "https://evil.example.com/keys",
+			})
+		}))
+		defer mismatched.Close()
+
+		if _, err := NewDiscovery(mismatched.URL, time.Hour).JWKSURI(ctx); err == nil {
+			t.Error("expected error for mismatched issuer")
+		}
+	})
+}
+
+func TestGitHubVerifier_WithDiscovery(t *testing.T) {
+	ti := newTestIssuer(t)
+	server, requests, _ := newDiscoveryServer(t, ti)
+	now := time.Now()
+
+	v := NewGitHubVerifier(server.URL, "robohub", time.Minute, time.Hour,
+		WithClock(testutil.NewFakeClock(now)),
+		WithDiscovery(time.Hour),
+	)
+
+	if err := v.Discover(context.Background()); err != nil {
+		t.Fatalf("unexpected discovery error: %v", err)
+	}
+
+	claims := ti.claims(now)
+	claims["iss"] = server.URL
+	if _, err := v.Verify(context.Background(), ti.sign(t, claims)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("expected discovery to be fetched once, got %d", n)
+	}
+
+	t.Run("disabled keeps the default JWKS path", func(t *testing.T) {
+		v := NewGitHubVerifier(ti.server.URL, "robohub", time.Minute, time.Hour)
+		if err := v.Discover(context.Background()); err != nil {
+			t.Fatalf("expected no-op discovery, got %v", err)
+		}
+		if _, err := v.Verify(context.Background(), ti.sign(t, ti.claims(time.Now()))); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
@@ -19,6 +19,7 @@ func (f *FakeVerifier) Verify(ctx context.Context, token string) (*types.Verifie
 	}
 	// Default successful verification
 	return &types.VerifiedClaims{
+		Provider:   ProviderGitHub,
 		Repository: "test/repo",
 		Ref:        "refs/heads/main",
 		Actor:      "testuser",
@@ -0,0 +1,490 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/robohub/auth-service/internal/types"
+)
+
+// ProviderGitHub identifies GitHub Actions in subject details
+const ProviderGitHub = "github_actions"
+
+// ErrUntrustedIssuer is returned when a token's issuer is not one of the
+// verifier's trusted issuers
+var ErrUntrustedIssuer = errors.New("untrusted issuer")
+
+// GitHubSubjectError reports a GitHub sub claim that cannot be parsed or
+// disagrees with the token's other claims
+type GitHubSubjectError struct {
+	Subject string
+	Reason  string
+}
+
+func (e *GitHubSubjectError) Error() string {
+	return fmt.Sprintf("unexpected GitHub sub %q: %s", e.Subject, e.Reason)
+}
+
+// GitHubWorkflowError reports a workflow_ref claim that cannot be parsed or
+// names a different repository than the token's repository claim
+type GitHubWorkflowError struct {
+	WorkflowRef string
+	Reason      string
+}
+
+func (e *GitHubWorkflowError) Error() string {
+	return fmt.Sprintf("unexpected GitHub workflow_ref %q: %s", e.WorkflowRef, e.Reason)
+}
+
+// defaultSubjectTemplate is GitHub's default sub format, in template form
+const defaultSubjectTemplate = "repo:context"
+
+// githubSubject is a parsed GitHub Actions sub claim. Exactly one of Ref,
+// Environment and PullRequest is set.
+type githubSubject struct {
+	Repository  string
+	Ref         string
+	Environment string
+	PullRequest bool
+}
+
+// GitHubIssuer is a trusted GitHub or GitHub Enterprise Server token issuer,
+// such as https://github.example.com/_services/token
+type GitHubIssuer struct {
+	Issuer string
+
+	// Audiences are accepted interchangeably, e.g. while migrating to a new
+	// audience value
+	Audiences []string
+
+	// JWKSURL overrides issuer + "/.well-known/jwks" and disables discovery
+	JWKSURL string
+
+	// ClockSkew, JWKSTTL and MaxTokenLifetime override the verifier-wide
+	// settings for this issuer when non-zero
+	ClockSkew        time.Duration
+	JWKSTTL          time.Duration
+	MaxTokenLifetime time.Duration
+}
+
+// GitHubVerifier verifies GitHub Actions OIDC tokens from one or more
+// trusted issuers. The embedded core is the first configured issuer.
+type GitHubVerifier struct {
+	*verifierCore
+	issuers map[string]*verifierCore
+}
+
+// NewGitHubVerifier creates a new GitHub OIDC verifier trusting a single
+// issuer
+func NewGitHubVerifier(issuer, audience string, clockSkew time.Duration, jwksTTL time.Duration, opts ...Option) *GitHubVerifier {
+	return NewGitHubVerifierForIssuers([]GitHubIssuer{{Issuer: issuer, Audiences: []string{audience}}}, clockSkew, jwksTTL, opts...)
+}
+
+// NewGitHubVerifierForIssuers creates a GitHub OIDC verifier that accepts
+// tokens from any of the given issuers, each checked against its own
+// audience and JWKS. clockSkew, jwksTTL and opts apply to every issuer unless
+// it overrides them. issuers must not be empty.
+func NewGitHubVerifierForIssuers(issuers []GitHubIssuer, clockSkew time.Duration, jwksTTL time.Duration, opts ...Option) *GitHubVerifier {
+	v := &GitHubVerifier{
+		issuers: make(map[string]*verifierCore, len(issuers)),
+	}
+	for _, iss := range issuers {
+		issuerOpts := append([]Option{}, opts...)
+		if iss.JWKSURL != "" {
+			issuerOpts = append(issuerOpts, WithJWKSURL(iss.JWKSURL))
+		}
+		if iss.MaxTokenLifetime != 0 {
+			issuerOpts = append(issuerOpts, WithMaxTokenLifetime(iss.MaxTokenLifetime))
+		}
+		issuerSkew, issuerTTL := clockSkew, jwksTTL
+		if iss.ClockSkew != 0 {
+			issuerSkew = iss.ClockSkew
+		}
+		if iss.JWKSTTL != 0 {
+			issuerTTL = iss.JWKSTTL
+		}
+		core := newVerifierCore(iss.Issuer, iss.Audiences, iss.Issuer+"/.well-known/jwks", issuerSkew, issuerTTL, issuerOpts)
+		if v.verifierCore == nil {
+			v.verifierCore = core
+		}
+		v.issuers[iss.Issuer] = core
+	}
+	return v
+}
+
+// Discover resolves the JWKS URL of every trusted issuer through OIDC
+// discovery
+func (v *GitHubVerifier) Discover(ctx context.Context) error {
+	var errs []error
+	for issuer, core := range v.issuers {
+		if err := core.Discover(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", issuer, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Warm fetches the keys of every trusted issuer
+func (v *GitHubVerifier) Warm(ctx context.Context) error {
+	var errs []error
+	for issuer, core := range v.issuers {
+		if err := core.Warm(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", issuer, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Start refreshes the keys of every trusted issuer in the background
+func (v *GitHubVerifier) Start() {
+	for _, core := range v.issuers {
+		core.Start()
+	}
+}
+
+// Stop ends the background key refresh of every trusted issuer
+func (v *GitHubVerifier) Stop() {
+	for _, core := range v.issuers {
+		core.Stop()
+	}
+}
+
+// KeyStatus reports the stalest keys across the trusted issuers
+func (v *GitHubVerifier) KeyStatus() KeyStatus {
+	var worst KeyStatus
+	for _, core := range v.issuers {
+		status := core.KeyStatus()
+		if status.Staleness > worst.Staleness {
+			worst.Staleness = status.Staleness
+		}
+		worst.Expired = worst.Expired || status.Expired
+		worst.Cold = worst.Cold || status.Cold
+	}
+	return worst
+}
+
+// JWKSStats reports the metrics of every trusted issuer's key cache, ordered
+// by issuer
+func (v *GitHubVerifier) JWKSStats() []JWKSStats {
+	issuers := make([]string, 0, len(v.issuers))
+	for issuer := range v.issuers {
+		issuers = append(issuers, issuer)
+	}
+	sort.Strings(issuers)
+
+	stats := make([]JWKSStats, 0, len(issuers))
+	for _, issuer := range issuers {
+		stats = append(stats, v.issuers[issuer].JWKSStats()...)
+	}
+	return stats
+}
+
+// Verify verifies a GitHub Actions OIDC token
+func (v *GitHubVerifier) Verify(ctx context.Context, tokenString string) (*types.VerifiedClaims, error) {
+	// Picking the issuer decodes the token, so bound it first
+	if err := v.checkTokenSize(tokenString); err != nil {
+		return nil, err
+	}
+	core, err := v.coreFor(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := core.verify(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	audience, err := core.matchAudience(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	// Extract required claims
+	repository, ok := claims["repository"].(string)
+	if !ok || repository == "" {
+		return nil, &MissingClaimError{Name: "repository"}
+	}
+
+	ref, ok := claims["ref"].(string)
+	if !ok || ref == "" {
+		return nil, &MissingClaimError{Name: "ref"}
+	}
+
+	actor, ok := claims["actor"].(string)
+	if !ok || actor == "" {
+		return nil, &MissingClaimError{Name: "actor"}
+	}
+
+	// Extract run_id (can be string or number)
+	runID := v.extractRunID(claims)
+	if runID == "" {
+		return nil, &MissingClaimError{Name: "run_id"}
+	}
+
+	// The first attempt of a run is 1; tokens from before run_attempt was
+	// added are treated the same
+	runAttempt := v.extractStringOrNumber(claims, "run_attempt")
+	if runAttempt == "" {
+		runAttempt = "1"
+	}
+
+	// Extract workflow (try workflow_ref first, then job_workflow_ref)
+	workflowRef, _ := claims["workflow_ref"].(string)
+	jobWorkflowRef, _ := claims["job_workflow_ref"].(string)
+	workflow := workflowRef
+	if workflow == "" {
+		workflow = jobWorkflowRef
+	}
+	if workflow == "" {
+		return nil, &MissingClaimError{Name: "workflow_ref or job_workflow_ref"}
+	}
+	if workflowRef != "" {
+		if err := checkGitHubWorkflow(workflowRef, repository); err != nil {
+			return nil, err
+		}
+	}
+	// The job may run a reusable workflow from another repository, which is
+	// allowed but worth knowing
+	reusableWorkflow := false
+	if jobRepository, ok := workflowRepository(jobWorkflowRef); ok && jobRepository != repository {
+		reusableWorkflow = true
+	}
+
+	environment, _ := claims["environment"].(string)
+	// repository_owner and repository_id let policies survive renames, but
+	// are not required
+	owner, _ := claims["repository_owner"].(string)
+	repositoryID := v.extractStringOrNumber(claims, "repository_id")
+	visibility, _ := claims["repository_visibility"].(string)
+	// Only set for repositories owned by an enterprise; personal accounts
+	// have none
+	enterprise, _ := claims["enterprise"].(string)
+	actorID := v.extractStringOrNumber(claims, "actor_id")
+
+	// Older tokens may lack event_name, so it is optional
+	eventName, _ := claims["event_name"].(string)
+	sha, _ := claims["sha"].(string)
+	refType, _ := claims["ref_type"].(string)
+	// Only set for pull_request and pull_request_target events; GitHub sends
+	// empty strings otherwise
+	baseRef, _ := claims["base_ref"].(string)
+	headRef, _ := claims["head_ref"].(string)
+	runnerEnvironment, _ := claims["runner_environment"].(string)
+	sub, _ := claims["sub"].(string)
+	subjectTemplate, err := core.matchGitHubSubject(sub, repository, ref, environment, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	// Extract timestamps
+	iat := v.extractTimestamp(claims, "iat")
+	exp := v.extractTimestamp(claims, "exp")
+
+	return &types.VerifiedClaims{
+		Provider:          ProviderGitHub,
+		Issuer:            core.issuer,
+		Audience:          audience,
+		Repository:        repository,
+		Owner:             owner,
+		RepositoryID:      repositoryID,
+		Visibility:        visibility,
+		Enterprise:        enterprise,
+		Ref:               ref,
+		RefType:           refType,
+		BaseRef:           baseRef,
+		HeadRef:           headRef,
+		SHA:               sha,
+		Actor:             actor,
+		ActorID:           actorID,
+		RunID:             runID,
+		RunAttempt:        runAttempt,
+		Workflow:          workflow,
+		WorkflowRef:       workflowRef,
+		JobWorkflowRef:    jobWorkflowRef,
+		ReusableWorkflow:  reusableWorkflow,
+		RunnerEnvironment: runnerEnvironment,
+		Environment:       environment,
+		EventName:         eventName,
+		IssuedAt:          iat,
+		ExpiresAt:         exp,
+		SubjectTemplate:   subjectTemplate,
+		Raw:               claims,
+	}, nil
+}
+
+// coreFor selects the trusted issuer named by the token's unverified iss
+// claim. The selected core then verifies the signature and the issuer again.
+func (v *GitHubVerifier) coreFor(tokenString string) (*verifierCore, error) {
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, claims); err != nil {
+		return nil, fmt.Errorf("failed to parse token: %w", err)
+	}
+
+	iss, _ := claims["iss"].(string)
+	core, ok := v.issuers[iss]
+	if !ok {
+		return nil, fmt.Errorf("%w: %w %q", ErrIssuerMismatch, ErrUntrustedIssuer, iss)
+	}
+	return core, nil
+}
+
+func (v *GitHubVerifier) extractRunID(claims jwt.MapClaims) string {
+	return v.extractStringOrNumber(claims, "run_id")
+}
+
+// parseGitHubSubject parses the default GitHub sub shapes:
+// repo:<owner>/<repo>:ref:<ref>, repo:<owner>/<repo>:environment:<name> and
+// repo:<owner>/<repo>:pull_request
+func parseGitHubSubject(sub string) (githubSubject, error) {
+	if sub == "" {
+		return githubSubject{}, &GitHubSubjectError{Subject: sub, Reason: "missing sub claim"}
+	}
+
+	rest, ok := strings.CutPrefix(sub, "repo:")
+	if !ok {
+		return githubSubject{}, &GitHubSubjectError{Subject: sub, Reason: `expected "repo:" prefix`}
+	}
+
+	repository, scope, ok := strings.Cut(rest, ":")
+	owner, name, _ := strings.Cut(repository, "/")
+	if !ok || owner == "" || name == "" {
+		return githubSubject{}, &GitHubSubjectError{Subject: sub, Reason: "expected repo:<owner>/<repo>:<context>"}
+	}
+
+	subject := githubSubject{Repository: repository}
+	switch {
+	case scope == "pull_request":
+		subject.PullRequest = true
+	case strings.HasPrefix(scope, "ref:"):
+		subject.Ref = strings.TrimPrefix(scope, "ref:")
+	case strings.HasPrefix(scope, "environment:"):
+		subject.Environment = strings.TrimPrefix(scope, "environment:")
+	default:
+		return githubSubject{}, &GitHubSubjectError{Subject: sub, Reason: "expected ref, environment or pull_request context"}
+	}
+	if subject.Ref == "" && subject.Environment == "" && !subject.PullRequest {
+		return githubSubject{}, &GitHubSubjectError{Subject: sub, Reason: "empty ref or environment"}
+	}
+
+	return subject, nil
+}
+
+// workflowRepository returns the repository of a workflow ref such as
+// owner/repo/.github/workflows/ci.yml@refs/heads/main
+func workflowRepository(workflowRef string) (string, bool) {
+	path, _, ok := strings.Cut(workflowRef, "@")
+	if !ok {
+		return "", false
+	}
+	parts := strings.SplitN(path, "/", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", false
+	}
+	return parts[0] + "/" + parts[1], true
+}
+
+// checkGitHubWorkflow verifies that the caller workflow belongs to the
+// token's repository
+func checkGitHubWorkflow(workflowRef, repository string) error {
+	workflowRepo, ok := workflowRepository(workflowRef)
+	if !ok {
+		return &GitHubWorkflowError{WorkflowRef: workflowRef, Reason: "expected owner/repo/path@ref"}
+	}
+	if workflowRepo != repository {
+		return &GitHubWorkflowError{WorkflowRef: workflowRef, Reason: fmt.Sprintf("does not match repository %q", repository)}
+	}
+	return nil
+}
+
+// checkGitHubSubject checks that sub names the same repository, and ref or
+// environment, as the token's other claims
+func checkGitHubSubject(sub, repository, ref, environment string) error {
+	subject, err := parseGitHubSubject(sub)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case subject.Repository != repository:
+		return &GitHubSubjectError{Subject: sub, Reason: fmt.Sprintf("does not match repository %q", repository)}
+	case subject.Ref != "" && subject.Ref != ref:
+		return &GitHubSubjectError{Subject: sub, Reason: fmt.Sprintf("does not match ref %q", ref)}
+	case subject.Environment != "" && subject.Environment != environment:
+		return &GitHubSubjectError{Subject: sub, Reason: fmt.Sprintf("does not match environment %q", environment)}
+	case subject.PullRequest && !strings.HasPrefix(ref, "refs/pull/"):
+		return &GitHubSubjectError{Subject: sub, Reason: fmt.Sprintf("pull_request subject with non pull request ref %q", ref)}
+	}
+	return nil
+}
+
+// matchGitHubSubject checks sub against the templates configured for the
+// token's repository, or failing that its owner, and returns the template it
+// matched. Without templates sub must have one of GitHub's default shapes.
+func (v *verifierCore) matchGitHubSubject(sub, repository, ref, environment string, claims jwt.MapClaims) (string, error) {
+	scope := repository
+	templates, ok := v.subjects[scope]
+	if !ok {
+		scope, _, _ = strings.Cut(repository, "/")
+		templates, ok = v.subjects[scope]
+	}
+	if !ok {
+		if err := checkGitHubSubject(sub, repository, ref, environment); err != nil {
+			return "", err
+		}
+		return defaultSubjectTemplate, nil
+	}
+
+	if sub == "" {
+		return "", &GitHubSubjectError{Subject: sub, Reason: "missing sub claim"}
+	}
+	for _, template := range templates {
+		if slices.Contains(v.renderGitHubSubject(template, repository, ref, environment, claims), sub) {
+			return template, nil
+		}
+	}
+	return "", &GitHubSubjectError{Subject: sub, Reason: fmt.Sprintf("matches none of the templates configured for %s", scope)}
+}
+
+// renderGitHubSubject returns the subs GitHub could have issued for the
+// token's claims under template. There may be more than one since the
+// context key depends on the job's environment and triggering event.
+func (v *verifierCore) renderGitHubSubject(template, repository, ref, environment string, claims jwt.MapClaims) []string {
+	contexts := []string{"ref:" + ref}
+	if environment != "" {
+		contexts = append(contexts, "environment:"+environment)
+	}
+	if strings.HasPrefix(ref, "refs/pull/") {
+		contexts = append(contexts, "pull_request")
+	}
+
+	subs := []string{""}
+	for i, key := range strings.Split(template, ":") {
+		var values []string
+		switch key {
+		case "context":
+			values = contexts
+		case "repo":
+			values = []string{"repo:" + repository}
+		default:
+			values = []string{key + ":" + v.extractStringOrNumber(claims, key)}
+		}
+
+		next := make([]string, 0, len(subs)*len(values))
+		for _, prefix := range subs {
+			for _, value := range values {
+				if i > 0 {
+					value = prefix + ":" + value
+				}
+				next = append(next, value)
+			}
+		}
+		subs = next
+	}
+	return subs
+}
@@ -0,0 +1,1084 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/robohub/auth-service/internal/testutil"
+)
+
+func TestGitHubVerifier_Verify(t *testing.T) {
+	ti := newTestIssuer(t)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	v := NewGitHubVerifier(ti.server.URL, "robohub", time.Minute, time.Hour, WithClock(testutil.NewFakeClock(now)))
+
+	claims, err := v.Verify(context.Background(), ti.sign(t, ti.claims(now)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claims.Repository != "owner/repo" {
+		t.Errorf("unexpected repository: %s", claims.Repository)
+	}
+	if !claims.IssuedAt.Equal(now) {
+		t.Errorf("expected iat %v, got %v", now, claims.IssuedAt)
+	}
+	if claims.Environment != "" {
+		t.Errorf("expected no environment, got %s", claims.Environment)
+	}
+	if claims.EventName != "" {
+		t.Errorf("expected no event name, got %s", claims.EventName)
+	}
+	if claims.SHA != "" {
+		t.Errorf("expected no sha, got %s", claims.SHA)
+	}
+
+	t.Run("runner environment", func(t *testing.T) {
+		for _, env := range []string{"github-hosted", "self-hosted", "ephemeral-pool", ""} {
+			tokenClaims := ti.claims(now)
+			if env != "" {
+				tokenClaims["runner_environment"] = env
+			}
+
+			claims, err := v.Verify(context.Background(), ti.sign(t, tokenClaims))
+			if err != nil {
+				t.Fatalf("unexpected error for %q: %v", env, err)
+			}
+			if claims.RunnerEnvironment != env {
+				t.Errorf("expected runner environment %q, got %q", env, claims.RunnerEnvironment)
+			}
+		}
+	})
+
+	t.Run("ref type", func(t *testing.T) {
+		tokenClaims := ti.claims(now)
+		tokenClaims["sub"] = "repo:owner/repo:ref:refs/tags/v1.2.3"
+		tokenClaims["ref"] = "refs/tags/v1.2.3"
+		tokenClaims["ref_type"] = "tag"
+
+		claims, err := v.Verify(context.Background(), ti.sign(t, tokenClaims))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if claims.RefType != "tag" {
+			t.Errorf("expected ref type tag, got %s", claims.RefType)
+		}
+	})
+
+	t.Run("pull request refs", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			event   string
+			baseRef string
+			headRef string
+		}{
+			{"pull request", "pull_request", "main", "feature/login"},
+			{"push", "push", "", ""},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tokenClaims := ti.claims(now)
+				tokenClaims["event_name"] = tt.event
+				tokenClaims["base_ref"] = tt.baseRef
+				tokenClaims["head_ref"] = tt.headRef
+				if tt.event == "pull_request" {
+					tokenClaims["sub"] = "repo:owner/repo:pull_request"
+					tokenClaims["ref"] = "refs/pull/42/merge"
+				}
+
+				claims, err := v.Verify(context.Background(), ti.sign(t, tokenClaims))
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if claims.BaseRef != tt.baseRef || claims.HeadRef != tt.headRef {
+					t.Errorf("expected base %q head %q, got base %q head %q", tt.baseRef, tt.headRef, claims.BaseRef, claims.HeadRef)
+				}
+			})
+		}
+	})
+
+	t.Run("run attempt", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			runAttempt interface{}
+			want       string
+		}{
+			{"numeric", 2, "2"},
+			{"string", "3", "3"},
+			{"missing", nil, "1"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tokenClaims := ti.claims(now)
+				if tt.runAttempt != nil {
+					tokenClaims["run_attempt"] = tt.runAttempt
+				}
+
+				claims, err := v.Verify(context.Background(), ti.sign(t, tokenClaims))
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if claims.RunAttempt != tt.want {
+					t.Errorf("expected run attempt %q, got %q", tt.want, claims.RunAttempt)
+				}
+			})
+		}
+	})
+
+	t.Run("raw claims", func(t *testing.T) {
+		tokenClaims := ti.claims(now)
+		tokenClaims["job_workflow_sha"] = "9f2c5e1"
+
+		claims, err := v.Verify(context.Background(), ti.sign(t, tokenClaims))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if claims.Raw["job_workflow_sha"] != "9f2c5e1" {
+			t.Errorf("expected unmodelled claim in Raw, got %v", claims.Raw["job_workflow_sha"])
+		}
+		if claims.Raw["repository"] != "owner/repo" {
+			t.Errorf("expected repository in Raw, got %v", claims.Raw["repository"])
+		}
+	})
+
+	t.Run("sha", func(t *testing.T) {
+		tokenClaims := ti.claims(now)
+		tokenClaims["sha"] = "ffac537e6cbbf934b08745a378932722df287a53"
+
+		claims, err := v.Verify(context.Background(), ti.sign(t, tokenClaims))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if claims.SHA != "ffac537e6cbbf934b08745a378932722df287a53" {
+			t.Errorf("unexpected sha: %s", claims.SHA)
+		}
+	})
+
+	t.Run("repository owner and id", func(t *testing.T) {
+		tests := []struct {
+			name         string
+			repositoryID interface{}
+			want         string
+		}{
+			{"numeric id", 123456789, "123456789"},
+			{"string id", "123456789", "123456789"},
+			{"missing id", nil, ""},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tokenClaims := ti.claims(now)
+				tokenClaims["repository_owner"] = "owner"
+				if tt.repositoryID != nil {
+					tokenClaims["repository_id"] = tt.repositoryID
+				}
+
+				claims, err := v.Verify(context.Background(), ti.sign(t, tokenClaims))
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if claims.Owner != "owner" {
+					t.Errorf("expected owner owner, got %s", claims.Owner)
+				}
+				if claims.RepositoryID != tt.want {
+					t.Errorf("expected repository id %q, got %q", tt.want, claims.RepositoryID)
+				}
+			})
+		}
+	})
+
+	t.Run("actor id and visibility", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			actorID    interface{}
+			visibility string
+			wantID     string
+		}{
+			{"numeric actor id", 1234567, "public", "1234567"},
+			{"string actor id", "1234567", "private", "1234567"},
+			{"missing visibility", 1234567, "", "1234567"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tokenClaims := ti.claims(now)
+				tokenClaims["actor_id"] = tt.actorID
+				if tt.visibility != "" {
+					tokenClaims["repository_visibility"] = tt.visibility
+				}
+
+				claims, err := v.Verify(context.Background(), ti.sign(t, tokenClaims))
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if claims.ActorID != tt.wantID {
+					t.Errorf("expected actor id %q, got %q", tt.wantID, claims.ActorID)
+				}
+				if claims.Visibility != tt.visibility {
+					t.Errorf("expected visibility %q, got %q", tt.visibility, claims.Visibility)
+				}
+			})
+		}
+	})
+
+	t.Run("event name", func(t *testing.T) {
+		for _, event := range []string{"push", "pull_request", "workflow_dispatch", "schedule"} {
+			tokenClaims := ti.claims(now)
+			tokenClaims["event_name"] = event
+			if event == "pull_request" {
+				tokenClaims["sub"] = "repo:owner/repo:pull_request"
+				tokenClaims["ref"] = "refs/pull/42/merge"
+			}
+
+			claims, err := v.Verify(context.Background(), ti.sign(t, tokenClaims))
+			if err != nil {
+				t.Fatalf("unexpected error for %s: %v", event, err)
+			}
+			if claims.EventName != event {
+				t.Errorf("expected event name %s, got %s", event, claims.EventName)
+			}
+		}
+	})
+
+	t.Run("enterprise", func(t *testing.T) {
+		for _, enterprise := range []string{"robohub-corp", ""} {
+			tokenClaims := ti.claims(now)
+			if enterprise != "" {
+				tokenClaims["enterprise"] = enterprise
+			}
+
+			claims, err := v.Verify(context.Background(), ti.sign(t, tokenClaims))
+			if err != nil {
+				t.Fatalf("unexpected error for %q: %v", enterprise, err)
+			}
+			if claims.Enterprise != enterprise {
+				t.Errorf("expected enterprise %q, got %q", enterprise, claims.Enterprise)
+			}
+		}
+	})
+
+	t.Run("environment", func(t *testing.T) {
+		tokenClaims := ti.claims(now)
+		tokenClaims["sub"] = "repo:owner/repo:environment:production"
+		tokenClaims["environment"] = "production"
+
+		claims, err := v.Verify(context.Background(), ti.sign(t, tokenClaims))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if claims.Environment != "production" {
+			t.Errorf("expected environment production, got %s", claims.Environment)
+		}
+	})
+}
+
+func TestGitHubVerifier_Verify_Subject(t *testing.T) {
+	ti := newTestIssuer(t)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	v := NewGitHubVerifier(ti.server.URL, "robohub", time.Minute, time.Hour, WithClock(testutil.NewFakeClock(now)))
+
+	tests := []struct {
+		name      string
+		modify    func(jwt.MapClaims)
+		wantError bool
+	}{
+		{"matching ref", func(c jwt.MapClaims) {}, false},
+		{"matching environment", func(c jwt.MapClaims) {
+			c["sub"] = "repo:owner/repo:environment:production"
+			c["environment"] = "production"
+		}, false},
+		{"pull request", func(c jwt.MapClaims) {
+			c["sub"] = "repo:owner/repo:pull_request"
+			c["ref"] = "refs/pull/42/merge"
+		}, false},
+		{"missing sub", func(c jwt.MapClaims) { delete(c, "sub") }, true},
+		{"other repository", func(c jwt.MapClaims) { c["sub"] = "repo:owner/other:ref:refs/heads/main" }, true},
+		{"other ref", func(c jwt.MapClaims) { c["sub"] = "repo:owner/repo:ref:refs/heads/release" }, true},
+		{"other environment", func(c jwt.MapClaims) {
+			c["sub"] = "repo:owner/repo:environment:production"
+			c["environment"] = "staging"
+		}, true},
+		{"pull request with branch ref", func(c jwt.MapClaims) { c["sub"] = "repo:owner/repo:pull_request" }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := ti.claims(now)
+			tt.modify(claims)
+
+			_, err := v.Verify(context.Background(), ti.sign(t, claims))
+			if (err != nil) != tt.wantError {
+				t.Fatalf("expected error=%v, got error=%v", tt.wantError, err)
+			}
+			var subErr *GitHubSubjectError
+			if tt.wantError && !errors.As(err, &subErr) {
+				t.Errorf("expected GitHubSubjectError, got %v", err)
+			}
+		})
+	}
+}
+
+func TestGitHubVerifier_Verify_SubjectTemplates(t *testing.T) {
+	ti := newTestIssuer(t)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	v := NewGitHubVerifier(ti.server.URL, "robohub", time.Minute, time.Hour,
+		WithClock(testutil.NewFakeClock(now)),
+		WithGitHubSubjectTemplates(map[string][]string{
+			"owner":        {"repository_owner:context", "repo:context:job_workflow_ref"},
+			"owner/legacy": {"repo:context"},
+		}),
+	)
+
+	tests := []struct {
+		name         string
+		modify       func(jwt.MapClaims)
+		wantTemplate string
+		wantError    bool
+	}{
+		{"owner template", func(c jwt.MapClaims) {
+			c["sub"] = "repository_owner:owner:ref:refs/heads/main"
+			c["repository_owner"] = "owner"
+		}, "repository_owner:context", false},
+		{"owner template with environment", func(c jwt.MapClaims) {
+			c["sub"] = "repository_owner:owner:environment:production"
+			c["repository_owner"] = "owner"
+			c["environment"] = "production"
+		}, "repository_owner:context", false},
+		{"second owner template", func(c jwt.MapClaims) {
+			c["sub"] = "repo:owner/repo:ref:refs/heads/main:job_workflow_ref:shared/workflows/.github/workflows/deploy.yml@refs/tags/v1"
+			c["job_workflow_ref"] = "shared/workflows/.github/workflows/deploy.yml@refs/tags/v1"
+		}, "repo:context:job_workflow_ref", false},
+		{"repository template takes precedence", func(c jwt.MapClaims) {
+			c["sub"] = "repo:owner/legacy:ref:refs/heads/main"
+			c["repository"] = "owner/legacy"
+			c["workflow_ref"] = "owner/legacy/.github/workflows/ci.yml@refs/heads/main"
+		}, "repo:context", false},
+		{"owner without templates uses default", func(c jwt.MapClaims) {
+			c["sub"] = "repo:other/repo:ref:refs/heads/main"
+			c["repository"] = "other/repo"
+			c["workflow_ref"] = "other/repo/.github/workflows/ci.yml@refs/heads/main"
+		}, "repo:context", false},
+		{"default shape under owner template", func(c jwt.MapClaims) {}, "", true},
+		{"other job workflow", func(c jwt.MapClaims) {
+			c["sub"] = "repo:owner/repo:ref:refs/heads/main:job_workflow_ref:evil/workflows/.github/workflows/deploy.yml@refs/heads/main"
+			c["job_workflow_ref"] = "shared/workflows/.github/workflows/deploy.yml@refs/tags/v1"
+		}, "", true},
+		{"other owner", func(c jwt.MapClaims) {
+			c["sub"] = "repository_owner:evil:ref:refs/heads/main"
+			c["repository_owner"] = "owner"
+		}, "", true},
+		{"missing sub", func(c jwt.MapClaims) { delete(c, "sub") }, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := ti.claims(now)
+			tt.modify(claims)
+
+			verified, err := v.Verify(context.Background(), ti.sign(t, claims))
+			if (err != nil) != tt.wantError {
+				t.Fatalf("expected error=%v, got error=%v", tt.wantError, err)
+			}
+			if tt.wantError {
+				var subErr *GitHubSubjectError
+				if !errors.As(err, &subErr) {
+					t.Errorf("expected GitHubSubjectError, got %v", err)
+				}
+				return
+			}
+			if verified.SubjectTemplate != tt.wantTemplate {
+				t.Errorf("expected template %q, got %q", tt.wantTemplate, verified.SubjectTemplate)
+			}
+		})
+	}
+}
+
+func TestGitHubVerifier_Verify_WorkflowRef(t *testing.T) {
+	ti := newTestIssuer(t)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	v := NewGitHubVerifier(ti.server.URL, "robohub", time.Minute, time.Hour, WithClock(testutil.NewFakeClock(now)))
+
+	tests := []struct {
+		name           string
+		workflowRef    string
+		jobWorkflowRef string
+		wantReusable   bool
+		wantError      bool
+	}{
+		{"matching repository", "owner/repo/.github/workflows/ci.yml@refs/heads/main", "", false, false},
+		{"other repository", "other/repo/.github/workflows/ci.yml@refs/heads/main", "", false, true},
+		{"repository prefix", "owner/repo-fork/.github/workflows/ci.yml@refs/heads/main", "", false, true},
+		{"malformed", "ci.yml", "", false, true},
+		{"job workflow in same repository", "owner/repo/.github/workflows/ci.yml@refs/heads/main", "owner/repo/.github/workflows/build.yml@refs/heads/main", false, false},
+		{"reusable workflow", "owner/repo/.github/workflows/ci.yml@refs/heads/main", "shared/workflows/.github/workflows/deploy.yml@refs/tags/v1", true, false},
+		{"reusable workflow only", "", "shared/workflows/.github/workflows/deploy.yml@refs/tags/v1", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenClaims := ti.claims(now)
+			delete(tokenClaims, "workflow_ref")
+			if tt.workflowRef != "" {
+				tokenClaims["workflow_ref"] = tt.workflowRef
+			}
+			if tt.jobWorkflowRef != "" {
+				tokenClaims["job_workflow_ref"] = tt.jobWorkflowRef
+			}
+
+			claims, err := v.Verify(context.Background(), ti.sign(t, tokenClaims))
+			if tt.wantError {
+				var workflowErr *GitHubWorkflowError
+				if !errors.As(err, &workflowErr) {
+					t.Fatalf("expected GitHubWorkflowError, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if claims.ReusableWorkflow != tt.wantReusable {
+				t.Errorf("expected reusable workflow=%v, got %v", tt.wantReusable, claims.ReusableWorkflow)
+			}
+			if claims.WorkflowRef != tt.workflowRef || claims.JobWorkflowRef != tt.jobWorkflowRef {
+				t.Errorf("expected workflow refs %q and %q, got %q and %q", tt.workflowRef, tt.jobWorkflowRef, claims.WorkflowRef, claims.JobWorkflowRef)
+			}
+			wantWorkflow := tt.workflowRef
+			if wantWorkflow == "" {
+				wantWorkflow = tt.jobWorkflowRef
+			}
+			if claims.Workflow != wantWorkflow {
+				t.Errorf("expected workflow %q, got %q", wantWorkflow, claims.Workflow)
+			}
+		})
+	}
+}
+
+func TestParseGitHubSubject(t *testing.T) {
+	tests := []struct {
+		name      string
+		sub       string
+		want      githubSubject
+		wantError bool
+	}{
+		{"branch", "repo:owner/repo:ref:refs/heads/main", githubSubject{Repository: "owner/repo", Ref: "refs/heads/main"}, false},
+		{"tag", "repo:owner/repo:ref:refs/tags/v1.0.0", githubSubject{Repository: "owner/repo", Ref: "refs/tags/v1.0.0"}, false},
+		{"environment", "repo:owner/repo:environment:production", githubSubject{Repository: "owner/repo", Environment: "production"}, false},
+		{"pull request", "repo:owner/repo:pull_request", githubSubject{Repository: "owner/repo", PullRequest: true}, false},
+		{"missing", "", githubSubject{}, true},
+		{"wrong prefix", "organization:owner:repo:repo", githubSubject{}, true},
+		{"no context", "repo:owner/repo", githubSubject{}, true},
+		{"no owner", "repo:repo:ref:refs/heads/main", githubSubject{}, true},
+		{"empty ref", "repo:owner/repo:ref:", githubSubject{}, true},
+		{"unknown context", "repo:owner/repo:workflow:ci", githubSubject{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseGitHubSubject(tt.sub)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("expected error=%v, got error=%v", tt.wantError, err)
+			}
+			if tt.wantError {
+				var subErr *GitHubSubjectError
+				if !errors.As(err, &subErr) || subErr.Subject != tt.sub {
+					t.Errorf("expected GitHubSubjectError for %q, got %v", tt.sub, err)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGitHubVerifier_Audiences(t *testing.T) {
+	ti := newTestIssuer(t)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	v := NewGitHubVerifierForIssuers([]GitHubIssuer{
+		{Issuer: ti.server.URL, Audiences: []string{"robohub", "robohub-prod"}},
+	}, time.Minute, time.Hour, WithClock(testutil.NewFakeClock(now)))
+
+	tests := []struct {
+		name         string
+		aud          interface{}
+		wantAudience string
+		wantError    bool
+	}{
+		{"old audience", "robohub", "robohub", false},
+		{"new audience", "robohub-prod", "robohub-prod", false},
+		{"list containing new audience", []string{"other", "robohub-prod"}, "robohub-prod", false},
+		{"unknown audience", "robohub-staging", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := ti.claims(now)
+			claims["aud"] = tt.aud
+
+			verified, err := v.Verify(context.Background(), ti.sign(t, claims))
+			if (err != nil) != tt.wantError {
+				t.Fatalf("expected error=%v, got error=%v", tt.wantError, err)
+			}
+			if !tt.wantError && verified.Audience != tt.wantAudience {
+				t.Errorf("expected audience %s, got %s", tt.wantAudience, verified.Audience)
+			}
+		})
+	}
+}
+
+func TestGitHubVerifier_SigningMethods(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	ti := newTestIssuer(t)
+
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	jwks := serveJWKS(t, ecJWK("es256", &p256.PublicKey), ecJWK("es384", &p384.PublicKey), rsaJWK("rsa", &rsaKey.PublicKey))
+
+	defaultAlgs := NewGitHubVerifier(ti.server.URL, "robohub", time.Minute, time.Hour,
+		WithJWKSURL(jwks.URL),
+		WithClock(testutil.NewFakeClock(now)),
+	)
+	ecAlgs := NewGitHubVerifier(ti.server.URL, "robohub", time.Minute, time.Hour,
+		WithJWKSURL(jwks.URL),
+		WithClock(testutil.NewFakeClock(now)),
+		WithSigningAlgorithms("ES256", "ES384"),
+	)
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}) string {
+		token := jwt.NewWithClaims(method, ti.claims(now))
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		return signed
+	}
+
+	tests := []struct {
+		name      string
+		verifier  *GitHubVerifier
+		token     string
+		wantError bool
+	}{
+		{"RS256 by default", defaultAlgs, sign(jwt.SigningMethodRS256, "rsa", rsaKey), false},
+		{"RS384 not allowed by default", defaultAlgs, sign(jwt.SigningMethodRS384, "rsa", rsaKey), true},
+		{"ES256 not allowed by default", defaultAlgs, sign(jwt.SigningMethodES256, "es256", p256), true},
+		{"none", defaultAlgs, sign(jwt.SigningMethodNone, "rsa", jwt.UnsafeAllowNoneSignatureType), true},
+		{"ES256", ecAlgs, sign(jwt.SigningMethodES256, "es256", p256), false},
+		{"ES384", ecAlgs, sign(jwt.SigningMethodES384, "es384", p384), false},
+		{"RS256 not in configured list", ecAlgs, sign(jwt.SigningMethodRS256, "rsa", rsaKey), true},
+		{"ES384 signature under the ES256 kid", ecAlgs, sign(jwt.SigningMethodES384, "es256", p384), true},
+		{"HS256", ecAlgs, sign(jwt.SigningMethodHS256, "es256", []byte("secret")), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.verifier.Verify(context.Background(), tt.token)
+			if (err != nil) != tt.wantError {
+				t.Errorf("expected error=%v, got error=%v", tt.wantError, err)
+			}
+		})
+	}
+}
+
+func TestGitHubVerifier_MultipleIssuers(t *testing.T) {
+	dotcom := newTestIssuer(t)
+	ghes := newTestIssuer(t)
+	untrusted := newTestIssuer(t)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	v := NewGitHubVerifierForIssuers([]GitHubIssuer{
+		{Issuer: dotcom.server.URL, Audiences: []string{"robohub"}},
+		{Issuer: ghes.server.URL, Audiences: []string{"robohub-ghes"}, JWKSURL: ghes.server.URL + "/.well-known/jwks"},
+	}, time.Minute, time.Hour, WithClock(testutil.NewFakeClock(now)))
+
+	ghesClaims := func() jwt.MapClaims {
+		claims := ghes.claims(now)
+		claims["aud"] = "robohub-ghes"
+		return claims
+	}
+
+	t.Run("accepts each trusted issuer", func(t *testing.T) {
+		for _, tc := range []struct {
+			issuer *testIssuer
+			claims jwt.MapClaims
+		}{
+			{dotcom, dotcom.claims(now)},
+			{ghes, ghesClaims()},
+		} {
+			claims, err := v.Verify(context.Background(), tc.issuer.sign(t, tc.claims))
+			if err != nil {
+				t.Fatalf("unexpected error for %s: %v", tc.issuer.server.URL, err)
+			}
+			if claims.Issuer != tc.issuer.server.URL {
+				t.Errorf("expected issuer %s, got %s", tc.issuer.server.URL, claims.Issuer)
+			}
+		}
+	})
+
+	t.Run("checks each issuer's own audience", func(t *testing.T) {
+		claims := ghesClaims()
+		claims["aud"] = "robohub"
+		if _, err := v.Verify(context.Background(), ghes.sign(t, claims)); err == nil {
+			t.Error("expected error for audience of another issuer")
+		}
+	})
+
+	t.Run("rejects a token signed by another trusted issuer's key", func(t *testing.T) {
+		if _, err := v.Verify(context.Background(), ghes.sign(t, dotcom.claims(now))); err == nil {
+			t.Error("expected signature verification to fail")
+		}
+	})
+
+	t.Run("rejects untrusted issuer", func(t *testing.T) {
+		_, err := v.Verify(context.Background(), untrusted.sign(t, untrusted.claims(now)))
+		if !errors.Is(err, ErrUntrustedIssuer) {
+			t.Fatalf("expected ErrUntrustedIssuer, got %v", err)
+		}
+		if untrusted.fetches.Load() != 0 {
+			t.Error("expected no JWKS fetch from an untrusted issuer")
+		}
+	})
+}
+
+func TestGitHubVerifier_IssuerOverrides(t *testing.T) {
+	dotcom := newTestIssuer(t)
+	ghes := newTestIssuer(t)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	v := NewGitHubVerifierForIssuers([]GitHubIssuer{
+		{Issuer: dotcom.server.URL, Audiences: []string{"robohub"}},
+		{Issuer: ghes.server.URL, Audiences: []string{"robohub"}, ClockSkew: 5 * time.Minute, JWKSTTL: 10 * time.Minute, MaxTokenLifetime: time.Hour},
+	}, time.Minute, time.Hour, WithClock(testutil.NewFakeClock(now)), WithMaxTokenLifetime(15*time.Minute))
+
+	if ttl := v.issuers[dotcom.server.URL].jwksCache.ttl; ttl != time.Hour {
+		t.Errorf("expected default JWKS TTL of 1h, got %v", ttl)
+	}
+	if ttl := v.issuers[ghes.server.URL].jwksCache.ttl; ttl != 10*time.Minute {
+		t.Errorf("expected overridden JWKS TTL of 10m, got %v", ttl)
+	}
+
+	tests := []struct {
+		name     string
+		modify   func(jwt.MapClaims)
+		wantDot  bool
+		wantGHES bool
+	}{
+		{"within both settings", func(c jwt.MapClaims) {}, true, true},
+		{"issued in the future beyond the default skew", func(c jwt.MapClaims) {
+			c["iat"] = now.Add(3 * time.Minute).Unix()
+			c["nbf"] = now.Add(3 * time.Minute).Unix()
+			c["exp"] = now.Add(8 * time.Minute).Unix()
+		}, false, true},
+		{"issued in the future beyond the overridden skew", func(c jwt.MapClaims) {
+			c["iat"] = now.Add(10 * time.Minute).Unix()
+			c["nbf"] = now.Add(10 * time.Minute).Unix()
+			c["exp"] = now.Add(15 * time.Minute).Unix()
+		}, false, false},
+		{"lifetime beyond the default maximum", func(c jwt.MapClaims) {
+			c["exp"] = now.Add(30 * time.Minute).Unix()
+		}, false, true},
+		{"lifetime beyond the overridden maximum", func(c jwt.MapClaims) {
+			c["exp"] = now.Add(2 * time.Hour).Unix()
+		}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, tc := range []struct {
+				issuer *testIssuer
+				want   bool
+			}{
+				{dotcom, tt.wantDot},
+				{ghes, tt.wantGHES},
+			} {
+				claims := tc.issuer.claims(now)
+				tt.modify(claims)
+				_, err := v.Verify(context.Background(), tc.issuer.sign(t, claims))
+				if (err == nil) != tc.want {
+					t.Errorf("%s: expected success=%v, got error=%v", tc.issuer.server.URL, tc.want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestGitHubVerifier_Verify_ExpiryBoundary(t *testing.T) {
+	ti := newTestIssuer(t)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	skew := time.Minute
+
+	tests := []struct {
+		name      string
+		exp       time.Time
+		wantError bool
+	}{
+		{"expires well in the future", now.Add(5 * time.Minute), false},
+		{"expires at now plus skew", now.Add(skew), false},
+		{"expires at now", now, false},
+		{"expired within skew", now.Add(-skew + time.Second), false},
+		{"expired exactly at now minus skew", now.Add(-skew), true},
+		{"expired beyond skew", now.Add(-skew - time.Second), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewGitHubVerifier(ti.server.URL, "robohub", skew, time.Hour, WithClock(testutil.NewFakeClock(now)))
+
+			claims := ti.claims(now.Add(-10 * time.Minute))
+			claims["exp"] = tt.exp.Unix()
+
+			_, err := v.Verify(context.Background(), ti.sign(t, claims))
+			if (err != nil) != tt.wantError {
+				t.Errorf("expected error=%v, got error=%v", tt.wantError, err)
+			}
+		})
+	}
+}
+
+func TestGitHubVerifier_Verify_NotBefore(t *testing.T) {
+	ti := newTestIssuer(t)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	skew := time.Minute
+	v := NewGitHubVerifier(ti.server.URL, "robohub", skew, time.Hour, WithClock(testutil.NewFakeClock(now)))
+
+	tests := []struct {
+		name      string
+		nbf       interface{}
+		wantError bool
+	}{
+		{"in the past", now.Add(-time.Minute).Unix(), false},
+		{"slightly in the future", now.Add(30 * time.Second).Unix(), false},
+		{"at now plus skew", now.Add(skew).Unix(), false},
+		{"beyond skew", now.Add(skew + time.Second).Unix(), true},
+		{"far in the future", now.Add(24 * time.Hour).Unix(), true},
+		{"missing", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := ti.claims(now)
+			if tt.nbf == nil {
+				delete(claims, "nbf")
+			} else {
+				claims["nbf"] = tt.nbf
+			}
+
+			_, err := v.Verify(context.Background(), ti.sign(t, claims))
+			if (err != nil) != tt.wantError {
+				t.Fatalf("expected error=%v, got error=%v", tt.wantError, err)
+			}
+			if tt.wantError && !errors.Is(err, ErrTokenNotYetValid) {
+				t.Errorf("expected ErrTokenNotYetValid, got %v", err)
+			}
+		})
+	}
+}
+
+func TestGitHubVerifier_Verify_MaxTokenAge(t *testing.T) {
+	ti := newTestIssuer(t)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	skew := 30 * time.Second
+	maxAge := 2 * time.Minute
+
+	tests := []struct {
+		name      string
+		maxAge    time.Duration
+		iat       time.Time
+		wantError bool
+	}{
+		{"fresh token", maxAge, now, false},
+		{"just inside window", maxAge, now.Add(-maxAge - skew), false},
+		{"just outside window", maxAge, now.Add(-maxAge - skew - time.Second), true},
+		{"old token with check disabled", 0, now.Add(-4 * time.Minute), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewGitHubVerifier(ti.server.URL, "robohub", skew, time.Hour,
+				WithClock(testutil.NewFakeClock(now)),
+				WithMaxTokenAge(tt.maxAge),
+			)
+
+			claims := ti.claims(tt.iat)
+			_, err := v.Verify(context.Background(), ti.sign(t, claims))
+			if (err != nil) != tt.wantError {
+				t.Fatalf("expected error=%v, got error=%v", tt.wantError, err)
+			}
+			if tt.wantError && !errors.Is(err, ErrTokenTooOld) {
+				t.Errorf("expected ErrTokenTooOld, got %v", err)
+			}
+		})
+	}
+
+	t.Run("missing iat", func(t *testing.T) {
+		v := NewGitHubVerifier(ti.server.URL, "robohub", skew, time.Hour,
+			WithClock(testutil.NewFakeClock(now)),
+			WithMaxTokenAge(maxAge),
+		)
+
+		claims := ti.claims(now)
+		delete(claims, "iat")
+		if _, err := v.Verify(context.Background(), ti.sign(t, claims)); !errors.Is(err, ErrTokenTooOld) {
+			t.Errorf("expected ErrTokenTooOld, got %v", err)
+		}
+	})
+}
+
+func TestGitHubVerifier_Verify_MaxTokenLifetime(t *testing.T) {
+	ti := newTestIssuer(t)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	maxLifetime := 15 * time.Minute
+
+	tests := []struct {
+		name        string
+		maxLifetime time.Duration
+		lifetime    time.Duration
+		remove      string
+		wantError   bool
+	}{
+		{"typical GitHub token", maxLifetime, 5 * time.Minute, "", false},
+		{"at the maximum", maxLifetime, maxLifetime, "", false},
+		{"just over the maximum", maxLifetime, maxLifetime + time.Second, "", true},
+		{"24 hour token", maxLifetime, 24 * time.Hour, "", true},
+		{"missing iat", maxLifetime, 5 * time.Minute, "iat", true},
+		{"missing exp", maxLifetime, 5 * time.Minute, "exp", true},
+		{"24 hour token with check disabled", 0, 24 * time.Hour, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewGitHubVerifier(ti.server.URL, "robohub", time.Minute, time.Hour,
+				WithClock(testutil.NewFakeClock(now)),
+				WithMaxTokenLifetime(tt.maxLifetime),
+			)
+
+			claims := ti.claims(now)
+			claims["exp"] = now.Add(tt.lifetime).Unix()
+			if tt.remove != "" {
+				delete(claims, tt.remove)
+			}
+
+			_, err := v.Verify(context.Background(), ti.sign(t, claims))
+			if (err != nil) != tt.wantError {
+				t.Fatalf("expected error=%v, got error=%v", tt.wantError, err)
+			}
+			if tt.wantError && !errors.Is(err, ErrTokenLifetimeTooLong) {
+				t.Errorf("expected ErrTokenLifetimeTooLong, got %v", err)
+			}
+		})
+	}
+}
+
+func TestGitHubVerifier_Verify_RequiredClaims(t *testing.T) {
+	ti := newTestIssuer(t)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	v := NewGitHubVerifier(ti.server.URL, "robohub", time.Minute, time.Hour,
+		WithClock(testutil.NewFakeClock(now)),
+		WithRequiredClaims("sha", "repository_id"),
+	)
+
+	tests := []struct {
+		name      string
+		modify    func(jwt.MapClaims)
+		wantError string
+	}{
+		{"present", func(c jwt.MapClaims) {}, ""},
+		{"absent", func(c jwt.MapClaims) { delete(c, "sha") }, "missing or invalid sha claim"},
+		{"empty string", func(c jwt.MapClaims) { c["sha"] = "" }, "missing or invalid sha claim"},
+		{"numeric value", func(c jwt.MapClaims) { c["repository_id"] = 123456789 }, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := ti.claims(now)
+			claims["sha"] = "ffac537e6cbbf934b08745a378932722df287a53"
+			claims["repository_id"] = "123456789"
+			tt.modify(claims)
+
+			_, err := v.Verify(context.Background(), ti.sign(t, claims))
+			if tt.wantError == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+				t.Errorf("expected error containing %q, got %v", tt.wantError, err)
+			}
+		})
+	}
+
+	t.Run("built-in claims still required", func(t *testing.T) {
+		claims := ti.claims(now)
+		claims["sha"] = "ffac537e6cbbf934b08745a378932722df287a53"
+		claims["repository_id"] = "123456789"
+		delete(claims, "actor")
+		if _, err := v.Verify(context.Background(), ti.sign(t, claims)); err == nil {
+			t.Error("expected error for missing actor")
+		}
+	})
+}
+
+func TestGitHubVerifier_Verify_ErrorTypes(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		token  func(t *testing.T, ti *testIssuer) string
+		target error
+		claim  string
+	}{
+		{"expired", func(t *testing.T, ti *testIssuer) string {
+			return ti.sign(t, ti.claims(now.Add(-time.Hour)))
+		}, ErrExpired, ""},
+		{"signature", func(t *testing.T, ti *testIssuer) string {
+			token := ti.sign(t, ti.claims(now))
+			// Same kid, different key
+			ti.rotate(t, "test-kid")
+			return token
+		}, ErrSignature, ""},
+		{"issuer", func(t *testing.T, ti *testIssuer) string {
+			claims := ti.claims(now)
+			claims["iss"] = "https://github.evil.example/_services/token"
+			return ti.sign(t, claims)
+		}, ErrIssuerMismatch, ""},
+		{"audience", func(t *testing.T, ti *testIssuer) string {
+			claims := ti.claims(now)
+			claims["aud"] = "someone-else"
+			return ti.sign(t, claims)
+		}, ErrAudienceMismatch, ""},
+		{"missing claim", func(t *testing.T, ti *testIssuer) string {
+			claims := ti.claims(now)
+			delete(claims, "actor")
+			return ti.sign(t, claims)
+		}, nil, "actor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ti := newTestIssuer(t)
+			v := NewGitHubVerifier(ti.server.URL, "robohub", time.Minute, time.Hour, WithClock(testutil.NewFakeClock(now)))
+
+			_, err := v.Verify(context.Background(), tt.token(t, ti))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if tt.target != nil && !errors.Is(err, tt.target) {
+				t.Errorf("expected %v, got %v", tt.target, err)
+			}
+			if tt.claim != "" {
+				var claimErr *MissingClaimError
+				if !errors.As(err, &claimErr) || claimErr.Name != tt.claim {
+					t.Errorf("expected missing %s claim, got %v", tt.claim, err)
+				}
+			}
+		})
+	}
+}
+
+func TestGitHubVerifier_Verify_Oversized(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		opts      []Option
+		token     func(t *testing.T, ti *testIssuer) string
+		wantLarge bool
+	}{
+		{"padded claims", nil, func(t *testing.T, ti *testIssuer) string {
+			claims := ti.claims(now)
+			claims["padding"] = strings.Repeat("a", 16<<10)
+			return ti.sign(t, claims)
+		}, true},
+		{"not a token", nil, func(t *testing.T, ti *testIssuer) string {
+			return strings.Repeat("a", 4<<20)
+		}, true},
+		{"custom maximum", []Option{WithMaxTokenSize(512)}, func(t *testing.T, ti *testIssuer) string {
+			return ti.sign(t, ti.claims(now))
+		}, true},
+		{"long kid", nil, func(t *testing.T, ti *testIssuer) string {
+			ti.rotate(t, strings.Repeat("k", 1024))
+			return ti.sign(t, ti.claims(now))
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ti := newTestIssuer(t)
+			opts := append([]Option{WithClock(testutil.NewFakeClock(now))}, tt.opts...)
+			v := NewGitHubVerifier(ti.server.URL, "robohub", time.Minute, time.Hour, opts...)
+
+			_, err := v.Verify(context.Background(), tt.token(t, ti))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if errors.Is(err, ErrTokenTooLarge) != tt.wantLarge {
+				t.Errorf("expected ErrTokenTooLarge=%v, got %v", tt.wantLarge, err)
+			}
+			if n := ti.fetches.Load(); n != 0 {
+				t.Errorf("expected no JWKS fetch, got %d", n)
+			}
+		})
+	}
+}
+
+func TestGitHubVerifier_Verify_ExpiresAsClockAdvances(t *testing.T) {
+	ti := newTestIssuer(t)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	clk := testutil.NewFakeClock(now)
+	v := NewGitHubVerifier(ti.server.URL, "robohub", time.Minute, time.Hour, WithClock(clk))
+
+	tokenString := ti.sign(t, ti.claims(now))
+	if _, err := v.Verify(context.Background(), tokenString); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	clk.Advance(6 * time.Minute)
+
+	if _, err := v.Verify(context.Background(), tokenString); err == nil {
+		t.Error("expected error once the token expired beyond the skew")
+	}
+}
+
+func TestGitHubVerifier_extractRunID(t *testing.T) {
+	v := &GitHubVerifier{}
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		want   string
+	}{
+		{"string run_id", map[string]interface{}{"run_id": "123456789"}, "123456789"},
+		{"number run_id", map[string]interface{}{"run_id": 123456789.0}, "123456789"},
+		{"missing run_id", map[string]interface{}{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := v.extractRunID(tt.claims); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
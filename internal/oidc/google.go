@@ -0,0 +1,80 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robohub/auth-service/internal/types"
+)
+
+const (
+	// ProviderGoogle identifies Google Cloud service account tokens in
+	// subject details
+	ProviderGoogle = "google"
+
+	// GoogleIssuer is the issuer of Google-signed ID tokens
+	GoogleIssuer = "https://accounts.google.com"
+
+	// GoogleJWKSURL is where Google publishes its ID token signing keys
+	GoogleJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+)
+
+// GoogleVerifier verifies Google-signed ID tokens for GCP service accounts,
+// such as those minted for Cloud Build
+type GoogleVerifier struct {
+	*verifierCore
+	serviceAccounts map[string]bool
+	repository      string
+}
+
+// NewGoogleVerifier creates a new Google OIDC verifier. Only tokens whose
+// email is in serviceAccounts are accepted, and they are all attributed to the
+// logical repository name.
+func NewGoogleVerifier(issuer, audience string, serviceAccounts []string, repository string, clockSkew time.Duration, jwksTTL time.Duration, opts ...Option) *GoogleVerifier {
+	allowed := make(map[string]bool, len(serviceAccounts))
+	for _, sa := range serviceAccounts {
+		allowed[strings.ToLower(sa)] = true
+	}
+
+	return &GoogleVerifier{
+		verifierCore:    newVerifierCore(issuer, []string{audience}, GoogleJWKSURL, clockSkew, jwksTTL, opts),
+		serviceAccounts: allowed,
+		repository:      repository,
+	}
+}
+
+// Verify verifies a Google ID token and checks that it belongs to an allowed
+// service account. The email becomes the actor.
+func (v *GoogleVerifier) Verify(ctx context.Context, tokenString string) (*types.VerifiedClaims, error) {
+	claims, err := v.verify(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	email, ok := claims["email"].(string)
+	if !ok || email == "" {
+		return nil, &MissingClaimError{Name: "email"}
+	}
+
+	// email_verified is only present when the token was requested with the
+	// full format, so only reject it when it is explicitly false
+	if verified, ok := claims["email_verified"].(bool); ok && !verified {
+		return nil, fmt.Errorf("email %s is not verified", email)
+	}
+
+	if !v.serviceAccounts[strings.ToLower(email)] {
+		return nil, fmt.Errorf("service account %s is not allowed", email)
+	}
+
+	return &types.VerifiedClaims{
+		Provider:   ProviderGoogle,
+		Issuer:     v.issuer,
+		Repository: v.repository,
+		Actor:      email,
+		IssuedAt:   v.extractTimestamp(claims, "iat"),
+		ExpiresAt:  v.extractTimestamp(claims, "exp"),
+		Raw:        claims,
+	}, nil
+}
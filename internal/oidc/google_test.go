@@ -0,0 +1,74 @@
+package oidc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/robohub/auth-service/internal/testutil"
+)
+
+func TestGoogleVerifier_Verify(t *testing.T) {
+	ti := newTestIssuer(t)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	v := NewGoogleVerifier(ti.server.URL, "https://auth.robohub.example",
+		[]string{"firmware-builder@robohub-ci.iam.gserviceaccount.com"},
+		"robohub/firmware",
+		time.Minute, time.Hour,
+		WithJWKSURL(ti.server.URL+"/.well-known/jwks"),
+		WithClock(testutil.NewFakeClock(now)),
+	)
+
+	baseClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":            ti.server.URL,
+			"aud":            "https://auth.robohub.example",
+			"sub":            "104958723301948572031",
+			"email":          "firmware-builder@robohub-ci.iam.gserviceaccount.com",
+			"email_verified": true,
+			"iat":            now.Unix(),
+			"exp":            now.Add(time.Hour).Unix(),
+		}
+	}
+
+	tests := []struct {
+		name      string
+		modify    func(jwt.MapClaims)
+		wantError bool
+	}{
+		{"allowed service account", func(c jwt.MapClaims) {}, false},
+		{"email matched case-insensitively", func(c jwt.MapClaims) { c["email"] = "Firmware-Builder@robohub-ci.iam.gserviceaccount.com" }, false},
+		{"without email_verified", func(c jwt.MapClaims) { delete(c, "email_verified") }, false},
+		{"service account not allowed", func(c jwt.MapClaims) { c["email"] = "intruder@other.iam.gserviceaccount.com" }, true},
+		{"missing email", func(c jwt.MapClaims) { delete(c, "email") }, true},
+		{"unverified email", func(c jwt.MapClaims) { c["email_verified"] = false }, true},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "https://other.example" }, true},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://accounts.example.com" }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := baseClaims()
+			tt.modify(claims)
+
+			verified, err := v.Verify(context.Background(), ti.sign(t, claims))
+			if (err != nil) != tt.wantError {
+				t.Fatalf("expected error=%v, got error=%v", tt.wantError, err)
+			}
+			if tt.wantError {
+				return
+			}
+			if verified.Provider != ProviderGoogle {
+				t.Errorf("expected provider %s, got %s", ProviderGoogle, verified.Provider)
+			}
+			if verified.Actor != claims["email"] {
+				t.Errorf("expected actor %v, got %s", claims["email"], verified.Actor)
+			}
+			if verified.Repository != "robohub/firmware" {
+				t.Errorf("expected repository robohub/firmware, got %s", verified.Repository)
+			}
+		})
+	}
+}
@@ -0,0 +1,695 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/robohub/auth-service/internal/clock"
+	"github.com/robohub/auth-service/internal/correlation"
+	"golang.org/x/sync/singleflight"
+)
+
+// JWKSCache caches JWKS keys. Reads are lock-free: each successful fetch
+// publishes an immutable keySet snapshot that replaces the previous one, and
+// concurrent fetches are collapsed into a single outbound request.
+type JWKSCache struct {
+	url        string
+	discovery  *Discovery
+	ttl        time.Duration
+	clock      clock.Clock
+	logger     *slog.Logger
+	httpClient *http.Client
+	current    atomic.Pointer[keySet]
+	fetches    singleflight.Group
+
+	// When an unknown kid last forced a refetch of servable keys
+	lastForcedRefresh atomic.Pointer[time.Time]
+
+	// How long past the TTL keys are still served when refreshing fails
+	maxStale time.Duration
+
+	// Retries after a failed fetch, with exponential backoff from
+	// retryBaseDelay
+	retries        int
+	retryBaseDelay time.Duration
+
+	// Counters reported by Stats
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	fetchAttempts atomic.Uint64
+	fetchFailures atomic.Uint64
+
+	// Background refresher state, see Start
+	startOnce  sync.Once
+	stopOnce   sync.Once
+	refreshing atomic.Bool
+	cancel     context.CancelFunc
+	done       chan struct{}
+}
+
+// refreshAhead is the fraction of the TTL after which the background
+// refresher re-fetches the keys
+const refreshAhead = 0.8
+
+// forcedRefreshInterval limits refetches triggered by unknown kids while the
+// cached keys are still fresh
+const forcedRefreshInterval = 30 * time.Second
+
+const (
+	defaultMaxStale    = time.Hour
+	defaultJWKSRetries = 2
+	defaultRetryDelay  = 200 * time.Millisecond
+	maxRetryDelay      = 5 * time.Second
+)
+
+// keySet is an immutable snapshot of the keys served by the JWKS endpoint
+type keySet struct {
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+
+	// ttl is the configured TTL, shortened by the response's max-age
+	ttl time.Duration
+
+	// url and etag identify the response for conditional refreshes
+	url  string
+	etag string
+}
+
+// NewJWKSCache creates a new JWKS cache
+func NewJWKSCache(url string, ttl time.Duration) *JWKSCache {
+	return &JWKSCache{
+		url:    url,
+		ttl:    ttl,
+		clock:  clock.Real{},
+		logger: slog.Default(),
+		// The default transport honors HTTPS_PROXY and NO_PROXY
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+
+		maxStale:       defaultMaxStale,
+		retries:        defaultJWKSRetries,
+		retryBaseDelay: defaultRetryDelay,
+	}
+}
+
+// KeyStatus reports how old the cached keys are, for readiness checks and
+// metrics
+type KeyStatus struct {
+	// Staleness is how long past the TTL the current keys are; zero while
+	// they are fresh or before the first fetch
+	Staleness time.Duration
+
+	// Expired is set once the keys are stale beyond the max-stale bound and
+	// are no longer served
+	Expired bool
+
+	// Cold is set while the cache holds no keys, before the first successful
+	// fetch
+	Cold bool
+}
+
+// Status reports the staleness of the current keys
+func (c *JWKSCache) Status() KeyStatus {
+	set := c.current.Load()
+	if set == nil {
+		return KeyStatus{Cold: true}
+	}
+	staleness := c.staleness(set)
+	return KeyStatus{
+		Staleness: staleness,
+		Expired:   staleness > c.maxStale,
+		Cold:      len(set.keys) == 0,
+	}
+}
+
+// Warm fetches the keys unless the cache already holds some, retrying with
+// backoff until a fetch succeeds or ctx is done
+func (c *JWKSCache) Warm(ctx context.Context) error {
+	for attempt := 0; ; attempt++ {
+		if set := c.current.Load(); set != nil && len(set.keys) > 0 {
+			return nil
+		}
+
+		_, err := c.refresh(ctx, "")
+		if err == nil {
+			if set := c.current.Load(); set != nil && len(set.keys) > 0 {
+				return nil
+			}
+			// An empty key set fails the attempt like an error, so it is
+			// not refetched without backoff
+			err = errors.New("JWKS holds no usable keys")
+		}
+
+		delay := c.retryDelay(attempt)
+		c.logger.WarnContext(ctx, "JWKS warm-up failed, retrying", "url", c.url, "attempt", attempt+1, "retry_in", delay, "error", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("JWKS warm-up gave up after %d attempts: %w", attempt+1, err)
+		case <-timer.C:
+		}
+	}
+}
+
+// JWKSStats are the counters and current state of a JWKS cache, for metrics
+type JWKSStats struct {
+	// Issuer is set by the verifier owning the cache
+	Issuer string
+
+	// Hits and Misses count GetKey calls answered from the cache and those
+	// that had to refresh it
+	Hits   uint64
+	Misses uint64
+
+	// FetchAttempts counts requests to the JWKS endpoint, including retries,
+	// and FetchFailures those that failed
+	FetchAttempts uint64
+	FetchFailures uint64
+
+	// KeysLoaded is the number of keys in the current snapshot
+	KeysLoaded int
+
+	// SinceLastFetch is how long ago keys were last fetched or revalidated;
+	// only meaningful once Fetched is set
+	SinceLastFetch time.Duration
+	Fetched        bool
+}
+
+// Stats reports the cache's counters and current state
+func (c *JWKSCache) Stats() JWKSStats {
+	stats := JWKSStats{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		FetchAttempts: c.fetchAttempts.Load(),
+		FetchFailures: c.fetchFailures.Load(),
+	}
+	if set := c.current.Load(); set != nil {
+		stats.KeysLoaded = len(set.keys)
+		stats.SinceLastFetch = c.clock.Now().Sub(set.fetchedAt)
+		stats.Fetched = true
+	}
+	return stats
+}
+
+// GetKey retrieves a public key by kid. The key is an *rsa.PublicKey, an
+// *ecdsa.PublicKey or an ed25519.PublicKey.
+func (c *JWKSCache) GetKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if key, ok := c.lookup(kid); ok {
+		c.hits.Add(1)
+		return key, nil
+	}
+	c.misses.Add(1)
+
+	set, err := c.refresh(ctx, kid)
+	if err != nil {
+		// Keys from before the failed refresh are almost certainly still
+		// valid, so keep serving them for a bounded time
+		if key, ok := c.lookupStale(kid); ok {
+			c.logger.WarnContext(ctx, "JWKS refresh failed, serving stale keys",
+				"url", c.url, "staleness", c.Status().Staleness, "error", err)
+			return key, nil
+		}
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+
+	key, exists := set.keys[kid]
+	if !exists {
+		return nil, fmt.Errorf("key with kid %s not found in JWKS", kid)
+	}
+
+	return key, nil
+}
+
+// refresh fetches and publishes a new snapshot, sharing the fetch with any
+// concurrent callers. When kid is set, a servable snapshot is returned without
+// fetching if it already holds the kid or a forced refresh ran too recently.
+// The caller stops waiting when ctx is done, but the shared fetch carries on
+// for the other waiters.
+func (c *JWKSCache) refresh(ctx context.Context, kid string) (*keySet, error) {
+	// The fetch is shared by every waiter, so it must not be cut short when
+	// the caller that started it goes away
+	fetchCtx := context.WithoutCancel(ctx)
+
+	ch := c.fetches.DoChan("jwks", func() (interface{}, error) {
+		if set := c.current.Load(); kid != "" && set != nil && c.servable(set) {
+			// A flight that finished just before this one may already
+			// have loaded the key
+			if set.keys[kid] != nil {
+				return set, nil
+			}
+			// An unknown kid in servable keys usually means the issuer
+			// rotated its keys, but refetching for every unknown kid would
+			// let bogus tokens hammer the issuer
+			if !c.allowForcedRefresh() {
+				return set, nil
+			}
+		}
+
+		set, err := c.fetchJWKS(fetchCtx)
+		if err != nil {
+			return nil, err
+		}
+		c.current.Store(set)
+		return set, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result := <-ch:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.(*keySet), nil
+	}
+}
+
+// lookup returns the key for kid from the current snapshot if it is fresh.
+// While the background refresher runs a stale snapshot is still used, since
+// the refresher is already replacing it.
+func (c *JWKSCache) lookup(kid string) (crypto.PublicKey, bool) {
+	set := c.current.Load()
+	if set == nil || !c.servable(set) {
+		return nil, false
+	}
+	key, exists := set.keys[kid]
+	return key, exists
+}
+
+// servable reports whether lookups may use a snapshot without fetching
+func (c *JWKSCache) servable(set *keySet) bool {
+	if c.fresh(set) {
+		return true
+	}
+	return c.refreshing.Load() && c.staleness(set) <= c.maxStale
+}
+
+// allowForcedRefresh reports whether a refetch for an unknown kid may run
+// now, and if so records it. It is only called from within a flight, so
+// forced refreshes never race each other.
+func (c *JWKSCache) allowForcedRefresh() bool {
+	now := c.clock.Now()
+	if last := c.lastForcedRefresh.Load(); last != nil && now.Sub(*last) < forcedRefreshInterval {
+		return false
+	}
+	c.lastForcedRefresh.Store(&now)
+	return true
+}
+
+// lookupStale returns the key for kid from the current snapshot unless it is
+// stale beyond the max-stale bound
+func (c *JWKSCache) lookupStale(kid string) (crypto.PublicKey, bool) {
+	set := c.current.Load()
+	if set == nil || c.staleness(set) > c.maxStale {
+		return nil, false
+	}
+	key, exists := set.keys[kid]
+	return key, exists
+}
+
+// Start re-fetches the keys in the background shortly before they expire, so
+// that GetKey only blocks on a fetch when the cache is cold or the kid is
+// unknown. Call Stop to end the refresher.
+func (c *JWKSCache) Start() {
+	c.startOnce.Do(func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		c.cancel = cancel
+		c.done = make(chan struct{})
+		c.refreshing.Store(true)
+		go c.refreshLoop(ctx)
+	})
+}
+
+// Stop ends the background refresher started by Start and waits for it to
+// exit. It is a no-op if the refresher was never started.
+func (c *JWKSCache) Stop() {
+	if !c.refreshing.Load() {
+		return
+	}
+	c.stopOnce.Do(func() {
+		c.cancel()
+		<-c.done
+	})
+}
+
+func (c *JWKSCache) refreshLoop(ctx context.Context) {
+	defer close(c.done)
+
+	timer := time.NewTimer(c.nextRefresh())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		wait := max(c.ttl/10, time.Second)
+		if _, err := c.refresh(ctx, ""); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.logger.Warn("background JWKS refresh failed, retrying", "url", c.url, "retry_in", wait, "error", err)
+		} else {
+			wait = c.nextRefresh()
+		}
+		timer.Reset(wait)
+	}
+}
+
+// nextRefresh returns how long until the current snapshot should be replaced
+func (c *JWKSCache) nextRefresh() time.Duration {
+	set := c.current.Load()
+	if set == nil {
+		return 0
+	}
+	due := set.fetchedAt.Add(time.Duration(float64(set.ttl) * refreshAhead))
+	if wait := due.Sub(c.clock.Now()); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+func (c *JWKSCache) fresh(set *keySet) bool {
+	return c.clock.Now().Sub(set.fetchedAt) < set.ttl
+}
+
+// staleness returns how long past the TTL a snapshot is
+func (c *JWKSCache) staleness(set *keySet) time.Duration {
+	if stale := c.clock.Now().Sub(set.fetchedAt) - set.ttl; stale > 0 {
+		return stale
+	}
+	return 0
+}
+
+// retryableError marks fetch failures worth retrying: network errors and 5xx
+// responses
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+
+func (e *retryableError) Unwrap() error { return e.err }
+
+// fetchJWKS fetches the key set, retrying network errors and 5xx responses
+// with exponential backoff and jitter. It gives up early rather than sleep
+// past the context deadline.
+func (c *JWKSCache) fetchJWKS(ctx context.Context) (*keySet, error) {
+	for attempt := 0; ; attempt++ {
+		c.fetchAttempts.Add(1)
+		set, err := c.fetchJWKSOnce(ctx)
+		if err != nil {
+			c.fetchFailures.Add(1)
+		}
+		var retryable *retryableError
+		if err == nil || !errors.As(err, &retryable) || attempt >= c.retries {
+			if err != nil && attempt > 0 {
+				err = fmt.Errorf("after %d attempts: %w", attempt+1, err)
+			}
+			return set, err
+		}
+
+		delay := c.retryDelay(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return nil, fmt.Errorf("giving up before deadline after %d attempts: %w", attempt+1, err)
+		}
+
+		c.logger.WarnContext(ctx, "JWKS fetch failed, retrying", "url", c.url, "attempt", attempt+1, "retry_in", delay, "error", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("after %d attempts: %w", attempt+1, err)
+		case <-timer.C:
+		}
+	}
+}
+
+// retryDelay returns the backoff before retry attempt+1: the base delay
+// doubled per attempt, capped, with the upper half jittered
+func (c *JWKSCache) retryDelay(attempt int) time.Duration {
+	delay := c.retryBaseDelay << attempt
+	if delay <= 0 || delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+func (c *JWKSCache) fetchJWKSOnce(ctx context.Context) (*keySet, error) {
+	url := c.url
+	if c.discovery != nil {
+		var err error
+		if url, err = c.discovery.JWKSURI(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if id := correlation.FromContext(ctx); id != "" {
+		req.Header.Set(correlation.Header, id)
+	}
+
+	previous := c.current.Load()
+	if previous != nil && previous.url == url && previous.etag != "" {
+		req.Header.Set("If-None-Match", previous.etag)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, &retryableError{fmt.Errorf("failed to fetch JWKS: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, &retryableError{fmt.Errorf("unexpected status code: %d", resp.StatusCode)}
+	}
+
+	// The keys are unchanged; revalidation only renews them
+	if resp.StatusCode == http.StatusNotModified && previous != nil {
+		return &keySet{
+			keys:      previous.keys,
+			fetchedAt: c.clock.Now(),
+			ttl:       c.effectiveTTL(resp.Header),
+			url:       url,
+			etag:      previous.etag,
+		}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kid string   `json:"kid"`
+			Kty string   `json:"kty"`
+			Use string   `json:"use"`
+			N   string   `json:"n"`
+			E   string   `json:"e"`
+			Crv string   `json:"crv"`
+			X   string   `json:"x"`
+			Y   string   `json:"y"`
+			X5C []string `json:"x5c"`
+		} `json:"keys"`
+	}
+
+	if err := json.Unmarshal(body, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JWKS: %w", err)
+	}
+
+	// Parse keys into a new snapshot
+	newKeys := make(map[string]crypto.PublicKey)
+	for _, key := range jwks.Keys {
+		var pubKey crypto.PublicKey
+		var err error
+		switch {
+		case key.Kty == "RSA" && key.N != "":
+			pubKey, err = parseRSAPublicKey(key.N, key.E)
+		case key.Kty == "EC" && key.X != "":
+			pubKey, err = parseECPublicKey(key.Crv, key.X, key.Y)
+		case key.Kty == "OKP" && key.X != "":
+			pubKey, err = parseOKPPublicKey(key.Crv, key.X)
+		case (key.Kty == "RSA" || key.Kty == "EC") && len(key.X5C) > 0:
+			// Some enterprise IdPs publish only the certificate chain
+			pubKey, err = parseX5C(key.X5C, c.clock.Now())
+		default:
+			continue
+		}
+		if err != nil {
+			c.logger.Warn("skipping invalid JWKS key", "kid", key.Kid, "error", err)
+			continue
+		}
+
+		newKeys[key.Kid] = pubKey
+	}
+
+	return &keySet{
+		keys:      newKeys,
+		fetchedAt: c.clock.Now(),
+		ttl:       c.effectiveTTL(resp.Header),
+		url:       url,
+		etag:      resp.Header.Get("ETag"),
+	}, nil
+}
+
+// minJWKSTTL keeps a tiny or zero max-age from turning into a fetch per
+// request
+const minJWKSTTL = time.Minute
+
+// effectiveTTL returns the configured TTL, shortened to the response's
+// Cache-Control max-age when that is lower
+func (c *JWKSCache) effectiveTTL(header http.Header) time.Duration {
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(directive), "=")
+		if !ok || !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+		if err != nil || seconds < 0 {
+			break
+		}
+		maxAge := time.Duration(seconds) * time.Second
+		return max(min(maxAge, c.ttl), min(minJWKSTTL, c.ttl))
+	}
+	return c.ttl
+}
+
+func parseRSAPublicKey(nStr, eStr string) (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(nStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode n: %w", err)
+	}
+
+	eBytes, err := base64.RawURLEncoding.DecodeString(eStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode e: %w", err)
+	}
+
+	n := new(big.Int).SetBytes(nBytes)
+	e := 0
+	for _, b := range eBytes {
+		e = e*256 + int(b)
+	}
+
+	return &rsa.PublicKey{
+		N: n,
+		E: e,
+	}, nil
+}
+
+func parseECPublicKey(crv, xStr, yStr string) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", crv)
+	}
+
+	xBytes, err := base64.RawURLEncoding.DecodeString(xStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode x: %w", err)
+	}
+
+	yBytes, err := base64.RawURLEncoding.DecodeString(yStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode y: %w", err)
+	}
+
+	// RFC 7518 requires coordinates padded to the full field size
+	size := (curve.Params().BitSize + 7) / 8
+	if len(xBytes) != size || len(yBytes) != size {
+		return nil, fmt.Errorf("invalid coordinate length for %s", crv)
+	}
+
+	key := &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(xBytes),
+		Y:     new(big.Int).SetBytes(yBytes),
+	}
+
+	// ECDH rejects points that are not on the curve
+	if _, err := key.ECDH(); err != nil {
+		return nil, fmt.Errorf("invalid point for %s: %w", crv, err)
+	}
+
+	return key, nil
+}
+
+// parseOKPPublicKey parses an RFC 8037 octet key pair. Only Ed25519, the
+// curve for EdDSA signatures, is supported.
+func parseOKPPublicKey(crv, xStr string) (ed25519.PublicKey, error) {
+	if crv != "Ed25519" {
+		return nil, fmt.Errorf("unsupported curve %q", crv)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(xStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode x: %w", err)
+	}
+	if len(x) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid Ed25519 key length %d", len(x))
+	}
+	return ed25519.PublicKey(x), nil
+}
+
+// parseX5C returns the public key of the leaf certificate in an x5c chain,
+// rejecting certificates that are not valid at now
+func parseX5C(chain []string, now time.Time) (crypto.PublicKey, error) {
+	// x5c uses standard base64, not base64url
+	der, err := base64.StdEncoding.DecodeString(chain[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode x5c leaf certificate: %w", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse x5c leaf certificate: %w", err)
+	}
+
+	if now.After(cert.NotAfter) {
+		return nil, fmt.Errorf("x5c leaf certificate expired at %s", cert.NotAfter.Format(time.RFC3339))
+	}
+	if now.Before(cert.NotBefore) {
+		return nil, fmt.Errorf("x5c leaf certificate is not valid until %s", cert.NotBefore.Format(time.RFC3339))
+	}
+
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported x5c public key type %T", cert.PublicKey)
+	}
+}
@@ -0,0 +1,957 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/robohub/auth-service/internal/correlation"
+	"github.com/robohub/auth-service/internal/testutil"
+)
+
+func TestParseRSAPublicKey(t *testing.T) {
+	// Test with valid RSA key components (example from GitHub's JWKS)
+	// These are base64url encoded modulus and exponent
+	n := "xjlCRBqkQRiii6JJzkKNlLYNrwqqCRsf3a0g6s7dTbZSJmNvL0gVKfT_2GqM2cPbhGqJqJL9lFXJ5gZnMgSvVFCLkEYpQY3rR-pJQzkJFM1lLqJFd7QJIxJQlJQJpJGnJn9LjQQUKB6LQJ9n-2MnQQNnQmJMJJnQnQJMQQJnQQnQ"
+	e := "AQAB"
+
+	key, err := parseRSAPublicKey(n, e)
+	if err != nil {
+		t.Fatalf("expected valid key, got error: %v", err)
+	}
+	if key == nil {
+		t.Fatal("expected non-nil key")
+	}
+	if key.E != 65537 { // AQAB is standard exponent 65537
+		t.Errorf("expected exponent 65537, got %d", key.E)
+	}
+}
+
+func TestParseECPublicKey(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	valid := ecJWK("kid", &p256.PublicKey)
+	offCurve := ecJWK("kid", &ecdsa.PublicKey{Curve: elliptic.P256(), X: big.NewInt(1), Y: big.NewInt(1)})
+	p521, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	unsupported := ecJWK("kid", &p521.PublicKey)
+
+	tests := []struct {
+		name      string
+		crv, x, y string
+		wantError bool
+	}{
+		{"valid P-256", valid["crv"], valid["x"], valid["y"], false},
+		{"unsupported curve", unsupported["crv"], unsupported["x"], unsupported["y"], true},
+		{"point not on curve", offCurve["crv"], offCurve["x"], offCurve["y"], true},
+		{"unpadded coordinate", valid["crv"], "AQ", valid["y"], true},
+		{"invalid base64", valid["crv"], "!!", valid["y"], true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parseECPublicKey(tt.crv, tt.x, tt.y)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("expected error=%v, got error=%v", tt.wantError, err)
+			}
+			if !tt.wantError && !key.Equal(&p256.PublicKey) {
+				t.Error("expected parsed key to equal the original")
+			}
+		})
+	}
+}
+
+func TestJWKSCache_KeyTypes(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	okp := map[string]string{"kid": "okp", "kty": "OKP", "crv": "Ed25519", "x": base64.RawURLEncoding.EncodeToString(edKey)}
+	x25519 := map[string]string{"kid": "x25519", "kty": "OKP", "crv": "X25519", "x": base64.RawURLEncoding.EncodeToString(edKey)}
+	short := map[string]string{"kid": "short", "kty": "OKP", "crv": "Ed25519", "x": "AQ"}
+
+	tests := []struct {
+		name string
+		keys []map[string]string
+		want map[string]crypto.PublicKey
+	}{
+		{
+			name: "RSA only",
+			keys: []map[string]string{rsaJWK("rsa", &rsaKey.PublicKey)},
+			want: map[string]crypto.PublicKey{"rsa": &rsaKey.PublicKey},
+		},
+		{
+			name: "EC only",
+			keys: []map[string]string{ecJWK("p256", &p256.PublicKey), ecJWK("p384", &p384.PublicKey)},
+			want: map[string]crypto.PublicKey{"p256": &p256.PublicKey, "p384": &p384.PublicKey},
+		},
+		{
+			name: "mixed",
+			keys: []map[string]string{rsaJWK("rsa", &rsaKey.PublicKey), ecJWK("p256", &p256.PublicKey), okp},
+			want: map[string]crypto.PublicKey{"rsa": &rsaKey.PublicKey, "p256": &p256.PublicKey, "okp": edKey},
+		},
+		{
+			name: "unsupported OKP keys skipped",
+			keys: []map[string]string{okp, x25519, short},
+			want: map[string]crypto.PublicKey{"okp": edKey},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewJWKSCache(serveJWKS(t, tt.keys...).URL, time.Hour)
+
+			set, err := cache.fetchJWKS(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(set.keys) != len(tt.want) {
+				t.Errorf("expected %d keys, got %d", len(tt.want), len(set.keys))
+			}
+			for kid, want := range tt.want {
+				got, err := cache.GetKey(context.Background(), kid)
+				if err != nil {
+					t.Fatalf("unexpected error for %s: %v", kid, err)
+				}
+				if !want.(interface{ Equal(crypto.PublicKey) bool }).Equal(got) {
+					t.Errorf("key %s does not match", kid)
+				}
+			}
+		})
+	}
+}
+
+// selfSignedCert returns a base64 DER certificate for key valid between
+// notBefore and notAfter
+func selfSignedCert(t *testing.T, pub, priv interface{}, notBefore, notAfter time.Time) string {
+	t.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.com"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, priv)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(der)
+}
+
+func TestParseX5C(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		chain     []string
+		want      crypto.PublicKey
+		wantError bool
+	}{
+		{"RSA leaf", []string{selfSignedCert(t, &rsaKey.PublicKey, rsaKey, now.Add(-time.Hour), now.Add(time.Hour))}, &rsaKey.PublicKey, false},
+		{"EC leaf", []string{selfSignedCert(t, &ecKey.PublicKey, ecKey, now.Add(-time.Hour), now.Add(time.Hour))}, &ecKey.PublicKey, false},
+		{"expired leaf", []string{selfSignedCert(t, &rsaKey.PublicKey, rsaKey, now.Add(-48*time.Hour), now.Add(-time.Hour))}, nil, true},
+		{"not yet valid leaf", []string{selfSignedCert(t, &rsaKey.PublicKey, rsaKey, now.Add(time.Hour), now.Add(48*time.Hour))}, nil, true},
+		{"unsupported key type", []string{selfSignedCert(t, edPub, edPriv, now.Add(-time.Hour), now.Add(time.Hour))}, nil, true},
+		{"invalid base64", []string{"not base64!"}, nil, true},
+		{"invalid certificate", []string{base64.StdEncoding.EncodeToString([]byte("garbage"))}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parseX5C(tt.chain, now)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("expected error=%v, got error=%v", tt.wantError, err)
+			}
+			if !tt.wantError && !tt.want.(interface{ Equal(crypto.PublicKey) bool }).Equal(key) {
+				t.Error("expected key from leaf certificate")
+			}
+		})
+	}
+}
+
+func TestJWKSCache_X5C(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	current := selfSignedCert(t, &key.PublicKey, key, now.Add(-time.Hour), now.Add(time.Hour))
+	expired := selfSignedCert(t, &key.PublicKey, key, now.Add(-48*time.Hour), now.Add(-time.Hour))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]interface{}{
+				{"kid": "current", "kty": "RSA", "x5c": []string{current}},
+				{"kid": "expired", "kty": "RSA", "x5c": []string{expired}},
+			},
+		})
+	}))
+	defer server.Close()
+
+	cache := NewJWKSCache(server.URL, time.Hour)
+	cache.clock = testutil.NewFakeClock(now)
+
+	got, err := cache.GetKey(context.Background(), "current")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !key.PublicKey.Equal(got) {
+		t.Error("expected key from x5c leaf certificate")
+	}
+
+	if _, err := cache.GetKey(context.Background(), "expired"); err == nil {
+		t.Error("expected key with expired certificate to be rejected")
+	}
+}
+
+func TestJWKSCache_ForwardsCorrelationID(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(correlation.Header)
+		_, _ = w.Write([]byte(`{"keys": []}`))
+	}))
+	defer server.Close()
+
+	cache := NewJWKSCache(server.URL, time.Hour)
+	ctx := correlation.NewContext(context.Background(), "pipeline-42")
+	_, _ = cache.GetKey(ctx, "missing")
+
+	if got != "pipeline-42" {
+		t.Errorf("expected correlation ID pipeline-42 on JWKS fetch, got %q", got)
+	}
+}
+
+func TestJWKSCache(t *testing.T) {
+	// Basic cache test - we can't test real JWKS fetching without a mock server
+	// but we can test the cache structure
+	cache := NewJWKSCache("https://example.com/.well-known/jwks", 1*time.Hour)
+	if cache == nil {
+		t.Fatal("expected non-nil cache")
+	}
+	if cache.url != "https://example.com/.well-known/jwks" {
+		t.Errorf("unexpected URL: %s", cache.url)
+	}
+	if cache.ttl != 1*time.Hour {
+		t.Errorf("unexpected TTL: %v", cache.ttl)
+	}
+}
+
+func TestJWKSCache_ConcurrentRotation(t *testing.T) {
+	ti := newTestIssuer(t)
+	clk := testutil.NewFakeClock(time.Now())
+	cache := NewJWKSCache(ti.server.URL+"/.well-known/jwks", time.Minute)
+	cache.clock = clk
+	ctx := context.Background()
+
+	if _, err := cache.GetKey(ctx, "test-kid"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// Keys disappear after rotation, so only the lookup itself
+				// (and the race detector) is under test here
+				_, _ = cache.GetKey(ctx, "test-kid")
+				_, _ = cache.GetKey(ctx, "rotated-kid")
+			}
+		}()
+	}
+
+	ti.rotate(t, "rotated-kid")
+	clk.Advance(2 * time.Minute)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := cache.GetKey(ctx, "rotated-kid"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("rotated key never became visible")
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+}
+
+func TestJWKSCache_SharedFetch(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "success", status: http.StatusOK},
+		{name: "error", status: http.StatusBadRequest, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ti := newTestIssuer(t)
+			var fetches atomic.Int32
+			release := make(chan struct{})
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fetches.Add(1)
+				<-release
+				if tt.status != http.StatusOK {
+					w.WriteHeader(tt.status)
+					return
+				}
+				ti.server.Config.Handler.ServeHTTP(w, r)
+			}))
+			defer server.Close()
+
+			cache := NewJWKSCache(server.URL+"/.well-known/jwks", time.Hour)
+			ctx := context.Background()
+
+			const callers = 100
+			var ready, wg sync.WaitGroup
+			errs := make([]error, callers)
+			ready.Add(callers)
+			for i := 0; i < callers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					ready.Done()
+					_, errs[i] = cache.GetKey(ctx, "test-kid")
+				}(i)
+			}
+
+			// Hold the first fetch open until every caller has had the
+			// chance to join it
+			ready.Wait()
+			for fetches.Load() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			if got := fetches.Load(); got != 1 {
+				t.Errorf("expected 1 fetch, got %d", got)
+			}
+			for i, err := range errs {
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("caller %d: expected error %v, got %v", i, tt.wantErr, err)
+				}
+			}
+		})
+	}
+}
+
+func TestJWKSCache_FetchErrorKeepsSnapshot(t *testing.T) {
+	var fail atomic.Bool
+	ti := newTestIssuer(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		ti.server.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	cache := NewJWKSCache(server.URL+"/.well-known/jwks", time.Hour)
+	cache.retryBaseDelay = time.Millisecond
+	ctx := context.Background()
+
+	if _, err := cache.GetKey(ctx, "test-kid"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fail.Store(true)
+	if _, err := cache.GetKey(ctx, "unknown-kid"); err == nil {
+		t.Fatal("expected error while JWKS endpoint fails")
+	}
+
+	// The published snapshot must survive a failed fetch
+	if _, err := cache.GetKey(ctx, "test-kid"); err != nil {
+		t.Errorf("expected cached key to remain available, got %v", err)
+	}
+}
+
+func TestJWKSCache_Stats(t *testing.T) {
+	var fail atomic.Bool
+	ti := newTestIssuer(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		ti.server.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	clk := testutil.NewFakeClock(time.Now())
+	cache := NewJWKSCache(server.URL+"/.well-known/jwks", time.Hour)
+	cache.clock = clk
+	cache.retries = 1
+	cache.retryBaseDelay = time.Millisecond
+	ctx := context.Background()
+
+	if stats := cache.Stats(); stats != (JWKSStats{}) {
+		t.Errorf("expected zero stats before the first fetch, got %+v", stats)
+	}
+
+	// Miss and fetch, then hit
+	if _, err := cache.GetKey(ctx, "test-kid"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := cache.GetKey(ctx, "test-kid"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clk.Advance(time.Minute)
+
+	stats := cache.Stats()
+	want := JWKSStats{Hits: 1, Misses: 1, FetchAttempts: 1, KeysLoaded: 1, SinceLastFetch: time.Minute, Fetched: true}
+	if stats != want {
+		t.Errorf("expected %+v, got %+v", want, stats)
+	}
+
+	// A failed forced refresh counts every attempt
+	fail.Store(true)
+	if _, err := cache.GetKey(ctx, "unknown-kid"); err == nil {
+		t.Fatal("expected error while JWKS endpoint fails")
+	}
+
+	stats = cache.Stats()
+	want = JWKSStats{Hits: 1, Misses: 2, FetchAttempts: 3, FetchFailures: 2, KeysLoaded: 1, SinceLastFetch: time.Minute, Fetched: true}
+	if stats != want {
+		t.Errorf("expected %+v, got %+v", want, stats)
+	}
+}
+
+func TestJWKSCache_Warm(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  int32
+		timeout   time.Duration
+		wantError bool
+	}{
+		{"first fetch succeeds", 0, time.Second, false},
+		{"succeeds after failures", 3, time.Second, false},
+		{"times out", 1 << 30, 100 * time.Millisecond, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ti := newTestIssuer(t)
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if requests.Add(1) <= tt.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				ti.server.Config.Handler.ServeHTTP(w, r)
+			}))
+			defer server.Close()
+
+			cache := NewJWKSCache(server.URL+"/.well-known/jwks", time.Hour)
+			cache.retries = 0
+			cache.retryBaseDelay = time.Millisecond
+
+			if status := cache.Status(); !status.Cold {
+				t.Errorf("expected cold status before warm-up, got %+v", status)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			err := cache.Warm(ctx)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("expected error=%v, got error=%v", tt.wantError, err)
+			}
+			if status := cache.Status(); status.Cold != tt.wantError {
+				t.Errorf("expected cold=%v after warm-up, got %+v", tt.wantError, status)
+			}
+		})
+	}
+
+	t.Run("empty key set", func(t *testing.T) {
+		var requests atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"keys": []}`))
+		}))
+		defer server.Close()
+
+		cache := NewJWKSCache(server.URL+"/.well-known/jwks", time.Hour)
+		cache.retries = 0
+		cache.retryBaseDelay = 10 * time.Millisecond
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		if err := cache.Warm(ctx); err == nil || !strings.Contains(err.Error(), "no usable keys") {
+			t.Fatalf("expected warm-up to fail for lack of keys, got %v", err)
+		}
+		// 10ms doubling to 160ms fits at most five attempts in 200ms
+		if n := requests.Load(); n > 6 {
+			t.Errorf("expected empty key sets to be retried with backoff, got %d fetches", n)
+		}
+	})
+
+	t.Run("already warm", func(t *testing.T) {
+		ti := newTestIssuer(t)
+		cache := NewJWKSCache(ti.server.URL+"/.well-known/jwks", time.Hour)
+		if _, err := cache.GetKey(context.Background(), "test-kid"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := cache.Warm(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if n := ti.fetches.Load(); n != 1 {
+			t.Errorf("expected warm-up to reuse the cached keys, got %d fetches", n)
+		}
+	})
+}
+
+func TestJWKSCache_ForcedRefresh(t *testing.T) {
+	ti := newTestIssuer(t)
+	clk := testutil.NewFakeClock(time.Now())
+	cache := NewJWKSCache(ti.server.URL+"/.well-known/jwks", time.Hour)
+	cache.clock = clk
+	ctx := context.Background()
+
+	if _, err := cache.GetKey(ctx, "test-kid"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The new kid only appears in the second fetch, well within the TTL
+	ti.rotate(t, "rotated-kid")
+	if _, err := cache.GetKey(ctx, "rotated-kid"); err != nil {
+		t.Fatalf("expected rotated key after forced refresh, got %v", err)
+	}
+	if got := ti.fetches.Load(); got != 2 {
+		t.Fatalf("expected 2 fetches, got %d", got)
+	}
+
+	// Further unknown kids within the interval must not reach the issuer
+	for i := 0; i < 10; i++ {
+		if _, err := cache.GetKey(ctx, "bogus-kid"); err == nil {
+			t.Fatal("expected error for unknown kid")
+		}
+	}
+	if got := ti.fetches.Load(); got != 2 {
+		t.Errorf("expected forced refreshes to be rate limited, got %d fetches", got)
+	}
+
+	clk.Advance(forcedRefreshInterval + time.Second)
+	if _, err := cache.GetKey(ctx, "bogus-kid"); err == nil {
+		t.Fatal("expected error for unknown kid")
+	}
+	if got := ti.fetches.Load(); got != 3 {
+		t.Errorf("expected a forced refresh once the interval passed, got %d fetches", got)
+	}
+
+	// Known kids are served from the cache throughout
+	if _, err := cache.GetKey(ctx, "rotated-kid"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got := ti.fetches.Load(); got != 3 {
+		t.Errorf("expected no fetch for a cached kid, got %d fetches", got)
+	}
+}
+
+func TestJWKSCache_BackgroundRefresh(t *testing.T) {
+	ti := newTestIssuer(t)
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Every fetch after the first is slow until released
+		if fetches.Add(1) > 1 {
+			<-release
+		}
+		ti.server.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ttl := 100 * time.Millisecond
+	cache := NewJWKSCache(server.URL+"/.well-known/jwks", ttl)
+	ctx := context.Background()
+
+	if _, err := cache.GetKey(ctx, "test-kid"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	warmedAt := time.Now()
+
+	cache.Start()
+	defer cache.Stop()
+
+	// Wait for the refresher to start its (blocked) fetch and for the
+	// snapshot to expire
+	deadline := time.Now().Add(5 * time.Second)
+	for fetches.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("background refresh never started")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(time.Until(warmedAt.Add(2 * ttl)))
+
+	start := time.Now()
+	if _, err := cache.GetKey(ctx, "test-kid"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("expected GetKey not to wait for the refresh, took %v", elapsed)
+	}
+}
+
+func TestJWKSCache_Stop(t *testing.T) {
+	ti := newTestIssuer(t)
+	cache := NewJWKSCache(ti.server.URL+"/.well-known/jwks", time.Hour)
+
+	// Stop before Start is a no-op
+	cache.Stop()
+
+	cache.Start()
+	if _, err := cache.GetKey(context.Background(), "test-kid"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		cache.Stop()
+		cache.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop did not return")
+	}
+}
+
+func TestJWKSCache_Retries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int32
+		status       int // 0 drops the connection
+		retries      int
+		wantAttempts int32
+		wantError    bool
+	}{
+		{"succeeds first time", 0, http.StatusBadGateway, 2, 1, false},
+		{"recovers from transient 502", 1, http.StatusBadGateway, 2, 2, false},
+		{"recovers from repeated 503", 2, http.StatusServiceUnavailable, 2, 3, false},
+		{"gives up after retries", 5, http.StatusBadGateway, 2, 3, true},
+		{"recovers from network error", 1, 0, 2, 2, false},
+		{"does not retry 404", 5, http.StatusNotFound, 2, 1, true},
+		{"does not retry 429", 5, http.StatusTooManyRequests, 2, 1, true},
+		{"retries disabled", 1, http.StatusBadGateway, 0, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ti := newTestIssuer(t)
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if attempts.Add(1) <= tt.failures {
+					if tt.status == 0 {
+						conn, _, _ := w.(http.Hijacker).Hijack()
+						conn.Close()
+						return
+					}
+					w.WriteHeader(tt.status)
+					return
+				}
+				ti.server.Config.Handler.ServeHTTP(w, r)
+			}))
+			defer server.Close()
+
+			cache := NewJWKSCache(server.URL+"/.well-known/jwks", time.Hour)
+			cache.retries = tt.retries
+			cache.retryBaseDelay = time.Millisecond
+
+			_, err := cache.GetKey(context.Background(), "test-kid")
+			if (err != nil) != tt.wantError {
+				t.Errorf("expected error=%v, got error=%v", tt.wantError, err)
+			}
+			if got := attempts.Load(); got != tt.wantAttempts {
+				t.Errorf("expected %d attempts, got %d", tt.wantAttempts, got)
+			}
+		})
+	}
+}
+
+func TestJWKSCache_RetriesRespectDeadline(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	cache := NewJWKSCache(server.URL, time.Hour)
+	cache.retries = 5
+	cache.retryBaseDelay = time.Second
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := cache.fetchJWKS(ctx); err == nil {
+		t.Fatal("expected error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected to give up before the deadline, took %v", elapsed)
+	}
+	if got := attempts.Load(); got != 1 {
+		t.Errorf("expected 1 attempt, got %d", got)
+	}
+}
+
+func TestJWKSCache_RetryDelay(t *testing.T) {
+	cache := NewJWKSCache("https://example.com", time.Hour)
+	cache.retryBaseDelay = 100 * time.Millisecond
+
+	for attempt, base := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond} {
+		for i := 0; i < 20; i++ {
+			if d := cache.retryDelay(attempt); d < base/2 || d > base {
+				t.Errorf("attempt %d: expected delay in [%v, %v], got %v", attempt, base/2, base, d)
+			}
+		}
+	}
+
+	if d := cache.retryDelay(30); d > maxRetryDelay {
+		t.Errorf("expected delay capped at %v, got %v", maxRetryDelay, d)
+	}
+}
+
+func TestJWKSCache_ServesStaleKeysOnError(t *testing.T) {
+	var fail atomic.Bool
+	ti := newTestIssuer(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		ti.server.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	cache := NewJWKSCache(server.URL+"/.well-known/jwks", time.Hour)
+	cache.clock = clk
+	cache.maxStale = 30 * time.Minute
+	cache.retries = 0
+	ctx := context.Background()
+
+	if _, err := cache.GetKey(ctx, "test-kid"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status := cache.Status(); status != (KeyStatus{}) {
+		t.Errorf("expected fresh status, got %+v", status)
+	}
+
+	fail.Store(true)
+	clk.Advance(time.Hour + 10*time.Minute)
+
+	if _, err := cache.GetKey(ctx, "test-kid"); err != nil {
+		t.Errorf("expected stale key to be served, got %v", err)
+	}
+	if _, err := cache.GetKey(ctx, "unknown-kid"); err == nil {
+		t.Error("expected error for a kid missing from the stale keys")
+	}
+	if status := cache.Status(); status.Staleness != 10*time.Minute || status.Expired {
+		t.Errorf("expected 10m staleness, got %+v", status)
+	}
+
+	clk.Advance(25 * time.Minute)
+
+	if _, err := cache.GetKey(ctx, "test-kid"); err == nil {
+		t.Error("expected error once keys are stale beyond max-stale")
+	}
+	if status := cache.Status(); !status.Expired {
+		t.Errorf("expected expired status, got %+v", status)
+	}
+
+	fail.Store(false)
+
+	if _, err := cache.GetKey(ctx, "test-kid"); err != nil {
+		t.Errorf("expected recovery once the endpoint is back, got %v", err)
+	}
+	if status := cache.Status(); status != (KeyStatus{}) {
+		t.Errorf("expected fresh status after recovery, got %+v", status)
+	}
+}
+
+func TestJWKSCache_ETagRevalidation(t *testing.T) {
+	ti := newTestIssuer(t)
+	var full, notModified atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		ti.server.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	cache := NewJWKSCache(server.URL+"/.well-known/jwks", time.Hour)
+	cache.clock = clk
+	ctx := context.Background()
+
+	if _, err := cache.GetKey(ctx, "test-kid"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	clk.Advance(2 * time.Hour)
+
+	if _, err := cache.GetKey(ctx, "test-kid"); err != nil {
+		t.Fatalf("expected key after 304 revalidation, got %v", err)
+	}
+	if full.Load() != 1 || notModified.Load() != 1 {
+		t.Errorf("expected 1 full fetch and 1 revalidation, got %d and %d", full.Load(), notModified.Load())
+	}
+
+	// The revalidation renews the keys for another TTL
+	if status := cache.Status(); status.Staleness != 0 {
+		t.Errorf("expected fresh keys after revalidation, got %+v", status)
+	}
+	if _, err := cache.GetKey(ctx, "test-kid"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if notModified.Load() != 1 {
+		t.Errorf("expected no further requests while fresh, got %d revalidations", notModified.Load())
+	}
+}
+
+func TestJWKSCache_CacheControlMaxAge(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		wantTTL      time.Duration
+	}{
+		{"no header", "", time.Hour},
+		{"max-age shorter than TTL", "public, max-age=900", 15 * time.Minute},
+		{"max-age longer than TTL", "max-age=86400", time.Hour},
+		{"max-age below minimum", "max-age=0", minJWKSTTL},
+		{"invalid max-age", "max-age=soon", time.Hour},
+		{"no max-age", "no-transform", time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ti := newTestIssuer(t)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.cacheControl != "" {
+					w.Header().Set("Cache-Control", tt.cacheControl)
+				}
+				ti.server.Config.Handler.ServeHTTP(w, r)
+			}))
+			defer server.Close()
+
+			clk := testutil.NewFakeClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+			cache := NewJWKSCache(server.URL+"/.well-known/jwks", time.Hour)
+			cache.clock = clk
+
+			if _, err := cache.GetKey(context.Background(), "test-kid"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			clk.Advance(tt.wantTTL - time.Second)
+			if status := cache.Status(); status.Staleness != 0 {
+				t.Errorf("expected keys fresh just before %v", tt.wantTTL)
+			}
+			clk.Advance(2 * time.Second)
+			if status := cache.Status(); status.Staleness != time.Second {
+				t.Errorf("expected keys stale just after %v, got %+v", tt.wantTTL, status)
+			}
+		})
+	}
+}
+
+// BenchmarkJWKSCache_GetKey measures the lookup path with 64 concurrent
+// verifiers per CPU, both with a warm cache and with the TTL short enough
+// that refreshes run continuously underneath the readers.
+func BenchmarkJWKSCache_GetKey(b *testing.B) {
+	ti := newTestIssuer(b)
+
+	for _, bc := range []struct {
+		name string
+		ttl  time.Duration
+	}{
+		{"warm", time.Hour},
+		{"refreshing", 2 * time.Millisecond},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			cache := NewJWKSCache(ti.server.URL+"/.well-known/jwks", bc.ttl)
+			ctx := context.Background()
+			if _, err := cache.GetKey(ctx, "test-kid"); err != nil {
+				b.Fatalf("unexpected error: %v", err)
+			}
+
+			b.SetParallelism(64)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := cache.GetKey(ctx, "test-kid"); err != nil {
+						b.Errorf("unexpected error: %v", err)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// DefaultProvider is the provider used for exchange requests that do not name
+// one, for compatibility with clients written before multiple providers were
+// supported
+const DefaultProvider = "github"
+
+// VerifierRegistry maps the provider names that clients send in exchange
+// requests to the verifier trusting that provider's issuer. It is populated at
+// startup and read-only afterwards, so lookups need no locking.
+type VerifierRegistry struct {
+	verifiers map[string]Verifier
+}
+
+// NewVerifierRegistry creates an empty verifier registry
+func NewVerifierRegistry() *VerifierRegistry {
+	return &VerifierRegistry{
+		verifiers: make(map[string]Verifier),
+	}
+}
+
+// Register adds the verifier for a provider, replacing any existing one
+func (r *VerifierRegistry) Register(provider string, v Verifier) {
+	r.verifiers[provider] = v
+}
+
+// Lookup returns the verifier registered for a provider
+func (r *VerifierRegistry) Lookup(provider string) (Verifier, bool) {
+	v, ok := r.verifiers[provider]
+	return v, ok
+}
+
+// Providers returns the registered provider names in sorted order
+func (r *VerifierRegistry) Providers() []string {
+	names := make([]string, 0, len(r.verifiers))
+	for name := range r.verifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Discover runs OIDC discovery for every registered verifier that supports
+// it and returns the failures joined together
+func (r *VerifierRegistry) Discover(ctx context.Context) error {
+	var errs []error
+	for _, name := range r.Providers() {
+		d, ok := r.verifiers[name].(interface {
+			Discover(ctx context.Context) error
+		})
+		if !ok {
+			continue
+		}
+		if err := d.Discover(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Warm fetches the keys of every registered verifier that caches them,
+// retrying until each succeeds or ctx is done, and returns the failures
+// joined together
+func (r *VerifierRegistry) Warm(ctx context.Context) error {
+	var errs []error
+	for _, name := range r.Providers() {
+		w, ok := r.verifiers[name].(interface {
+			Warm(ctx context.Context) error
+		})
+		if !ok {
+			continue
+		}
+		if err := w.Warm(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// KeyStatus reports the key staleness of every registered verifier that
+// caches keys, keyed by provider
+func (r *VerifierRegistry) KeyStatus() map[string]KeyStatus {
+	statuses := make(map[string]KeyStatus)
+	for name, v := range r.verifiers {
+		if s, ok := v.(interface{ KeyStatus() KeyStatus }); ok {
+			statuses[name] = s.KeyStatus()
+		}
+	}
+	return statuses
+}
+
+// JWKSStats reports the key cache metrics of every registered verifier that
+// caches keys, keyed by provider
+func (r *VerifierRegistry) JWKSStats() map[string][]JWKSStats {
+	stats := make(map[string][]JWKSStats)
+	for name, v := range r.verifiers {
+		if s, ok := v.(interface{ JWKSStats() []JWKSStats }); ok {
+			stats[name] = s.JWKSStats()
+		}
+	}
+	return stats
+}
+
+// Start starts background key refresh for every registered verifier that
+// supports it
+func (r *VerifierRegistry) Start() {
+	for _, v := range r.verifiers {
+		if s, ok := v.(interface{ Start() }); ok {
+			s.Start()
+		}
+	}
+}
+
+// Stop ends the background key refresh started by Start
+func (r *VerifierRegistry) Stop() {
+	for _, v := range r.verifiers {
+		if s, ok := v.(interface{ Stop() }); ok {
+			s.Stop()
+		}
+	}
+}
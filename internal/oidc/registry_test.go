@@ -0,0 +1,85 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type fakeDiscoverer struct {
+	FakeVerifier
+	err     error
+	started bool
+}
+
+func (f *fakeDiscoverer) Start() { f.started = true }
+
+func (f *fakeDiscoverer) Stop() { f.started = false }
+
+func (f *fakeDiscoverer) Discover(ctx context.Context) error {
+	return f.err
+}
+
+func TestVerifierRegistry(t *testing.T) {
+	github := &FakeVerifier{}
+	buildkite := &FakeVerifier{}
+
+	r := NewVerifierRegistry()
+	r.Register("github", github)
+	r.Register("buildkite", buildkite)
+
+	if v, ok := r.Lookup("github"); !ok || v != github {
+		t.Errorf("expected github verifier, got %v", v)
+	}
+	if v, ok := r.Lookup("buildkite"); !ok || v != buildkite {
+		t.Errorf("expected buildkite verifier, got %v", v)
+	}
+	if _, ok := r.Lookup("gitlab"); ok {
+		t.Error("expected unregistered provider lookup to fail")
+	}
+
+	if got := r.Providers(); !reflect.DeepEqual(got, []string{"buildkite", "github"}) {
+		t.Errorf("expected sorted providers, got %v", got)
+	}
+}
+
+func TestVerifierRegistry_Discover(t *testing.T) {
+	discoveryErr := errors.New("connection refused")
+
+	r := NewVerifierRegistry()
+	r.Register("github", &fakeDiscoverer{})
+	r.Register("bitbucket", &fakeDiscoverer{err: discoveryErr})
+	r.Register("fake", &FakeVerifier{})
+
+	err := r.Discover(context.Background())
+	if !errors.Is(err, discoveryErr) {
+		t.Fatalf("expected discovery error, got %v", err)
+	}
+	if err.Error() != "bitbucket: connection refused" {
+		t.Errorf("expected error to name the provider, got %q", err.Error())
+	}
+
+	r.Register("bitbucket", &fakeDiscoverer{})
+	if err := r.Discover(context.Background()); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestVerifierRegistry_StartStop(t *testing.T) {
+	github := &fakeDiscoverer{}
+
+	r := NewVerifierRegistry()
+	r.Register("github", github)
+	r.Register("fake", &FakeVerifier{})
+
+	r.Start()
+	if !github.started {
+		t.Error("expected verifier to be started")
+	}
+
+	r.Stop()
+	if github.started {
+		t.Error("expected verifier to be stopped")
+	}
+}
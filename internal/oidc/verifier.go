@@ -2,49 +2,305 @@ package oidc
 
 import (
 	"context"
-	"crypto/rsa"
-	"encoding/base64"
-	"encoding/json"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"io"
-	"math/big"
+	"log/slog"
 	"net/http"
-	"sync"
+	"net/url"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/robohub/auth-service/internal/clock"
 	"github.com/robohub/auth-service/internal/types"
 )
 
+// ErrTokenTooOld is returned when a token was issued longer ago than the
+// configured maximum token age
+var ErrTokenTooOld = errors.New("token too old")
+
+// ErrTokenNotYetValid is returned when a token's nbf is further in the future
+// than the configured clock skew allows
+var ErrTokenNotYetValid = errors.New("token not yet valid")
+
+// ErrTokenLifetimeTooLong is returned when a token's exp is further after its
+// iat than the configured maximum lifetime
+var ErrTokenLifetimeTooLong = errors.New("token lifetime too long")
+
+// ErrTokenTooLarge is returned, before any parsing, for tokens longer than
+// the configured maximum size
+var ErrTokenTooLarge = errors.New("token too large")
+
+// ErrExpired is returned when a token's exp is further in the past than the
+// configured clock skew allows
+var ErrExpired = errors.New("token expired")
+
+// ErrSignature is returned when a token's signature does not verify against
+// the issuer's published keys
+var ErrSignature = errors.New("invalid token signature")
+
+// ErrIssuerMismatch is returned when a token's iss is not the verifier's
+// issuer
+var ErrIssuerMismatch = errors.New("issuer mismatch")
+
+// ErrAudienceMismatch is returned when none of a token's audiences is
+// accepted by the verifier
+var ErrAudienceMismatch = errors.New("audience mismatch")
+
+// MissingClaimError reports a claim the verifier requires that is absent,
+// empty or of the wrong type
+type MissingClaimError struct {
+	Name string
+}
+
+func (e *MissingClaimError) Error() string {
+	return fmt.Sprintf("missing or invalid %s claim", e.Name)
+}
+
+const (
+	// defaultMaxTokenSize comfortably fits GitHub tokens, which are around
+	// 2 KiB even for long repository and workflow names
+	defaultMaxTokenSize = 16 << 10
+
+	// maxKidLength bounds the kid looked up in the JWKS cache
+	maxKidLength = 256
+)
+
+// defaultSigningAlgorithms are the algorithms accepted from OIDC providers
+// unless WithSigningAlgorithms overrides them
+var defaultSigningAlgorithms = []string{"RS256"}
+
 // Verifier defines the interface for verifying OIDC tokens
 type Verifier interface {
 	Verify(ctx context.Context, token string) (*types.VerifiedClaims, error)
 }
 
-// GitHubVerifier verifies GitHub Actions OIDC tokens
-type GitHubVerifier struct {
+// verifierCore performs the signature, issuer, audience and timing checks
+// shared by every provider's verifier. Provider verifiers embed it and map
+// the validated claim set into VerifiedClaims.
+type verifierCore struct {
 	issuer    string
-	audience  string
+	audiences []string
 	clockSkew time.Duration
+	maxAge    time.Duration
+	maxLife   time.Duration
+	required  []string
+	subjects  map[string][]string
+	algs      []string
+	maxSize   int
+	clock     clock.Clock
 	jwksCache *JWKSCache
 }
 
-// NewGitHubVerifier creates a new GitHub OIDC verifier
-func NewGitHubVerifier(issuer, audience string, clockSkew time.Duration, jwksTTL time.Duration) *GitHubVerifier {
-	return &GitHubVerifier{
+// Option configures optional verifier behavior
+type Option func(*verifierCore)
+
+// WithClock sets the time source used for token and cache expiry checks
+func WithClock(c clock.Clock) Option {
+	return func(v *verifierCore) {
+		v.clock = c
+		v.jwksCache.clock = c
+		if v.jwksCache.discovery != nil {
+			v.jwksCache.discovery.clock = c
+		}
+	}
+}
+
+// WithJWKSURL overrides the provider's default JWKS URL. It disables
+// discovery when applied after WithDiscovery.
+func WithJWKSURL(url string) Option {
+	return func(v *verifierCore) {
+		v.jwksCache.url = url
+		v.jwksCache.discovery = nil
+	}
+}
+
+// WithDiscovery resolves the JWKS URL from the issuer's
+// /.well-known/openid-configuration document, caching it for ttl, instead of
+// using the provider's default JWKS URL
+func WithDiscovery(ttl time.Duration) Option {
+	return func(v *verifierCore) {
+		d := NewDiscovery(v.issuer, ttl)
+		d.clock = v.clock
+		d.logger = v.jwksCache.logger
+		d.httpClient = v.jwksCache.httpClient
+		v.jwksCache.discovery = d
+	}
+}
+
+// WithLogger sets the logger used for background warnings such as failed
+// discovery refreshes
+func WithLogger(logger *slog.Logger) Option {
+	return func(v *verifierCore) {
+		v.jwksCache.logger = logger
+		if v.jwksCache.discovery != nil {
+			v.jwksCache.discovery.logger = logger
+		}
+	}
+}
+
+// WithProxy sends JWKS and discovery requests through proxyURL instead of
+// the proxy named by HTTPS_PROXY and NO_PROXY. Credentials in the URL are
+// sent as proxy basic auth.
+func WithProxy(proxyURL *url.URL) Option {
+	return func(v *verifierCore) {
+		v.transport().Proxy = http.ProxyURL(proxyURL)
+	}
+}
+
+// WithTLSConfig sets the TLS configuration for JWKS and discovery requests,
+// such as extra root CAs for a private JWKS mirror
+func WithTLSConfig(config *tls.Config) Option {
+	return func(v *verifierCore) {
+		v.transport().TLSClientConfig = config
+	}
+}
+
+// WithJWKSRetries sets how many times a failed JWKS fetch is retried.
+// Network errors and 5xx responses are retried; 4xx responses are not.
+func WithJWKSRetries(retries int) Option {
+	return func(v *verifierCore) {
+		v.jwksCache.retries = retries
+	}
+}
+
+// WithJWKSMaxStale sets how long past the JWKS TTL the cached keys are still
+// served when refreshing them fails. Zero disables serving stale keys.
+func WithJWKSMaxStale(maxStale time.Duration) Option {
+	return func(v *verifierCore) {
+		v.jwksCache.maxStale = maxStale
+	}
+}
+
+// WithMaxTokenAge rejects tokens whose iat is more than maxAge (plus the
+// clock skew) in the past. Zero disables the check.
+func WithMaxTokenAge(maxAge time.Duration) Option {
+	return func(v *verifierCore) {
+		v.maxAge = maxAge
+	}
+}
+
+// WithMaxTokenLifetime rejects tokens whose exp is more than maxLifetime
+// after their iat, and tokens missing either claim. Zero disables the check.
+func WithMaxTokenLifetime(maxLifetime time.Duration) Option {
+	return func(v *verifierCore) {
+		v.maxLife = maxLifetime
+	}
+}
+
+// WithSigningAlgorithms sets the JWS algorithms accepted in token headers.
+// Tokens signed with any other algorithm, including none, are rejected before
+// their key is looked up.
+func WithSigningAlgorithms(algs ...string) Option {
+	return func(v *verifierCore) {
+		v.algs = algs
+	}
+}
+
+// WithMaxTokenSize rejects tokens longer than size bytes before decoding
+// them
+func WithMaxTokenSize(size int) Option {
+	return func(v *verifierCore) {
+		v.maxSize = size
+	}
+}
+
+// WithRequiredClaims rejects tokens in which any of the named claims is
+// missing or an empty string, on top of the claims the provider requires
+func WithRequiredClaims(names ...string) Option {
+	return func(v *verifierCore) {
+		v.required = names
+	}
+}
+
+// WithGitHubSubjectTemplates sets the customized sub claim formats accepted
+// for GitHub tokens, keyed by owner or owner/repo. A template lists the claim
+// keys GitHub joins into the sub, such as repo:context:job_workflow_ref.
+// Repositories without templates, either their own or their owner's, must use
+// GitHub's default sub format.
+func WithGitHubSubjectTemplates(templates map[string][]string) Option {
+	return func(v *verifierCore) {
+		v.subjects = templates
+	}
+}
+
+func newVerifierCore(issuer string, audiences []string, jwksURL string, clockSkew, jwksTTL time.Duration, opts []Option) *verifierCore {
+	v := &verifierCore{
 		issuer:    issuer,
-		audience:  audience,
+		audiences: audiences,
 		clockSkew: clockSkew,
-		jwksCache: NewJWKSCache(issuer+"/.well-known/jwks", jwksTTL),
+		algs:      defaultSigningAlgorithms,
+		maxSize:   defaultMaxTokenSize,
+		clock:     clock.Real{},
+		jwksCache: NewJWKSCache(jwksURL, jwksTTL),
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// transport returns the JWKS client's own transport, replacing the shared
+// default transport with a copy the first time it is customized
+func (v *verifierCore) transport() *http.Transport {
+	if t, ok := v.jwksCache.httpClient.Transport.(*http.Transport); ok {
+		return t
 	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	v.jwksCache.httpClient.Transport = t
+	return t
+}
+
+// Discover resolves the JWKS URL through OIDC discovery ahead of the first
+// verification. It is a no-op when discovery is disabled.
+func (v *verifierCore) Discover(ctx context.Context) error {
+	if v.jwksCache.discovery == nil {
+		return nil
+	}
+	_, err := v.jwksCache.discovery.JWKSURI(ctx)
+	return err
+}
+
+// Warm fetches the provider's keys ahead of the first verification
+func (v *verifierCore) Warm(ctx context.Context) error {
+	return v.jwksCache.Warm(ctx)
 }
 
-// Verify verifies a GitHub Actions OIDC token
-func (v *GitHubVerifier) Verify(ctx context.Context, tokenString string) (*types.VerifiedClaims, error) {
+// Start refreshes the provider's keys in the background ahead of expiry
+func (v *verifierCore) Start() {
+	v.jwksCache.Start()
+}
+
+// Stop ends the background key refresh
+func (v *verifierCore) Stop() {
+	v.jwksCache.Stop()
+}
+
+// KeyStatus reports the staleness of the provider's cached keys
+func (v *verifierCore) KeyStatus() KeyStatus {
+	return v.jwksCache.Status()
+}
+
+// JWKSStats reports the metrics of the provider's key cache
+func (v *verifierCore) JWKSStats() []JWKSStats {
+	stats := v.jwksCache.Stats()
+	stats.Issuer = v.issuer
+	return []JWKSStats{stats}
+}
+
+// verify checks the token's signature, lifetime, issuer, audience and age
+// and returns its claim set
+func (v *verifierCore) verify(ctx context.Context, tokenString string) (jwt.MapClaims, error) {
+	if err := v.checkTokenSize(tokenString); err != nil {
+		return nil, err
+	}
+
 	// Parse token to get kid from header
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Verify signing method
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+		// Verify signing method before trusting anything else in the token
+		if alg, _ := token.Header["alg"].(string); !slices.Contains(v.algs, alg) {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 
@@ -53,6 +309,9 @@ func (v *GitHubVerifier) Verify(ctx context.Context, tokenString string) (*types
 		if !ok {
 			return nil, fmt.Errorf("missing or invalid kid in token header")
 		}
+		if len(kid) > maxKidLength {
+			return nil, fmt.Errorf("kid is %d bytes, maximum is %d", len(kid), maxKidLength)
+		}
 
 		// Get public key from JWKS
 		publicKey, err := v.jwksCache.GetKey(ctx, kid)
@@ -61,9 +320,19 @@ func (v *GitHubVerifier) Verify(ctx context.Context, tokenString string) (*types
 		}
 
 		return publicKey, nil
-	}, jwt.WithLeeway(v.clockSkew))
+	}, jwt.WithValidMethods(v.algs), jwt.WithLeeway(v.clockSkew), jwt.WithTimeFunc(v.clock.Now))
 
 	if err != nil {
+		// jwt checks claims only after the signature, so nbf can be trusted
+		if errors.Is(err, jwt.ErrTokenNotValidYet) {
+			return nil, v.notYetValid(token)
+		}
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, fmt.Errorf("%w: %w", ErrExpired, err)
+		}
+		if errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+			return nil, fmt.Errorf("%w: %w", ErrSignature, err)
+		}
 		return nil, fmt.Errorf("failed to verify token: %w", err)
 	}
 
@@ -80,67 +349,74 @@ func (v *GitHubVerifier) Verify(ctx context.Context, tokenString string) (*types
 	// Validate issuer
 	iss, ok := claims["iss"].(string)
 	if !ok || iss != v.issuer {
-		return nil, fmt.Errorf("invalid issuer: expected %s, got %s", v.issuer, iss)
+		return nil, fmt.Errorf("%w: expected %s, got %s", ErrIssuerMismatch, v.issuer, iss)
 	}
 
-	// Validate audience
-	aud, err := v.extractAudience(claims)
-	if err != nil {
-		return nil, fmt.Errorf("invalid audience: %w", err)
+	if _, err := v.matchAudience(claims); err != nil {
+		return nil, err
 	}
-	if !v.containsAudience(aud, v.audience) {
-		return nil, fmt.Errorf("audience does not match: expected %s", v.audience)
+
+	if err := v.checkTokenAge(v.extractTimestamp(claims, "iat")); err != nil {
+		return nil, err
 	}
 
-	// Extract required claims
-	repository, ok := claims["repository"].(string)
-	if !ok || repository == "" {
-		return nil, fmt.Errorf("missing or invalid repository claim")
+	if err := v.checkTokenLifetime(v.extractTimestamp(claims, "iat"), v.extractTimestamp(claims, "exp")); err != nil {
+		return nil, err
 	}
 
-	ref, ok := claims["ref"].(string)
-	if !ok || ref == "" {
-		return nil, fmt.Errorf("missing or invalid ref claim")
+	if err := v.checkRequiredClaims(claims); err != nil {
+		return nil, err
 	}
 
-	actor, ok := claims["actor"].(string)
-	if !ok || actor == "" {
-		return nil, fmt.Errorf("missing or invalid actor claim")
+	return claims, nil
+}
+
+// checkTokenSize rejects oversized tokens before any decoding work is spent
+// on them
+func (v *verifierCore) checkTokenSize(tokenString string) error {
+	if len(tokenString) > v.maxSize {
+		return fmt.Errorf("%w: %d bytes, maximum is %d", ErrTokenTooLarge, len(tokenString), v.maxSize)
 	}
+	return nil
+}
 
-	// Extract run_id (can be string or number)
-	runID := v.extractRunID(claims)
-	if runID == "" {
-		return nil, fmt.Errorf("missing or invalid run_id claim")
+// notYetValid describes how far in the future a rejected token's nbf is
+func (v *verifierCore) notYetValid(token *jwt.Token) error {
+	nbf, err := token.Claims.GetNotBefore()
+	if err != nil || nbf == nil {
+		return ErrTokenNotYetValid
 	}
+	ahead := nbf.Sub(v.clock.Now()).Truncate(time.Second)
+	return fmt.Errorf("%w: nbf is %s in the future, allowed clock skew is %s", ErrTokenNotYetValid, ahead, v.clockSkew)
+}
 
-	// Extract workflow (try workflow_ref first, then job_workflow_ref)
-	workflow := ""
-	if wf, ok := claims["workflow_ref"].(string); ok {
-		workflow = wf
-	} else if jwf, ok := claims["job_workflow_ref"].(string); ok {
-		workflow = jwf
+func (v *verifierCore) checkTokenAge(iat time.Time) error {
+	if v.maxAge <= 0 {
+		return nil
 	}
-	if workflow == "" {
-		return nil, fmt.Errorf("missing workflow_ref or job_workflow_ref claim")
+	if iat.IsZero() {
+		return fmt.Errorf("%w: missing iat claim", ErrTokenTooOld)
 	}
+	if age := v.clock.Now().Sub(iat); age > v.maxAge+v.clockSkew {
+		return fmt.Errorf("%w: issued %s ago, maximum is %s", ErrTokenTooOld, age.Truncate(time.Second), v.maxAge)
+	}
+	return nil
+}
 
-	// Extract timestamps
-	iat := v.extractTimestamp(claims, "iat")
-	exp := v.extractTimestamp(claims, "exp")
-
-	return &types.VerifiedClaims{
-		Repository: repository,
-		Ref:        ref,
-		Actor:      actor,
-		RunID:      runID,
-		Workflow:   workflow,
-		IssuedAt:   iat,
-		ExpiresAt:  exp,
-	}, nil
+func (v *verifierCore) checkTokenLifetime(iat, exp time.Time) error {
+	if v.maxLife <= 0 {
+		return nil
+	}
+	if iat.IsZero() || exp.IsZero() {
+		return fmt.Errorf("%w: missing iat or exp claim", ErrTokenLifetimeTooLong)
+	}
+	if lifetime := exp.Sub(iat); lifetime > v.maxLife {
+		return fmt.Errorf("%w: valid for %s, maximum is %s", ErrTokenLifetimeTooLong, lifetime, v.maxLife)
+	}
+	return nil
 }
 
-func (v *GitHubVerifier) extractAudience(claims jwt.MapClaims) ([]string, error) {
+func (v *verifierCore) extractAudience(claims jwt.MapClaims) ([]string, error) {
 	aud := claims["aud"]
 	switch a := aud.(type) {
 	case string:
@@ -158,159 +434,50 @@ func (v *GitHubVerifier) extractAudience(claims jwt.MapClaims) ([]string, error)
 	}
 }
 
-func (v *GitHubVerifier) containsAudience(audiences []string, expected string) bool {
-	for _, aud := range audiences {
-		if aud == expected {
-			return true
+func (v *verifierCore) checkRequiredClaims(claims jwt.MapClaims) error {
+	for _, name := range v.required {
+		switch value := claims[name].(type) {
+		case nil:
+			return &MissingClaimError{Name: name}
+		case string:
+			if value == "" {
+				return &MissingClaimError{Name: name}
+			}
 		}
 	}
-	return false
-}
-
-func (v *GitHubVerifier) extractRunID(claims jwt.MapClaims) string {
-	if runID, ok := claims["run_id"].(string); ok {
-		return runID
-	}
-	if runID, ok := claims["run_id"].(float64); ok {
-		return fmt.Sprintf("%.0f", runID)
-	}
-	return ""
-}
-
-func (v *GitHubVerifier) extractTimestamp(claims jwt.MapClaims, key string) time.Time {
-	if val, ok := claims[key].(float64); ok {
-		return time.Unix(int64(val), 0)
-	}
-	return time.Time{}
-}
-
-// JWKSCache caches JWKS keys
-type JWKSCache struct {
-	url        string
-	ttl        time.Duration
-	mu         sync.RWMutex
-	keys       map[string]*rsa.PublicKey
-	fetchedAt  time.Time
-	httpClient *http.Client
-}
-
-// NewJWKSCache creates a new JWKS cache
-func NewJWKSCache(url string, ttl time.Duration) *JWKSCache {
-	return &JWKSCache{
-		url:        url,
-		ttl:        ttl,
-		keys:       make(map[string]*rsa.PublicKey),
-		httpClient: &http.Client{Timeout: 10 * time.Second},
-	}
-}
-
-// GetKey retrieves a public key by kid
-func (c *JWKSCache) GetKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
-	// Check cache first
-	c.mu.RLock()
-	if key, exists := c.keys[kid]; exists && time.Since(c.fetchedAt) < c.ttl {
-		c.mu.RUnlock()
-		return key, nil
-	}
-	c.mu.RUnlock()
-
-	// Fetch JWKS
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	// Double-check after acquiring write lock
-	if key, exists := c.keys[kid]; exists && time.Since(c.fetchedAt) < c.ttl {
-		return key, nil
-	}
-
-	// Fetch from remote
-	if err := c.fetchJWKS(ctx); err != nil {
-		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
-	}
-
-	key, exists := c.keys[kid]
-	if !exists {
-		return nil, fmt.Errorf("key with kid %s not found in JWKS", kid)
-	}
-
-	return key, nil
+	return nil
 }
 
-func (c *JWKSCache) fetchJWKS(ctx context.Context) error {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to fetch JWKS: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
+// matchAudience returns the first accepted audience found in the token's aud
+// claim
+func (v *verifierCore) matchAudience(claims jwt.MapClaims) (string, error) {
+	aud, err := v.extractAudience(claims)
 	if err != nil {
-		return fmt.Errorf("failed to read response body: %w", err)
-	}
-
-	var jwks struct {
-		Keys []struct {
-			Kid string `json:"kid"`
-			Kty string `json:"kty"`
-			Use string `json:"use"`
-			N   string `json:"n"`
-			E   string `json:"e"`
-		} `json:"keys"`
+		return "", fmt.Errorf("%w: %w", ErrAudienceMismatch, err)
 	}
-
-	if err := json.Unmarshal(body, &jwks); err != nil {
-		return fmt.Errorf("failed to unmarshal JWKS: %w", err)
-	}
-
-	// Parse and cache keys
-	newKeys := make(map[string]*rsa.PublicKey)
-	for _, key := range jwks.Keys {
-		if key.Kty != "RSA" {
-			continue
-		}
-
-		pubKey, err := parseRSAPublicKey(key.N, key.E)
-		if err != nil {
-			continue // Skip invalid keys
+	for _, accepted := range v.audiences {
+		if slices.Contains(aud, accepted) {
+			return accepted, nil
 		}
-
-		newKeys[key.Kid] = pubKey
 	}
-
-	c.keys = newKeys
-	c.fetchedAt = time.Now()
-
-	return nil
+	return "", fmt.Errorf("%w: expected one of %s", ErrAudienceMismatch, strings.Join(v.audiences, ", "))
 }
 
-func parseRSAPublicKey(nStr, eStr string) (*rsa.PublicKey, error) {
-	nBytes, err := base64.RawURLEncoding.DecodeString(nStr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode n: %w", err)
+// extractStringOrNumber returns a claim that providers encode either as a
+// string or as a JSON number, such as GitHub's run_id
+func (v *verifierCore) extractStringOrNumber(claims jwt.MapClaims, key string) string {
+	if val, ok := claims[key].(string); ok {
+		return val
 	}
-
-	eBytes, err := base64.RawURLEncoding.DecodeString(eStr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode e: %w", err)
+	if val, ok := claims[key].(float64); ok {
+		return fmt.Sprintf("%.0f", val)
 	}
+	return ""
+}
 
-	n := new(big.Int).SetBytes(nBytes)
-	e := 0
-	for _, b := range eBytes {
-		e = e*256 + int(b)
+func (v *verifierCore) extractTimestamp(claims jwt.MapClaims, key string) time.Time {
+	if val, ok := claims[key].(float64); ok {
+		return time.Unix(int64(val), 0)
 	}
-
-	return &rsa.PublicKey{
-		N: n,
-		E: e,
-	}, nil
+	return time.Time{}
 }
@@ -2,13 +2,144 @@ package oidc
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/robohub/auth-service/internal/testutil"
 	"github.com/robohub/auth-service/internal/types"
 )
 
+// testIssuer serves a JWKS for a generated RSA key and signs tokens with it.
+// rotate swaps in a new key and kid, as the real issuer does on rotation.
+type testIssuer struct {
+	server  *httptest.Server
+	fetches atomic.Int32
+
+	mu  sync.Mutex
+	key *rsa.PrivateKey
+	kid string
+}
+
+func newTestIssuer(t testing.TB) *testIssuer {
+	t.Helper()
+
+	ti := &testIssuer{}
+	ti.rotate(t, "test-kid")
+	ti.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/jwks" {
+			http.NotFound(w, r)
+			return
+		}
+		ti.fetches.Add(1)
+
+		ti.mu.Lock()
+		key, kid := ti.key, ti.kid
+		ti.mu.Unlock()
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{rsaJWK(kid, &key.PublicKey)},
+		})
+	}))
+	t.Cleanup(ti.server.Close)
+
+	return ti
+}
+
+// rotate replaces the signing key and publishes it under kid
+func (ti *testIssuer) rotate(t testing.TB, kid string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	ti.key, ti.kid = key, kid
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kid": kid,
+		"kty": "RSA",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]string {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	return map[string]string{
+		"kid": kid,
+		"kty": "EC",
+		"use": "sig",
+		"crv": key.Curve.Params().Name,
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))),
+	}
+}
+
+// serveJWKS serves the given JWKs as a key set
+func serveJWKS(t testing.TB, keys ...map[string]string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// claims returns a valid set of GitHub claims issued at now
+func (ti *testIssuer) claims(now time.Time) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":          ti.server.URL,
+		"aud":          "robohub",
+		"sub":          "repo:owner/repo:ref:refs/heads/main",
+		"iat":          now.Unix(),
+		"nbf":          now.Unix(),
+		"exp":          now.Add(5 * time.Minute).Unix(),
+		"repository":   "owner/repo",
+		"ref":          "refs/heads/main",
+		"actor":        "testuser",
+		"run_id":       "123456789",
+		"workflow_ref": "owner/repo/.github/workflows/ci.yml@refs/heads/main",
+	}
+}
+
+func (ti *testIssuer) sign(t testing.TB, claims jwt.MapClaims) string {
+	t.Helper()
+
+	ti.mu.Lock()
+	key, kid := ti.key, ti.kid
+	ti.mu.Unlock()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signed
+}
+
 func TestGitHubVerifier_extractAudience(t *testing.T) {
 	v := &GitHubVerifier{}
 
@@ -50,46 +181,31 @@ func TestGitHubVerifier_extractAudience(t *testing.T) {
 	}
 }
 
-func TestGitHubVerifier_containsAudience(t *testing.T) {
-	v := &GitHubVerifier{}
+func TestGitHubVerifier_matchAudience(t *testing.T) {
+	v := &GitHubVerifier{verifierCore: &verifierCore{audiences: []string{"robohub", "robohub-prod"}}}
 
 	tests := []struct {
 		name      string
-		audiences []string
-		expected  string
-		want      bool
+		aud       interface{}
+		want      string
+		wantError bool
 	}{
-		{"contains", []string{"robohub", "other"}, "robohub", true},
-		{"not contains", []string{"other"}, "robohub", false},
-		{"empty", []string{}, "robohub", false},
+		{"contains", []interface{}{"robohub", "other"}, "robohub", false},
+		{"contains second accepted", []interface{}{"other", "robohub-prod"}, "robohub-prod", false},
+		{"string", "robohub-prod", "robohub-prod", false},
+		{"not contains", []interface{}{"other"}, "", true},
+		{"empty", []interface{}{}, "", true},
+		{"invalid type", 42.0, "", true},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := v.containsAudience(tt.audiences, tt.expected); got != tt.want {
-				t.Errorf("expected %v, got %v", tt.want, got)
+			got, err := v.matchAudience(map[string]interface{}{"aud": tt.aud})
+			if (err != nil) != tt.wantError {
+				t.Fatalf("expected error=%v, got error=%v", tt.wantError, err)
 			}
-		})
-	}
-}
-
-func TestGitHubVerifier_extractRunID(t *testing.T) {
-	v := &GitHubVerifier{}
-
-	tests := []struct {
-		name   string
-		claims map[string]interface{}
-		want   string
-	}{
-		{"string run_id", map[string]interface{}{"run_id": "123456789"}, "123456789"},
-		{"number run_id", map[string]interface{}{"run_id": 123456789.0}, "123456789"},
-		{"missing run_id", map[string]interface{}{}, ""},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := v.extractRunID(tt.claims); got != tt.want {
-				t.Errorf("expected %s, got %s", tt.want, got)
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
 			}
 		})
 	}
@@ -113,6 +229,88 @@ func TestGitHubVerifier_extractTimestamp(t *testing.T) {
 	}
 }
 
+func TestGitHubVerifier_WithProxy(t *testing.T) {
+	ti := newTestIssuer(t)
+	now := time.Now()
+
+	var proxied atomic.Int32
+	wantAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("robohub:secret"))
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != wantAuth {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		if r.URL.Host != "jwks.example.invalid" {
+			http.Error(w, "unexpected host "+r.URL.Host, http.StatusBadGateway)
+			return
+		}
+		proxied.Add(1)
+		ti.server.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatalf("failed to parse proxy URL: %v", err)
+	}
+	proxyURL.User = url.UserPassword("robohub", "secret")
+
+	// The JWKS host does not resolve, so the fetch only succeeds through the
+	// proxy
+	v := NewGitHubVerifier(ti.server.URL, "robohub", time.Minute, time.Hour,
+		WithClock(testutil.NewFakeClock(now)),
+		WithJWKSURL("http://jwks.example.invalid/.well-known/jwks"),
+		WithProxy(proxyURL),
+	)
+
+	if _, err := v.Verify(context.Background(), ti.sign(t, ti.claims(now))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := proxied.Load(); n != 1 {
+		t.Errorf("expected 1 proxied JWKS request, got %d", n)
+	}
+}
+
+func TestGitHubVerifier_WithTLSConfig(t *testing.T) {
+	ti := newTestIssuer(t)
+	now := time.Now()
+
+	mirror := httptest.NewTLSServer(ti.server.Config.Handler)
+	defer mirror.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(mirror.Certificate())
+
+	tests := []struct {
+		name      string
+		config    *tls.Config
+		wantError bool
+	}{
+		{"system roots only", nil, true},
+		{"mirror CA trusted", &tls.Config{RootCAs: roots}, false},
+		{"insecure skip verify", &tls.Config{InsecureSkipVerify: true}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []Option{
+				WithClock(testutil.NewFakeClock(now)),
+				WithJWKSURL(mirror.URL + "/.well-known/jwks"),
+				WithJWKSRetries(0),
+			}
+			if tt.config != nil {
+				opts = append(opts, WithTLSConfig(tt.config))
+			}
+			v := NewGitHubVerifier(ti.server.URL, "robohub", time.Minute, time.Hour, opts...)
+
+			_, err := v.Verify(context.Background(), ti.sign(t, ti.claims(now)))
+			if (err != nil) != tt.wantError {
+				t.Errorf("expected error=%v, got error=%v", tt.wantError, err)
+			}
+		})
+	}
+}
+
 func TestFakeVerifier(t *testing.T) {
 	ctx := context.Background()
 
@@ -142,36 +340,3 @@ func TestFakeVerifier(t *testing.T) {
 		}
 	})
 }
-
-func TestParseRSAPublicKey(t *testing.T) {
-	// Test with valid RSA key components (example from GitHub's JWKS)
-	// These are base64url encoded modulus and exponent
-	n := "xjlCRBqkQRiii6JJzkKNlLYNrwqqCRsf3a0g6s7dTbZSJmNvL0gVKfT_2GqM2cPbhGqJqJL9lFXJ5gZnMgSvVFCLkEYpQY3rR-pJQzkJFM1lLqJFd7QJIxJQlJQJpJGnJn9LjQQUKB6LQJ9n-2MnQQNnQmJMJJnQnQJMQQJnQQnQ"
-	e := "AQAB"
-
-	key, err := parseRSAPublicKey(n, e)
-	if err != nil {
-		t.Fatalf("expected valid key, got error: %v", err)
-	}
-	if key == nil {
-		t.Fatal("expected non-nil key")
-	}
-	if key.E != 65537 { // AQAB is standard exponent 65537
-		t.Errorf("expected exponent 65537, got %d", key.E)
-	}
-}
-
-func TestJWKSCache(t *testing.T) {
-	// Basic cache test - we can't test real JWKS fetching without a mock server
-	// but we can test the cache structure
-	cache := NewJWKSCache("https://example.com/.well-known/jwks", 1*time.Hour)
-	if cache == nil {
-		t.Fatal("expected non-nil cache")
-	}
-	if cache.url != "https://example.com/.well-known/jwks" {
-		t.Errorf("unexpected URL: %s", cache.url)
-	}
-	if cache.ttl != 1*time.Hour {
-		t.Errorf("unexpected TTL: %v", cache.ttl)
-	}
-}
@@ -0,0 +1,103 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+
+	"github.com/robohub/auth-service/internal/types"
+)
+
+// ErrConditionEvaluation marks a policy condition that failed to evaluate,
+// such as one reading a claim the token does not have. The token is denied.
+var ErrConditionEvaluation = errors.New("policy condition failed to evaluate")
+
+// Condition is a compiled policy condition: a boolean CEL expression over the
+// verified claims. Tokens are denied when it is false.
+//
+// The variables are repository, owner, ref, ref_type, actor, event_name,
+// environment, runner_environment, workflow_ref, job_workflow_ref, sha and
+// visibility, all strings, plus claims, the raw claim set as a map from
+// string to dyn. The CEL standard library is available.
+type Condition struct {
+	source  string
+	program cel.Program
+}
+
+// condVariables are the claims conditions may name, besides claims itself
+var condVariables = map[string]func(*types.VerifiedClaims) string{
+	"repository":         func(c *types.VerifiedClaims) string { return c.Repository },
+	"owner":              repositoryOwner,
+	"ref":                func(c *types.VerifiedClaims) string { return c.Ref },
+	"ref_type":           func(c *types.VerifiedClaims) string { return c.RefType },
+	"actor":              func(c *types.VerifiedClaims) string { return c.Actor },
+	"event_name":         func(c *types.VerifiedClaims) string { return c.EventName },
+	"environment":        func(c *types.VerifiedClaims) string { return c.Environment },
+	"runner_environment": func(c *types.VerifiedClaims) string { return c.RunnerEnvironment },
+	"workflow_ref":       func(c *types.VerifiedClaims) string { return c.WorkflowRef },
+	"job_workflow_ref":   func(c *types.VerifiedClaims) string { return c.JobWorkflowRef },
+	"sha":                func(c *types.VerifiedClaims) string { return c.SHA },
+	"visibility":         func(c *types.VerifiedClaims) string { return c.Visibility },
+}
+
+// conditionEnv declares the condition variables, once for every condition
+var conditionEnv = sync.OnceValues(func() (*cel.Env, error) {
+	opts := []cel.EnvOption{cel.Variable("claims", cel.MapType(cel.StringType, cel.DynType))}
+	for name := range condVariables {
+		opts = append(opts, cel.Variable(name, cel.StringType))
+	}
+	return cel.NewEnv(opts...)
+})
+
+// CompileCondition parses and type checks a condition, which must be boolean.
+// Regular expressions given as literals are compiled here too.
+func CompileCondition(source string) (*Condition, error) {
+	env, err := conditionEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CEL environment: %w", err)
+	}
+	ast, issues := env.Compile(source)
+	if issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	// A bare claim, such as claims['reusable'], is only known to be bool
+	// when evaluated
+	if t := ast.OutputType(); !t.IsExactType(cel.BoolType) && !t.IsExactType(cel.DynType) {
+		return nil, fmt.Errorf("condition must be boolean, got %s", t)
+	}
+	program, err := env.Program(ast, cel.EvalOptions(cel.OptOptimize))
+	if err != nil {
+		return nil, err
+	}
+	return &Condition{source: source, program: program}, nil
+}
+
+// String returns the condition's source
+func (c *Condition) String() string {
+	return c.source
+}
+
+// Eval reports whether the claims satisfy the condition
+func (c *Condition) Eval(claims *types.VerifiedClaims) (bool, error) {
+	vars := make(map[string]interface{}, len(condVariables)+1)
+	for name, get := range condVariables {
+		vars[name] = get(claims)
+	}
+	raw := claims.Raw
+	if raw == nil {
+		raw = map[string]interface{}{}
+	}
+	vars["claims"] = raw
+
+	out, _, err := c.program.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("condition evaluated to %s, not bool", out.Type())
+	}
+	return b, nil
+}
@@ -0,0 +1,181 @@
+package policy
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/robohub/auth-service/internal/types"
+)
+
+func TestCompileCondition(t *testing.T) {
+	tests := []struct {
+		name          string
+		source        string
+		errorContains string
+	}{
+		{"comparison", `actor != 'dependabot[bot]'`, ""},
+		{"logical", `actor != "dependabot[bot]" && (ref.matches('^refs/heads/release/') || environment == 'prod')`, ""},
+		{"list membership", `event_name in ['push', 'workflow_dispatch']`, ""},
+		{"raw claim", `claims['repository_visibility'] == 'private'`, ""},
+		{"claim presence", `!('head_ref' in claims)`, ""},
+		{"escaped quote", `actor == 'o\'brien'`, ""},
+		{"macro", `claims['runner_labels'].exists(label, label == 'linux')`, ""},
+		{"empty", ``, "Syntax error"},
+		{"not boolean", `repository`, "condition must be boolean, got string"},
+		{"undeclared variable", `branch == 'main'`, "undeclared reference to 'branch'"},
+		{"unknown method", `ref.glob('refs/*')`, "undeclared reference to 'glob'"},
+		{"invalid pattern", `ref.matches('[')`, "error parsing regexp"},
+		{"mismatched comparison", `ref == true`, "no matching overload for '_==_'"},
+		{"string in logical", `ref && true`, "expected type 'bool' but found 'string'"},
+		{"not on string", `!actor`, "no matching overload for '!_'"},
+		{"in string", `actor in 'octocat'`, "no matching overload for '@in'"},
+		{"index string", `ref['x'] == 'y'`, "no matching overload for '_[_]'"},
+		{"unterminated string", `actor == 'octocat`, "Syntax error"},
+		{"trailing tokens", `actor == 'octocat' actor`, "extraneous input 'actor'"},
+		{"unexpected character", `actor = 'octocat'`, "Syntax error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CompileCondition(tt.source)
+			if tt.errorContains == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+				t.Errorf("expected error to contain %q, got %v", tt.errorContains, err)
+			}
+		})
+	}
+}
+
+func TestCondition_Eval(t *testing.T) {
+	release := &types.VerifiedClaims{
+		Repository:        "myorg/firmware",
+		Owner:             "myorg",
+		Ref:               "refs/heads/release/2.0",
+		Actor:             "octocat",
+		EventName:         "push",
+		RunnerEnvironment: "github-hosted",
+		Raw: map[string]interface{}{
+			"repository_visibility": "private",
+			"reusable":              true,
+			"runner_labels":         []interface{}{"linux"},
+		},
+	}
+	dependabot := &types.VerifiedClaims{
+		Repository:  "myorg/firmware",
+		Ref:         "refs/heads/main",
+		Actor:       "dependabot[bot]",
+		EventName:   "pull_request",
+		Environment: "prod",
+	}
+	prod := &types.VerifiedClaims{
+		Repository:  "myorg/firmware",
+		Ref:         "refs/heads/main",
+		Actor:       "octocat",
+		EventName:   "workflow_dispatch",
+		Environment: "prod",
+	}
+
+	tests := []struct {
+		name          string
+		source        string
+		claims        *types.VerifiedClaims
+		want          bool
+		errorContains string
+	}{
+		{"release branch", `actor != 'dependabot[bot]' && (ref.matches('^refs/heads/release/') || environment == 'prod')`, release, true, ""},
+		{"prod environment", `actor != 'dependabot[bot]' && (ref.matches('^refs/heads/release/') || environment == 'prod')`, prod, true, ""},
+		{"dependabot", `actor != 'dependabot[bot]' && (ref.matches('^refs/heads/release/') || environment == 'prod')`, dependabot, false, ""},
+		{"event in list", `event_name in ['push', 'workflow_dispatch']`, dependabot, false, ""},
+		{"owner", `owner == 'myorg' && repository.startsWith('myorg/')`, release, true, ""},
+		{"hosted runner", `runner_environment == 'github-hosted'`, prod, false, ""},
+		{"raw string claim", `claims['repository_visibility'] == 'private'`, release, true, ""},
+		{"raw bool claim", `claims['reusable']`, release, true, ""},
+		{"claim presence", `'repository_visibility' in claims`, prod, false, ""},
+		{"short circuit skips missing claim", `'repository_visibility' in claims && claims['repository_visibility'] == 'private'`, prod, false, ""},
+		{"missing claim", `claims['repository_visibility'] == 'private'`, prod, false, "no such key: repository_visibility"},
+		{"missing claim absorbed by ||", `claims['repository_visibility'] == 'private' || environment == 'prod'`, prod, true, ""},
+		{"missing claim absorbed by &&", `claims['repository_visibility'] == 'private' && environment == 'staging'`, prod, false, ""},
+		{"claim of the wrong type", `claims['runner_labels'] == 'linux'`, release, false, ""},
+		{"list claim", `claims['runner_labels'].exists(label, label == 'linux')`, release, true, ""},
+		{"escape sequences", `'\x6f\u0063tocat\n' == actor + '\n'`, prod, true, ""},
+		{"non-bool claim", `claims['repository_visibility']`, release, false, "not bool"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			condition, err := CompileCondition(tt.source)
+			if err != nil {
+				t.Fatalf("failed to compile condition: %v", err)
+			}
+			got, err := condition.Eval(tt.claims)
+			if tt.errorContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("expected error to contain %q, got %v", tt.errorContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEnforcer_EvaluateCondition(t *testing.T) {
+	f, err := LoadFile(writePolicyFile(t, `{
+		"defaults": {"condition": "actor != 'dependabot[bot]'"},
+		"rules": [
+			{"repository": "myorg/firmware", "condition": "ref.matches('^refs/heads/release/') || environment == 'prod'"},
+			{"repository": "myorg/private", "condition": "claims['repository_visibility'] == 'private'"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("failed to load policy file: %v", err)
+	}
+	e := NewEnforcer(false, "main", nil, nil, WithFile(f))
+
+	tests := []struct {
+		name       string
+		claims     types.VerifiedClaims
+		wantReason Reason
+		wantRuleID string
+	}{
+		{"defaults", types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/main", Actor: "octocat"}, ReasonAllowed, ""},
+		{"defaults deny", types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/main", Actor: "dependabot[bot]"}, ReasonConditionFailed, "file:defaults"},
+		{"rule", types.VerifiedClaims{Repository: "myorg/firmware", Ref: "refs/heads/release/2.0"}, ReasonAllowed, ""},
+		{"rule deny", types.VerifiedClaims{Repository: "myorg/firmware", Ref: "refs/heads/main"}, ReasonConditionFailed, "file:myorg/firmware"},
+		{"missing claim", types.VerifiedClaims{Repository: "myorg/private", Ref: "refs/heads/main"}, ReasonConditionError, "file:myorg/private"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := e.Check(&tt.claims)
+			if d.Reason != tt.wantReason || d.RuleID != tt.wantRuleID {
+				t.Errorf("expected %s by %q, got %+v", tt.wantReason, tt.wantRuleID, d)
+			}
+		})
+	}
+
+	t.Run("evaluation failure is marked", func(t *testing.T) {
+		err := e.Evaluate(&types.VerifiedClaims{Repository: "myorg/private", Ref: "refs/heads/main"})
+		if !errors.Is(err, ErrConditionEvaluation) {
+			t.Errorf("expected ErrConditionEvaluation, got %v", err)
+		}
+	})
+
+	t.Run("uncompiled condition denies", func(t *testing.T) {
+		e := NewEnforcer(false, "main", nil, nil, WithFile(&File{Defaults: Rule{Condition: "true"}}))
+		if err := e.Evaluate(&types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/main"}); !errors.Is(err, ErrConditionEvaluation) {
+			t.Errorf("expected ErrConditionEvaluation, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package policy
+
+import "fmt"
+
+// Reason is a stable code for the outcome of a policy decision, safe to
+// match on in dashboards and alerts unlike the human message
+type Reason string
+
+const (
+	ReasonAllowed               Reason = "allowed"
+	ReasonSubjectNotAllowed     Reason = "subject_not_allowed"
+	ReasonRepoDenied            Reason = "repo_denied"
+	ReasonRepoArchived          Reason = "repo_archived"
+	ReasonOwnerDenied           Reason = "owner_denied"
+	ReasonNotInAllowlist        Reason = "not_in_allowlist"
+	ReasonBotActorDenied        Reason = "bot_actor_denied"
+	ReasonTokenTooOld           Reason = "token_too_old"
+	ReasonEnterpriseNotAllowed  Reason = "enterprise_not_allowed"
+	ReasonVisibilityNotAllowed  Reason = "visibility_not_allowed"
+	ReasonWorkflowNotAllowed    Reason = "workflow_not_allowed"
+	ReasonEventNotAllowed       Reason = "event_not_allowed"
+	ReasonForkPRDenied          Reason = "fork_pr_denied"
+	ReasonBaseRefNotAllowed     Reason = "base_ref_not_allowed"
+	ReasonWorkflowNotPinned     Reason = "workflow_not_pinned"
+	ReasonRefTypeNotAllowed     Reason = "ref_type_not_allowed"
+	ReasonTagNotAllowed         Reason = "tag_not_allowed"
+	ReasonRefNotAllowed         Reason = "ref_not_allowed"
+	ReasonBranchNotAllowed      Reason = "branch_not_allowed"
+	ReasonRunnerNotAllowed      Reason = "runner_not_allowed"
+	ReasonEnvironmentNotAllowed Reason = "environment_not_allowed"
+	ReasonSHARequired           Reason = "sha_required"
+	ReasonConditionFailed       Reason = "condition_failed"
+	ReasonConditionError        Reason = "condition_error"
+	ReasonFreezeWindow          Reason = "freeze_window"
+	ReasonOPADenied             Reason = "opa_denied"
+	ReasonScopeNotPermitted     Reason = "scope_not_permitted"
+)
+
+// Decision is the outcome of evaluating a token against the policy
+type Decision struct {
+	Allowed bool
+
+	// RuleID names the setting that denied the token, such as repo_denylist,
+	// default_branch or file:myorg/* for a policy file rule. It is empty
+	// when the token is allowed.
+	RuleID string
+
+	Reason  Reason
+	Message string
+
+	// Reduced is the branch denial a token was allowed despite, with only
+	// the reduced scopes. It is only set on allowed decisions.
+	Reduced *Decision
+
+	// PolicyVersion identifies the policy the decision was made with
+	PolicyVersion string
+
+	// file is the policy file the decision was made with, which GrantFor
+	// grants from so that a reload in between cannot mix two versions
+	file *File
+
+	// cause is an error the denial wraps, such as ErrConditionEvaluation
+	cause error
+}
+
+// allowed is the Decision for a token that passes policy
+var allowed = Decision{Allowed: true, Reason: ReasonAllowed}
+
+func deny(ruleID string, reason Reason, format string, args ...interface{}) Decision {
+	return Decision{RuleID: ruleID, Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+// Err returns the denial as a *DeniedError, or nil when the token is allowed
+func (d Decision) Err() error {
+	if d.Allowed {
+		return nil
+	}
+	return &DeniedError{Decision: d}
+}
+
+// DeniedError is a policy denial. Its message is the Decision's.
+type DeniedError struct {
+	Decision Decision
+}
+
+func (e *DeniedError) Error() string {
+	return e.Decision.Message
+}
+
+func (e *DeniedError) Unwrap() error {
+	return e.Decision.cause
+}
+
+// fileRuleID names the policy file rule that applies to a repository
+func fileRuleID(rule Rule) string {
+	if rule.Repository == "" {
+		return "file:defaults"
+	}
+	return "file:" + rule.Repository
+}
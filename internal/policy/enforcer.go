@@ -1,25 +1,544 @@
 package policy
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"path"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/robohub/auth-service/internal/clock"
+	"github.com/robohub/auth-service/internal/types"
 )
 
 // Enforcer enforces repository and branch policies
 type Enforcer struct {
 	defaultBranchOnly bool
-	defaultBranch     string
+	allowedBranches   []string
+	refPatterns       []string
+	tagPatterns       []string
+	refTypes          []string
 	allowList         map[string]bool
 	denyList          map[string]bool
+	allowListFile     string
+	denyListFile      string
+	repoLists         atomic.Pointer[RepoLists]
+	ownerAllowList    map[string]bool
+	ownerDenyList     map[string]bool
+	denyBots          bool
+	allowedBots       map[string]bool
+	requireSHA        bool
+	hostedRunnerRepos map[string]bool
+	hostedRunnersOnly bool
+	denyNoRunner      bool
+	enterprises       map[string]bool
+	visibilities      map[string]bool
+	denyNoVisibility  bool
+	workflows         map[string]bool
+	workflowPins      map[string]map[string]bool
+	environments      []environmentRule
+	events            EventRule
+	repoEvents        map[string]EventRule
+	denyMissingEvent  bool
+	denyForkPRs       bool
+	repoScopes        []scopeRule
+	defaultScopes     []string
+	reducedScopes     []string
+	repoTTLs          []ttlRule
+	maxTTL            time.Duration
+	opa               *OPAClient
+	opaFailOpen       bool
+	github            *GitHubClient
+	githubFailOpen    bool
+	branchLookup      *GitHubClient
+	dryRun            bool
+	dryRunDenials     atomic.Uint64
+	decisionsMu       sync.Mutex
+	decisions         map[DecisionKey]uint64
+	logger            *slog.Logger
+	clock             clock.Clock
+	file              atomic.Pointer[File]
+	version           string
+	reloads           atomic.Uint64
+	reloadFailures    atomic.Uint64
+}
+
+// Status describes the loaded policy file, its reloads and the decisions made
+type Status struct {
+	// Hash identifies the loaded policy file; empty without one
+	Hash string
+
+	// PolicyVersion identifies the policy decisions are made with: the
+	// policy file's version or hash, else WithPolicyVersion
+	PolicyVersion string
+
+	Reloads        uint64
+	ReloadFailures uint64
+
+	// DryRunDenials counts denials dry-run mode let through
+	DryRunDenials uint64
+
+	// Decisions counts the outcomes of Decide by reason and rule
+	Decisions map[DecisionKey]uint64
+
+	// GitHubLookups counts the GitHub API lookups of the enforcer's checks
+	GitHubLookups GitHubStats
+}
+
+// Outcomes of Decide
+const (
+	OutcomeAllow       = "allow"
+	OutcomeDeny        = "deny"
+	OutcomeWouldDeny   = "would_deny"
+	OutcomeReduced     = "reduced"
+	OutcomeUnavailable = "unavailable"
+)
+
+// DecisionKey groups decisions for counting. It holds rule IDs rather than
+// repositories so that the number of keys stays bounded by the policy.
+type DecisionKey struct {
+	Outcome string
+	Reason  Reason
+	RuleID  string
+}
+
+// EventRule allows or denies tokens by their event_name claim. Deny takes
+// precedence; a non-empty Allow rejects every event not listed.
+type EventRule struct {
+	Allow []string
+	Deny  []string
+}
+
+// empty reports whether the rule restricts no events
+func (r EventRule) empty() bool {
+	return len(r.Allow) == 0 && len(r.Deny) == 0
+}
+
+// environmentRule requires tokens for repositories matching pattern to come
+// from a job running in one of environments
+type environmentRule struct {
+	pattern      string
+	environments []string
+}
+
+// scopeRule grants scopes to tokens for repositories matching pattern
+type scopeRule struct {
+	pattern string
+	scopes  []string
+}
+
+// ttlRule grants a token lifetime to repositories matching pattern
+type ttlRule struct {
+	pattern string
+	ttl     time.Duration
+}
+
+// Option configures optional Enforcer behavior
+type Option func(*Enforcer)
+
+// WithClock sets the clock token ages and freeze windows are measured with
+func WithClock(c clock.Clock) Option {
+	return func(e *Enforcer) {
+		e.clock = c
+	}
+}
+
+// WithPolicyVersion sets the version reported for the policy the options
+// make up, while no policy file is loaded
+func WithPolicyVersion(version string) Option {
+	return func(e *Enforcer) {
+		e.version = version
+	}
+}
+
+// WithRequireSHA rejects tokens that do not name the commit they were
+// issued for
+func WithRequireSHA(require bool) Option {
+	return func(e *Enforcer) {
+		e.requireSHA = require
+	}
+}
+
+// WithAllowedBranches replaces the default branch with the branch names,
+// such as main and master, allowed when only default branch tokens are
+// accepted. An empty list keeps the default branch.
+func WithAllowedBranches(branches []string) Option {
+	return func(e *Enforcer) {
+		if len(branches) > 0 {
+			e.allowedBranches = branches
+		}
+	}
+}
+
+// WithRefPatterns restricts tokens to refs matching one of the given glob
+// patterns, such as refs/heads/release/*, matched against the full ref with
+// path.Match. When set it replaces the default branch check.
+func WithRefPatterns(patterns []string) Option {
+	return func(e *Enforcer) {
+		e.refPatterns = append(e.refPatterns, patterns...)
+	}
+}
+
+// WithTagPatterns allows tags matching one of the given glob patterns, such
+// as refs/tags/v*, in addition to the refs allowed by the default branch check
+// or WithRefPatterns. Other tags are rejected. A ref only counts as a tag when
+// the token's ref_type, if present, agrees.
+func WithTagPatterns(patterns []string) Option {
+	return func(e *Enforcer) {
+		e.tagPatterns = append(e.tagPatterns, patterns...)
+	}
+}
+
+// WithAllowedRefTypes restricts tokens to refs of the given types, branch or
+// tag, taken from the token's ref_type or, without one, the prefix of its ref
+func WithAllowedRefTypes(refTypes []string) Option {
+	return func(e *Enforcer) {
+		e.refTypes = refTypes
+	}
+}
+
+// WithOwnerAllowlist allows every repository of the given owners, as though
+// each were in the repository allowlist. Once either allowlist is set,
+// repositories in neither are rejected.
+func WithOwnerAllowlist(owners []string) Option {
+	return func(e *Enforcer) {
+		for _, owner := range owners {
+			e.ownerAllowList[owner] = true
+		}
+	}
+}
+
+// WithOwnerDenylist rejects every repository of the given owners, even those
+// in the repository allowlist
+func WithOwnerDenylist(owners []string) Option {
+	return func(e *Enforcer) {
+		for _, owner := range owners {
+			e.ownerDenyList[owner] = true
+		}
+	}
+}
+
+// WithDenyBots rejects tokens whose actor is a GitHub App, such as
+// dependabot[bot], unless it is one of WithAllowedBots
+func WithDenyBots(deny bool) Option {
+	return func(e *Enforcer) {
+		e.denyBots = deny
+	}
+}
+
+// WithAllowedBots exempts the given bot actors, such as renovate[bot], from
+// WithDenyBots
+func WithAllowedBots(bots []string) Option {
+	return func(e *Enforcer) {
+		for _, bot := range bots {
+			e.allowedBots[bot] = true
+		}
+	}
+}
+
+// WithHostedRunnersOnly rejects tokens for the given repositories unless
+// they were issued to a GitHub-hosted runner. Tokens without a
+// runner_environment claim are rejected too, unless
+// WithDenyMissingRunnerEnvironment(false) is set.
+func WithHostedRunnersOnly(repos []string) Option {
+	return func(e *Enforcer) {
+		for _, repo := range repos {
+			e.hostedRunnerRepos[repo] = true
+		}
+	}
+}
+
+// WithRequireHostedRunners rejects tokens for every repository unless they
+// were issued to a GitHub-hosted runner
+func WithRequireHostedRunners(require bool) Option {
+	return func(e *Enforcer) {
+		e.hostedRunnersOnly = require
+	}
+}
+
+// WithDenyMissingRunnerEnvironment sets whether tokens without a
+// runner_environment claim, such as those from older GitHub Enterprise
+// Server versions, are rejected where a GitHub-hosted runner is required.
+// They are by default; otherwise the runner check is skipped for them.
+func WithDenyMissingRunnerEnvironment(deny bool) Option {
+	return func(e *Enforcer) {
+		e.denyNoRunner = deny
+	}
+}
+
+// WithEnterpriseAllowlist rejects tokens unless they were issued for a
+// repository in one of the given GitHub enterprises. Tokens without an
+// enterprise claim, such as those for personal accounts, are rejected too.
+// An empty list allows every enterprise and none.
+func WithEnterpriseAllowlist(enterprises []string) Option {
+	return func(e *Enforcer) {
+		for _, enterprise := range enterprises {
+			e.enterprises[enterprise] = true
+		}
+	}
+}
+
+// WithAllowedVisibilities rejects tokens unless their repository_visibility
+// claim is one of the given visibilities, such as private and internal, so
+// that a public repository cannot pass as an internal one of the same name.
+// An empty list allows every visibility.
+func WithAllowedVisibilities(visibilities []string) Option {
+	return func(e *Enforcer) {
+		for _, visibility := range visibilities {
+			e.visibilities[visibility] = true
+		}
+	}
+}
+
+// WithDenyMissingVisibility rejects tokens without a repository_visibility
+// claim when allowed visibilities are configured. Otherwise the visibility
+// check is skipped for them.
+func WithDenyMissingVisibility(deny bool) Option {
+	return func(e *Enforcer) {
+		e.denyNoVisibility = deny
+	}
+}
+
+// WithWorkflowAllowlist rejects tokens unless their workflow_ref or
+// job_workflow_ref matches one of the given workflows. Jobs running a
+// reusable workflow from another repository must match by job_workflow_ref,
+// since that is the code that ran. An entry without an @ref, such as
+// shared/workflows/.github/workflows/deploy.yml, matches the workflow at any
+// ref. An empty list allows every workflow.
+func WithWorkflowAllowlist(workflows []string) Option {
+	return func(e *Enforcer) {
+		for _, workflow := range workflows {
+			e.workflows[workflow] = true
+		}
+	}
+}
+
+// WithWorkflowPins restricts the given repositories to the listed workflow
+// paths, such as owner/repo/.github/workflows/release.yml, matched against
+// workflow_ref or job_workflow_ref without their @ref. As with
+// WithWorkflowAllowlist, jobs running a reusable workflow from another
+// repository must match by job_workflow_ref. Repositories without pins may use
+// any workflow.
+func WithWorkflowPins(pins map[string][]string) Option {
+	return func(e *Enforcer) {
+		for repo, workflows := range pins {
+			if e.workflowPins[repo] == nil {
+				e.workflowPins[repo] = make(map[string]bool, len(workflows))
+			}
+			for _, workflow := range workflows {
+				path, _, _ := strings.Cut(workflow, "@")
+				e.workflowPins[repo][path] = true
+			}
+		}
+	}
+}
+
+// WithRequiredEnvironments requires tokens for repositories matching each
+// glob pattern, such as owner/repo or owner/robot-*, to come from a job
+// running in one of the listed GitHub environments. Environment names are
+// compared case-sensitively. Tokens without an environment claim are
+// rejected.
+func WithRequiredEnvironments(required map[string][]string) Option {
+	return func(e *Enforcer) {
+		patterns := make([]string, 0, len(required))
+		for pattern := range required {
+			patterns = append(patterns, pattern)
+		}
+		sort.Strings(patterns)
+		for _, pattern := range patterns {
+			e.environments = append(e.environments, environmentRule{pattern: pattern, environments: required[pattern]})
+		}
+	}
+}
+
+// WithRepoScopes grants tokens for repositories matching each glob pattern,
+// such as owner/repo or owner/docs-*, the listed scopes instead of the
+// default ones. An exact repository wins over patterns, and otherwise the
+// longest matching pattern wins.
+func WithRepoScopes(scopes map[string][]string) Option {
+	return func(e *Enforcer) {
+		for _, pattern := range longestFirst(scopes) {
+			e.repoScopes = append(e.repoScopes, scopeRule{pattern: pattern, scopes: scopes[pattern]})
+		}
+	}
+}
+
+// WithRepoTTLs gives tokens for repositories matching each glob pattern the
+// listed lifetime instead of the minter's. Patterns match as in
+// WithRepoScopes.
+func WithRepoTTLs(ttls map[string]time.Duration) Option {
+	return func(e *Enforcer) {
+		for _, pattern := range longestFirst(ttls) {
+			e.repoTTLs = append(e.repoTTLs, ttlRule{pattern: pattern, ttl: ttls[pattern]})
+		}
+	}
+}
+
+// WithMaxTokenTTL caps the lifetimes granted by the policy file,
+// WithRepoTTLs and OPA. Zero leaves them uncapped.
+func WithMaxTokenTTL(maxTTL time.Duration) Option {
+	return func(e *Enforcer) {
+		e.maxTTL = maxTTL
+	}
+}
+
+// longestFirst returns the map's patterns, longest first so that the most
+// specific matching pattern is found first
+func longestFirst[T any](rules map[string]T) []string {
+	patterns := make([]string, 0, len(rules))
+	for pattern := range rules {
+		patterns = append(patterns, pattern)
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i]) != len(patterns[j]) {
+			return len(patterns[i]) > len(patterns[j])
+		}
+		return patterns[i] < patterns[j]
+	})
+	return patterns
+}
+
+// WithDefaultScopes sets the scopes granted when neither the policy file nor
+// WithRepoScopes maps the repository. Empty keeps ingest:build.
+func WithDefaultScopes(scopes []string) Option {
+	return func(e *Enforcer) {
+		if len(scopes) > 0 {
+			e.defaultScopes = scopes
+		}
+	}
+}
+
+// WithReducedScopes allows tokens from branches the default branch check, or
+// a policy file rule's branches, would deny, granting them only those of
+// their scopes in scopes. Tokens left with no scopes are still denied.
+func WithReducedScopes(scopes []string) Option {
+	return func(e *Enforcer) {
+		e.reducedScopes = scopes
+	}
+}
+
+// WithOPA hands decisions to an OPA server instead of the built-in policy.
+// When OPA cannot decide, failOpen falls back to the built-in policy and
+// otherwise the token is denied.
+func WithOPA(client *OPAClient, failOpen bool) Option {
+	return func(e *Enforcer) {
+		e.opa = client
+		e.opaFailOpen = failOpen
+	}
+}
+
+// WithArchivedCheck rejects tokens for repositories the GitHub API reports
+// as archived, once they have passed the rest of the policy. When the API
+// cannot be reached the token is rejected with an error wrapping
+// ErrGitHubUnavailable, unless failOpen skips the check.
+func WithArchivedCheck(client *GitHubClient, failOpen bool) Option {
+	return func(e *Enforcer) {
+		e.github = client
+		e.githubFailOpen = failOpen
+	}
+}
+
+// WithDefaultBranchLookup makes the default branch check, when on, require
+// each repository's own default branch as reported by the GitHub API rather
+// than WithAllowedBranches. The configured branches remain the fallback when
+// the API cannot be reached.
+func WithDefaultBranchLookup(client *GitHubClient) Option {
+	return func(e *Enforcer) {
+		e.branchLookup = client
+	}
+}
+
+// WithDryRun makes Decide let tokens the policy denies through, reporting
+// the denial in Grant.WouldDeny, so a stricter policy can be tried without
+// blocking anyone. Policy file rules can enable it per repository.
+func WithDryRun(dryRun bool) Option {
+	return func(e *Enforcer) {
+		e.dryRun = dryRun
+	}
+}
+
+// WithLogger sets the logger used for warnings such as OPA fallbacks
+func WithLogger(logger *slog.Logger) Option {
+	return func(e *Enforcer) {
+		e.logger = logger
+	}
+}
+
+// WithEventRule restricts the events, such as push or pull_request, that
+// tokens may be issued for
+func WithEventRule(rule EventRule) Option {
+	return func(e *Enforcer) {
+		e.events = rule
+	}
+}
+
+// WithRepoEventRules sets event rules for individual repositories, replacing
+// the global rule for them
+func WithRepoEventRules(rules map[string]EventRule) Option {
+	return func(e *Enforcer) {
+		for repo, rule := range rules {
+			e.repoEvents[repo] = rule
+		}
+	}
+}
+
+// WithDenyMissingEventName rejects tokens without an event_name claim when
+// an event rule applies to them. Otherwise the event check is skipped for
+// them.
+func WithDenyMissingEventName(deny bool) Option {
+	return func(e *Enforcer) {
+		e.denyMissingEvent = deny
+	}
+}
+
+// WithDenyForkPRs rejects tokens that may have been issued for a pull request
+// from a fork. GitHub tokens do not name a pull request's head repository, so
+// every pull_request_target token is rejected: that event runs with the base
+// repository's privileges for pull requests from any fork. pull_request tokens
+// are still allowed, since GitHub only issues them to fork pull requests when
+// a private repository opts in to sending write tokens to forks.
+func WithDenyForkPRs(deny bool) Option {
+	return func(e *Enforcer) {
+		e.denyForkPRs = deny
+	}
+}
+
+// WithFile applies a validated policy file. Its defaults and rules take
+// precedence over the ref pattern, tag pattern, workflow pin and required
+// environment options they overlap with.
+func WithFile(f *File) Option {
+	return func(e *Enforcer) {
+		e.file.Store(f)
+	}
 }
 
 // NewEnforcer creates a new policy enforcer
-func NewEnforcer(defaultBranchOnly bool, defaultBranch string, allowList, denyList []string) *Enforcer {
+func NewEnforcer(defaultBranchOnly bool, defaultBranch string, allowList, denyList []string, opts ...Option) *Enforcer {
 	e := &Enforcer{
 		defaultBranchOnly: defaultBranchOnly,
-		defaultBranch:     defaultBranch,
+		allowedBranches:   []string{defaultBranch},
 		allowList:         make(map[string]bool),
 		denyList:          make(map[string]bool),
+		ownerAllowList:    make(map[string]bool),
+		ownerDenyList:     make(map[string]bool),
+		allowedBots:       make(map[string]bool),
+		hostedRunnerRepos: make(map[string]bool),
+		denyNoRunner:      true,
+		enterprises:       make(map[string]bool),
+		visibilities:      make(map[string]bool),
+		workflows:         make(map[string]bool),
+		workflowPins:      make(map[string]map[string]bool),
+		repoEvents:        make(map[string]EventRule),
+		decisions:         make(map[DecisionKey]uint64),
+		defaultScopes:     []string{"ingest:build"},
+		logger:            slog.Default(),
+		clock:             clock.Real{},
 	}
 
 	for _, repo := range allowList {
@@ -30,40 +549,813 @@ func NewEnforcer(defaultBranchOnly bool, defaultBranch string, allowList, denyLi
 		e.denyList[repo] = true
 	}
 
+	for _, opt := range opts {
+		opt(e)
+	}
+
 	return e
 }
 
-// Evaluate checks if the repository and ref are allowed by policy
-func (e *Enforcer) Evaluate(repository, ref string) error {
-	// Check denylist first
-	if e.denyList[repository] {
-		return fmt.Errorf("repository %s is denied by policy", repository)
+// Evaluate checks if the verified token's repository and ref are allowed by
+// policy, returning a *DeniedError if not
+func (e *Enforcer) Evaluate(claims *types.VerifiedClaims) error {
+	return e.Check(claims).Err()
+}
+
+// Check is CheckContext with a background context
+func (e *Enforcer) Check(claims *types.VerifiedClaims) Decision {
+	return e.CheckContext(context.Background(), claims)
+}
+
+// CheckContext evaluates the verified token against the built-in policy and
+// the policy file, naming the rule and reason of a denial. With
+// WithDefaultBranchLookup it looks the repository's default branch up, as
+// Decide does.
+func (e *Enforcer) CheckContext(ctx context.Context, claims *types.VerifiedClaims) Decision {
+	return e.check(claims, e.defaultBranches(ctx, claims.Repository))
+}
+
+// check is Check with defaultBranches as the branches the default branch
+// check allows
+func (e *Enforcer) check(claims *types.VerifiedClaims, defaultBranches []string) Decision {
+	f := e.file.Load()
+	d := e.checkFile(f, claims, defaultBranches)
+	d.PolicyVersion, d.file = e.versionOf(f), f
+	if d.Reduced != nil {
+		d.Reduced.PolicyVersion = d.PolicyVersion
 	}
+	return d
+}
 
-	// Check allowlist if configured
-	if len(e.allowList) > 0 && !e.allowList[repository] {
-		return fmt.Errorf("repository %s is not in allowlist", repository)
+// versionOf returns the version of the policy made up of the options and f
+func (e *Enforcer) versionOf(f *File) string {
+	if version := f.version(); version != "" {
+		return version
 	}
+	return e.version
+}
 
-	// Check default branch requirement
-	if e.defaultBranchOnly {
-		expectedRef := "refs/heads/" + e.defaultBranch
-		if ref != expectedRef {
-			return fmt.Errorf("only default branch %s is allowed, got %s", expectedRef, ref)
+// checkFile is check with f as the policy file
+func (e *Enforcer) checkFile(f *File, claims *types.VerifiedClaims, defaultBranches []string) Decision {
+	repository, ref := claims.Repository, claims.Ref
+	owner := repositoryOwner(claims)
+	rule := f.rule(claims)
+
+	if f != nil {
+		if d := f.Subjects.check(claims); !d.Allowed {
+			return d
 		}
 	}
 
+	// List files that never loaded fail closed
+	lists := e.repoLists.Load()
+	if lists == nil && (e.allowListFile != "" || e.denyListFile != "") {
+		return deny("repo_denylist", ReasonRepoDenied, "repository list files were not loaded")
+	}
+
+	// Denylists take precedence over allowlists, and repository lists over
+	// owner lists
+	if listed(e.denyList, claims) || lists.denies(claims) {
+		return deny("repo_denylist", ReasonRepoDenied, "repository %s is denied by policy", repository)
+	}
+	if e.ownerDenyList[owner] {
+		return deny("owner_denylist", ReasonOwnerDenied, "owner %s of repository %s is denied by policy", owner, repository)
+	}
+	if rule.Deny {
+		return deny(fileRuleID(rule), ReasonRepoDenied, "repository %s is denied by policy file rule %s", repository, rule.Repository)
+	}
+
+	// Check allowlists if configured
+	if len(e.allowList) > 0 || len(e.ownerAllowList) > 0 || e.allowListFile != "" {
+		if !listed(e.allowList, claims) && !lists.allows(claims) && !e.ownerAllowList[owner] {
+			return deny("allowlist", ReasonNotInAllowlist, "repository %s is not in allowlist", repository)
+		}
+	}
+
+	denyBots, botsID := e.denyBots, "deny_bots"
+	if rule.DenyBots != nil {
+		denyBots, botsID = *rule.DenyBots, fileRuleID(rule)
+	}
+	if denyBots && isBot(claims.Actor) && !e.allowedBots[claims.Actor] {
+		return deny(botsID, ReasonBotActorDenied, "actor %s is a bot, which is denied by policy", claims.Actor)
+	}
+
+	if rule.MaxTokenAgeSeconds > 0 {
+		maxAge := time.Duration(rule.MaxTokenAgeSeconds) * time.Second
+		if claims.IssuedAt.IsZero() {
+			return deny(fileRuleID(rule), ReasonTokenTooOld, "token has no iat claim, and repository %s allows tokens up to %s old", repository, maxAge)
+		}
+		if age := e.clock.Now().Sub(claims.IssuedAt); age > maxAge {
+			return deny(fileRuleID(rule), ReasonTokenTooOld, "token for repository %s was issued %s ago, maximum is %s", repository, age.Truncate(time.Second), maxAge)
+		}
+	}
+
+	if len(e.enterprises) > 0 {
+		if claims.Enterprise == "" {
+			return deny("enterprise_allowlist", ReasonEnterpriseNotAllowed, "repository %s does not belong to an enterprise, and an enterprise allowlist is configured", repository)
+		}
+		if !e.enterprises[claims.Enterprise] {
+			return deny("enterprise_allowlist", ReasonEnterpriseNotAllowed, "enterprise %s is not in allowlist", claims.Enterprise)
+		}
+	}
+
+	if len(e.visibilities) > 0 {
+		if claims.Visibility == "" {
+			if e.denyNoVisibility {
+				return deny("visibility", ReasonVisibilityNotAllowed, "token for repository %s has no repository_visibility claim", repository)
+			}
+		} else if !e.visibilities[claims.Visibility] {
+			return deny("visibility", ReasonVisibilityNotAllowed, "repository %s is %s, which is not an allowed visibility", repository, claims.Visibility)
+		}
+	}
+
+	if len(e.workflows) > 0 {
+		if claims.ReusableWorkflow {
+			if !e.workflowAllowed(claims.JobWorkflowRef) {
+				return deny("workflow_allowlist", ReasonWorkflowNotAllowed, "reusable workflow %s is not in allowlist", claims.JobWorkflowRef)
+			}
+		} else if !e.workflowAllowed(claims.WorkflowRef) && !e.workflowAllowed(claims.JobWorkflowRef) {
+			return deny("workflow_allowlist", ReasonWorkflowNotAllowed, "workflow %s is not in allowlist", claims.Workflow)
+		}
+	}
+
+	if d := e.checkEvent(repository, claims.EventName); !d.Allowed {
+		return d
+	}
+
+	if e.denyForkPRs && claims.EventName == "pull_request_target" {
+		return deny("deny_fork_prs", ReasonForkPRDenied, "event %s may run for a fork pull request, which is denied by policy (head ref %s)", claims.EventName, claims.HeadRef)
+	}
+
+	if len(rule.PullRequestBaseRefs) > 0 && (claims.EventName == "pull_request" || claims.EventName == "pull_request_target") {
+		if d := checkBaseRef(fileRuleID(rule), claims, rule.PullRequestBaseRefs); !d.Allowed {
+			return d
+		}
+	}
+
+	pins, ok := e.workflowPins[repository]
+	pinsID := "workflow_pins"
+	if len(rule.Workflows) > 0 {
+		pins, ok, pinsID = make(map[string]bool, len(rule.Workflows)), true, fileRuleID(rule)
+		for _, workflow := range rule.Workflows {
+			workflowPath, _, _ := strings.Cut(workflow, "@")
+			pins[workflowPath] = true
+		}
+	}
+	if ok {
+		if claims.ReusableWorkflow {
+			if !pinned(pins, claims.JobWorkflowRef) {
+				return deny(pinsID, ReasonWorkflowNotPinned, "reusable workflow %s is not pinned for repository %s", claims.JobWorkflowRef, repository)
+			}
+		} else if !pinned(pins, claims.WorkflowRef) && !pinned(pins, claims.JobWorkflowRef) {
+			return deny(pinsID, ReasonWorkflowNotPinned, "workflow %s is not pinned for repository %s", claims.Workflow, repository)
+		}
+	}
+
+	refTypes, refTypesID := e.refTypes, "allowed_ref_types"
+	if len(rule.AllowedRefTypes) > 0 {
+		refTypes, refTypesID = rule.AllowedRefTypes, fileRuleID(rule)
+	}
+	if len(refTypes) > 0 {
+		refType := refTypeOf(ref, claims.RefType)
+		if refType == "" {
+			return deny(refTypesID, ReasonRefTypeNotAllowed, "only ref types %s are allowed, and the type of ref %s is unknown", strings.Join(refTypes, ", "), ref)
+		}
+		if !slices.Contains(refTypes, refType) {
+			return deny(refTypesID, ReasonRefTypeNotAllowed, "only ref types %s are allowed, got %s %s", strings.Join(refTypes, ", "), refType, ref)
+		}
+	}
+
+	refPatterns, tagPatterns := e.refPatterns, e.tagPatterns
+	refID, tagID := "ref_patterns", "tag_patterns"
+	if len(rule.RefPatterns) > 0 {
+		refPatterns, refID = rule.RefPatterns, fileRuleID(rule)
+	}
+	if len(rule.TagPatterns) > 0 {
+		tagPatterns, tagID = rule.TagPatterns, fileRuleID(rule)
+	}
+	// Branches in the policy file restrict their repositories whether or
+	// not the default branch check is on
+	branchOnly, branches, branchID := e.defaultBranchOnly, defaultBranches, "default_branch"
+	if len(rule.Branches) > 0 {
+		branchOnly, branches, branchID = true, rule.Branches, fileRuleID(rule)
+	}
+	// With reduced scopes a branch denial lets the token through, provided
+	// it passes the remaining checks
+	var reduced *Decision
+
+	// Tag patterns decide for tags; otherwise ref patterns replace the
+	// default branch requirement
+	if len(tagPatterns) > 0 && isTag(ref, claims.RefType) {
+		if !matchRef(tagPatterns, ref, claims.RefType) {
+			return deny(tagID, ReasonTagNotAllowed, "tag %s does not match any allowed tag pattern", ref)
+		}
+	} else if len(refPatterns) > 0 {
+		if !matchRef(refPatterns, ref, claims.RefType) {
+			return deny(refID, ReasonRefNotAllowed, "ref %s does not match any allowed ref pattern", ref)
+		}
+	} else if branchOnly && !matchBranch(branches, ref, claims.RefType) {
+		d := deny(branchID, ReasonBranchNotAllowed, "only branches %s are allowed, got %s", branchRefs(branches), ref)
+		if claims.RefType != "" && claims.RefType != "branch" {
+			d = deny(branchID, ReasonBranchNotAllowed, "only branches %s are allowed, got %s %s", branchRefs(branches), claims.RefType, ref)
+		}
+		if len(e.reducedScopesFor(rule)) == 0 {
+			return d
+		}
+		reduced = &d
+	}
+
+	if d := e.checkRunner(rule, repository, claims.RunnerEnvironment); !d.Allowed {
+		return d
+	}
+
+	if len(rule.Environments) > 0 {
+		if d := checkEnvironment(fileRuleID(rule), repository, claims.Environment, rule.Environments); !d.Allowed {
+			return d
+		}
+	} else {
+		for _, envRule := range e.environments {
+			if ok, _ := path.Match(envRule.pattern, repository); !ok {
+				continue
+			}
+			if d := checkEnvironment("required_environments:"+envRule.pattern, repository, claims.Environment, envRule.environments); !d.Allowed {
+				return d
+			}
+		}
+	}
+
+	requireSHA, shaID := e.requireSHA, "require_sha"
+	if rule.RequireSHA != nil {
+		requireSHA, shaID = *rule.RequireSHA, fileRuleID(rule)
+	}
+	if requireSHA && claims.SHA == "" {
+		return deny(shaID, ReasonSHARequired, "commit sha is required by policy")
+	}
+
+	if rule.Condition != "" {
+		if d := checkCondition(repository, rule, claims); !d.Allowed {
+			return d
+		}
+	}
+
+	if reduced != nil {
+		d := allowed
+		d.Reduced = reduced
+		return d
+	}
+	return allowed
+}
+
+// reducedScopesFor returns the scopes tokens for the rule's repositories keep
+// on a branch they would otherwise be denied for, none to deny them
+func (e *Enforcer) reducedScopesFor(rule Rule) []string {
+	if len(rule.ReducedScopes) > 0 {
+		return rule.ReducedScopes
+	}
+	return e.reducedScopes
+}
+
+// GrantFor returns the grant of a token Check allowed with decision d. After a
+// branch denial, d.Reduced, only the grant's scopes among the reduced scopes
+// remain, and a token left without scopes is denied after all. Tokens an
+// active freeze window covers are denied too. The grant comes from the
+// policy file d was made with.
+func (e *Enforcer) GrantFor(claims *types.VerifiedClaims, d Decision) (Grant, error) {
+	f := d.file
+	if f == nil {
+		f = e.file.Load()
+	}
+	grant := e.grant(f, claims)
+	if d.Reduced != nil {
+		var err error
+		if grant, err = e.reduce(f, claims, grant, d); err != nil {
+			return Grant{}, err
+		}
+	}
+	if frozen := f.checkFreezes(claims.Repository, grant.Scopes, e.clock.Now()); !frozen.Allowed {
+		frozen.PolicyVersion = grant.PolicyVersion
+		return Grant{}, frozen.Err()
+	}
+	return grant, nil
+}
+
+// reduce limits the grant of a token allowed despite the branch denial
+// d.Reduced to the reduced scopes
+func (e *Enforcer) reduce(f *File, claims *types.VerifiedClaims, grant Grant, d Decision) (Grant, error) {
+	reducedScopes := e.reducedScopesFor(f.rule(claims))
+	var scopes, withheld []string
+	for _, scope := range grant.Scopes {
+		if slices.Contains(reducedScopes, scope) {
+			scopes = append(scopes, scope)
+		} else {
+			withheld = append(withheld, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return Grant{}, d.Reduced.Err()
+	}
+	grant.Elevations = slices.DeleteFunc(grant.Elevations, func(elevation Elevation) bool {
+		return !slices.Contains(scopes, elevation.Scope)
+	})
+	if len(grant.Elevations) == 0 {
+		grant.Elevations = nil
+	}
+	grant.Scopes, grant.Withheld, grant.Reduced = scopes, withheld, d.Reduced.Err()
+	return grant, nil
+}
+
+// checkCondition evaluates the policy file rule's condition, denying tokens
+// when it is false or fails to evaluate
+func checkCondition(repository string, rule Rule, claims *types.VerifiedClaims) Decision {
+	// A File that skipped Validate has no compiled condition; fail closed
+	if rule.condition == nil {
+		d := deny(fileRuleID(rule), ReasonConditionError, "%s: condition %q for repository %s was not compiled", ErrConditionEvaluation, rule.Condition, repository)
+		d.cause = ErrConditionEvaluation
+		return d
+	}
+	ok, err := rule.condition.Eval(claims)
+	if err != nil {
+		d := deny(fileRuleID(rule), ReasonConditionError, "%s: condition %q for repository %s: %v", ErrConditionEvaluation, rule.Condition, repository, err)
+		d.cause = ErrConditionEvaluation
+		return d
+	}
+	if !ok {
+		return deny(fileRuleID(rule), ReasonConditionFailed, "repository %s does not satisfy policy condition %q", repository, rule.Condition)
+	}
+	return allowed
+}
+
+// Decide evaluates the claims and returns the grant of an allowed token. With
+// WithOPA the OPA server decides, and scopes it leaves out come from Grant.
+// In dry-run mode denials are returned in the grant's WouldDeny instead.
+func (e *Enforcer) Decide(ctx context.Context, claims *types.VerifiedClaims) (Grant, error) {
+	grant, err := e.decide(ctx, claims)
+	if err == nil {
+		var reduced *DeniedError
+		if errors.As(grant.Reduced, &reduced) {
+			e.count(OutcomeReduced, reduced.Decision)
+		} else {
+			e.count(OutcomeAllow, allowed)
+		}
+		return grant, nil
+	}
+	if errors.Is(err, ErrOPAUnavailable) {
+		e.count(OutcomeUnavailable, Decision{RuleID: "opa"})
+		return grant, err
+	}
+	if errors.Is(err, ErrGitHubUnavailable) {
+		e.count(OutcomeUnavailable, Decision{RuleID: "archived"})
+		return grant, err
+	}
+
+	var decision Decision
+	var denied *DeniedError
+	if errors.As(err, &denied) {
+		// Denials made outside check, such as by OPA, carry no version
+		if denied.Decision.PolicyVersion == "" {
+			denied.Decision.PolicyVersion = e.versionOf(e.file.Load())
+		}
+		decision = denied.Decision
+	}
+	if !e.dryRun && !e.file.Load().rule(claims).DryRun {
+		e.count(OutcomeDeny, decision)
+		return Grant{}, err
+	}
+	e.count(OutcomeWouldDeny, decision)
+	e.dryRunDenials.Add(1)
+	grant = e.Grant(claims)
+	grant.WouldDeny = err
+	return grant, nil
+}
+
+func (e *Enforcer) decide(ctx context.Context, claims *types.VerifiedClaims) (Grant, error) {
+	grant, err := e.evaluate(ctx, claims)
+	if err != nil {
+		return Grant{}, err
+	}
+	if err := e.checkArchived(ctx, claims.Repository); err != nil {
+		return Grant{}, err
+	}
+	return grant, nil
+}
+
+// evaluate asks OPA, or the built-in policy, for a decision
+func (e *Enforcer) evaluate(ctx context.Context, claims *types.VerifiedClaims) (Grant, error) {
+	if e.opa != nil {
+		grant, err := e.opa.Decide(ctx, claims)
+		switch {
+		case err == nil:
+			if len(grant.Scopes) == 0 {
+				builtin := e.Grant(claims)
+				grant.Scopes, grant.Elevations = builtin.Scopes, builtin.Elevations
+			}
+			grant.TTL = e.capTTL(grant.TTL)
+			grant.PolicyVersion = e.versionOf(e.file.Load())
+			return grant, nil
+		case !errors.Is(err, ErrOPAUnavailable) || !e.opaFailOpen:
+			return Grant{}, err
+		}
+		e.logger.WarnContext(ctx, "OPA unavailable, falling back to built-in policy",
+			"repository", claims.Repository,
+			"error", err,
+		)
+	}
+
+	d := e.check(claims, e.defaultBranches(ctx, claims.Repository))
+	if !d.Allowed {
+		return Grant{}, d.Err()
+	}
+	return e.GrantFor(claims, d)
+}
+
+// defaultBranches returns the branches the default branch check allows for
+// the repository: its default branch with WithDefaultBranchLookup, else, or
+// when the lookup fails, WithAllowedBranches
+func (e *Enforcer) defaultBranches(ctx context.Context, repository string) []string {
+	if e.branchLookup == nil || !e.defaultBranchOnly {
+		return e.allowedBranches
+	}
+	branch, err := e.branchLookup.DefaultBranch(ctx, repository)
+	if err != nil {
+		e.logger.WarnContext(ctx, "GitHub API unavailable, falling back to the configured default branches",
+			"repository", repository,
+			"branches", e.allowedBranches,
+			"error", err,
+		)
+		return e.allowedBranches
+	}
+	return []string{branch}
+}
+
+// checkArchived rejects repositories GitHub reports as archived
+func (e *Enforcer) checkArchived(ctx context.Context, repository string) error {
+	if e.github == nil {
+		return nil
+	}
+	archived, err := e.github.Archived(ctx, repository)
+	if err != nil {
+		if !e.githubFailOpen {
+			return err
+		}
+		e.logger.WarnContext(ctx, "GitHub API unavailable, skipping archived repository check",
+			"repository", repository,
+			"error", err,
+		)
+		return nil
+	}
+	if archived {
+		return deny("archived", ReasonRepoArchived, "repository %s is archived", repository).Err()
+	}
 	return nil
 }
 
-// IsDefaultBranch checks if the given ref is the default branch
-func (e *Enforcer) IsDefaultBranch(ref string) bool {
-	expectedRef := "refs/heads/" + e.defaultBranch
-	return ref == expectedRef
+// IsUnavailable reports whether err is a decision that could not be made
+// because OPA or the GitHub API could not be reached, rather than a denial
+func IsUnavailable(err error) bool {
+	return errors.Is(err, ErrOPAUnavailable) || errors.Is(err, ErrGitHubUnavailable)
+}
+
+// count records a decision of Decide
+func (e *Enforcer) count(outcome string, d Decision) {
+	e.decisionsMu.Lock()
+	defer e.decisionsMu.Unlock()
+	e.decisions[DecisionKey{Outcome: outcome, Reason: d.Reason, RuleID: d.RuleID}]++
+}
+
+// Reload loads the policy file at name and swaps it in for subsequent
+// evaluations, flushing cached GitHub API lookups. On error the current
+// policy stays in place.
+func (e *Enforcer) Reload(name string) (*File, error) {
+	f, err := LoadFile(name)
+	if err != nil {
+		e.reloadFailures.Add(1)
+		return nil, err
+	}
+	e.file.Store(f)
+	e.reloads.Add(1)
+	for _, client := range e.githubClients() {
+		client.Flush()
+	}
+	return f, nil
+}
+
+// githubClients returns the distinct GitHub API clients of the enforcer's
+// checks
+func (e *Enforcer) githubClients() []*GitHubClient {
+	var clients []*GitHubClient
+	for _, client := range []*GitHubClient{e.github, e.branchLookup} {
+		if client != nil && !slices.Contains(clients, client) {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+// Status reports the loaded policy file's hash, reload counts and decision
+// counts
+func (e *Enforcer) Status() Status {
+	status := Status{
+		Reloads:        e.reloads.Load(),
+		ReloadFailures: e.reloadFailures.Load(),
+		DryRunDenials:  e.dryRunDenials.Load(),
+	}
+	e.decisionsMu.Lock()
+	status.Decisions = maps.Clone(e.decisions)
+	e.decisionsMu.Unlock()
+	for _, client := range e.githubClients() {
+		stats := client.Stats()
+		status.GitHubLookups.CacheHits += stats.CacheHits
+		status.GitHubLookups.CacheMisses += stats.CacheMisses
+		status.GitHubLookups.Failures += stats.Failures
+	}
+	f := e.file.Load()
+	if f != nil {
+		status.Hash = f.Hash
+	}
+	status.PolicyVersion = e.versionOf(f)
+	return status
+}
+
+// Grant returns the scopes and lifetime granted to tokens for the repository.
+// Scopes come from the policy file, else WithRepoScopes, else the defaults,
+// plus those of the policy file's matching scope grants. The TTL likewise
+// comes from the policy file, else WithRepoTTLs, capped by WithMaxTokenTTL;
+// zero leaves the minter's lifetime in place.
+func (e *Enforcer) Grant(claims *types.VerifiedClaims) Grant {
+	return e.grant(e.file.Load(), claims)
+}
+
+// grant is Grant with f as the policy file
+func (e *Enforcer) grant(f *File, claims *types.VerifiedClaims) Grant {
+	rule := f.rule(claims)
+	grant := Grant{
+		Scopes:        rule.Scopes,
+		TTL:           time.Duration(rule.TokenTTLSeconds) * time.Second,
+		PolicyVersion: e.versionOf(f),
+	}
+	if len(grant.Scopes) == 0 {
+		grant.Scopes = e.scopesFor(claims.Repository)
+	}
+	if grant.TTL == 0 {
+		grant.TTL = e.ttlFor(claims.Repository)
+	}
+	grant.TTL = e.capTTL(grant.TTL)
+
+	for _, scopeGrant := range rule.ScopeGrants {
+		if !scopeGrant.matches(claims) {
+			continue
+		}
+		for _, scope := range scopeGrant.Scopes {
+			if slices.Contains(grant.Scopes, scope) {
+				continue
+			}
+			// Clip so that the shared scopes are copied rather than appended to
+			grant.Scopes = append(slices.Clip(grant.Scopes), scope)
+			grant.Elevations = append(grant.Elevations, Elevation{Scope: scope, RuleID: fileRuleID(rule), Condition: scopeGrant.condition()})
+		}
+	}
+	return grant
+}
+
+// ttlFor returns the lifetime WithRepoTTLs maps repository to, or zero
+func (e *Enforcer) ttlFor(repository string) time.Duration {
+	for _, rule := range e.repoTTLs {
+		if rule.pattern == repository {
+			return rule.ttl
+		}
+	}
+	for _, rule := range e.repoTTLs {
+		if ok, _ := path.Match(rule.pattern, repository); ok {
+			return rule.ttl
+		}
+	}
+	return 0
+}
+
+// capTTL limits a granted lifetime to WithMaxTokenTTL
+func (e *Enforcer) capTTL(ttl time.Duration) time.Duration {
+	if e.maxTTL > 0 && ttl > e.maxTTL {
+		return e.maxTTL
+	}
+	return ttl
+}
+
+// scopesFor returns the scopes WithRepoScopes maps repository to, or else
+// the default scopes
+func (e *Enforcer) scopesFor(repository string) []string {
+	for _, rule := range e.repoScopes {
+		if rule.pattern == repository {
+			return rule.scopes
+		}
+	}
+	for _, rule := range e.repoScopes {
+		if ok, _ := path.Match(rule.pattern, repository); ok {
+			return rule.scopes
+		}
+	}
+	return e.defaultScopes
+}
+
+// checkBaseRef checks that a pull request targets one of baseRefs, compared
+// with or without refs/heads/
+func checkBaseRef(ruleID string, claims *types.VerifiedClaims, baseRefs []string) Decision {
+	expected := strings.Join(baseRefs, ", ")
+	if claims.BaseRef == "" {
+		return deny(ruleID, ReasonBaseRefNotAllowed, "%s token for repository %s has no base_ref, which must be one of %s", claims.EventName, claims.Repository, expected)
+	}
+	base := strings.TrimPrefix(claims.BaseRef, "refs/heads/")
+	for _, baseRef := range baseRefs {
+		if strings.TrimPrefix(baseRef, "refs/heads/") == base {
+			return allowed
+		}
+	}
+	return deny(ruleID, ReasonBaseRefNotAllowed, "pull request targets %s, but only base branches %s are allowed", claims.BaseRef, expected)
+}
+
+// checkEnvironment checks that the job ran in one of the required
+// environments, compared case-sensitively
+func checkEnvironment(ruleID, repository, environment string, required []string) Decision {
+	if slices.Contains(required, environment) {
+		return allowed
+	}
+	expected := strings.Join(required, ", ")
+	if environment == "" {
+		return deny(ruleID, ReasonEnvironmentNotAllowed, "repository %s requires environment %s, but the job has no environment", repository, expected)
+	}
+	return deny(ruleID, ReasonEnvironmentNotAllowed, "repository %s requires environment %s, got %q", repository, expected, environment)
+}
+
+// checkEvent applies the repository's event rule, or else the global one, to
+// the token's event_name
+func (e *Enforcer) checkEvent(repository, eventName string) Decision {
+	rule, ok := e.repoEvents[repository]
+	ruleID := "event_rule:" + repository
+	if !ok {
+		rule, ruleID = e.events, "event_rule"
+	}
+	if rule.empty() {
+		return allowed
+	}
+
+	if eventName == "" {
+		if e.denyMissingEvent {
+			return deny(ruleID, ReasonEventNotAllowed, "token has no event_name claim, which repository %s requires", repository)
+		}
+		return allowed
+	}
+	if slices.Contains(rule.Deny, eventName) {
+		return deny(ruleID, ReasonEventNotAllowed, "event %s is denied by policy for repository %s", eventName, repository)
+	}
+	if len(rule.Allow) > 0 && !slices.Contains(rule.Allow, eventName) {
+		return deny(ruleID, ReasonEventNotAllowed, "event %s is not in allowed events %s for repository %s", eventName, strings.Join(rule.Allow, ", "), repository)
+	}
+	return allowed
+}
+
+// checkRunner requires a GitHub-hosted runner where the policy file, the
+// repository list or the global setting asks for one, in that order of
+// precedence
+func (e *Enforcer) checkRunner(rule Rule, repository, runnerEnvironment string) Decision {
+	required, ruleID := e.hostedRunnersOnly, "require_hosted_runners"
+	if e.hostedRunnerRepos[repository] {
+		required, ruleID = true, "hosted_runner_repos"
+	}
+	if rule.HostedRunnersOnly != nil {
+		required, ruleID = *rule.HostedRunnersOnly, fileRuleID(rule)
+	}
+	if !required || runnerEnvironment == "github-hosted" {
+		return allowed
+	}
+
+	if runnerEnvironment == "" {
+		if !e.denyNoRunner {
+			return allowed
+		}
+		return deny(ruleID, ReasonRunnerNotAllowed, "repository %s requires a GitHub-hosted runner, and the token has no runner_environment claim", repository)
+	}
+	return deny(ruleID, ReasonRunnerNotAllowed, "repository %s requires a GitHub-hosted runner, got %q", repository, runnerEnvironment)
+}
+
+// pinned reports whether a workflow ref's path, without the @ref, is one of
+// the pinned workflows
+func pinned(pins map[string]bool, workflowRef string) bool {
+	if workflowRef == "" {
+		return false
+	}
+	path, _, _ := strings.Cut(workflowRef, "@")
+	return pins[path]
+}
+
+// isTag reports whether ref is a tag. refType is the token's ref_type claim;
+// when present it must agree with the ref.
+func isTag(ref, refType string) bool {
+	return strings.HasPrefix(ref, "refs/tags/") && (refType == "" || refType == "tag")
+}
+
+// refTypeOf returns the token's ref_type, or without one the type its ref's
+// prefix implies; empty when neither tells
+func refTypeOf(ref, refType string) string {
+	switch {
+	case refType != "":
+		return refType
+	case strings.HasPrefix(ref, "refs/heads/"):
+		return "branch"
+	case strings.HasPrefix(ref, "refs/tags/"):
+		return "tag"
+	}
+	return ""
 }
 
-// ExtractBranch extracts the branch name from a ref
-func ExtractBranch(ref string) string {
+// matchRef reports whether ref matches any of patterns. A ref whose refType
+// contradicts it, such as a tag ref reported as a branch, never matches.
+func matchRef(patterns []string, ref, refType string) bool {
+	switch refType {
+	case "branch":
+		if !strings.HasPrefix(ref, "refs/heads/") {
+			return false
+		}
+	case "tag":
+		if !strings.HasPrefix(ref, "refs/tags/") {
+			return false
+		}
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, ref); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// listed reports whether list names the token's repository, as owner/name
+// or as id:<repository_id>, which survives renames
+func listed(list map[string]bool, claims *types.VerifiedClaims) bool {
+	return list[claims.Repository] || (claims.RepositoryID != "" && list["id:"+claims.RepositoryID])
+}
+
+// repositoryOwner returns the owner of the token's repository, preferring
+// the repository_owner claim to the repository's owner segment
+func repositoryOwner(claims *types.VerifiedClaims) string {
+	if claims.Owner != "" {
+		return claims.Owner
+	}
+	owner, _, _ := strings.Cut(claims.Repository, "/")
+	return owner
+}
+
+// workflowAllowed reports whether a workflow ref matches the allowlist,
+// either exactly or by its path without the @ref
+func (e *Enforcer) workflowAllowed(workflowRef string) bool {
+	if workflowRef == "" {
+		return false
+	}
+	path, _, _ := strings.Cut(workflowRef, "@")
+	return e.workflows[workflowRef] || e.workflows[path]
+}
+
+// IsAllowedBranch checks if the given ref is one of the allowed branches.
+// refType is the token's ref_type claim; anything other than "branch" or
+// empty (unknown) is never an allowed branch.
+func (e *Enforcer) IsAllowedBranch(ref, refType string) bool {
+	return matchBranch(e.allowedBranches, ref, refType)
+}
+
+// IsDefaultBranch checks if the given ref is an allowed branch.
+//
+// Deprecated: Use IsAllowedBranch.
+func (e *Enforcer) IsDefaultBranch(ref, refType string) bool {
+	return e.IsAllowedBranch(ref, refType)
+}
+
+// matchBranch reports whether ref is refs/heads/ followed by one of branches
+func matchBranch(branches []string, ref, refType string) bool {
+	if refType != "" && refType != "branch" {
+		return false
+	}
+	branch, ok := strings.CutPrefix(ref, "refs/heads/")
+	return ok && slices.Contains(branches, branch)
+}
+
+// branchRefs lists branches as full refs for denial messages
+func branchRefs(branches []string) string {
+	refs := make([]string, len(branches))
+	for i, branch := range branches {
+		refs[i] = "refs/heads/" + branch
+	}
+	return strings.Join(refs, ", ")
+}
+
+// isBot reports whether actor is a GitHub App's bot user
+func isBot(actor string) bool {
+	return strings.HasSuffix(actor, "[bot]")
+}
+
+// ExtractBranch extracts the branch name from a ref. It returns an empty
+// string when refType says the ref is a tag.
+func ExtractBranch(ref, refType string) string {
+	if refType == "tag" {
+		return ""
+	}
 	if strings.HasPrefix(ref, "refs/heads/") {
 		return strings.TrimPrefix(ref, "refs/heads/")
 	}
@@ -2,6 +2,8 @@ package policy
 
 import (
 	"testing"
+
+	"github.com/robohub/auth-service/internal/types"
 )
 
 func TestEnforcer_Evaluate(t *testing.T) {
@@ -9,12 +11,40 @@ func TestEnforcer_Evaluate(t *testing.T) {
 		name              string
 		defaultBranchOnly bool
 		defaultBranch     string
+		refPatterns       []string
+		tagPatterns       []string
 		allowList         []string
 		denyList          []string
+		ownerAllowList    []string
+		ownerDenyList     []string
+		owner             string
+		requireSHA        bool
+		hostedRunnerRepos []string
+		runnerEnvironment string
+		enterprises       []string
+		enterprise        string
+		visibilities      []string
+		visibility        string
+		denyNoVisibility  bool
+		workflows         []string
+		workflowPins      map[string][]string
+		environments      map[string][]string
+		environment       string
+		events            EventRule
+		repoEvents        map[string]EventRule
+		denyMissingEvent  bool
+		eventName         string
+		headRef           string
+		denyForkPRs       bool
+		workflowRef       string
+		jobWorkflowRef    string
+		reusableWorkflow  bool
 		repository        string
 		ref               string
+		refType           string
+		sha               string
 		wantError         bool
-		errorContains     string
+		wantReason        Reason
 	}{
 		{
 			name:       "allowed repo and ref",
@@ -23,20 +53,20 @@ func TestEnforcer_Evaluate(t *testing.T) {
 			wantError:  false,
 		},
 		{
-			name:          "denied repo",
-			denyList:      []string{"evil/repo"},
-			repository:    "evil/repo",
-			ref:           "refs/heads/main",
-			wantError:     true,
-			errorContains: "denied by policy",
+			name:       "denied repo",
+			denyList:   []string{"evil/repo"},
+			repository: "evil/repo",
+			ref:        "refs/heads/main",
+			wantError:  true,
+			wantReason: ReasonRepoDenied,
 		},
 		{
-			name:          "not in allowlist",
-			allowList:     []string{"good/repo"},
-			repository:    "other/repo",
-			ref:           "refs/heads/main",
-			wantError:     true,
-			errorContains: "not in allowlist",
+			name:       "not in allowlist",
+			allowList:  []string{"good/repo"},
+			repository: "other/repo",
+			ref:        "refs/heads/main",
+			wantError:  true,
+			wantReason: ReasonNotInAllowlist,
 		},
 		{
 			name:       "in allowlist",
@@ -60,7 +90,26 @@ func TestEnforcer_Evaluate(t *testing.T) {
 			repository:        "owner/repo",
 			ref:               "refs/heads/develop",
 			wantError:         true,
-			errorContains:     "only default branch",
+			wantReason:        ReasonBranchNotAllowed,
+		},
+		{
+			name:              "default branch only - branch ref type",
+			defaultBranchOnly: true,
+			defaultBranch:     "main",
+			repository:        "owner/repo",
+			ref:               "refs/heads/main",
+			refType:           "branch",
+			wantError:         false,
+		},
+		{
+			name:              "default branch only - tag ref type",
+			defaultBranchOnly: true,
+			defaultBranch:     "main",
+			repository:        "owner/repo",
+			ref:               "refs/heads/main",
+			refType:           "tag",
+			wantError:         true,
+			wantReason:        ReasonBranchNotAllowed,
 		},
 		{
 			name:              "custom default branch",
@@ -77,91 +126,905 @@ func TestEnforcer_Evaluate(t *testing.T) {
 			repository:        "owner/repo",
 			ref:               "refs/heads/main",
 			wantError:         true,
-			errorContains:     "only default branch",
+			wantReason:        ReasonBranchNotAllowed,
+		},
+		{
+			name:        "ref patterns - default branch",
+			refPatterns: []string{"refs/heads/main", "refs/heads/release/*"},
+			repository:  "owner/repo",
+			ref:         "refs/heads/main",
+			wantError:   false,
+		},
+		{
+			name:        "ref patterns - release branch",
+			refPatterns: []string{"refs/heads/main", "refs/heads/release/*"},
+			repository:  "owner/repo",
+			ref:         "refs/heads/release/1.2",
+			wantError:   false,
+		},
+		{
+			name:        "ref patterns - nested release branch",
+			refPatterns: []string{"refs/heads/main", "refs/heads/release/*"},
+			repository:  "owner/repo",
+			ref:         "refs/heads/release/1.2/hotfix",
+			wantError:   true,
+			wantReason:  ReasonRefNotAllowed,
+		},
+		{
+			name:        "ref patterns - feature branch",
+			refPatterns: []string{"refs/heads/main", "refs/heads/release/*"},
+			repository:  "owner/repo",
+			ref:         "refs/heads/feature/login",
+			wantError:   true,
+			wantReason:  ReasonRefNotAllowed,
+		},
+		{
+			name:        "ref patterns - tag",
+			refPatterns: []string{"refs/heads/main", "refs/heads/release/*"},
+			repository:  "owner/repo",
+			ref:         "refs/tags/release/1.2",
+			refType:     "tag",
+			wantError:   true,
+			wantReason:  ReasonRefNotAllowed,
+		},
+		{
+			name:        "ref patterns - pull request",
+			refPatterns: []string{"refs/heads/main", "refs/heads/release/*"},
+			repository:  "owner/repo",
+			ref:         "refs/pull/42/merge",
+			wantError:   true,
+			wantReason:  ReasonRefNotAllowed,
+		},
+		{
+			name:              "ref patterns replace default branch only",
+			defaultBranchOnly: true,
+			defaultBranch:     "main",
+			refPatterns:       []string{"refs/heads/main", "refs/heads/release/*"},
+			repository:        "owner/repo",
+			ref:               "refs/heads/release/2.0",
+			wantError:         false,
+		},
+		{
+			name:              "tag patterns with default branch only - release tag",
+			defaultBranchOnly: true,
+			defaultBranch:     "main",
+			tagPatterns:       []string{"refs/tags/v*"},
+			repository:        "owner/repo",
+			ref:               "refs/tags/v1.2.0",
+			refType:           "tag",
+			wantError:         false,
+		},
+		{
+			name:              "tag patterns with default branch only - tag without ref_type",
+			defaultBranchOnly: true,
+			defaultBranch:     "main",
+			tagPatterns:       []string{"refs/tags/v*"},
+			repository:        "owner/repo",
+			ref:               "refs/tags/v1.2.0",
+			wantError:         false,
+		},
+		{
+			name:              "tag patterns with default branch only - default branch",
+			defaultBranchOnly: true,
+			defaultBranch:     "main",
+			tagPatterns:       []string{"refs/tags/v*"},
+			repository:        "owner/repo",
+			ref:               "refs/heads/main",
+			refType:           "branch",
+			wantError:         false,
+		},
+		{
+			name:              "tag patterns with default branch only - other branch",
+			defaultBranchOnly: true,
+			defaultBranch:     "main",
+			tagPatterns:       []string{"refs/tags/v*"},
+			repository:        "owner/repo",
+			ref:               "refs/heads/develop",
+			refType:           "branch",
+			wantError:         true,
+			wantReason:        ReasonBranchNotAllowed,
+		},
+		{
+			name:              "tag patterns with default branch only - other tag",
+			defaultBranchOnly: true,
+			defaultBranch:     "main",
+			tagPatterns:       []string{"refs/tags/v*"},
+			repository:        "owner/repo",
+			ref:               "refs/tags/nightly",
+			refType:           "tag",
+			wantError:         true,
+			wantReason:        ReasonTagNotAllowed,
+		},
+		{
+			name:              "tag patterns - branch named like a tag",
+			defaultBranchOnly: true,
+			defaultBranch:     "main",
+			tagPatterns:       []string{"refs/tags/v*", "refs/*/tags/v*"},
+			repository:        "owner/repo",
+			ref:               "refs/heads/tags/v1",
+			refType:           "branch",
+			wantError:         true,
+			wantReason:        ReasonBranchNotAllowed,
 		},
 		{
-			name:          "denylist takes precedence over allowlist",
-			allowList:     []string{"conflicted/repo"},
-			denyList:      []string{"conflicted/repo"},
-			repository:    "conflicted/repo",
+			name:              "tag patterns - tag ref reported as a branch",
+			defaultBranchOnly: true,
+			defaultBranch:     "main",
+			tagPatterns:       []string{"refs/tags/v*"},
+			repository:        "owner/repo",
+			ref:               "refs/tags/v1",
+			refType:           "branch",
+			wantError:         true,
+			wantReason:        ReasonBranchNotAllowed,
+		},
+		{
+			name:        "tag patterns without default branch only - any branch",
+			tagPatterns: []string{"refs/tags/v*"},
+			repository:  "owner/repo",
+			ref:         "refs/heads/feature/login",
+			refType:     "branch",
+			wantError:   false,
+		},
+		{
+			name:        "ref patterns - tags instead of default branch",
+			refPatterns: []string{"refs/tags/v*"},
+			repository:  "owner/repo",
+			ref:         "refs/tags/v2.0.0",
+			refType:     "tag",
+			wantError:   false,
+		},
+		{
+			name:        "ref patterns - tags instead of default branch, branch named like a tag",
+			refPatterns: []string{"refs/*/v*"},
+			repository:  "owner/repo",
+			ref:         "refs/tags/v2.0.0",
+			refType:     "branch",
+			wantError:   true,
+			wantReason:  ReasonRefNotAllowed,
+		},
+		{
+			name:       "denylist takes precedence over allowlist",
+			allowList:  []string{"conflicted/repo"},
+			denyList:   []string{"conflicted/repo"},
+			repository: "conflicted/repo",
+			ref:        "refs/heads/main",
+			wantError:  true,
+			wantReason: ReasonRepoDenied,
+		},
+		{
+			name:           "owner allowlist - allowed owner",
+			ownerAllowList: []string{"myorg"},
+			repository:     "myorg/any-repo",
+			ref:            "refs/heads/main",
+			wantError:      false,
+		},
+		{
+			name:           "owner allowlist - other owner",
+			ownerAllowList: []string{"myorg"},
+			repository:     "other/repo",
+			ref:            "refs/heads/main",
+			wantError:      true,
+			wantReason:     ReasonNotInAllowlist,
+		},
+		{
+			name:           "owner allowlist - repository_owner claim preferred",
+			ownerAllowList: []string{"myorg"},
+			owner:          "other",
+			repository:     "myorg/repo",
+			ref:            "refs/heads/main",
+			wantError:      true,
+			wantReason:     ReasonNotInAllowlist,
+		},
+		{
+			name:           "owner allowlist and repo allowlist - repo allowed",
+			allowList:      []string{"partner/repo"},
+			ownerAllowList: []string{"myorg"},
+			repository:     "partner/repo",
+			ref:            "refs/heads/main",
+			wantError:      false,
+		},
+		{
+			name:           "owner allowlist and repo allowlist - owner allowed",
+			allowList:      []string{"partner/repo"},
+			ownerAllowList: []string{"myorg"},
+			repository:     "myorg/repo",
+			ref:            "refs/heads/main",
+			wantError:      false,
+		},
+		{
+			name:          "owner denylist",
+			ownerDenyList: []string{"evil"},
+			repository:    "evil/repo",
+			ref:           "refs/heads/main",
+			wantError:     true,
+			wantReason:    ReasonOwnerDenied,
+		},
+		{
+			name:          "owner denylist - repository_owner claim",
+			ownerDenyList: []string{"evil"},
+			owner:         "evil",
+			repository:    "evil/repo",
+			ref:           "refs/heads/main",
+			wantError:     true,
+			wantReason:    ReasonOwnerDenied,
+		},
+		{
+			name:          "owner denylist takes precedence over repo allowlist",
+			allowList:     []string{"evil/repo"},
+			ownerDenyList: []string{"evil"},
+			repository:    "evil/repo",
 			ref:           "refs/heads/main",
 			wantError:     true,
-			errorContains: "denied by policy",
+			wantReason:    ReasonOwnerDenied,
+		},
+		{
+			name:           "repo denylist takes precedence over owner allowlist",
+			denyList:       []string{"myorg/legacy"},
+			ownerAllowList: []string{"myorg"},
+			repository:     "myorg/legacy",
+			ref:            "refs/heads/main",
+			wantError:      true,
+			wantReason:     ReasonRepoDenied,
+		},
+		{
+			name:          "repo denylist takes precedence over owner denylist",
+			denyList:      []string{"evil/repo"},
+			ownerDenyList: []string{"evil"},
+			repository:    "evil/repo",
+			ref:           "refs/heads/main",
+			wantError:     true,
+			wantReason:    ReasonRepoDenied,
+		},
+		{
+			name:       "sha not required",
+			repository: "owner/repo",
+			ref:        "refs/heads/main",
+			wantError:  false,
+		},
+		{
+			name:       "sha required - present",
+			requireSHA: true,
+			repository: "owner/repo",
+			ref:        "refs/heads/main",
+			sha:        "ffac537e6cbbf934b08745a378932722df287a53",
+			wantError:  false,
+		},
+		{
+			name:       "sha required - missing",
+			requireSHA: true,
+			repository: "owner/repo",
+			ref:        "refs/heads/main",
+			wantError:  true,
+			wantReason: ReasonSHARequired,
+		},
+		{
+			name:              "hosted runner required - github-hosted",
+			hostedRunnerRepos: []string{"owner/repo"},
+			repository:        "owner/repo",
+			ref:               "refs/heads/main",
+			runnerEnvironment: "github-hosted",
+			wantError:         false,
+		},
+		{
+			name:              "hosted runner required - self-hosted",
+			hostedRunnerRepos: []string{"owner/repo"},
+			repository:        "owner/repo",
+			ref:               "refs/heads/main",
+			runnerEnvironment: "self-hosted",
+			wantError:         true,
+			wantReason:        ReasonRunnerNotAllowed,
+		},
+		{
+			name:              "hosted runner required - missing",
+			hostedRunnerRepos: []string{"owner/repo"},
+			repository:        "owner/repo",
+			ref:               "refs/heads/main",
+			wantError:         true,
+			wantReason:        ReasonRunnerNotAllowed,
+		},
+		{
+			name:              "hosted runner required for other repo",
+			hostedRunnerRepos: []string{"release/repo"},
+			repository:        "owner/repo",
+			ref:               "refs/heads/main",
+			runnerEnvironment: "self-hosted",
+			wantError:         false,
+		},
+		{
+			name:       "denied event",
+			events:     EventRule{Deny: []string{"pull_request", "schedule"}},
+			eventName:  "pull_request",
+			repository: "owner/repo",
+			ref:        "refs/pull/1/merge",
+			wantError:  true,
+			wantReason: ReasonEventNotAllowed,
+		},
+		{
+			name:       "event not denied",
+			events:     EventRule{Deny: []string{"pull_request", "schedule"}},
+			eventName:  "push",
+			repository: "owner/repo",
+			ref:        "refs/heads/main",
+			wantError:  false,
+		},
+		{
+			name:       "allowed event",
+			events:     EventRule{Allow: []string{"push", "workflow_dispatch"}},
+			eventName:  "workflow_dispatch",
+			repository: "owner/repo",
+			ref:        "refs/heads/main",
+			wantError:  false,
+		},
+		{
+			name:       "event not in allowed events",
+			events:     EventRule{Allow: []string{"push", "workflow_dispatch"}},
+			eventName:  "schedule",
+			repository: "owner/repo",
+			ref:        "refs/heads/main",
+			wantError:  true,
+			wantReason: ReasonEventNotAllowed,
+		},
+		{
+			name:       "deny takes precedence over allow",
+			events:     EventRule{Allow: []string{"push"}, Deny: []string{"push"}},
+			eventName:  "push",
+			repository: "owner/repo",
+			ref:        "refs/heads/main",
+			wantError:  true,
+			wantReason: ReasonEventNotAllowed,
+		},
+		{
+			name:       "missing event name skipped",
+			events:     EventRule{Allow: []string{"push"}},
+			repository: "owner/repo",
+			ref:        "refs/heads/main",
+			wantError:  false,
+		},
+		{
+			name:             "missing event name denied",
+			events:           EventRule{Allow: []string{"push"}},
+			denyMissingEvent: true,
+			repository:       "owner/repo",
+			ref:              "refs/heads/main",
+			wantError:        true,
+			wantReason:       ReasonEventNotAllowed,
+		},
+		{
+			name:             "missing event name without event rules",
+			denyMissingEvent: true,
+			repository:       "owner/repo",
+			ref:              "refs/heads/main",
+			wantError:        false,
+		},
+		{
+			name:       "repository event rule replaces global rule",
+			events:     EventRule{Deny: []string{"pull_request"}},
+			repoEvents: map[string]EventRule{"owner/preview": {Allow: []string{"pull_request"}}},
+			eventName:  "pull_request",
+			repository: "owner/preview",
+			ref:        "refs/pull/1/merge",
+			wantError:  false,
+		},
+		{
+			name:       "repository event rule",
+			repoEvents: map[string]EventRule{"owner/deploy": {Allow: []string{"workflow_dispatch"}}},
+			eventName:  "push",
+			repository: "owner/deploy",
+			ref:        "refs/heads/main",
+			wantError:  true,
+			wantReason: ReasonEventNotAllowed,
+		},
+		{
+			name:        "fork PRs denied - same-repository pull request",
+			denyForkPRs: true,
+			eventName:   "pull_request",
+			headRef:     "feature/login",
+			repository:  "owner/repo",
+			ref:         "refs/pull/42/merge",
+			wantError:   false,
+		},
+		{
+			name:        "fork PRs denied - pull_request_target",
+			denyForkPRs: true,
+			eventName:   "pull_request_target",
+			headRef:     "patch-1",
+			repository:  "owner/repo",
+			ref:         "refs/heads/main",
+			wantError:   true,
+			wantReason:  ReasonForkPRDenied,
+		},
+		{
+			name:        "fork PRs denied - push",
+			denyForkPRs: true,
+			eventName:   "push",
+			repository:  "owner/repo",
+			ref:         "refs/heads/main",
+			wantError:   false,
+		},
+		{
+			name:       "fork PRs allowed - pull_request_target",
+			eventName:  "pull_request_target",
+			headRef:    "patch-1",
+			repository: "owner/repo",
+			ref:        "refs/heads/main",
+			wantError:  false,
+		},
+		{
+			name:        "fork PRs denied - pull requests denied by event rule",
+			denyForkPRs: true,
+			events:      EventRule{Deny: []string{"pull_request"}},
+			eventName:   "pull_request",
+			headRef:     "feature/login",
+			repository:  "owner/repo",
+			ref:         "refs/pull/42/merge",
+			wantError:   true,
+			wantReason:  ReasonEventNotAllowed,
+		},
+		{
+			name:         "required environment - present",
+			environments: map[string][]string{"owner/deploy": {"production"}},
+			environment:  "production",
+			repository:   "owner/deploy",
+			ref:          "refs/heads/main",
+			wantError:    false,
+		},
+		{
+			name:         "required environment - missing",
+			environments: map[string][]string{"owner/deploy": {"production"}},
+			repository:   "owner/deploy",
+			ref:          "refs/heads/main",
+			wantError:    true,
+			wantReason:   ReasonEnvironmentNotAllowed,
+		},
+		{
+			name:         "required environment - other environment",
+			environments: map[string][]string{"owner/deploy": {"production"}},
+			environment:  "staging",
+			repository:   "owner/deploy",
+			ref:          "refs/heads/main",
+			wantError:    true,
+			wantReason:   ReasonEnvironmentNotAllowed,
+		},
+		{
+			name:         "required environment - case sensitive",
+			environments: map[string][]string{"owner/deploy": {"production"}},
+			environment:  "Production",
+			repository:   "owner/deploy",
+			ref:          "refs/heads/main",
+			wantError:    true,
+			wantReason:   ReasonEnvironmentNotAllowed,
+		},
+		{
+			name:         "required environment - one of several",
+			environments: map[string][]string{"owner/robot-*": {"production", "canary"}},
+			environment:  "canary",
+			repository:   "owner/robot-arm",
+			ref:          "refs/heads/main",
+			wantError:    false,
+		},
+		{
+			name:         "required environment - pattern match",
+			environments: map[string][]string{"owner/robot-*": {"production", "canary"}},
+			repository:   "owner/robot-arm",
+			ref:          "refs/heads/main",
+			wantError:    true,
+			wantReason:   ReasonEnvironmentNotAllowed,
+		},
+		{
+			name:         "required environment - other repository",
+			environments: map[string][]string{"owner/deploy": {"production"}},
+			repository:   "owner/repo",
+			ref:          "refs/heads/main",
+			wantError:    false,
+		},
+		{
+			name:       "no enterprise allowlist - personal account",
+			repository: "someone/repo",
+			ref:        "refs/heads/main",
+			wantError:  false,
+		},
+		{
+			name:       "no enterprise allowlist - any enterprise",
+			enterprise: "other-corp",
+			repository: "owner/repo",
+			ref:        "refs/heads/main",
+			wantError:  false,
+		},
+		{
+			name:        "enterprise allowlist - allowed",
+			enterprises: []string{"robohub-corp"},
+			enterprise:  "robohub-corp",
+			repository:  "owner/repo",
+			ref:         "refs/heads/main",
+			wantError:   false,
+		},
+		{
+			name:        "enterprise allowlist - other enterprise",
+			enterprises: []string{"robohub-corp"},
+			enterprise:  "other-corp",
+			repository:  "owner/repo",
+			ref:         "refs/heads/main",
+			wantError:   true,
+			wantReason:  ReasonEnterpriseNotAllowed,
+		},
+		{
+			name:        "enterprise allowlist - personal account",
+			enterprises: []string{"robohub-corp"},
+			repository:  "someone/repo",
+			ref:         "refs/heads/main",
+			wantError:   true,
+			wantReason:  ReasonEnterpriseNotAllowed,
+		},
+		{
+			name:       "no visibility restriction - public repo",
+			visibility: "public",
+			repository: "owner/repo",
+			ref:        "refs/heads/main",
+			wantError:  false,
+		},
+		{
+			name:         "allowed visibilities - internal repo",
+			visibilities: []string{"private", "internal"},
+			visibility:   "internal",
+			repository:   "owner/repo",
+			ref:          "refs/heads/main",
+			wantError:    false,
+		},
+		{
+			name:         "allowed visibilities - public repo",
+			visibilities: []string{"private", "internal"},
+			visibility:   "public",
+			allowList:    []string{"owner/repo"},
+			repository:   "owner/repo",
+			ref:          "refs/heads/main",
+			wantError:    true,
+			wantReason:   ReasonVisibilityNotAllowed,
+		},
+		{
+			name:         "allowed visibilities - missing claim skipped",
+			visibilities: []string{"private", "internal"},
+			repository:   "owner/repo",
+			ref:          "refs/heads/main",
+			wantError:    false,
+		},
+		{
+			name:             "allowed visibilities - missing claim denied",
+			visibilities:     []string{"private", "internal"},
+			denyNoVisibility: true,
+			repository:       "owner/repo",
+			ref:              "refs/heads/main",
+			wantError:        true,
+			wantReason:       ReasonVisibilityNotAllowed,
+		},
+		{
+			name:        "workflow allowlist - caller workflow at any ref",
+			workflows:   []string{"owner/repo/.github/workflows/release.yml"},
+			workflowRef: "owner/repo/.github/workflows/release.yml@refs/heads/main",
+			repository:  "owner/repo",
+			ref:         "refs/heads/main",
+			wantError:   false,
+		},
+		{
+			name:             "workflow allowlist - reusable workflow at pinned ref",
+			workflows:        []string{"shared/workflows/.github/workflows/deploy.yml@refs/tags/v1"},
+			workflowRef:      "owner/repo/.github/workflows/ci.yml@refs/heads/main",
+			jobWorkflowRef:   "shared/workflows/.github/workflows/deploy.yml@refs/tags/v1",
+			reusableWorkflow: true,
+			repository:       "owner/repo",
+			ref:              "refs/heads/main",
+			wantError:        false,
+		},
+		{
+			name:             "workflow allowlist - reusable workflow at other ref",
+			workflows:        []string{"shared/workflows/.github/workflows/deploy.yml@refs/tags/v1"},
+			workflowRef:      "owner/repo/.github/workflows/ci.yml@refs/heads/main",
+			jobWorkflowRef:   "shared/workflows/.github/workflows/deploy.yml@refs/heads/main",
+			reusableWorkflow: true,
+			repository:       "owner/repo",
+			ref:              "refs/heads/main",
+			wantError:        true,
+			wantReason:       ReasonWorkflowNotAllowed,
+		},
+		{
+			name:             "workflow allowlist - caller allowed but reusable workflow not",
+			workflows:        []string{"owner/repo/.github/workflows/ci.yml"},
+			workflowRef:      "owner/repo/.github/workflows/ci.yml@refs/heads/main",
+			jobWorkflowRef:   "shared/workflows/.github/workflows/deploy.yml@refs/heads/main",
+			reusableWorkflow: true,
+			repository:       "owner/repo",
+			ref:              "refs/heads/main",
+			wantError:        true,
+			wantReason:       ReasonWorkflowNotAllowed,
+		},
+		{
+			name:           "workflow allowlist - same-repository job workflow",
+			workflows:      []string{"owner/repo/.github/workflows/ci.yml"},
+			workflowRef:    "owner/repo/.github/workflows/ci.yml@refs/heads/main",
+			jobWorkflowRef: "owner/repo/.github/workflows/build.yml@refs/heads/main",
+			repository:     "owner/repo",
+			ref:            "refs/heads/main",
+			wantError:      false,
+		},
+		{
+			name:         "workflow pins - pinned workflow",
+			workflowPins: map[string][]string{"owner/repo": {"owner/repo/.github/workflows/release.yml"}},
+			workflowRef:  "owner/repo/.github/workflows/release.yml@refs/heads/main",
+			repository:   "owner/repo",
+			ref:          "refs/heads/main",
+			wantError:    false,
+		},
+		{
+			name:         "workflow pins - pin with ref matches any ref",
+			workflowPins: map[string][]string{"owner/repo": {"owner/repo/.github/workflows/release.yml@refs/heads/main"}},
+			workflowRef:  "owner/repo/.github/workflows/release.yml@refs/heads/feature",
+			repository:   "owner/repo",
+			ref:          "refs/heads/feature",
+			wantError:    false,
+		},
+		{
+			name:         "workflow pins - new workflow",
+			workflowPins: map[string][]string{"owner/repo": {"owner/repo/.github/workflows/release.yml"}},
+			workflowRef:  "owner/repo/.github/workflows/exfiltrate.yml@refs/heads/main",
+			repository:   "owner/repo",
+			ref:          "refs/heads/main",
+			wantError:    true,
+			wantReason:   ReasonWorkflowNotPinned,
+		},
+		{
+			name:         "workflow pins - unpinned repository",
+			workflowPins: map[string][]string{"owner/repo": {"owner/repo/.github/workflows/release.yml"}},
+			workflowRef:  "owner/other/.github/workflows/anything.yml@refs/heads/main",
+			repository:   "owner/other",
+			ref:          "refs/heads/main",
+			wantError:    false,
+		},
+		{
+			name:             "workflow pins - pinned reusable workflow",
+			workflowPins:     map[string][]string{"owner/repo": {"shared/workflows/.github/workflows/deploy.yml"}},
+			workflowRef:      "owner/repo/.github/workflows/ci.yml@refs/heads/main",
+			jobWorkflowRef:   "shared/workflows/.github/workflows/deploy.yml@refs/tags/v1",
+			reusableWorkflow: true,
+			repository:       "owner/repo",
+			ref:              "refs/heads/main",
+			wantError:        false,
+		},
+		{
+			name:             "workflow pins - caller pinned but reusable workflow not",
+			workflowPins:     map[string][]string{"owner/repo": {"owner/repo/.github/workflows/ci.yml"}},
+			workflowRef:      "owner/repo/.github/workflows/ci.yml@refs/heads/main",
+			jobWorkflowRef:   "evil/workflows/.github/workflows/deploy.yml@refs/heads/main",
+			reusableWorkflow: true,
+			repository:       "owner/repo",
+			ref:              "refs/heads/main",
+			wantError:        true,
+			wantReason:       ReasonWorkflowNotPinned,
+		},
+		{
+			name:        "workflow allowlist - no match",
+			workflows:   []string{"owner/repo/.github/workflows/release.yml"},
+			workflowRef: "owner/repo/.github/workflows/ci.yml@refs/heads/main",
+			repository:  "owner/repo",
+			ref:         "refs/heads/main",
+			wantError:   true,
+			wantReason:  ReasonWorkflowNotAllowed,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			e := NewEnforcer(tt.defaultBranchOnly, tt.defaultBranch, tt.allowList, tt.denyList)
-			err := e.Evaluate(tt.repository, tt.ref)
+			e := NewEnforcer(tt.defaultBranchOnly, tt.defaultBranch, tt.allowList, tt.denyList, WithRequireSHA(tt.requireSHA), WithRefPatterns(tt.refPatterns), WithTagPatterns(tt.tagPatterns), WithOwnerAllowlist(tt.ownerAllowList), WithOwnerDenylist(tt.ownerDenyList), WithHostedRunnersOnly(tt.hostedRunnerRepos), WithEnterpriseAllowlist(tt.enterprises), WithAllowedVisibilities(tt.visibilities), WithDenyMissingVisibility(tt.denyNoVisibility), WithWorkflowAllowlist(tt.workflows), WithWorkflowPins(tt.workflowPins), WithRequiredEnvironments(tt.environments), WithEventRule(tt.events), WithRepoEventRules(tt.repoEvents), WithDenyMissingEventName(tt.denyMissingEvent), WithDenyForkPRs(tt.denyForkPRs))
+			d := e.Check(&types.VerifiedClaims{
+				Repository:        tt.repository,
+				Owner:             tt.owner,
+				Ref:               tt.ref,
+				RefType:           tt.refType,
+				SHA:               tt.sha,
+				RunnerEnvironment: tt.runnerEnvironment,
+				Enterprise:        tt.enterprise,
+				Visibility:        tt.visibility,
+				Environment:       tt.environment,
+				EventName:         tt.eventName,
+				HeadRef:           tt.headRef,
+				Workflow:          tt.workflowRef,
+				WorkflowRef:       tt.workflowRef,
+				JobWorkflowRef:    tt.jobWorkflowRef,
+				ReusableWorkflow:  tt.reusableWorkflow,
+			})
 
-			if (err != nil) != tt.wantError {
-				t.Errorf("expected error=%v, got error=%v", tt.wantError, err)
+			if d.Allowed == tt.wantError {
+				t.Errorf("expected denied=%v, got %+v", tt.wantError, d)
 			}
 
-			if tt.wantError && tt.errorContains != "" {
-				if err == nil || !contains(err.Error(), tt.errorContains) {
-					t.Errorf("expected error to contain %q, got %v", tt.errorContains, err)
+			wantReason := tt.wantReason
+			if !tt.wantError {
+				wantReason = ReasonAllowed
+			}
+			if d.Reason != wantReason {
+				t.Errorf("expected reason %s, got %s (%s)", wantReason, d.Reason, d.Message)
+			}
+		})
+	}
+}
+
+func TestEnforcer_CheckRuleID(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		claims     types.VerifiedClaims
+		wantRuleID string
+	}{
+		{"allowed", nil, types.VerifiedClaims{Repository: "owner/repo", Ref: "refs/heads/main"}, ""},
+		{"repo denylist", nil, types.VerifiedClaims{Repository: "evil/repo", Ref: "refs/heads/main"}, "repo_denylist"},
+		{"owner denylist", []Option{WithOwnerDenylist([]string{"owner"})}, types.VerifiedClaims{Repository: "owner/repo"}, "owner_denylist"},
+		{"default branch", nil, types.VerifiedClaims{Repository: "owner/repo", Ref: "refs/heads/feature"}, "default_branch"},
+		{"ref patterns", []Option{WithRefPatterns([]string{"refs/heads/main"})}, types.VerifiedClaims{Repository: "owner/repo", Ref: "refs/heads/feature"}, "ref_patterns"},
+		{"global event rule", []Option{WithEventRule(EventRule{Deny: []string{"schedule"}})}, types.VerifiedClaims{Repository: "owner/repo", Ref: "refs/heads/main", EventName: "schedule"}, "event_rule"},
+		{
+			"repository event rule",
+			[]Option{WithRepoEventRules(map[string]EventRule{"owner/repo": {Allow: []string{"push"}}})},
+			types.VerifiedClaims{Repository: "owner/repo", Ref: "refs/heads/main", EventName: "schedule"},
+			"event_rule:owner/repo",
+		},
+		{
+			"required environments",
+			[]Option{WithRequiredEnvironments(map[string][]string{"owner/*": {"production"}})},
+			types.VerifiedClaims{Repository: "owner/repo", Ref: "refs/heads/main"},
+			"required_environments:owner/*",
+		},
+		{"visibility", []Option{WithAllowedVisibilities([]string{"private"})}, types.VerifiedClaims{Repository: "owner/repo", Ref: "refs/heads/main", Visibility: "public"}, "visibility"},
+		{"require SHA", []Option{WithRequireSHA(true)}, types.VerifiedClaims{Repository: "owner/repo", Ref: "refs/heads/main"}, "require_sha"},
+		{"deny bots", []Option{WithDenyBots(true)}, types.VerifiedClaims{Repository: "owner/repo", Ref: "refs/heads/main", Actor: "dependabot[bot]"}, "deny_bots"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEnforcer(true, "main", nil, []string{"evil/repo"}, tt.opts...)
+			if d := e.Check(&tt.claims); d.RuleID != tt.wantRuleID {
+				t.Errorf("expected rule %q, got %+v", tt.wantRuleID, d)
+			}
+		})
+	}
+}
+
+func TestEnforcer_HostedRunners(t *testing.T) {
+	f, err := LoadFile(writePolicyFile(t, `{
+		"rules": [
+			{"repository": "myorg/docs", "hosted_runners_only": false},
+			{"repository": "myorg/release-*", "hosted_runners_only": true}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("failed to load policy file: %v", err)
+	}
+
+	tests := []struct {
+		name              string
+		opts              []Option
+		repository        string
+		runnerEnvironment string
+		wantRuleID        string
+	}{
+		{"not required", nil, "myorg/robot", "self-hosted", ""},
+		{"global github-hosted", []Option{WithRequireHostedRunners(true)}, "myorg/robot", "github-hosted", ""},
+		{"global self-hosted", []Option{WithRequireHostedRunners(true)}, "myorg/robot", "self-hosted", "require_hosted_runners"},
+		{"global missing claim", []Option{WithRequireHostedRunners(true)}, "myorg/robot", "", "require_hosted_runners"},
+		{"global missing claim tolerated", []Option{WithRequireHostedRunners(true), WithDenyMissingRunnerEnvironment(false)}, "myorg/robot", "", ""},
+		{"global self-hosted despite tolerance", []Option{WithRequireHostedRunners(true), WithDenyMissingRunnerEnvironment(false)}, "myorg/robot", "self-hosted", "require_hosted_runners"},
+		{"repository list self-hosted", []Option{WithHostedRunnersOnly([]string{"myorg/robot"})}, "myorg/robot", "self-hosted", "hosted_runner_repos"},
+		{"repository list missing claim tolerated", []Option{WithHostedRunnersOnly([]string{"myorg/robot"}), WithDenyMissingRunnerEnvironment(false)}, "myorg/robot", "", ""},
+		{"file rule requires", []Option{WithFile(f)}, "myorg/release-tools", "self-hosted", "file:myorg/release-*"},
+		{"file rule github-hosted", []Option{WithFile(f)}, "myorg/release-tools", "github-hosted", ""},
+		{"file rule exempts", []Option{WithRequireHostedRunners(true), WithHostedRunnersOnly([]string{"myorg/docs"}), WithFile(f)}, "myorg/docs", "self-hosted", ""},
+		{"file rule missing claim", []Option{WithFile(f)}, "myorg/release-tools", "", "file:myorg/release-*"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEnforcer(false, "main", nil, nil, tt.opts...)
+			d := e.Check(&types.VerifiedClaims{Repository: tt.repository, Ref: "refs/heads/main", RunnerEnvironment: tt.runnerEnvironment})
+			if tt.wantRuleID == "" {
+				if !d.Allowed {
+					t.Errorf("unexpected denial: %+v", d)
 				}
+				return
+			}
+			if d.Allowed || d.Reason != ReasonRunnerNotAllowed || d.RuleID != tt.wantRuleID {
+				t.Errorf("expected runner denial by %s, got %+v", tt.wantRuleID, d)
 			}
 		})
 	}
 }
 
-func TestEnforcer_IsDefaultBranch(t *testing.T) {
+func TestEnforcer_IsAllowedBranch(t *testing.T) {
 	tests := []struct {
 		name          string
 		defaultBranch string
+		branches      []string
 		ref           string
+		refType       string
 		want          bool
 	}{
-		{"main is default", "main", "refs/heads/main", true},
-		{"develop is not default", "main", "refs/heads/develop", false},
-		{"custom default branch", "develop", "refs/heads/develop", true},
-		{"tag ref", "main", "refs/tags/v1.0.0", false},
+		{"main is default", "main", nil, "refs/heads/main", "", true},
+		{"develop is not default", "main", nil, "refs/heads/develop", "", false},
+		{"custom default branch", "develop", nil, "refs/heads/develop", "", true},
+		{"tag ref", "main", nil, "refs/tags/v1.0.0", "", false},
+		{"branch ref type", "main", nil, "refs/heads/main", "branch", true},
+		{"tag ref type", "main", nil, "refs/heads/main", "tag", false},
+		{"first of several", "main", []string{"main", "master", "stable"}, "refs/heads/main", "", true},
+		{"last of several", "main", []string{"main", "master", "stable"}, "refs/heads/stable", "branch", true},
+		{"not listed", "main", []string{"master", "stable"}, "refs/heads/main", "", false},
+		{"branch name without prefix", "main", []string{"main", "master"}, "master", "", false},
+		{"tag named like a branch", "main", []string{"main", "master"}, "refs/tags/master", "", false},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			e := NewEnforcer(false, tt.defaultBranch, nil, nil)
-			if got := e.IsDefaultBranch(tt.ref); got != tt.want {
+			e := NewEnforcer(false, tt.defaultBranch, nil, nil, WithAllowedBranches(tt.branches))
+			if got := e.IsAllowedBranch(tt.ref, tt.refType); got != tt.want {
 				t.Errorf("expected %v, got %v", tt.want, got)
 			}
+			if got := e.IsDefaultBranch(tt.ref, tt.refType); got != tt.want {
+				t.Errorf("expected IsDefaultBranch %v, got %v", tt.want, got)
+			}
 		})
 	}
 }
 
 func TestExtractBranch(t *testing.T) {
 	tests := []struct {
-		name string
-		ref  string
-		want string
+		name    string
+		ref     string
+		refType string
+		want    string
 	}{
-		{"branch ref", "refs/heads/main", "main"},
-		{"branch ref develop", "refs/heads/develop", "develop"},
-		{"tag ref", "refs/tags/v1.0.0", "refs/tags/v1.0.0"},
-		{"plain string", "main", "main"},
+		{"branch ref", "refs/heads/main", "", "main"},
+		{"branch ref develop", "refs/heads/develop", "", "develop"},
+		{"tag ref", "refs/tags/v1.0.0", "", "refs/tags/v1.0.0"},
+		{"plain string", "main", "", "main"},
+		{"branch ref type", "refs/heads/main", "branch", "main"},
+		{"tag ref type", "refs/tags/v1.0.0", "tag", ""},
+		{"tag named like a branch", "refs/heads/main", "tag", ""},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := ExtractBranch(tt.ref); got != tt.want {
+			if got := ExtractBranch(tt.ref, tt.refType); got != tt.want {
 				t.Errorf("expected %q, got %q", tt.want, got)
 			}
 		})
 	}
 }
 
-func contains(s, substr string) bool {
-	return len(s) >= len(substr) && (s == substr || len(substr) == 0 ||
-		(len(s) > 0 && len(substr) > 0 && containsHelper(s, substr)))
-}
+func TestEnforcer_RepositoryIDLists(t *testing.T) {
+	// myorg/robot, id 123456, was renamed to myorg/robot-v2
+	tests := []struct {
+		name       string
+		allowList  []string
+		denyList   []string
+		wantReason Reason
+	}{
+		{"allowed by id after rename", []string{"id:123456"}, nil, ReasonAllowed},
+		{"old name no longer allowed", []string{"myorg/robot"}, nil, ReasonNotInAllowlist},
+		{"denied by id after rename", nil, []string{"id:123456"}, ReasonRepoDenied},
+		{"old name no longer denied", nil, []string{"myorg/robot"}, ReasonAllowed},
+		{"id deny wins over name allow", []string{"myorg/robot-v2"}, []string{"id:123456"}, ReasonRepoDenied},
+		{"other id", []string{"id:654321"}, nil, ReasonNotInAllowlist},
+	}
 
-func containsHelper(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return true
-		}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEnforcer(false, "main", tt.allowList, tt.denyList)
+			d := e.Check(&types.VerifiedClaims{Repository: "myorg/robot-v2", RepositoryID: "123456", Ref: "refs/heads/main"})
+			if d.Reason != tt.wantReason {
+				t.Errorf("expected %s, got %+v", tt.wantReason, d)
+			}
+		})
 	}
-	return false
+
+	t.Run("tokens without an id only match names", func(t *testing.T) {
+		e := NewEnforcer(false, "main", []string{"id:123456"}, nil)
+		if d := e.Check(&types.VerifiedClaims{Repository: "myorg/robot-v2", Ref: "refs/heads/main"}); d.Reason != ReasonNotInAllowlist {
+			t.Errorf("expected %s, got %+v", ReasonNotInAllowlist, d)
+		}
+	})
 }
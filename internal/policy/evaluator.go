@@ -0,0 +1,27 @@
+package policy
+
+import (
+	"context"
+
+	"github.com/robohub/auth-service/internal/types"
+)
+
+// Evaluator decides whether verified tokens may be exchanged and what they
+// are granted. Enforcer is the built-in implementation; the HTTP server
+// accepts any other.
+type Evaluator interface {
+	// Decide returns the grant of an allowed token, a *DeniedError for a
+	// denied one, or an error for which IsUnavailable holds when no decision
+	// could be made
+	Decide(ctx context.Context, claims *types.VerifiedClaims) (Grant, error)
+
+	// CheckContext previews the decision for the claims without side
+	// effects, and GrantFor returns the grant of a token it allowed
+	CheckContext(ctx context.Context, claims *types.VerifiedClaims) Decision
+	GrantFor(claims *types.VerifiedClaims, d Decision) (Grant, error)
+
+	// Status reports the evaluator's policy version and decision counts
+	Status() Status
+}
+
+var _ Evaluator = (*Enforcer)(nil)
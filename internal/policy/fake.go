@@ -0,0 +1,44 @@
+package policy
+
+import (
+	"context"
+
+	"github.com/robohub/auth-service/internal/types"
+)
+
+// FakeEvaluator is a test implementation of Evaluator. Without the funcs it
+// allows every token with the minter's default scopes and lifetime.
+type FakeEvaluator struct {
+	DecideFunc func(ctx context.Context, claims *types.VerifiedClaims) (Grant, error)
+	CheckFunc  func(ctx context.Context, claims *types.VerifiedClaims) Decision
+	StatusFunc func() Status
+}
+
+// Decide implements the Evaluator interface
+func (f *FakeEvaluator) Decide(ctx context.Context, claims *types.VerifiedClaims) (Grant, error) {
+	if f.DecideFunc != nil {
+		return f.DecideFunc(ctx, claims)
+	}
+	return Grant{}, nil
+}
+
+// CheckContext implements the Evaluator interface
+func (f *FakeEvaluator) CheckContext(ctx context.Context, claims *types.VerifiedClaims) Decision {
+	if f.CheckFunc != nil {
+		return f.CheckFunc(ctx, claims)
+	}
+	return allowed
+}
+
+// GrantFor implements the Evaluator interface, granting the minter's defaults
+func (f *FakeEvaluator) GrantFor(claims *types.VerifiedClaims, d Decision) (Grant, error) {
+	return Grant{}, d.Err()
+}
+
+// Status implements the Evaluator interface
+func (f *FakeEvaluator) Status() Status {
+	if f.StatusFunc != nil {
+		return f.StatusFunc()
+	}
+	return Status{}
+}
@@ -0,0 +1,408 @@
+package policy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/robohub/auth-service/internal/types"
+)
+
+// File is a policy file: defaults for every repository plus rules for the
+// repositories matching their patterns. Its settings take precedence over the
+// Enforcer's options.
+type File struct {
+	// Subjects are checked before every other setting
+	Subjects SubjectRules `json:"subjects"`
+
+	// Version identifies the policy in decisions, audit events and minted
+	// tokens in place of Hash, such as a release tag or commit
+	Version string `json:"version,omitempty"`
+
+	// FreezeWindows deny the tokens they cover while active
+	FreezeWindows []FreezeWindow `json:"freeze_windows,omitempty"`
+
+	Defaults Rule   `json:"defaults"`
+	Rules    []Rule `json:"rules"`
+
+	// Hash is the hex SHA-256 of the file's contents, identifying the loaded
+	// policy version in logs and metrics
+	Hash string `json:"-"`
+}
+
+// Rule holds policy settings. In File.Defaults they apply to every
+// repository; in File.Rules they apply to the repositories matching
+// Repository and take precedence over the defaults. Empty fields are
+// inherited.
+type Rule struct {
+	// Repository is a glob, such as owner/* or owner/robot-*, matched against
+	// the token's repository, or id:<repository_id>, which matches the
+	// repository across renames. Only the first matching rule applies.
+	Repository string `json:"repository,omitempty"`
+
+	// Deny rejects every token for matching repositories
+	Deny bool `json:"deny,omitempty"`
+
+	// Branches replaces WithAllowedBranches and requires matching
+	// repositories' tokens to come from one of the branches, even when the
+	// default branch check is off. RefPatterns take precedence.
+	Branches []string `json:"branches,omitempty"`
+
+	// RefPatterns and TagPatterns replace WithRefPatterns and
+	// WithTagPatterns
+	RefPatterns []string `json:"ref_patterns,omitempty"`
+	TagPatterns []string `json:"tag_patterns,omitempty"`
+
+	// AllowedRefTypes replaces WithAllowedRefTypes
+	AllowedRefTypes []string `json:"allowed_ref_types,omitempty"`
+
+	// Workflows pins the workflow paths, without @ref, allowed to exchange
+	// tokens, as WithWorkflowPins does
+	Workflows []string `json:"workflows,omitempty"`
+
+	// HostedRunnersOnly requires tokens to come from GitHub-hosted runners
+	// when true, and exempts matching repositories from
+	// WithRequireHostedRunners and WithHostedRunnersOnly when false
+	HostedRunnersOnly *bool `json:"hosted_runners_only,omitempty"`
+
+	// DenyBots replaces WithDenyBots for matching repositories.
+	// WithAllowedBots still applies.
+	DenyBots *bool `json:"deny_bots,omitempty"`
+
+	// RequireSHA replaces WithRequireSHA for matching repositories
+	RequireSHA *bool `json:"require_sha,omitempty"`
+
+	// Environments are the GitHub environments tokens must come from
+	Environments []string `json:"environments,omitempty"`
+
+	// PullRequestBaseRefs are the branches, with or without refs/heads/,
+	// that pull_request and pull_request_target tokens' base_ref must name.
+	// Other events are not checked.
+	PullRequestBaseRefs []string `json:"pull_request_base_refs,omitempty"`
+
+	// MaxTokenAgeSeconds rejects OIDC tokens issued longer ago, measured
+	// from their iat. The verifier's maximum token age still applies, so
+	// repositories can only be given more time than it allows when it is off.
+	MaxTokenAgeSeconds int `json:"max_token_age_seconds,omitempty"`
+
+	// Scopes and TokenTTLSeconds override the minted token's defaults
+	Scopes          []string `json:"scopes,omitempty"`
+	TokenTTLSeconds int      `json:"token_ttl_seconds,omitempty"`
+
+	// ScopeGrants add scopes to tokens from matching jobs on top of the
+	// token's other scopes
+	ScopeGrants []ScopeGrant `json:"scope_grants,omitempty"`
+
+	// ReducedScopes replaces WithReducedScopes for matching repositories
+	ReducedScopes []string `json:"reduced_scopes,omitempty"`
+
+	// DryRun lets tokens the policy denies through, reporting the denial
+	// instead. In File.Defaults it applies to every repository.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Condition denies tokens for which it is false; see Condition for the
+	// syntax. It is compiled by Validate.
+	Condition string `json:"condition,omitempty"`
+	condition *Condition
+}
+
+// matches reports whether the rule's Repository names the token's repository
+func (r Rule) matches(claims *types.VerifiedClaims) bool {
+	if id, ok := strings.CutPrefix(r.Repository, "id:"); ok {
+		return claims.RepositoryID != "" && id == claims.RepositoryID
+	}
+	ok, _ := path.Match(r.Repository, claims.Repository)
+	return ok
+}
+
+// isRepositoryID reports whether id is a numeric repository ID
+func isRepositoryID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, c := range id {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ScopeGrant adds Scopes to tokens from jobs running in one of Environments
+// for one of EventNames. An empty list matches every value, but at least one
+// must be set.
+type ScopeGrant struct {
+	Environments []string `json:"environments,omitempty"`
+	EventNames   []string `json:"event_names,omitempty"`
+	Scopes       []string `json:"scopes"`
+}
+
+// matches reports whether the job the claims were issued to qualifies
+func (g ScopeGrant) matches(claims *types.VerifiedClaims) bool {
+	if len(g.Environments) > 0 && !slices.Contains(g.Environments, claims.Environment) {
+		return false
+	}
+	return len(g.EventNames) == 0 || slices.Contains(g.EventNames, claims.EventName)
+}
+
+// condition describes what the grant requires, for auditing
+func (g ScopeGrant) condition() string {
+	var parts []string
+	if len(g.Environments) > 0 {
+		parts = append(parts, "environment in ["+strings.Join(g.Environments, ", ")+"]")
+	}
+	if len(g.EventNames) > 0 {
+		parts = append(parts, "event_name in ["+strings.Join(g.EventNames, ", ")+"]")
+	}
+	return strings.Join(parts, " && ")
+}
+
+// Elevation is a scope a ScopeGrant added to a token, with the rule and
+// condition that granted it
+type Elevation struct {
+	Scope     string `json:"scope"`
+	RuleID    string `json:"rule_id"`
+	Condition string `json:"condition"`
+}
+
+// Grant is what a token exchange is granted beyond passing policy. Zero
+// fields leave the minter's defaults in place.
+type Grant struct {
+	Scopes []string
+	TTL    time.Duration
+
+	// Elevations are the Scopes added by scope grants
+	Elevations []Elevation
+
+	// Reduced is the branch denial that reduced Scopes to the reduced scopes
+	// instead, nil when the token got its full scopes. Withheld are the
+	// scopes it lost.
+	Reduced  error
+	Withheld []string
+
+	// PolicyVersion identifies the policy the grant was made with
+	PolicyVersion string
+
+	// WouldDeny is the denial dry-run mode let through, nil when the policy
+	// allowed the token
+	WouldDeny error
+}
+
+// LoadFile reads and validates a JSON policy file. Unknown fields are
+// rejected so that typos do not silently weaken the policy.
+func LoadFile(name string) (*File, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var f File
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %w", name, err)
+	}
+	if err := f.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %w", name, err)
+	}
+	sum := sha256.Sum256(data)
+	f.Hash = hex.EncodeToString(sum[:])
+	return &f, nil
+}
+
+// version returns the file's Version, else its Hash. It returns "" for a nil
+// file.
+func (f *File) version() string {
+	if f == nil {
+		return ""
+	}
+	if f.Version != "" {
+		return f.Version
+	}
+	return f.Hash
+}
+
+// Validate checks every rule, naming the first invalid one in its error
+func (f *File) Validate() error {
+	if err := f.Subjects.compile(); err != nil {
+		return fmt.Errorf("subjects: %w", err)
+	}
+	for i := range f.FreezeWindows {
+		if err := f.FreezeWindows[i].compile(); err != nil {
+			return fmt.Errorf("freeze_windows[%d]: %w", i, err)
+		}
+	}
+
+	if f.Defaults.Repository != "" || f.Defaults.Deny {
+		return fmt.Errorf("defaults: repository and deny are only allowed in rules")
+	}
+	if err := f.Defaults.validate(); err != nil {
+		return fmt.Errorf("defaults: %w", err)
+	}
+
+	for i := range f.Rules {
+		rule := &f.Rules[i]
+		if rule.Repository == "" {
+			return fmt.Errorf("rules[%d]: missing repository", i)
+		}
+		if id, ok := strings.CutPrefix(rule.Repository, "id:"); ok && !isRepositoryID(id) {
+			return fmt.Errorf("rules[%d] (%s): invalid repository id: must be id:<repository_id>", i, rule.Repository)
+		}
+		if _, err := path.Match(rule.Repository, ""); err != nil {
+			return fmt.Errorf("rules[%d] (%s): invalid repository pattern: %w", i, rule.Repository, err)
+		}
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("rules[%d] (%s): %w", i, rule.Repository, err)
+		}
+	}
+	return nil
+}
+
+func (r *Rule) validate() error {
+	for _, branch := range r.Branches {
+		if branch == "" || strings.HasPrefix(branch, "refs/") {
+			return fmt.Errorf("invalid branch %q: must be a branch name without refs/heads/", branch)
+		}
+	}
+	for _, pattern := range r.RefPatterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid ref pattern %q: %w", pattern, err)
+		}
+	}
+	for _, pattern := range r.TagPatterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid tag pattern %q: %w", pattern, err)
+		}
+		if !strings.HasPrefix(pattern, "refs/tags/") {
+			return fmt.Errorf("invalid tag pattern %q: must start with refs/tags/", pattern)
+		}
+	}
+	for _, refType := range r.AllowedRefTypes {
+		if refType != "branch" && refType != "tag" {
+			return fmt.Errorf("invalid ref type %q: must be branch or tag", refType)
+		}
+	}
+	for _, workflow := range r.Workflows {
+		if workflow == "" {
+			return fmt.Errorf("empty workflow")
+		}
+	}
+	for _, environment := range r.Environments {
+		if environment == "" {
+			return fmt.Errorf("empty environment")
+		}
+	}
+	for _, baseRef := range r.PullRequestBaseRefs {
+		if strings.TrimPrefix(baseRef, "refs/heads/") == "" {
+			return fmt.Errorf("invalid pull request base ref %q", baseRef)
+		}
+	}
+	for _, scope := range r.Scopes {
+		if scope == "" {
+			return fmt.Errorf("empty scope")
+		}
+	}
+	if slices.Contains(r.ReducedScopes, "") {
+		return fmt.Errorf("empty reduced scope")
+	}
+	for i, grant := range r.ScopeGrants {
+		if len(grant.Environments) == 0 && len(grant.EventNames) == 0 {
+			return fmt.Errorf("scope_grants[%d]: needs environments or event_names", i)
+		}
+		if len(grant.Scopes) == 0 {
+			return fmt.Errorf("scope_grants[%d]: missing scopes", i)
+		}
+		if slices.Contains(grant.Environments, "") || slices.Contains(grant.EventNames, "") || slices.Contains(grant.Scopes, "") {
+			return fmt.Errorf("scope_grants[%d]: empty environment, event name or scope", i)
+		}
+	}
+	if r.MaxTokenAgeSeconds < 0 {
+		return fmt.Errorf("negative max_token_age_seconds %d", r.MaxTokenAgeSeconds)
+	}
+	if r.TokenTTLSeconds < 0 {
+		return fmt.Errorf("negative token_ttl_seconds %d", r.TokenTTLSeconds)
+	}
+	if r.Condition != "" {
+		condition, err := CompileCondition(r.Condition)
+		if err != nil {
+			return fmt.Errorf("invalid condition: %w", err)
+		}
+		r.condition = condition
+	}
+	return nil
+}
+
+// rule merges the first file rule matching the token's repository over the
+// file's defaults. It returns the zero Rule without a file.
+func (f *File) rule(claims *types.VerifiedClaims) Rule {
+	if f == nil {
+		return Rule{}
+	}
+
+	merged := f.Defaults
+	for _, rule := range f.Rules {
+		if !rule.matches(claims) {
+			continue
+		}
+		merged.Repository = rule.Repository
+		merged.Deny = rule.Deny
+		merged.DryRun = merged.DryRun || rule.DryRun
+		if len(rule.Branches) > 0 {
+			merged.Branches = rule.Branches
+		}
+		if len(rule.RefPatterns) > 0 {
+			merged.RefPatterns = rule.RefPatterns
+		}
+		if len(rule.TagPatterns) > 0 {
+			merged.TagPatterns = rule.TagPatterns
+		}
+		if len(rule.AllowedRefTypes) > 0 {
+			merged.AllowedRefTypes = rule.AllowedRefTypes
+		}
+		if len(rule.Workflows) > 0 {
+			merged.Workflows = rule.Workflows
+		}
+		if rule.HostedRunnersOnly != nil {
+			merged.HostedRunnersOnly = rule.HostedRunnersOnly
+		}
+		if rule.DenyBots != nil {
+			merged.DenyBots = rule.DenyBots
+		}
+		if rule.RequireSHA != nil {
+			merged.RequireSHA = rule.RequireSHA
+		}
+		if len(rule.Environments) > 0 {
+			merged.Environments = rule.Environments
+		}
+		if len(rule.PullRequestBaseRefs) > 0 {
+			merged.PullRequestBaseRefs = rule.PullRequestBaseRefs
+		}
+		if len(rule.Scopes) > 0 {
+			merged.Scopes = rule.Scopes
+		}
+		if len(rule.ScopeGrants) > 0 {
+			merged.ScopeGrants = rule.ScopeGrants
+		}
+		if len(rule.ReducedScopes) > 0 {
+			merged.ReducedScopes = rule.ReducedScopes
+		}
+		if rule.MaxTokenAgeSeconds > 0 {
+			merged.MaxTokenAgeSeconds = rule.MaxTokenAgeSeconds
+		}
+		if rule.TokenTTLSeconds > 0 {
+			merged.TokenTTLSeconds = rule.TokenTTLSeconds
+		}
+		if rule.Condition != "" {
+			merged.Condition, merged.condition = rule.Condition, rule.condition
+		}
+		break
+	}
+	return merged
+}
@@ -0,0 +1,981 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robohub/auth-service/internal/testutil"
+	"github.com/robohub/auth-service/internal/types"
+)
+
+const testPolicyFile = `{
+	"defaults": {
+		"ref_patterns": ["refs/heads/main"],
+		"scopes": ["ingest:build"]
+	},
+	"rules": [
+		{"repository": "myorg/retired", "deny": true},
+		{
+			"repository": "myorg/deploy",
+			"ref_patterns": ["refs/heads/main", "refs/heads/release/*"],
+			"tag_patterns": ["refs/tags/v*"],
+			"workflows": ["myorg/deploy/.github/workflows/release.yml"],
+			"environments": ["production"],
+			"scopes": ["ingest:build", "deploy:robot"],
+			"token_ttl_seconds": 300
+		},
+		{"repository": "myorg/*", "ref_patterns": ["refs/heads/*"]}
+	]
+}`
+
+func writePolicyFile(t *testing.T, content string) string {
+	t.Helper()
+
+	name := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+	return name
+}
+
+func TestLoadFile(t *testing.T) {
+	tests := []struct {
+		name          string
+		content       string
+		errorContains string
+	}{
+		{"valid", testPolicyFile, ""},
+		{"empty", `{}`, ""},
+		{"unknown field", `{"rules": [{"repository": "myorg/*", "ref_pattern": ["refs/heads/main"]}]}`, `unknown field "ref_pattern"`},
+		{"missing repository", `{"rules": [{"deny": true}]}`, "rules[0]: missing repository"},
+		{"invalid repository pattern", `{"rules": [{"repository": "myorg/[x"}]}`, "rules[0] (myorg/[x): invalid repository pattern"},
+		{"invalid ref pattern", `{"rules": [{"repository": "myorg/a"}, {"repository": "myorg/b", "ref_patterns": ["refs/heads/[x"]}]}`, `rules[1] (myorg/b): invalid ref pattern "refs/heads/[x"`},
+		{"invalid tag pattern", `{"rules": [{"repository": "myorg/a", "tag_patterns": ["refs/heads/v*"]}]}`, "rules[0] (myorg/a): invalid tag pattern"},
+		{"full ref as branch", `{"rules": [{"repository": "myorg/a", "branches": ["refs/heads/main"]}]}`, `rules[0] (myorg/a): invalid branch "refs/heads/main"`},
+		{"negative TTL", `{"rules": [{"repository": "myorg/a", "token_ttl_seconds": -1}]}`, "rules[0] (myorg/a): negative token_ttl_seconds"},
+		{"negative max token age", `{"defaults": {"max_token_age_seconds": -1}}`, "defaults: negative max_token_age_seconds"},
+		{"deny in defaults", `{"defaults": {"deny": true}}`, "defaults: repository and deny are only allowed in rules"},
+		{"invalid defaults", `{"defaults": {"scopes": [""]}}`, "defaults: empty scope"},
+		{"invalid condition", `{"rules": [{"repository": "myorg/a", "condition": "branch == 'main'"}]}`, "rules[0] (myorg/a): invalid condition: ERROR: <input>:1:1: undeclared reference to 'branch'"},
+		{"invalid JSON", `rules: []`, "invalid policy file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadFile(writePolicyFile(t, tt.content))
+			if tt.errorContains == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+				t.Errorf("expected error to contain %q, got %v", tt.errorContains, err)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := LoadFile(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestEnforcer_EvaluateWithFile(t *testing.T) {
+	f, err := LoadFile(writePolicyFile(t, testPolicyFile))
+	if err != nil {
+		t.Fatalf("failed to load policy file: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		claims     types.VerifiedClaims
+		wantReason Reason
+		wantRuleID string
+	}{
+		{
+			name:       "denied by rule",
+			claims:     types.VerifiedClaims{Repository: "myorg/retired", Ref: "refs/heads/main"},
+			wantReason: ReasonRepoDenied,
+			wantRuleID: "file:myorg/retired",
+		},
+		{
+			name: "release branch under rule",
+			claims: types.VerifiedClaims{
+				Repository:  "myorg/deploy",
+				Ref:         "refs/heads/release/1.0",
+				WorkflowRef: "myorg/deploy/.github/workflows/release.yml@refs/heads/release/1.0",
+				Environment: "production",
+			},
+		},
+		{
+			name: "release tag under rule",
+			claims: types.VerifiedClaims{
+				Repository:  "myorg/deploy",
+				Ref:         "refs/tags/v1.0.0",
+				RefType:     "tag",
+				WorkflowRef: "myorg/deploy/.github/workflows/release.yml@refs/tags/v1.0.0",
+				Environment: "production",
+			},
+		},
+		{
+			name: "unpinned workflow under rule",
+			claims: types.VerifiedClaims{
+				Repository:  "myorg/deploy",
+				Ref:         "refs/heads/main",
+				Workflow:    "myorg/deploy/.github/workflows/ci.yml@refs/heads/main",
+				WorkflowRef: "myorg/deploy/.github/workflows/ci.yml@refs/heads/main",
+				Environment: "production",
+			},
+			wantReason: ReasonWorkflowNotPinned,
+			wantRuleID: "file:myorg/deploy",
+		},
+		{
+			name: "missing environment under rule",
+			claims: types.VerifiedClaims{
+				Repository:  "myorg/deploy",
+				Ref:         "refs/heads/main",
+				WorkflowRef: "myorg/deploy/.github/workflows/release.yml@refs/heads/main",
+			},
+			wantReason: ReasonEnvironmentNotAllowed,
+			wantRuleID: "file:myorg/deploy",
+		},
+		{
+			name:   "later rule by pattern",
+			claims: types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/feature"},
+		},
+		{
+			name:       "defaults",
+			claims:     types.VerifiedClaims{Repository: "other/repo", Ref: "refs/heads/feature"},
+			wantReason: ReasonRefNotAllowed,
+			wantRuleID: "file:defaults",
+		},
+		{
+			name:   "defaults allow main",
+			claims: types.VerifiedClaims{Repository: "other/repo", Ref: "refs/heads/main"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The file wins over the conflicting environment settings
+			e := NewEnforcer(true, "develop", nil, nil, WithRefPatterns([]string{"refs/heads/develop"}), WithFile(f))
+			d := e.Check(&tt.claims)
+			if tt.wantReason == "" {
+				if !d.Allowed {
+					t.Errorf("unexpected denial: %+v", d)
+				}
+				return
+			}
+			if d.Allowed || d.Reason != tt.wantReason || d.RuleID != tt.wantRuleID {
+				t.Errorf("expected denial %s by %s, got %+v", tt.wantReason, tt.wantRuleID, d)
+			}
+		})
+	}
+
+	t.Run("environment settings without a file", func(t *testing.T) {
+		e := NewEnforcer(false, "main", nil, nil, WithRefPatterns([]string{"refs/heads/develop"}))
+		if err := e.Evaluate(&types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/develop"}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		want := Grant{Scopes: []string{"ingest:build"}}
+		if grant := e.Grant(&types.VerifiedClaims{Repository: "myorg/robot"}); !reflect.DeepEqual(grant, want) {
+			t.Errorf("expected %+v, got %+v", want, grant)
+		}
+	})
+}
+
+func TestEnforcer_BranchesWithFile(t *testing.T) {
+	f, err := LoadFile(writePolicyFile(t, `{
+		"rules": [
+			{"repository": "myorg/legacy-*", "branches": ["master"]},
+			{"repository": "myorg/firmware", "branches": ["main", "stable"]}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("failed to load policy file: %v", err)
+	}
+
+	tests := []struct {
+		name              string
+		defaultBranchOnly bool
+		claims            types.VerifiedClaims
+		wantRuleID        string
+	}{
+		{"global branches", true, types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/master"}, ""},
+		{"global branches deny", true, types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/stable"}, "default_branch"},
+		{"rule overrides global", true, types.VerifiedClaims{Repository: "myorg/firmware", Ref: "refs/heads/stable"}, ""},
+		{"rule replaces global", true, types.VerifiedClaims{Repository: "myorg/legacy-app", Ref: "refs/heads/main"}, "file:myorg/legacy-*"},
+		{"rule applies without the global check", false, types.VerifiedClaims{Repository: "myorg/firmware", Ref: "refs/heads/feature"}, "file:myorg/firmware"},
+		{"other repositories unrestricted", false, types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/feature"}, ""},
+		{"rule tag", true, types.VerifiedClaims{Repository: "myorg/firmware", Ref: "refs/heads/main", RefType: "tag"}, "file:myorg/firmware"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEnforcer(tt.defaultBranchOnly, "main", nil, nil, WithAllowedBranches([]string{"main", "master"}), WithFile(f))
+			d := e.Check(&tt.claims)
+			if tt.wantRuleID == "" {
+				if !d.Allowed {
+					t.Errorf("unexpected denial: %+v", d)
+				}
+				return
+			}
+			if d.Allowed || d.Reason != ReasonBranchNotAllowed || d.RuleID != tt.wantRuleID {
+				t.Errorf("expected branch denial by %s, got %+v", tt.wantRuleID, d)
+			}
+		})
+	}
+}
+
+func TestEnforcer_RefTypes(t *testing.T) {
+	f, err := LoadFile(writePolicyFile(t, `{
+		"defaults": {"allowed_ref_types": ["branch"]},
+		"rules": [{"repository": "myorg/release", "allowed_ref_types": ["tag"]}]
+	}`))
+	if err != nil {
+		t.Fatalf("failed to load policy file: %v", err)
+	}
+	e := NewEnforcer(false, "main", nil, nil, WithFile(f))
+
+	tests := []struct {
+		name        string
+		claims      types.VerifiedClaims
+		wantMessage string
+	}{
+		{"tag-only repo, tag", types.VerifiedClaims{Repository: "myorg/release", Ref: "refs/tags/v1.0.0", RefType: "tag"}, ""},
+		{"tag-only repo, branch", types.VerifiedClaims{Repository: "myorg/release", Ref: "refs/heads/main", RefType: "branch"},
+			"only ref types tag are allowed, got branch refs/heads/main"},
+		{"branch-only repo, branch", types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/main", RefType: "branch"}, ""},
+		{"branch-only repo, tag", types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/tags/v1.0.0", RefType: "tag"},
+			"only ref types branch are allowed, got tag refs/tags/v1.0.0"},
+		// The claim wins over the ref's prefix
+		{"claim contradicts prefix", types.VerifiedClaims{Repository: "myorg/release", Ref: "refs/tags/v1.0.0", RefType: "branch"},
+			"only ref types tag are allowed, got branch refs/tags/v1.0.0"},
+		{"no claim, tag prefix", types.VerifiedClaims{Repository: "myorg/release", Ref: "refs/tags/v1.0.0"}, ""},
+		{"no claim, branch prefix", types.VerifiedClaims{Repository: "myorg/release", Ref: "refs/heads/main"},
+			"only ref types tag are allowed, got branch refs/heads/main"},
+		{"no claim, unknown ref", types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/pull/1/merge"},
+			"only ref types branch are allowed, and the type of ref refs/pull/1/merge is unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := e.Check(&tt.claims)
+			if tt.wantMessage == "" {
+				if !d.Allowed {
+					t.Errorf("expected allowed, got %+v", d)
+				}
+				return
+			}
+			if d.Reason != ReasonRefTypeNotAllowed || d.Message != tt.wantMessage {
+				t.Errorf("expected %s %q, got %+v", ReasonRefTypeNotAllowed, tt.wantMessage, d)
+			}
+			if want := fileRuleID(f.rule(&tt.claims)); d.RuleID != want {
+				t.Errorf("expected rule %s, got %q", want, d.RuleID)
+			}
+		})
+	}
+
+	t.Run("option", func(t *testing.T) {
+		e := NewEnforcer(false, "main", nil, nil, WithAllowedRefTypes([]string{"tag"}))
+		d := e.Check(&types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/main", RefType: "branch"})
+		if d.Reason != ReasonRefTypeNotAllowed || d.RuleID != "allowed_ref_types" {
+			t.Errorf("expected %s by allowed_ref_types, got %+v", ReasonRefTypeNotAllowed, d)
+		}
+	})
+
+	t.Run("invalid ref type", func(t *testing.T) {
+		_, err := LoadFile(writePolicyFile(t, `{"defaults": {"allowed_ref_types": ["pull_request"]}}`))
+		if err == nil || !strings.Contains(err.Error(), `invalid ref type "pull_request"`) {
+			t.Errorf("expected invalid ref type error, got %v", err)
+		}
+	})
+}
+
+func TestEnforcer_RepositoryIDRules(t *testing.T) {
+	f, err := LoadFile(writePolicyFile(t, `{
+		"rules": [
+			{"repository": "id:123456", "scopes": ["deploy:robot"]},
+			{"repository": "myorg/robot", "deny": true},
+			{"repository": "id:999", "deny": true},
+			{"repository": "myorg/*", "scopes": ["ingest:build"]}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("failed to load policy file: %v", err)
+	}
+	e := NewEnforcer(false, "main", nil, nil, WithFile(f))
+
+	tests := []struct {
+		name       string
+		claims     types.VerifiedClaims
+		wantRuleID string
+		wantScopes []string
+	}{
+		{"renamed repository matches by id", types.VerifiedClaims{Repository: "myorg/robot-v2", RepositoryID: "123456"}, "", []string{"deploy:robot"}},
+		{"id rule wins over the name rule", types.VerifiedClaims{Repository: "myorg/robot", RepositoryID: "123456"}, "", []string{"deploy:robot"}},
+		{"name rule without an id", types.VerifiedClaims{Repository: "myorg/robot"}, "file:myorg/robot", nil},
+		{"new name does not match the old name rule", types.VerifiedClaims{Repository: "myorg/robot-v2", RepositoryID: "555"}, "", []string{"ingest:build"}},
+		{"denied by id", types.VerifiedClaims{Repository: "myorg/legacy", RepositoryID: "999"}, "file:id:999", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.claims.Ref = "refs/heads/main"
+			d := e.Check(&tt.claims)
+			if tt.wantRuleID != "" {
+				if d.Allowed || d.RuleID != tt.wantRuleID {
+					t.Errorf("expected denial by %s, got %+v", tt.wantRuleID, d)
+				}
+				return
+			}
+			if !d.Allowed {
+				t.Fatalf("expected allowed, got %+v", d)
+			}
+			if got := e.Grant(&tt.claims).Scopes; !reflect.DeepEqual(got, tt.wantScopes) {
+				t.Errorf("expected scopes %v, got %v", tt.wantScopes, got)
+			}
+		})
+	}
+
+	t.Run("invalid id", func(t *testing.T) {
+		_, err := LoadFile(writePolicyFile(t, `{"rules": [{"repository": "id:robot", "deny": true}]}`))
+		if err == nil || !strings.Contains(err.Error(), "rules[0] (id:robot): invalid repository id") {
+			t.Errorf("expected invalid repository id error, got %v", err)
+		}
+	})
+}
+
+func TestEnforcer_PullRequestBaseRefs(t *testing.T) {
+	f, err := LoadFile(writePolicyFile(t, `{
+		"rules": [{"repository": "myorg/robot", "pull_request_base_refs": ["main", "refs/heads/release"]}]
+	}`))
+	if err != nil {
+		t.Fatalf("failed to load policy file: %v", err)
+	}
+	e := NewEnforcer(false, "main", nil, nil, WithFile(f))
+
+	tests := []struct {
+		name        string
+		claims      types.VerifiedClaims
+		wantMessage string
+	}{
+		{"targets main", types.VerifiedClaims{EventName: "pull_request", BaseRef: "main"}, ""},
+		{"targets refs/heads/main", types.VerifiedClaims{EventName: "pull_request", BaseRef: "refs/heads/main"}, ""},
+		{"targets release", types.VerifiedClaims{EventName: "pull_request_target", BaseRef: "release"}, ""},
+		{"targets a feature branch", types.VerifiedClaims{EventName: "pull_request", BaseRef: "feature/x"},
+			"pull request targets feature/x, but only base branches main, refs/heads/release are allowed"},
+		{"missing base_ref", types.VerifiedClaims{EventName: "pull_request"},
+			"pull_request token for repository myorg/robot has no base_ref, which must be one of main, refs/heads/release"},
+		{"push", types.VerifiedClaims{EventName: "push"}, ""},
+		{"other repository", types.VerifiedClaims{Repository: "myorg/docs", EventName: "pull_request", BaseRef: "feature/x"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := tt.claims
+			if claims.Repository == "" {
+				claims.Repository = "myorg/robot"
+			}
+			claims.Ref = "refs/pull/1/merge"
+			d := e.Check(&claims)
+			if tt.wantMessage == "" {
+				if !d.Allowed {
+					t.Errorf("expected allowed, got %+v", d)
+				}
+				return
+			}
+			if d.Reason != ReasonBaseRefNotAllowed || d.RuleID != "file:myorg/robot" || d.Message != tt.wantMessage {
+				t.Errorf("expected %s by file:myorg/robot %q, got %+v", ReasonBaseRefNotAllowed, tt.wantMessage, d)
+			}
+		})
+	}
+}
+
+func TestEnforcer_RequireSHAWithFile(t *testing.T) {
+	f, err := LoadFile(writePolicyFile(t, `{
+		"defaults": {"require_sha": true},
+		"rules": [
+			{"repository": "myorg/legacy", "require_sha": false},
+			{"repository": "myorg/*"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("failed to load policy file: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		requireSHA bool
+		file       *File
+		claims     types.VerifiedClaims
+		wantRuleID string
+	}{
+		{"present", true, nil, types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/main", SHA: "ffac537e6cbbf934b08745a378932722df287a53"}, ""},
+		{"absent with flag on", true, nil, types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/main"}, "require_sha"},
+		{"absent with flag off", false, nil, types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/main"}, ""},
+		{"file defaults", false, f, types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/main"}, "file:myorg/*"},
+		{"file rule exempts", true, f, types.VerifiedClaims{Repository: "myorg/legacy", Ref: "refs/heads/main"}, ""},
+		{"file defaults present", false, f, types.VerifiedClaims{Repository: "other/repo", Ref: "refs/heads/main", SHA: "ffac537e6cbbf934b08745a378932722df287a53"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEnforcer(false, "main", nil, nil, WithRequireSHA(tt.requireSHA), WithFile(tt.file))
+			d := e.Check(&tt.claims)
+			if tt.wantRuleID == "" {
+				if !d.Allowed {
+					t.Errorf("unexpected denial: %+v", d)
+				}
+				return
+			}
+			if d.Allowed || d.Reason != ReasonSHARequired || d.RuleID != tt.wantRuleID {
+				t.Errorf("expected sha_required denial by %s, got %+v", tt.wantRuleID, d)
+			}
+		})
+	}
+}
+
+func TestEnforcer_DenyBots(t *testing.T) {
+	f, err := LoadFile(writePolicyFile(t, `{
+		"defaults": {"deny_bots": true},
+		"rules": [
+			{"repository": "myorg/deps", "deny_bots": false},
+			{"repository": "myorg/*"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("failed to load policy file: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		denyBots   bool
+		file       *File
+		claims     types.VerifiedClaims
+		wantRuleID string
+	}{
+		{"dependabot", true, nil, types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/main", Actor: "dependabot[bot]"}, "deny_bots"},
+		{"excepted bot", true, nil, types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/main", Actor: "robohub-release[bot]"}, ""},
+		{"human", true, nil, types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/main", Actor: "octocat"}, ""},
+		{"flag off", false, nil, types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/main", Actor: "dependabot[bot]"}, ""},
+		{"file defaults", false, f, types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/main", Actor: "dependabot[bot]"}, "file:myorg/*"},
+		{"file rule exempts", true, f, types.VerifiedClaims{Repository: "myorg/deps", Ref: "refs/heads/main", Actor: "dependabot[bot]"}, ""},
+		{"file excepted bot", false, f, types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/main", Actor: "robohub-release[bot]"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEnforcer(false, "main", nil, nil, WithDenyBots(tt.denyBots), WithAllowedBots([]string{"robohub-release[bot]"}), WithFile(tt.file))
+			d := e.Check(&tt.claims)
+			if tt.wantRuleID == "" {
+				if !d.Allowed {
+					t.Errorf("unexpected denial: %+v", d)
+				}
+				return
+			}
+			if d.Allowed || d.Reason != ReasonBotActorDenied || d.RuleID != tt.wantRuleID {
+				t.Errorf("expected bot_actor_denied denial by %s, got %+v", tt.wantRuleID, d)
+			}
+		})
+	}
+}
+
+func TestEnforcer_MaxTokenAgeWithFile(t *testing.T) {
+	f, err := LoadFile(writePolicyFile(t, `{
+		"rules": [
+			{"repository": "myorg/signing", "max_token_age_seconds": 300},
+			{"repository": "myorg/*"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("failed to load policy file: %v", err)
+	}
+	now := time.Date(2026, 2, 15, 10, 30, 0, 0, time.UTC)
+	e := NewEnforcer(false, "main", nil, nil, WithFile(f), WithClock(testutil.NewFakeClock(now)))
+
+	tests := []struct {
+		name          string
+		repository    string
+		issuedAt      time.Time
+		errorContains string
+	}{
+		{"fresh token", "myorg/signing", now.Add(-time.Minute), ""},
+		{"at the limit", "myorg/signing", now.Add(-5 * time.Minute), ""},
+		{"past the limit", "myorg/signing", now.Add(-5*time.Minute - time.Second), "token for repository myorg/signing was issued 5m1s ago, maximum is 5m0s"},
+		{"missing iat", "myorg/signing", time.Time{}, "token has no iat claim"},
+		{"no limit", "myorg/robot", now.Add(-6 * time.Hour), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := e.Check(&types.VerifiedClaims{Repository: tt.repository, Ref: "refs/heads/main", IssuedAt: tt.issuedAt})
+			if tt.errorContains == "" {
+				if !d.Allowed {
+					t.Errorf("unexpected denial: %+v", d)
+				}
+				return
+			}
+			if d.Allowed || d.Reason != ReasonTokenTooOld || d.RuleID != "file:myorg/signing" {
+				t.Fatalf("expected token_too_old denial, got %+v", d)
+			}
+			if !strings.Contains(d.Message, tt.errorContains) {
+				t.Errorf("expected message to contain %q, got %q", tt.errorContains, d.Message)
+			}
+		})
+	}
+}
+
+func TestEnforcer_Grant(t *testing.T) {
+	f, err := LoadFile(writePolicyFile(t, testPolicyFile))
+	if err != nil {
+		t.Fatalf("failed to load policy file: %v", err)
+	}
+	repoScopes := map[string][]string{
+		"myorg/docs-*":   {"ingest:docs"},
+		"myorg/firmware": {"ingest:build", "artifact:sign"},
+		"myorg/*":        {"ingest:other"},
+	}
+	repoTTLs := map[string]time.Duration{
+		"myorg/signing": 2 * time.Minute,
+		"myorg/docs-*":  30 * time.Minute,
+		"myorg/deploy":  time.Hour,
+		"myorg/slow":    3 * time.Hour,
+	}
+	withFile := NewEnforcer(false, "main", nil, nil, WithRepoScopes(repoScopes), WithRepoTTLs(repoTTLs), WithFile(f))
+	withoutFile := NewEnforcer(false, "main", nil, nil, WithRepoScopes(repoScopes), WithRepoTTLs(repoTTLs), WithMaxTokenTTL(time.Hour), WithDefaultScopes([]string{"ingest:default"}))
+
+	tests := []struct {
+		name       string
+		enforcer   *Enforcer
+		repository string
+		want       Grant
+	}{
+		{"file rule", withFile, "myorg/deploy", Grant{Scopes: []string{"ingest:build", "deploy:robot"}, TTL: 5 * time.Minute}},
+		{"repo TTL under file defaults", withFile, "myorg/signing", Grant{Scopes: []string{"ingest:build"}, TTL: 2 * time.Minute}},
+		{"file defaults win over repo scopes", withFile, "myorg/robot", Grant{Scopes: []string{"ingest:build"}}},
+		{"file defaults", withFile, "other/repo", Grant{Scopes: []string{"ingest:build"}}},
+		{"repo scopes by pattern", withoutFile, "myorg/docs-site", Grant{Scopes: []string{"ingest:docs"}, TTL: 30 * time.Minute}},
+		{"repo TTL", withoutFile, "myorg/signing", Grant{Scopes: []string{"ingest:other"}, TTL: 2 * time.Minute}},
+		{"repo TTL capped", withoutFile, "myorg/slow", Grant{Scopes: []string{"ingest:other"}, TTL: time.Hour}},
+		{"exact repo wins over pattern", withoutFile, "myorg/firmware", Grant{Scopes: []string{"ingest:build", "artifact:sign"}}},
+		{"broader pattern", withoutFile, "myorg/robot", Grant{Scopes: []string{"ingest:other"}}},
+		{"default scopes", withoutFile, "other/repo", Grant{Scopes: []string{"ingest:default"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.enforcer.Grant(&types.VerifiedClaims{Repository: tt.repository})
+			got.PolicyVersion = ""
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEnforcer_ScopeGrants(t *testing.T) {
+	f, err := LoadFile(writePolicyFile(t, `{
+		"defaults": {"scopes": ["ingest:build"]},
+		"rules": [
+			{
+				"repository": "myorg/deploy",
+				"scope_grants": [
+					{"environments": ["production"], "scopes": ["deploy:prod"]},
+					{"environments": ["production", "staging"], "event_names": ["workflow_dispatch"], "scopes": ["deploy:prod", "deploy:manual"]}
+				]
+			}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("failed to load policy file: %v", err)
+	}
+	e := NewEnforcer(false, "main", nil, nil, WithFile(f))
+
+	tests := []struct {
+		name        string
+		claims      types.VerifiedClaims
+		want        []string
+		wantElevate []Elevation
+	}{
+		{"production", types.VerifiedClaims{Repository: "myorg/deploy", Environment: "production", EventName: "push"},
+			[]string{"ingest:build", "deploy:prod"},
+			[]Elevation{{Scope: "deploy:prod", RuleID: "file:myorg/deploy", Condition: "environment in [production]"}}},
+		{"staging", types.VerifiedClaims{Repository: "myorg/deploy", Environment: "staging", EventName: "push"}, []string{"ingest:build"}, nil},
+		{"missing environment", types.VerifiedClaims{Repository: "myorg/deploy", EventName: "push"}, []string{"ingest:build"}, nil},
+		{"staging dispatch", types.VerifiedClaims{Repository: "myorg/deploy", Environment: "staging", EventName: "workflow_dispatch"},
+			[]string{"ingest:build", "deploy:prod", "deploy:manual"},
+			[]Elevation{
+				{Scope: "deploy:prod", RuleID: "file:myorg/deploy", Condition: "environment in [production, staging] && event_name in [workflow_dispatch]"},
+				{Scope: "deploy:manual", RuleID: "file:myorg/deploy", Condition: "environment in [production, staging] && event_name in [workflow_dispatch]"},
+			}},
+		// A scope granted twice is recorded once, by the first grant
+		{"production dispatch", types.VerifiedClaims{Repository: "myorg/deploy", Environment: "production", EventName: "workflow_dispatch"},
+			[]string{"ingest:build", "deploy:prod", "deploy:manual"},
+			[]Elevation{
+				{Scope: "deploy:prod", RuleID: "file:myorg/deploy", Condition: "environment in [production]"},
+				{Scope: "deploy:manual", RuleID: "file:myorg/deploy", Condition: "environment in [production, staging] && event_name in [workflow_dispatch]"},
+			}},
+		{"other repository", types.VerifiedClaims{Repository: "myorg/robot", Environment: "production"}, []string{"ingest:build"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := e.Grant(&tt.claims)
+			if !reflect.DeepEqual(got.Scopes, tt.want) || !reflect.DeepEqual(got.Elevations, tt.wantElevate) {
+				t.Errorf("expected %v elevated by %+v, got %v elevated by %+v", tt.want, tt.wantElevate, got.Scopes, got.Elevations)
+			}
+		})
+	}
+
+	// Elevation must not leak into the scopes of other tokens
+	if got := e.Grant(&types.VerifiedClaims{Repository: "myorg/deploy"}).Scopes; !reflect.DeepEqual(got, []string{"ingest:build"}) {
+		t.Errorf("expected the base scopes to be unchanged, got %v", got)
+	}
+
+	invalid := []struct {
+		name          string
+		grant         string
+		errorContains string
+	}{
+		{"no condition", `{"scopes": ["deploy:prod"]}`, "needs environments or event_names"},
+		{"no scopes", `{"environments": ["production"]}`, "missing scopes"},
+		{"empty environment", `{"environments": [""], "scopes": ["deploy:prod"]}`, "empty environment"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadFile(writePolicyFile(t, `{"defaults": {"scope_grants": [`+tt.grant+`]}}`))
+			if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+				t.Errorf("expected error to contain %q, got %v", tt.errorContains, err)
+			}
+		})
+	}
+}
+
+func TestEnforcer_ReducedScopes(t *testing.T) {
+	f, err := LoadFile(writePolicyFile(t, `{
+		"defaults": {"scopes": ["ingest:build", "ingest:telemetry", "artifact:publish"]},
+		"rules": [
+			{"repository": "myorg/firmware", "branches": ["main", "release"], "reduced_scopes": ["ingest:telemetry"]},
+			{"repository": "myorg/docs", "scopes": ["artifact:publish"]},
+			{"repository": "myorg/deploy", "environments": ["production"]}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("failed to load policy file: %v", err)
+	}
+	e := NewEnforcer(true, "main", nil, nil, WithFile(f), WithReducedScopes([]string{"ingest:build", "ingest:telemetry"}))
+
+	tests := []struct {
+		name         string
+		claims       types.VerifiedClaims
+		want         []string
+		wantWithheld []string
+		wantReason   Reason
+		wantRuleID   string
+	}{
+		{"main branch", types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/main"},
+			[]string{"ingest:build", "ingest:telemetry", "artifact:publish"}, nil, "", ""},
+		{"feature branch", types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/feature"},
+			[]string{"ingest:build", "ingest:telemetry"}, []string{"artifact:publish"}, ReasonBranchNotAllowed, "default_branch"},
+		{"policy file branches and reduced scopes", types.VerifiedClaims{Repository: "myorg/firmware", Ref: "refs/heads/feature"},
+			[]string{"ingest:telemetry"}, []string{"ingest:build", "artifact:publish"}, ReasonBranchNotAllowed, "file:myorg/firmware"},
+		{"allowed policy file branch", types.VerifiedClaims{Repository: "myorg/firmware", Ref: "refs/heads/release"},
+			[]string{"ingest:build", "ingest:telemetry", "artifact:publish"}, nil, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			grant, err := e.Decide(context.Background(), &tt.claims)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(grant.Scopes, tt.want) || !reflect.DeepEqual(grant.Withheld, tt.wantWithheld) {
+				t.Errorf("expected scopes %v withholding %v, got %v withholding %v", tt.want, tt.wantWithheld, grant.Scopes, grant.Withheld)
+			}
+			var reduced *DeniedError
+			if errors.As(grant.Reduced, &reduced) != (tt.wantReason != "") {
+				t.Fatalf("expected reduced=%v, got %v", tt.wantReason != "", grant.Reduced)
+			}
+			if reduced != nil && (reduced.Decision.Reason != tt.wantReason || reduced.Decision.RuleID != tt.wantRuleID) {
+				t.Errorf("expected reduction by %s %s, got %+v", tt.wantReason, tt.wantRuleID, reduced.Decision)
+			}
+		})
+	}
+
+	t.Run("still denied", func(t *testing.T) {
+		for _, claims := range []*types.VerifiedClaims{
+			// No scope left
+			{Repository: "myorg/docs", Ref: "refs/heads/feature"},
+			// The other checks still apply
+			{Repository: "myorg/deploy", Ref: "refs/heads/feature", Environment: "staging"},
+		} {
+			if _, err := e.Decide(context.Background(), claims); err == nil {
+				t.Errorf("expected %s on %s to be denied", claims.Repository, claims.Ref)
+			}
+		}
+	})
+
+	t.Run("check", func(t *testing.T) {
+		claims := &types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/feature"}
+		d := e.Check(claims)
+		if !d.Allowed || d.Reduced == nil || d.Reduced.Reason != ReasonBranchNotAllowed {
+			t.Fatalf("expected an allowed decision reduced by the branch check, got %+v", d)
+		}
+		grant, err := e.GrantFor(claims, d)
+		if err != nil || !reflect.DeepEqual(grant.Scopes, []string{"ingest:build", "ingest:telemetry"}) {
+			t.Errorf("expected reduced scopes, got %v, %v", grant.Scopes, err)
+		}
+	})
+
+	t.Run("counted", func(t *testing.T) {
+		key := DecisionKey{Outcome: OutcomeReduced, Reason: ReasonBranchNotAllowed, RuleID: "default_branch"}
+		if got := e.Status().Decisions[key]; got != 1 {
+			t.Errorf("expected 1 reduced decision, got %d", got)
+		}
+	})
+
+	t.Run("off by default", func(t *testing.T) {
+		e := NewEnforcer(true, "main", nil, nil)
+		if _, err := e.Decide(context.Background(), &types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/feature"}); err == nil {
+			t.Error("expected the feature branch to be denied")
+		}
+	})
+}
+
+func TestEnforcer_Reload(t *testing.T) {
+	name := writePolicyFile(t, testPolicyFile)
+	f, err := LoadFile(name)
+	if err != nil {
+		t.Fatalf("failed to load policy file: %v", err)
+	}
+	e := NewEnforcer(false, "main", nil, nil, WithFile(f))
+	claims := &types.VerifiedClaims{Repository: "myorg/retired", Ref: "refs/heads/main"}
+	if err := e.Evaluate(claims); err == nil {
+		t.Fatal("expected denial before reload")
+	}
+
+	if err := os.WriteFile(name, []byte(`{"rules": [{"repository": "myorg/other", "deny": true}]}`), 0o600); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+	reloaded, err := e.Reload(name)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reloaded.Hash == f.Hash {
+		t.Error("expected the hash to change")
+	}
+	if err := e.Evaluate(claims); err != nil {
+		t.Errorf("unexpected error after reload: %v", err)
+	}
+
+	// A broken file keeps the current policy
+	if err := os.WriteFile(name, []byte(`{"rules": [{"deny": true}]}`), 0o600); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+	if _, err := e.Reload(name); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := e.Evaluate(claims); err != nil {
+		t.Errorf("unexpected error after failed reload: %v", err)
+	}
+
+	want := Status{Hash: reloaded.Hash, PolicyVersion: reloaded.Hash, Reloads: 1, ReloadFailures: 1, Decisions: map[DecisionKey]uint64{}}
+	if got := e.Status(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestEnforcer_PolicyVersion(t *testing.T) {
+	name := writePolicyFile(t, `{"defaults": {"scopes": ["ingest:build"]}}`)
+	e := NewEnforcer(false, "main", nil, []string{"myorg/legacy"}, WithPolicyVersion("env:0123456789ab"))
+	claims := &types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/main"}
+
+	if got := e.Check(claims).PolicyVersion; got != "env:0123456789ab" {
+		t.Errorf("expected the environment version without a file, got %q", got)
+	}
+
+	f, err := e.Reload(name)
+	if err != nil {
+		t.Fatalf("failed to load policy file: %v", err)
+	}
+	before := e.Check(claims)
+	if before.PolicyVersion != f.Hash {
+		t.Errorf("expected the file hash %s, got %q", f.Hash, before.PolicyVersion)
+	}
+	if got := e.Check(&types.VerifiedClaims{Repository: "myorg/legacy", Ref: "refs/heads/main"}); got.Allowed || got.PolicyVersion != f.Hash {
+		t.Errorf("expected a denial made with %s, got %+v", f.Hash, got)
+	}
+	if got := e.Status().PolicyVersion; got != f.Hash {
+		t.Errorf("expected status version %s, got %q", f.Hash, got)
+	}
+
+	// A decision is granted from the file it was made with, even after a
+	// reload
+	if err := os.WriteFile(name, []byte(`{"version": "2026.10.1", "defaults": {"scopes": ["ingest:telemetry"]}}`), 0o600); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+	if _, err := e.Reload(name); err != nil {
+		t.Fatalf("failed to reload policy file: %v", err)
+	}
+	grant, err := e.GrantFor(claims, before)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if grant.PolicyVersion != f.Hash || !reflect.DeepEqual(grant.Scopes, []string{"ingest:build"}) {
+		t.Errorf("expected the grant of %s, got %+v", f.Hash, grant)
+	}
+
+	grant, err = e.Decide(context.Background(), claims)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if grant.PolicyVersion != "2026.10.1" || !reflect.DeepEqual(grant.Scopes, []string{"ingest:telemetry"}) {
+		t.Errorf("expected the grant of 2026.10.1, got %+v", grant)
+	}
+}
+
+func TestEnforcer_ReloadConcurrent(t *testing.T) {
+	name := writePolicyFile(t, testPolicyFile)
+	e := NewEnforcer(false, "main", nil, nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			if _, err := e.Reload(name); err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+		}
+	}()
+
+	claims := &types.VerifiedClaims{Repository: "myorg/deploy", Ref: "refs/heads/main"}
+	for {
+		select {
+		case <-done:
+			return
+		default:
+			_ = e.Evaluate(claims)
+			_ = e.Grant(claims)
+			_ = e.Status()
+		}
+	}
+}
+
+func TestEnforcer_DecideDryRun(t *testing.T) {
+	f, err := LoadFile(writePolicyFile(t, `{
+		"rules": [
+			{"repository": "myorg/legacy", "ref_patterns": ["refs/heads/main"], "dry_run": true},
+			{"repository": "myorg/*", "ref_patterns": ["refs/heads/main"]}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("failed to load policy file: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		dryRun     bool
+		repository string
+		wouldDeny  bool
+	}{
+		{"enforced", false, "myorg/robot", false},
+		{"global dry run", true, "myorg/robot", true},
+		{"rule dry run", false, "myorg/legacy", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEnforcer(false, "main", nil, nil, WithDryRun(tt.dryRun), WithFile(f))
+			claims := &types.VerifiedClaims{Repository: tt.repository, Ref: "refs/heads/feature"}
+
+			grant, err := e.Decide(context.Background(), claims)
+			if !tt.wouldDeny {
+				var denied *DeniedError
+				if !errors.As(err, &denied) || denied.Decision.Reason != ReasonRefNotAllowed {
+					t.Errorf("expected a %s denial, got %v", ReasonRefNotAllowed, err)
+				}
+				if got := e.Status().DryRunDenials; got != 0 {
+					t.Errorf("expected no dry-run denials, got %d", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var denied *DeniedError
+			if !errors.As(grant.WouldDeny, &denied) || denied.Decision.Reason != ReasonRefNotAllowed {
+				t.Errorf("expected a %s denial in WouldDeny, got %v", ReasonRefNotAllowed, grant.WouldDeny)
+			}
+			if !reflect.DeepEqual(grant.Scopes, []string{"ingest:build"}) {
+				t.Errorf("expected default scopes, got %v", grant.Scopes)
+			}
+			if got := e.Status().DryRunDenials; got != 1 {
+				t.Errorf("expected 1 dry-run denial, got %d", got)
+			}
+
+			// Allowed tokens are not reported
+			grant, err = e.Decide(context.Background(), &types.VerifiedClaims{Repository: tt.repository, Ref: "refs/heads/main"})
+			if err != nil || grant.WouldDeny != nil {
+				t.Errorf("expected an allowed token, got %v, %v", grant.WouldDeny, err)
+			}
+		})
+	}
+}
+
+func TestEnforcer_DecisionCounts(t *testing.T) {
+	f, err := LoadFile(writePolicyFile(t, `{
+		"rules": [
+			{"repository": "myorg/legacy", "ref_patterns": ["refs/heads/main"], "dry_run": true},
+			{"repository": "myorg/*", "ref_patterns": ["refs/heads/main"]}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("failed to load policy file: %v", err)
+	}
+	e := NewEnforcer(false, "main", nil, []string{"evil/repo"}, WithFile(f))
+
+	for _, claims := range []*types.VerifiedClaims{
+		{Repository: "myorg/robot", Ref: "refs/heads/main"},
+		{Repository: "myorg/robot", Ref: "refs/heads/main"},
+		{Repository: "myorg/robot", Ref: "refs/heads/feature"},
+		{Repository: "myorg/legacy", Ref: "refs/heads/feature"},
+		{Repository: "evil/repo", Ref: "refs/heads/main"},
+	} {
+		_, _ = e.Decide(context.Background(), claims)
+	}
+
+	// Checks are not decisions
+	e.Check(&types.VerifiedClaims{Repository: "evil/repo", Ref: "refs/heads/main"})
+
+	want := map[DecisionKey]uint64{
+		{Outcome: OutcomeAllow, Reason: ReasonAllowed}:                                        2,
+		{Outcome: OutcomeDeny, Reason: ReasonRefNotAllowed, RuleID: "file:myorg/*"}:           1,
+		{Outcome: OutcomeWouldDeny, Reason: ReasonRefNotAllowed, RuleID: "file:myorg/legacy"}: 1,
+		{Outcome: OutcomeDeny, Reason: ReasonRepoDenied, RuleID: "repo_denylist"}:             1,
+	}
+	if got := e.Status().Decisions; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected decisions %v, got %v", want, got)
+	}
+
+	t.Run("OPA unavailable", func(t *testing.T) {
+		server, _ := newOPAStub(t, http.StatusInternalServerError, ``)
+		e := NewEnforcer(false, "main", nil, nil, WithOPA(NewOPAClient(server.URL, time.Second), false))
+		_, _ = e.Decide(context.Background(), &types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/main"})
+
+		want := map[DecisionKey]uint64{{Outcome: OutcomeUnavailable, RuleID: "opa"}: 1}
+		if got := e.Status().Decisions; !reflect.DeepEqual(got, want) {
+			t.Errorf("expected decisions %v, got %v", want, got)
+		}
+	})
+}
@@ -0,0 +1,197 @@
+package policy
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	// Embed the time zone database, which the runtime image lacks
+	_ "time/tzdata"
+)
+
+// FreezeWindow denies tokens while it is active, such as during a release
+// freeze or outside business hours. A window is either absolute, from Start
+// to End as RFC3339 times with their UTC offsets, or recurring, from From to
+// To on each of Days in Timezone. Repositories and Scopes narrow the tokens
+// it freezes.
+type FreezeWindow struct {
+	// Name identifies the window in denials, as rule file:freeze:<name>
+	Name string `json:"name"`
+
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+
+	// Days are mon through sun, every day when empty. From and To are HH:MM
+	// times, the whole day when both are empty; a To before From ends the
+	// next day, so mon-fri 18:00 to 09:00 freezes every weeknight.
+	Days []string `json:"days,omitempty"`
+	From string   `json:"from,omitempty"`
+	To   string   `json:"to,omitempty"`
+
+	// Timezone is the IANA time zone of Days, From and To, UTC when empty
+	Timezone string `json:"timezone,omitempty"`
+
+	// Repositories are globs, as in Rule.Repository, matched against the
+	// token's repository; every repository when empty
+	Repositories []string `json:"repositories,omitempty"`
+
+	// Scopes freeze only tokens granted at least one of them; every token
+	// when empty
+	Scopes []string `json:"scopes,omitempty"`
+
+	// Compiled by Validate
+	compiled bool
+	start    time.Time
+	end      time.Time
+	days     [7]bool
+	from     int
+	to       int
+	location *time.Location
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func (w *FreezeWindow) compile() error {
+	if w.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+	for _, pattern := range w.Repositories {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return fmt.Errorf("invalid repository pattern %q", pattern)
+		}
+	}
+	if slices.Contains(w.Scopes, "") {
+		return fmt.Errorf("empty scope")
+	}
+
+	recurring := len(w.Days) > 0 || w.From != "" || w.To != "" || w.Timezone != ""
+	switch {
+	case w.Start != "" || w.End != "":
+		if recurring {
+			return fmt.Errorf("start and end cannot be combined with days, from, to or timezone")
+		}
+		var err error
+		if w.start, err = time.Parse(time.RFC3339, w.Start); err != nil {
+			return fmt.Errorf("invalid start %q: must be an RFC3339 time", w.Start)
+		}
+		if w.end, err = time.Parse(time.RFC3339, w.End); err != nil {
+			return fmt.Errorf("invalid end %q: must be an RFC3339 time", w.End)
+		}
+		if !w.end.After(w.start) {
+			return fmt.Errorf("end %s is not after start %s", w.End, w.Start)
+		}
+	case recurring:
+		w.days = [7]bool{}
+		for _, day := range w.Days {
+			weekday, ok := weekdays[strings.ToLower(day)]
+			if !ok {
+				return fmt.Errorf("invalid day %q: must be mon, tue, wed, thu, fri, sat or sun", day)
+			}
+			w.days[weekday] = true
+		}
+		if len(w.Days) == 0 {
+			w.days = [7]bool{true, true, true, true, true, true, true}
+		}
+		if (w.From == "") != (w.To == "") {
+			return fmt.Errorf("from and to must be set together")
+		}
+		w.from, w.to = 0, 24*60
+		if w.From != "" {
+			var err error
+			if w.from, err = parseClock(w.From); err != nil {
+				return err
+			}
+			if w.to, err = parseClock(w.To); err != nil {
+				return err
+			}
+			if w.from == w.to {
+				return fmt.Errorf("from and to are both %s", w.From)
+			}
+		}
+		location, err := time.LoadLocation(w.Timezone)
+		if err != nil {
+			return fmt.Errorf("invalid timezone %q: %w", w.Timezone, err)
+		}
+		w.location = location
+	default:
+		return fmt.Errorf("needs start and end, or days, from and to")
+	}
+	w.compiled = true
+	return nil
+}
+
+// parseClock parses an HH:MM time of day, 00:00 through 24:00, into minutes
+// since midnight
+func parseClock(s string) (int, error) {
+	if s == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil || len(s) != len("15:04") {
+		return 0, fmt.Errorf("invalid time %q: must be HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// active reports whether the window covers now
+func (w *FreezeWindow) active(now time.Time) bool {
+	if w.location == nil {
+		return !now.Before(w.start) && now.Before(w.end)
+	}
+	now = now.In(w.location)
+	minute := now.Hour()*60 + now.Minute()
+	today := now.Weekday()
+	if w.from < w.to {
+		return w.days[today] && minute >= w.from && minute < w.to
+	}
+	yesterday := (today + 6) % 7
+	return (w.days[today] && minute >= w.from) || (w.days[yesterday] && minute < w.to)
+}
+
+// freezes reports whether the window applies to a token for repository
+// granted scopes
+func (w *FreezeWindow) freezes(repository string, scopes []string) bool {
+	if len(w.Repositories) > 0 && !slices.ContainsFunc(w.Repositories, func(pattern string) bool {
+		ok, _ := path.Match(pattern, repository)
+		return ok
+	}) {
+		return false
+	}
+	return len(w.Scopes) == 0 || slices.ContainsFunc(w.Scopes, func(scope string) bool {
+		return slices.Contains(scopes, scope)
+	})
+}
+
+// checkFreezes denies a token for repository granted scopes while a freeze
+// window covering it is active
+func (f *File) checkFreezes(repository string, scopes []string, now time.Time) Decision {
+	if f == nil {
+		return allowed
+	}
+	for i := range f.FreezeWindows {
+		w := &f.FreezeWindows[i]
+		ruleID := "file:freeze:" + w.Name
+		// A File that skipped Validate has no compiled windows; fail closed
+		if !w.compiled {
+			return deny(ruleID, ReasonFreezeWindow, "freeze window %s was not compiled", w.Name)
+		}
+		if !w.freezes(repository, scopes) || !w.active(now) {
+			continue
+		}
+		if len(w.Scopes) > 0 {
+			return deny(ruleID, ReasonFreezeWindow, "tokens with scopes %s for repository %s are frozen by freeze window %s", strings.Join(w.Scopes, ", "), repository, w.Name)
+		}
+		return deny(ruleID, ReasonFreezeWindow, "tokens for repository %s are frozen by freeze window %s", repository, w.Name)
+	}
+	return allowed
+}
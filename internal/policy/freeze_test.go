@@ -0,0 +1,136 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robohub/auth-service/internal/testutil"
+	"github.com/robohub/auth-service/internal/types"
+)
+
+func TestFreezeWindow_Validate(t *testing.T) {
+	tests := []struct {
+		name          string
+		window        string
+		errorContains string
+	}{
+		{"absolute", `{"name": "release", "start": "2026-12-18T18:00:00+01:00", "end": "2027-01-04T09:00:00+01:00"}`, ""},
+		{"recurring", `{"name": "nights", "days": ["mon", "Fri"], "from": "18:00", "to": "09:00", "timezone": "Europe/Berlin"}`, ""},
+		{"whole days", `{"name": "weekends", "days": ["sat", "sun"]}`, ""},
+		{"until midnight", `{"name": "evenings", "from": "18:00", "to": "24:00"}`, ""},
+		{"missing name", `{"days": ["sat"]}`, "freeze_windows[0]: missing name"},
+		{"no times", `{"name": "never"}`, "needs start and end"},
+		{"missing end", `{"name": "release", "start": "2026-12-18T18:00:00Z"}`, "invalid end \"\""},
+		{"no offset", `{"name": "release", "start": "2026-12-18T18:00:00", "end": "2027-01-04T09:00:00Z"}`, "must be an RFC3339 time"},
+		{"end before start", `{"name": "release", "start": "2027-01-04T09:00:00Z", "end": "2026-12-18T18:00:00Z"}`, "is not after start"},
+		{"mixed", `{"name": "release", "start": "2026-12-18T18:00:00Z", "end": "2027-01-04T09:00:00Z", "timezone": "UTC"}`, "cannot be combined"},
+		{"invalid day", `{"name": "nights", "days": ["monday"]}`, "invalid day \"monday\""},
+		{"from without to", `{"name": "nights", "from": "18:00"}`, "must be set together"},
+		{"invalid time", `{"name": "nights", "from": "6pm", "to": "09:00"}`, "invalid time \"6pm\""},
+		{"empty window", `{"name": "nights", "from": "09:00", "to": "09:00"}`, "both 09:00"},
+		{"invalid timezone", `{"name": "nights", "days": ["sat"], "timezone": "Mars/Olympus"}`, "invalid timezone"},
+		{"invalid repository", `{"name": "nights", "days": ["sat"], "repositories": ["myorg/["]}`, "invalid repository pattern"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadFile(writePolicyFile(t, `{"freeze_windows": [`+tt.window+`]}`))
+			if tt.errorContains == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+				t.Errorf("expected error to contain %q, got %v", tt.errorContains, err)
+			}
+		})
+	}
+}
+
+func TestEnforcer_FreezeWindows(t *testing.T) {
+	f, err := LoadFile(writePolicyFile(t, `{
+		"freeze_windows": [
+			{"name": "release", "start": "2026-12-18T18:00:00+01:00", "end": "2027-01-04T09:00:00+01:00", "repositories": ["myorg/*"]},
+			{"name": "nights", "days": ["mon", "tue", "wed", "thu", "fri"], "from": "18:00", "to": "09:00",
+			 "timezone": "America/New_York", "scopes": ["deploy"]}
+		],
+		"rules": [{"repository": "myorg/deploy", "scopes": ["read", "deploy"]}]
+	}`))
+	if err != nil {
+		t.Fatalf("failed to load policy file: %v", err)
+	}
+	clk := testutil.NewFakeClock(time.Now())
+	e := NewEnforcer(false, "main", nil, nil, WithFile(f), WithClock(clk))
+
+	tests := []struct {
+		name       string
+		repository string
+		now        string
+		wantRuleID string
+	}{
+		// 17:00 UTC is 18:00 in Berlin
+		{"before the release freeze", "myorg/robot", "2026-12-18T16:59:59Z", ""},
+		{"release freeze starts", "myorg/robot", "2026-12-18T17:00:00Z", "file:freeze:release"},
+		{"during the release freeze", "myorg/robot", "2026-12-25T12:00:00+09:00", "file:freeze:release"},
+		{"release freeze ends", "myorg/robot", "2027-01-04T08:00:00Z", ""},
+		{"other owner", "otherorg/robot", "2026-12-25T12:00:00Z", ""},
+
+		// New York is UTC-4 in October and UTC-5 in November
+		{"weekday afternoon", "myorg/deploy", "2026-10-14T21:59:00Z", ""},
+		{"weekday evening", "myorg/deploy", "2026-10-14T22:00:00Z", "file:freeze:nights"},
+		{"after midnight", "myorg/deploy", "2026-10-15T12:59:00Z", "file:freeze:nights"},
+		{"next morning", "myorg/deploy", "2026-10-15T13:00:00Z", ""},
+		{"after daylight saving ends", "myorg/deploy", "2026-11-04T22:30:00Z", ""},
+		{"evening after daylight saving ends", "myorg/deploy", "2026-11-04T23:00:00Z", "file:freeze:nights"},
+		{"friday night runs into saturday", "myorg/deploy", "2026-10-17T12:00:00Z", "file:freeze:nights"},
+		{"saturday night is not frozen", "myorg/deploy", "2026-10-17T23:00:00Z", ""},
+		{"monday morning after the weekend", "myorg/deploy", "2026-10-19T12:00:00Z", ""},
+		{"tokens without the frozen scopes", "myorg/robot", "2026-10-14T23:00:00Z", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now, err := time.Parse(time.RFC3339, tt.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clk.Set(now)
+
+			_, err = e.Decide(context.Background(), &types.VerifiedClaims{Repository: tt.repository, Ref: "refs/heads/main"})
+			if tt.wantRuleID == "" {
+				if err != nil {
+					t.Errorf("expected allowed, got %v", err)
+				}
+				return
+			}
+			var denied *DeniedError
+			if !errors.As(err, &denied) || denied.Decision.Reason != ReasonFreezeWindow || denied.Decision.RuleID != tt.wantRuleID {
+				t.Errorf("expected %s by %s, got %v", ReasonFreezeWindow, tt.wantRuleID, err)
+			}
+		})
+	}
+
+	t.Run("reduced scopes without the frozen scopes", func(t *testing.T) {
+		clk.Set(time.Date(2026, 10, 14, 23, 0, 0, 0, time.UTC))
+		e := NewEnforcer(true, "main", nil, nil, WithFile(f), WithClock(clk), WithReducedScopes([]string{"read"}))
+		grant, err := e.Decide(context.Background(), &types.VerifiedClaims{Repository: "myorg/deploy", Ref: "refs/heads/feature"})
+		if err != nil {
+			t.Fatalf("expected the reduced token to be allowed, got %v", err)
+		}
+		if len(grant.Scopes) != 1 || grant.Scopes[0] != "read" {
+			t.Errorf("expected scopes [read], got %v", grant.Scopes)
+		}
+	})
+
+	t.Run("uncompiled windows deny", func(t *testing.T) {
+		e := NewEnforcer(false, "main", nil, nil, WithFile(&File{FreezeWindows: []FreezeWindow{{Name: "release"}}}))
+		_, err := e.GrantFor(&types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/main"}, allowed)
+		if err == nil {
+			t.Error("expected uncompiled freeze windows to deny")
+		}
+	})
+}
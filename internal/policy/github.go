@@ -0,0 +1,176 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/robohub/auth-service/internal/clock"
+)
+
+// ErrGitHubUnavailable marks a repository lookup that failed, because the
+// GitHub API could not be reached, timed out, or answered with an error
+var ErrGitHubUnavailable = errors.New("GitHub repository lookup unavailable")
+
+// maxGitHubResponseSize bounds the GitHub API response body read into memory
+const maxGitHubResponseSize = 1 << 20
+
+// GitHubClient looks up repositories in the GitHub REST API, caching the
+// results so that token exchanges do not each cost an API request. One client
+// may serve several checks, which then share its cache.
+type GitHubClient struct {
+	baseURL    string
+	token      string
+	timeout    time.Duration
+	cacheTTL   time.Duration
+	httpClient *http.Client
+	clock      clock.Clock
+
+	mu    sync.Mutex
+	cache map[string]repoInfo
+
+	hits     atomic.Uint64
+	misses   atomic.Uint64
+	failures atomic.Uint64
+}
+
+// repoInfo is a cached repository lookup
+type repoInfo struct {
+	archived      bool
+	defaultBranch string
+	expires       time.Time
+}
+
+// GitHubStats counts repository lookups
+type GitHubStats struct {
+	// CacheHits were answered from the cache and CacheMisses with an API
+	// request, of which Failures failed
+	CacheHits   uint64
+	CacheMisses uint64
+	Failures    uint64
+}
+
+// NewGitHubClient creates a client for the REST API at baseURL, such as
+// https://api.github.com, authenticating with token. Each lookup must
+// complete within timeout, and its result is reused for cacheTTL.
+func NewGitHubClient(baseURL, token string, timeout, cacheTTL time.Duration) *GitHubClient {
+	return &GitHubClient{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		token:      token,
+		timeout:    timeout,
+		cacheTTL:   cacheTTL,
+		httpClient: &http.Client{},
+		clock:      clock.Real{},
+		cache:      make(map[string]repoInfo),
+	}
+}
+
+// Archived reports whether the repository, given as owner/name, is archived.
+// Failures wrap ErrGitHubUnavailable and are not cached.
+func (c *GitHubClient) Archived(ctx context.Context, repository string) (bool, error) {
+	info, err := c.lookup(ctx, repository)
+	return info.archived, err
+}
+
+// DefaultBranch returns the name of the repository's default branch, such as
+// main. Failures wrap ErrGitHubUnavailable and are not cached.
+func (c *GitHubClient) DefaultBranch(ctx context.Context, repository string) (string, error) {
+	info, err := c.lookup(ctx, repository)
+	return info.defaultBranch, err
+}
+
+// Flush empties the cache, so that the next lookup of every repository asks
+// the API
+func (c *GitHubClient) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.cache)
+}
+
+// Stats reports the lookups made so far
+func (c *GitHubClient) Stats() GitHubStats {
+	return GitHubStats{
+		CacheHits:   c.hits.Load(),
+		CacheMisses: c.misses.Load(),
+		Failures:    c.failures.Load(),
+	}
+}
+
+// lookup returns the repository from the cache, or from the API when it is
+// not cached or has expired
+func (c *GitHubClient) lookup(ctx context.Context, repository string) (repoInfo, error) {
+	now := c.clock.Now()
+	c.mu.Lock()
+	info, ok := c.cache[repository]
+	c.mu.Unlock()
+	if ok && now.Before(info.expires) {
+		c.hits.Add(1)
+		return info, nil
+	}
+
+	c.misses.Add(1)
+	info, err := c.fetch(ctx, repository)
+	if err != nil {
+		c.failures.Add(1)
+		return repoInfo{}, err
+	}
+	info.expires = now.Add(c.cacheTTL)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, cached := range c.cache {
+		if !now.Before(cached.expires) {
+			delete(c.cache, name)
+		}
+	}
+	c.cache[repository] = info
+	return info, nil
+}
+
+func (c *GitHubClient) fetch(ctx context.Context, repository string) (repoInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/repos/"+repository, nil)
+	if err != nil {
+		return repoInfo{}, fmt.Errorf("%w: %v", ErrGitHubUnavailable, err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return repoInfo{}, fmt.Errorf("%w: request for %s timed out after %s", ErrGitHubUnavailable, repository, c.timeout)
+		}
+		return repoInfo{}, fmt.Errorf("%w: %v", ErrGitHubUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	// A repository the token cannot see is a 404 too, so it is not taken
+	// to mean the repository is gone
+	if resp.StatusCode != http.StatusOK {
+		return repoInfo{}, fmt.Errorf("%w: unexpected status %d for repository %s", ErrGitHubUnavailable, resp.StatusCode, repository)
+	}
+
+	var repo struct {
+		Archived      *bool  `json:"archived"`
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxGitHubResponseSize)).Decode(&repo); err != nil {
+		return repoInfo{}, fmt.Errorf("%w: invalid response for repository %s: %v", ErrGitHubUnavailable, repository, err)
+	}
+	if repo.Archived == nil || repo.DefaultBranch == "" {
+		return repoInfo{}, fmt.Errorf("%w: response for repository %s has no archived or default_branch field", ErrGitHubUnavailable, repository)
+	}
+	return repoInfo{archived: *repo.Archived, defaultBranch: repo.DefaultBranch}, nil
+}
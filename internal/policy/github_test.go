@@ -0,0 +1,287 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/robohub/auth-service/internal/testutil"
+	"github.com/robohub/auth-service/internal/types"
+)
+
+// newGitHubStub serves the repositories API from repos, keyed by owner/name,
+// counting the requests it receives. Unknown repositories are not found.
+func newGitHubStub(t *testing.T, repos map[string]string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, ok := repos[strings.TrimPrefix(r.URL.Path, "/repos/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "Not Found"}`))
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestGitHubClient_Archived(t *testing.T) {
+	server, requests := newGitHubStub(t, map[string]string{
+		"myorg/robot":  `{"full_name": "myorg/robot", "archived": false, "default_branch": "main"}`,
+		"myorg/legacy": `{"full_name": "myorg/legacy", "archived": true, "default_branch": "master"}`,
+		"myorg/broken": `{"full_name": "myorg/broken", "default_branch": "main"}`,
+		"myorg/html":   `<html>`,
+	})
+
+	tests := []struct {
+		name          string
+		repository    string
+		want          bool
+		errorContains string
+	}{
+		{"active", "myorg/robot", false, ""},
+		{"archived", "myorg/legacy", true, ""},
+		{"not found", "myorg/missing", false, "unexpected status 404"},
+		{"no archived field", "myorg/broken", false, "no archived or default_branch field"},
+		{"invalid response", "myorg/html", false, "invalid response"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewGitHubClient(server.URL+"/", "test-token", time.Second, time.Minute)
+			got, err := client.Archived(context.Background(), tt.repository)
+			if tt.errorContains != "" {
+				if !errors.Is(err, ErrGitHubUnavailable) || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("expected ErrGitHubUnavailable containing %q, got %v", tt.errorContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected archived=%v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("caches results", func(t *testing.T) {
+		clk := testutil.NewFakeClock(time.Now())
+		client := NewGitHubClient(server.URL, "test-token", time.Second, time.Minute)
+		client.clock = clk
+		requests.Store(0)
+
+		for i := 0; i < 3; i++ {
+			if _, err := client.Archived(context.Background(), "myorg/legacy"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if got := requests.Load(); got != 1 {
+			t.Errorf("expected 1 request while cached, got %d", got)
+		}
+
+		clk.Advance(time.Minute)
+		if _, err := client.Archived(context.Background(), "myorg/legacy"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := requests.Load(); got != 2 {
+			t.Errorf("expected a new request once the cache expired, got %d", got)
+		}
+	})
+
+	t.Run("does not cache failures", func(t *testing.T) {
+		client := NewGitHubClient(server.URL, "test-token", time.Second, time.Minute)
+		requests.Store(0)
+
+		for i := 0; i < 2; i++ {
+			if _, err := client.Archived(context.Background(), "myorg/missing"); err == nil {
+				t.Fatal("expected error")
+			}
+		}
+		if got := requests.Load(); got != 2 {
+			t.Errorf("expected every failed lookup to be retried, got %d requests", got)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}))
+		defer server.Close()
+		defer close(release)
+
+		_, err := NewGitHubClient(server.URL, "test-token", 50*time.Millisecond, time.Minute).Archived(context.Background(), "myorg/robot")
+		if !errors.Is(err, ErrGitHubUnavailable) || !strings.Contains(err.Error(), "timed out after 50ms") {
+			t.Errorf("expected timeout, got %v", err)
+		}
+	})
+}
+
+func TestEnforcer_ArchivedCheck(t *testing.T) {
+	server, requests := newGitHubStub(t, map[string]string{
+		"myorg/robot":  `{"archived": false, "default_branch": "main"}`,
+		"myorg/legacy": `{"archived": true, "default_branch": "main"}`,
+	})
+
+	tests := []struct {
+		name        string
+		repository  string
+		ref         string
+		failOpen    bool
+		dryRun      bool
+		wantReason  Reason
+		unavailable bool
+		wouldDeny   bool
+	}{
+		{"active", "myorg/robot", "refs/heads/main", false, false, "", false, false},
+		{"archived", "myorg/legacy", "refs/heads/main", false, false, ReasonRepoArchived, false, false},
+		{"archived in dry run", "myorg/legacy", "refs/heads/main", false, true, "", false, true},
+		{"fail closed", "myorg/missing", "refs/heads/main", false, false, "", true, false},
+		{"fail open", "myorg/missing", "refs/heads/main", true, false, "", false, false},
+		// Denied tokens do not cost a lookup
+		{"denied first", "myorg/legacy", "refs/heads/feature", false, false, ReasonBranchNotAllowed, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests.Store(0)
+			e := NewEnforcer(true, "main", nil, nil,
+				WithArchivedCheck(NewGitHubClient(server.URL, "test-token", time.Second, time.Minute), tt.failOpen),
+				WithDryRun(tt.dryRun),
+			)
+
+			grant, err := e.Decide(context.Background(), &types.VerifiedClaims{Repository: tt.repository, Ref: tt.ref})
+			if IsUnavailable(err) != tt.unavailable {
+				t.Fatalf("expected unavailable=%v, got %v", tt.unavailable, err)
+			}
+			var denied *DeniedError
+			if errors.As(err, &denied) != (tt.wantReason != "") || (denied != nil && denied.Decision.Reason != tt.wantReason) {
+				t.Fatalf("expected denial %q, got %v", tt.wantReason, err)
+			}
+			if denied != nil && denied.Decision.Reason == ReasonRepoArchived && denied.Decision.RuleID != "archived" {
+				t.Errorf("expected rule archived, got %q", denied.Decision.RuleID)
+			}
+			if (grant.WouldDeny != nil) != tt.wouldDeny {
+				t.Errorf("expected would deny=%v, got %v", tt.wouldDeny, grant.WouldDeny)
+			}
+			if tt.wantReason == ReasonBranchNotAllowed && requests.Load() != 0 {
+				t.Errorf("expected no GitHub API requests for a denied token, got %d", requests.Load())
+			}
+		})
+	}
+}
+
+func TestEnforcer_DefaultBranchLookup(t *testing.T) {
+	server, requests := newGitHubStub(t, map[string]string{
+		"myorg/robot":  `{"archived": false, "default_branch": "main"}`,
+		"myorg/legacy": `{"archived": false, "default_branch": "master"}`,
+	})
+	clk := testutil.NewFakeClock(time.Now())
+	client := NewGitHubClient(server.URL, "test-token", time.Second, time.Minute)
+	client.clock = clk
+	e := NewEnforcer(true, "main", nil, nil, WithAllowedBranches([]string{"trunk"}), WithDefaultBranchLookup(client))
+
+	decide := func(t *testing.T, repository, ref string) Reason {
+		t.Helper()
+		_, err := e.Decide(context.Background(), &types.VerifiedClaims{Repository: repository, Ref: ref})
+		var denied *DeniedError
+		if errors.As(err, &denied) {
+			return denied.Decision.Reason
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return ReasonAllowed
+	}
+
+	t.Run("cache miss", func(t *testing.T) {
+		if got := decide(t, "myorg/legacy", "refs/heads/master"); got != ReasonAllowed {
+			t.Errorf("expected the repository's default branch to be allowed, got %s", got)
+		}
+		if got := decide(t, "myorg/robot", "refs/heads/master"); got != ReasonBranchNotAllowed {
+			t.Errorf("expected another repository's default branch to be denied, got %s", got)
+		}
+		if got := requests.Load(); got != 2 {
+			t.Errorf("expected 2 requests, got %d", got)
+		}
+	})
+
+	t.Run("cache hit", func(t *testing.T) {
+		if got := decide(t, "myorg/legacy", "refs/heads/main"); got != ReasonBranchNotAllowed {
+			t.Errorf("expected a branch other than the default to be denied, got %s", got)
+		}
+		if got := requests.Load(); got != 2 {
+			t.Errorf("expected the lookup to be cached, got %d requests", got)
+		}
+	})
+
+	t.Run("check", func(t *testing.T) {
+		d := e.CheckContext(context.Background(), &types.VerifiedClaims{Repository: "myorg/legacy", Ref: "refs/heads/master"})
+		if !d.Allowed {
+			t.Errorf("expected the check to allow the repository's default branch, got %s", d.Reason)
+		}
+		if got := requests.Load(); got != 2 {
+			t.Errorf("expected the check to share the cache, got %d requests", got)
+		}
+	})
+
+	t.Run("API failure falls back to the configured branches", func(t *testing.T) {
+		if got := decide(t, "myorg/missing", "refs/heads/trunk"); got != ReasonAllowed {
+			t.Errorf("expected the configured branch to be allowed, got %s", got)
+		}
+		if got := decide(t, "myorg/missing", "refs/heads/main"); got != ReasonBranchNotAllowed {
+			t.Errorf("expected other branches to be denied, got %s", got)
+		}
+	})
+
+	t.Run("expiry and reload refresh the cache", func(t *testing.T) {
+		clk.Advance(time.Minute)
+		decide(t, "myorg/legacy", "refs/heads/master")
+		if got := requests.Load(); got != 5 {
+			t.Errorf("expected an expired lookup to be refreshed, got %d requests", got)
+		}
+
+		if _, err := e.Reload(writePolicyFile(t, `{}`)); err != nil {
+			t.Fatalf("failed to reload policy file: %v", err)
+		}
+		decide(t, "myorg/legacy", "refs/heads/master")
+		if got := requests.Load(); got != 6 {
+			t.Errorf("expected a reload to flush the cache, got %d requests", got)
+		}
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		want := GitHubStats{CacheHits: 2, CacheMisses: 6, Failures: 2}
+		if got := e.Status().GitHubLookups; got != want {
+			t.Errorf("expected %+v, got %+v", want, got)
+		}
+	})
+
+	t.Run("only with the default branch check on", func(t *testing.T) {
+		requests.Store(0)
+		e := NewEnforcer(false, "main", nil, nil, WithDefaultBranchLookup(NewGitHubClient(server.URL, "test-token", time.Second, time.Minute)))
+		if _, err := e.Decide(context.Background(), &types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/feature"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := requests.Load(); got != 0 {
+			t.Errorf("expected no lookups, got %d", got)
+		}
+	})
+}
@@ -0,0 +1,166 @@
+package policy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/robohub/auth-service/internal/types"
+)
+
+// RepoLists are the repositories read from the allow and deny list files
+type RepoLists struct {
+	Allow []string
+	Deny  []string
+
+	allow map[string]bool
+	deny  map[string]bool
+
+	// versions are those of the files the lists were read from
+	versions [2]fileVersion
+}
+
+// allows and denies are safe to call on nil lists, which list nothing
+func (l *RepoLists) allows(claims *types.VerifiedClaims) bool {
+	return l != nil && listed(l.allow, claims)
+}
+
+func (l *RepoLists) denies(claims *types.VerifiedClaims) bool {
+	return l != nil && listed(l.deny, claims)
+}
+
+// WithRepoListFiles adds the repositories listed in the files at allowFile
+// and denyFile, either of which may be empty for none, to the allow and deny
+// lists. The files are read by ReloadRepoLists, and tokens are denied until
+// it first succeeds. An allowlist file listing no repositories allows none.
+func WithRepoListFiles(allowFile, denyFile string) Option {
+	return func(e *Enforcer) {
+		e.allowListFile = allowFile
+		e.denyListFile = denyFile
+	}
+}
+
+// LoadRepoList reads a newline-delimited list of repositories, as
+// owner/name or as id:<repository_id>. Blank lines and # comments are
+// skipped.
+func LoadRepoList(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read repository list: %w", err)
+	}
+	defer f.Close()
+
+	repos := []string{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		repo, _, _ := strings.Cut(scanner.Text(), "#")
+		repo = strings.TrimSpace(repo)
+		if repo == "" {
+			continue
+		}
+		if id, ok := strings.CutPrefix(repo, "id:"); ok {
+			if !isRepositoryID(id) {
+				return nil, fmt.Errorf("invalid repository list %s:%d: %q is not id:<repository_id>", name, line, repo)
+			}
+			repos = append(repos, repo)
+			continue
+		}
+		if strings.Count(repo, "/") != 1 || strings.HasPrefix(repo, "/") || strings.HasSuffix(repo, "/") || strings.ContainsAny(repo, " \t") {
+			return nil, fmt.Errorf("invalid repository list %s:%d: %q is not owner/name", name, line, repo)
+		}
+		repos = append(repos, repo)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read repository list %s: %w", name, err)
+	}
+	return repos, nil
+}
+
+// ReloadRepoLists reads the WithRepoListFiles files and swaps their
+// repositories in for subsequent evaluations. When either file cannot be read
+// or parsed the current lists stay in place.
+func (e *Enforcer) ReloadRepoLists() (*RepoLists, error) {
+	lists := &RepoLists{allow: make(map[string]bool), deny: make(map[string]bool), versions: e.repoListVersions()}
+	for _, list := range []struct {
+		name  string
+		repos *[]string
+		set   map[string]bool
+	}{
+		{e.allowListFile, &lists.Allow, lists.allow},
+		{e.denyListFile, &lists.Deny, lists.deny},
+	} {
+		if list.name == "" {
+			continue
+		}
+		repos, err := LoadRepoList(list.name)
+		if err != nil {
+			e.reloadFailures.Add(1)
+			return nil, err
+		}
+		*list.repos = repos
+		for _, repo := range repos {
+			list.set[repo] = true
+		}
+	}
+	e.repoLists.Store(lists)
+	e.reloads.Add(1)
+	return lists, nil
+}
+
+// WatchRepoLists reloads the WithRepoListFiles files whenever one's size or
+// modification time differs from the loaded lists', checking every interval
+// until ctx is done. A change is only read once it has held for an interval,
+// so that a file is not read while it is being written. Failed reloads are
+// logged and keep the current lists until the next change.
+func (e *Enforcer) WatchRepoLists(ctx context.Context, interval time.Duration) {
+	var pending, failed [2]fileVersion
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		versions := e.repoListVersions()
+		if lists := e.repoLists.Load(); (lists != nil && versions == lists.versions) || versions == failed {
+			continue
+		}
+		if versions != pending {
+			pending = versions
+			continue
+		}
+		lists, err := e.ReloadRepoLists()
+		if err != nil {
+			failed = versions
+			e.logger.Error("failed to reload repository lists, keeping the current lists", "error", err)
+			continue
+		}
+		e.logger.Info("reloaded repository lists", "allowlist", len(lists.Allow), "denylist", len(lists.Deny))
+	}
+}
+
+// fileVersion identifies the contents of a file without reading it; the zero
+// value stands for a missing file
+type fileVersion struct {
+	size    int64
+	modTime int64
+}
+
+func (e *Enforcer) repoListVersions() [2]fileVersion {
+	var versions [2]fileVersion
+	for i, name := range []string{e.allowListFile, e.denyListFile} {
+		if name == "" {
+			continue
+		}
+		if info, err := os.Stat(name); err == nil {
+			versions[i] = fileVersion{size: info.Size(), modTime: info.ModTime().UnixNano()}
+		}
+	}
+	return versions
+}
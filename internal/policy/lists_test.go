@@ -0,0 +1,186 @@
+package policy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robohub/auth-service/internal/types"
+)
+
+func writeRepoList(t *testing.T, name, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write repository list: %v", err)
+	}
+}
+
+func TestLoadRepoList(t *testing.T) {
+	tests := []struct {
+		name          string
+		content       string
+		want          []string
+		errorContains string
+	}{
+		{"repositories", "myorg/robot\nmyorg/legacy\n", []string{"myorg/robot", "myorg/legacy"}, ""},
+		{"comments and blank lines", "# retired\nmyorg/legacy # since 2025\n\n  myorg/old  \r\n", []string{"myorg/legacy", "myorg/old"}, ""},
+		{"empty", "# nothing yet\n", []string{}, ""},
+		{"owner only", "myorg/robot\nmyorg\n", nil, ":2: \"myorg\" is not owner/name"},
+		{"too many slashes", "myorg/robot/extra", nil, ":1: \"myorg/robot/extra\" is not owner/name"},
+		{"space", "myorg/robot\n\nmyorg/old robot", nil, ":3: \"myorg/old robot\" is not owner/name"},
+		{"repository ids", "id:123456 # myorg/robot\nmyorg/robot\n", []string{"id:123456", "myorg/robot"}, ""},
+		{"invalid id", "id:robot", nil, ":1: \"id:robot\" is not id:<repository_id>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "repos.txt")
+			writeRepoList(t, name, tt.content)
+
+			got, err := LoadRepoList(name)
+			if tt.errorContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("expected error to contain %q, got %v", tt.errorContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := LoadRepoList(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestEnforcer_ReloadRepoLists(t *testing.T) {
+	dir := t.TempDir()
+	allowFile, denyFile := filepath.Join(dir, "allow.txt"), filepath.Join(dir, "deny.txt")
+	writeRepoList(t, allowFile, "myorg/robot\nmyorg/legacy\n")
+	writeRepoList(t, denyFile, "myorg/legacy\n")
+	e := NewEnforcer(false, "main", []string{"myorg/env"}, []string{"myorg/blocked"}, WithRepoListFiles(allowFile, denyFile))
+
+	reason := func(repository string) Reason {
+		return e.Check(&types.VerifiedClaims{Repository: repository, Ref: "refs/heads/main"}).Reason
+	}
+
+	if got := reason("myorg/robot"); got != ReasonRepoDenied {
+		t.Errorf("expected tokens to be denied before the lists load, got %s", got)
+	}
+
+	if _, err := e.ReloadRepoLists(); err != nil {
+		t.Fatalf("failed to load repository lists: %v", err)
+	}
+	for repository, want := range map[string]Reason{
+		"myorg/robot":   ReasonAllowed,
+		"myorg/env":     ReasonAllowed,
+		"myorg/legacy":  ReasonRepoDenied,
+		"myorg/blocked": ReasonRepoDenied,
+		"myorg/other":   ReasonNotInAllowlist,
+	} {
+		if got := reason(repository); got != want {
+			t.Errorf("expected %s for %s, got %s", want, repository, got)
+		}
+	}
+
+	t.Run("reload", func(t *testing.T) {
+		writeRepoList(t, denyFile, "myorg/robot\n")
+		lists, err := e.ReloadRepoLists()
+		if err != nil {
+			t.Fatalf("failed to reload repository lists: %v", err)
+		}
+		if !reflect.DeepEqual(lists.Deny, []string{"myorg/robot"}) {
+			t.Errorf("expected the new denylist, got %v", lists.Deny)
+		}
+		if got := reason("myorg/robot"); got != ReasonRepoDenied {
+			t.Errorf("expected myorg/robot to be denied after reload, got %s", got)
+		}
+		if got := reason("myorg/legacy"); got != ReasonAllowed {
+			t.Errorf("expected myorg/legacy to be allowed after reload, got %s", got)
+		}
+	})
+
+	t.Run("bad file keeps the current lists", func(t *testing.T) {
+		before := e.Status()
+		writeRepoList(t, allowFile, "myorg/robot\nmyorg/legacy\nnot a repository\n")
+		writeRepoList(t, denyFile, "")
+		_, err := e.ReloadRepoLists()
+		if err == nil || !strings.Contains(err.Error(), "allow.txt:3:") {
+			t.Fatalf("expected an error naming line 3, got %v", err)
+		}
+		if got := reason("myorg/robot"); got != ReasonRepoDenied {
+			t.Errorf("expected the previous denylist to stay in place, got %s", got)
+		}
+		if got := e.Status().ReloadFailures; got != before.ReloadFailures+1 {
+			t.Errorf("expected a reload failure to be counted, got %d", got)
+		}
+	})
+
+	t.Run("empty allowlist allows none", func(t *testing.T) {
+		writeRepoList(t, allowFile, "# emptied\n")
+		if _, err := e.ReloadRepoLists(); err != nil {
+			t.Fatalf("failed to reload repository lists: %v", err)
+		}
+		if got := reason("myorg/robot"); got != ReasonNotInAllowlist {
+			t.Errorf("expected %s, got %s", ReasonNotInAllowlist, got)
+		}
+	})
+}
+
+func TestEnforcer_WatchRepoLists(t *testing.T) {
+	denyFile := filepath.Join(t.TempDir(), "deny.txt")
+	writeRepoList(t, denyFile, "myorg/legacy\n")
+	e := NewEnforcer(false, "main", nil, nil, WithRepoListFiles("", denyFile))
+	if _, err := e.ReloadRepoLists(); err != nil {
+		t.Fatalf("failed to load repository lists: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.WatchRepoLists(ctx, 10*time.Millisecond)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	denied := func(repository string) bool {
+		return !e.Check(&types.VerifiedClaims{Repository: repository, Ref: "refs/heads/main"}).Allowed
+	}
+	waitFor := func(t *testing.T, condition func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !condition() {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for the repository lists to reload")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// The size differs, so the change is seen even if the modification time
+	// is not
+	writeRepoList(t, denyFile, "myorg/legacy\nmyorg/robot\n")
+	waitFor(t, func() bool { return denied("myorg/robot") })
+
+	reloads := e.Status().ReloadFailures
+	writeRepoList(t, denyFile, "myorg/legacy\nmyorg/robot\nbroken\n")
+	waitFor(t, func() bool { return e.Status().ReloadFailures > reloads })
+	if !denied("myorg/robot") || !denied("myorg/legacy") {
+		t.Error("expected a bad file to keep the current denylist")
+	}
+}
@@ -0,0 +1,138 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/robohub/auth-service/internal/types"
+)
+
+// ErrOPAUnavailable marks an OPA decision that could not be made, because
+// the request failed, timed out, or the response was unusable
+var ErrOPAUnavailable = errors.New("OPA policy decision unavailable")
+
+// maxOPAResponseSize bounds the OPA response body read into memory
+const maxOPAResponseSize = 1 << 20
+
+// OPAClient asks an Open Policy Agent server for token exchange decisions
+// through its data API
+type OPAClient struct {
+	url        string
+	timeout    time.Duration
+	httpClient *http.Client
+}
+
+// NewOPAClient creates a client for the decision document at url, such as
+// http://localhost:8181/v1/data/robohub/authz. Each decision must complete
+// within timeout.
+func NewOPAClient(url string, timeout time.Duration) *OPAClient {
+	return &OPAClient{
+		url:        url,
+		timeout:    timeout,
+		httpClient: &http.Client{},
+	}
+}
+
+// opaResult is the decision document. A bare boolean is read as allow.
+type opaResult struct {
+	Allow      bool     `json:"allow"`
+	Reason     string   `json:"reason"`
+	Scopes     []string `json:"scopes"`
+	TTLSeconds int      `json:"ttl_seconds"`
+}
+
+// Decide posts the verified claims to OPA as input and returns the grant of
+// an allowed token. A denial is returned as a *DeniedError carrying OPA's
+// reason; failures to reach a decision wrap ErrOPAUnavailable.
+func (c *OPAClient) Decide(ctx context.Context, claims *types.VerifiedClaims) (Grant, error) {
+	body, err := json.Marshal(map[string]interface{}{"input": opaInput(claims)})
+	if err != nil {
+		return Grant{}, fmt.Errorf("%w: failed to encode input: %v", ErrOPAUnavailable, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return Grant{}, fmt.Errorf("%w: %v", ErrOPAUnavailable, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return Grant{}, fmt.Errorf("%w: request timed out after %s", ErrOPAUnavailable, c.timeout)
+		}
+		return Grant{}, fmt.Errorf("%w: %v", ErrOPAUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Grant{}, fmt.Errorf("%w: unexpected status %d", ErrOPAUnavailable, resp.StatusCode)
+	}
+
+	var document struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxOPAResponseSize)).Decode(&document); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return Grant{}, fmt.Errorf("%w: request timed out after %s", ErrOPAUnavailable, c.timeout)
+		}
+		return Grant{}, fmt.Errorf("%w: invalid response: %v", ErrOPAUnavailable, err)
+	}
+	// OPA omits the result when the decision document is undefined, which
+	// usually means the URL names the wrong package
+	if len(document.Result) == 0 {
+		return Grant{}, fmt.Errorf("%w: decision at %s is undefined", ErrOPAUnavailable, c.url)
+	}
+
+	var result opaResult
+	if err := json.Unmarshal(document.Result, &result.Allow); err != nil {
+		if err := json.Unmarshal(document.Result, &result); err != nil {
+			return Grant{}, fmt.Errorf("%w: invalid result: %v", ErrOPAUnavailable, err)
+		}
+	}
+	if result.TTLSeconds < 0 {
+		return Grant{}, fmt.Errorf("%w: negative ttl_seconds %d", ErrOPAUnavailable, result.TTLSeconds)
+	}
+
+	if !result.Allow {
+		if result.Reason != "" {
+			return Grant{}, deny("opa", ReasonOPADenied, "repository %s is denied by OPA policy: %s", claims.Repository, result.Reason).Err()
+		}
+		return Grant{}, deny("opa", ReasonOPADenied, "repository %s is denied by OPA policy", claims.Repository).Err()
+	}
+	return Grant{
+		Scopes: result.Scopes,
+		TTL:    time.Duration(result.TTLSeconds) * time.Second,
+	}, nil
+}
+
+// opaInput is the OPA input document for the claims
+func opaInput(claims *types.VerifiedClaims) map[string]interface{} {
+	return map[string]interface{}{
+		"provider":           claims.Provider,
+		"issuer":             claims.Issuer,
+		"repository":         claims.Repository,
+		"owner":              repositoryOwner(claims),
+		"visibility":         claims.Visibility,
+		"enterprise":         claims.Enterprise,
+		"ref":                claims.Ref,
+		"ref_type":           claims.RefType,
+		"sha":                claims.SHA,
+		"actor":              claims.Actor,
+		"run_id":             claims.RunID,
+		"event_name":         claims.EventName,
+		"environment":        claims.Environment,
+		"runner_environment": claims.RunnerEnvironment,
+		"workflow_ref":       claims.WorkflowRef,
+		"job_workflow_ref":   claims.JobWorkflowRef,
+		"claims":             claims.Raw,
+	}
+}
@@ -0,0 +1,180 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robohub/auth-service/internal/types"
+)
+
+// newOPAStub serves result as the decision document, recording the input of
+// the last request
+func newOPAStub(t *testing.T, status int, result string) (*httptest.Server, *map[string]interface{}) {
+	t.Helper()
+
+	var input map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Input map[string]interface{} `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("failed to decode OPA request: %v", err)
+		}
+		input = body.Input
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(result))
+	}))
+	t.Cleanup(server.Close)
+	return server, &input
+}
+
+func TestOPAClient_Decide(t *testing.T) {
+	claims := &types.VerifiedClaims{
+		Repository: "myorg/firmware",
+		Ref:        "refs/heads/main",
+		Actor:      "octocat",
+		EventName:  "push",
+		Raw:        map[string]interface{}{"repository_visibility": "private"},
+	}
+
+	tests := []struct {
+		name          string
+		status        int
+		result        string
+		want          Grant
+		errorContains string
+		unavailable   bool
+	}{
+		{"allow with grant", http.StatusOK, `{"result": {"allow": true, "scopes": ["ingest:build", "artifact:sign"], "ttl_seconds": 300}}`,
+			Grant{Scopes: []string{"ingest:build", "artifact:sign"}, TTL: 5 * time.Minute}, "", false},
+		{"allow", http.StatusOK, `{"result": {"allow": true}}`, Grant{}, "", false},
+		{"boolean result", http.StatusOK, `{"result": true}`, Grant{}, "", false},
+		{"deny with reason", http.StatusOK, `{"result": {"allow": false, "reason": "actor is a bot"}}`, Grant{},
+			"repository myorg/firmware is denied by OPA policy: actor is a bot", false},
+		{"deny", http.StatusOK, `{"result": false}`, Grant{}, "repository myorg/firmware is denied by OPA policy", false},
+		{"undefined decision", http.StatusOK, `{}`, Grant{}, "is undefined", true},
+		{"server error", http.StatusInternalServerError, `{"code": "internal_error"}`, Grant{}, "unexpected status 500", true},
+		{"invalid response", http.StatusOK, `allow`, Grant{}, "invalid response", true},
+		{"invalid result", http.StatusOK, `{"result": "yes"}`, Grant{}, "invalid result", true},
+		{"negative TTL", http.StatusOK, `{"result": {"allow": true, "ttl_seconds": -1}}`, Grant{}, "negative ttl_seconds", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, input := newOPAStub(t, tt.status, tt.result)
+			client := NewOPAClient(server.URL, time.Second)
+
+			grant, err := client.Decide(context.Background(), claims)
+			if errors.Is(err, ErrOPAUnavailable) != tt.unavailable {
+				t.Errorf("expected unavailable=%v, got %v", tt.unavailable, err)
+			}
+			var denied *DeniedError
+			if errors.As(err, &denied) && (denied.Decision.Reason != ReasonOPADenied || denied.Decision.RuleID != "opa") {
+				t.Errorf("expected an opa_denied decision, got %+v", denied.Decision)
+			}
+			if tt.errorContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("expected error to contain %q, got %v", tt.errorContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(grant, tt.want) {
+				t.Errorf("expected %+v, got %+v", tt.want, grant)
+			}
+
+			if (*input)["repository"] != "myorg/firmware" || (*input)["owner"] != "myorg" || (*input)["event_name"] != "push" {
+				t.Errorf("unexpected input %v", *input)
+			}
+			if raw, _ := (*input)["claims"].(map[string]interface{}); raw["repository_visibility"] != "private" {
+				t.Errorf("expected raw claims in input, got %v", (*input)["claims"])
+			}
+		})
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}))
+		defer server.Close()
+		defer close(release)
+
+		_, err := NewOPAClient(server.URL, 50*time.Millisecond).Decide(context.Background(), claims)
+		if !errors.Is(err, ErrOPAUnavailable) || !strings.Contains(err.Error(), "timed out after 50ms") {
+			t.Errorf("expected timeout, got %v", err)
+		}
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+
+		if _, err := NewOPAClient(server.URL, time.Second).Decide(context.Background(), claims); !errors.Is(err, ErrOPAUnavailable) {
+			t.Errorf("expected ErrOPAUnavailable, got %v", err)
+		}
+	})
+}
+
+func TestEnforcer_DecideWithOPA(t *testing.T) {
+	allowed := &types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/main"}
+	feature := &types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/feature"}
+
+	tests := []struct {
+		name        string
+		status      int
+		result      string
+		failOpen    bool
+		claims      *types.VerifiedClaims
+		want        Grant
+		wantReason  Reason
+		unavailable bool
+	}{
+		// The built-in policy allows only main, but OPA decides
+		{"OPA allows", http.StatusOK, `{"result": {"allow": true, "ttl_seconds": 60}}`, false, feature,
+			Grant{Scopes: []string{"ingest:docs"}, TTL: time.Minute}, "", false},
+		{"OPA scopes", http.StatusOK, `{"result": {"allow": true, "scopes": ["artifact:sign"]}}`, false, allowed,
+			Grant{Scopes: []string{"artifact:sign"}}, "", false},
+		{"OPA denies", http.StatusOK, `{"result": {"allow": false, "reason": "not today"}}`, true, allowed, Grant{}, ReasonOPADenied, false},
+		{"fail closed", http.StatusInternalServerError, ``, false, allowed, Grant{}, "", true},
+		{"fail open allows", http.StatusInternalServerError, ``, true, allowed, Grant{Scopes: []string{"ingest:docs"}}, "", false},
+		{"fail open applies built-in policy", http.StatusInternalServerError, ``, true, feature, Grant{}, ReasonBranchNotAllowed, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := newOPAStub(t, tt.status, tt.result)
+			e := NewEnforcer(true, "main", nil, nil,
+				WithDefaultScopes([]string{"ingest:docs"}),
+				WithOPA(NewOPAClient(server.URL, time.Second), tt.failOpen),
+			)
+
+			grant, err := e.Decide(context.Background(), tt.claims)
+			if errors.Is(err, ErrOPAUnavailable) != tt.unavailable {
+				t.Fatalf("expected unavailable=%v, got %v", tt.unavailable, err)
+			}
+			var denied *DeniedError
+			if errors.As(err, &denied) != (tt.wantReason != "") || (denied != nil && denied.Decision.Reason != tt.wantReason) {
+				t.Fatalf("expected denial %q, got %v", tt.wantReason, err)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(grant, tt.want) {
+				t.Errorf("expected %+v, got %+v", tt.want, grant)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/robohub/auth-service/internal/types"
+)
+
+// SubjectRules allow or deny tokens by globs matched against their raw sub
+// claim, such as repo:myorg/*:ref:refs/heads/main or
+// repo:myorg/deploy:environment:production. Unlike path.Match, * matches any
+// run of characters including / and :, as in AWS and GCP trust conditions;
+// ? matches one character, [...] a character class, and \ escapes the next
+// character. Deny takes precedence; a non-empty Allow rejects every subject
+// not matched. Neither exempts a token from the other checks.
+type SubjectRules struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+
+	// Compiled by Validate
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+}
+
+// empty reports whether the rules restrict no subjects
+func (r SubjectRules) empty() bool {
+	return len(r.Allow) == 0 && len(r.Deny) == 0
+}
+
+func (r *SubjectRules) compile() error {
+	r.allow, r.deny = nil, nil
+	for _, pattern := range r.Allow {
+		re, err := compileSubjectPattern(pattern)
+		if err != nil {
+			return err
+		}
+		r.allow = append(r.allow, re)
+	}
+	for _, pattern := range r.Deny {
+		re, err := compileSubjectPattern(pattern)
+		if err != nil {
+			return err
+		}
+		r.deny = append(r.deny, re)
+	}
+	return nil
+}
+
+// check evaluates the token's sub claim against the rules
+func (r SubjectRules) check(claims *types.VerifiedClaims) Decision {
+	if r.empty() {
+		return allowed
+	}
+	if len(r.allow) != len(r.Allow) || len(r.deny) != len(r.Deny) {
+		return deny("file:subjects", ReasonSubjectNotAllowed, "subject rules were not compiled")
+	}
+
+	subject, _ := claims.Raw["sub"].(string)
+	if subject == "" {
+		return deny("file:subjects", ReasonSubjectNotAllowed, "token for repository %s has no sub claim, which subject rules require", claims.Repository)
+	}
+	for i, re := range r.deny {
+		if re.MatchString(subject) {
+			return deny("file:subjects", ReasonSubjectNotAllowed, "subject %s is denied by subject pattern %s", subject, r.Deny[i])
+		}
+	}
+	if len(r.allow) == 0 {
+		return allowed
+	}
+	for _, re := range r.allow {
+		if re.MatchString(subject) {
+			return allowed
+		}
+	}
+	return deny("file:subjects", ReasonSubjectNotAllowed, "subject %s does not match any allowed subject pattern", subject)
+}
+
+// compileSubjectPattern translates a subject glob into an anchored regular
+// expression
+func compileSubjectPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("empty subject pattern")
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '\\':
+			if i+1 == len(pattern) {
+				return nil, fmt.Errorf("invalid subject pattern %q: trailing backslash", pattern)
+			}
+			_, size := utf8.DecodeRuneInString(pattern[i+1:])
+			b.WriteString(regexp.QuoteMeta(pattern[i+1 : i+1+size]))
+			i += size
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid subject pattern %q: unterminated character class", pattern)
+			}
+			class := pattern[i+1 : i+1+end]
+			negate := strings.HasPrefix(class, "^")
+			class = strings.TrimPrefix(class, "^")
+			if class == "" {
+				return nil, fmt.Errorf("invalid subject pattern %q: empty character class", pattern)
+			}
+			b.WriteString("[")
+			if negate {
+				b.WriteString("^")
+			}
+			for _, r := range class {
+				if r == '-' {
+					b.WriteRune(r)
+					continue
+				}
+				b.WriteString(regexp.QuoteMeta(string(r)))
+			}
+			b.WriteString("]")
+			i += end + 1
+		case ']':
+			return nil, fmt.Errorf("invalid subject pattern %q: unmatched ]", pattern)
+		default:
+			literal := len(pattern) - i
+			if end := strings.IndexAny(pattern[i:], `*?\[]`); end >= 0 {
+				literal = end
+			}
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+literal]))
+			i += literal - 1
+		}
+	}
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject pattern %q: %w", pattern, err)
+	}
+	return re, nil
+}
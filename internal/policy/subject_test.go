@@ -0,0 +1,119 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/robohub/auth-service/internal/types"
+)
+
+func TestCompileSubjectPattern(t *testing.T) {
+	tests := []struct {
+		name          string
+		pattern       string
+		subject       string
+		want          bool
+		errorContains string
+	}{
+		// The patterns of common AWS and GCP trust conditions
+		{"any ref of an owner", "repo:myorg/*", "repo:myorg/robot:ref:refs/heads/feature/x", true, ""},
+		{"owner wildcard spans colons", "repo:myorg/*", "repo:otherorg/robot:ref:refs/heads/main", false, ""},
+		{"main branch of any repository", "repo:myorg/*:ref:refs/heads/main", "repo:myorg/robot:ref:refs/heads/main", true, ""},
+		{"other branch", "repo:myorg/*:ref:refs/heads/main", "repo:myorg/robot:ref:refs/heads/feature", false, ""},
+		{"release tags", "repo:myorg/robot:ref:refs/tags/v*", "repo:myorg/robot:ref:refs/tags/v1.2.3", true, ""},
+		{"environment", "repo:myorg/deploy:environment:production", "repo:myorg/deploy:environment:production", true, ""},
+		{"other environment", "repo:myorg/deploy:environment:production", "repo:myorg/deploy:environment:staging", false, ""},
+		{"any environment", "repo:myorg/*:environment:*", "repo:myorg/deploy:environment:staging", true, ""},
+		{"pull requests", "repo:myorg/*:pull_request", "repo:myorg/robot:pull_request", true, ""},
+		{"anchored", "repo:myorg/robot", "repo:myorg/robot:pull_request", false, ""},
+		{"question mark", "repo:myorg/robot-?:ref:refs/heads/main", "repo:myorg/robot-2:ref:refs/heads/main", true, ""},
+		{"character class", "repo:myorg/robot-[0-9]:*", "repo:myorg/robot-x:ref:refs/heads/main", false, ""},
+		{"negated class", "repo:myorg/robot-[^0-9]:*", "repo:myorg/robot-x:ref:refs/heads/main", true, ""},
+		{"regexp characters are literal", "repo:myorg/robot.v2:*", "repo:myorg/robotxv2:ref:refs/heads/main", false, ""},
+		{"escaped star", `repo:myorg/\*:*`, "repo:myorg/*:ref:refs/heads/main", true, ""},
+		{"custom template", "project_path:myorg/robot:ref_type:branch:ref:main", "project_path:myorg/robot:ref_type:branch:ref:main", true, ""},
+		{"empty", "", "", false, "empty subject pattern"},
+		{"unterminated class", "repo:myorg/[abc", "", false, "unterminated character class"},
+		{"empty class", "repo:myorg/[]", "", false, "empty character class"},
+		{"unmatched bracket", "repo:myorg/]", "", false, "unmatched ]"},
+		{"reversed range", "repo:myorg/[z-a]", "", false, "invalid subject pattern"},
+		{"trailing backslash", `repo:myorg/\`, "", false, "trailing backslash"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			re, err := compileSubjectPattern(tt.pattern)
+			if tt.errorContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("expected error to contain %q, got %v", tt.errorContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := re.MatchString(tt.subject); got != tt.want {
+				t.Errorf("expected %q to match %q: %v, got %v", tt.pattern, tt.subject, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEnforcer_SubjectRules(t *testing.T) {
+	f, err := LoadFile(writePolicyFile(t, `{
+		"subjects": {
+			"allow": ["repo:myorg/*:ref:refs/heads/main", "repo:myorg/deploy:environment:*"],
+			"deny": ["repo:myorg/deploy:environment:sandbox"]
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("failed to load policy file: %v", err)
+	}
+	e := NewEnforcer(false, "main", nil, []string{"myorg/legacy"}, WithFile(f))
+
+	tests := []struct {
+		name       string
+		claims     types.VerifiedClaims
+		wantReason Reason
+	}{
+		{"main branch", types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/main",
+			Raw: map[string]interface{}{"sub": "repo:myorg/robot:ref:refs/heads/main"}}, ReasonAllowed},
+		{"other branch", types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/feature",
+			Raw: map[string]interface{}{"sub": "repo:myorg/robot:ref:refs/heads/feature"}}, ReasonSubjectNotAllowed},
+		{"environment", types.VerifiedClaims{Repository: "myorg/deploy", Ref: "refs/heads/main", Environment: "production",
+			Raw: map[string]interface{}{"sub": "repo:myorg/deploy:environment:production"}}, ReasonAllowed},
+		{"denied environment", types.VerifiedClaims{Repository: "myorg/deploy", Ref: "refs/heads/main", Environment: "sandbox",
+			Raw: map[string]interface{}{"sub": "repo:myorg/deploy:environment:sandbox"}}, ReasonSubjectNotAllowed},
+		{"missing sub", types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/main"}, ReasonSubjectNotAllowed},
+		// An allowed subject does not exempt the token from the other checks
+		{"allowed subject, denied repository", types.VerifiedClaims{Repository: "myorg/legacy", Ref: "refs/heads/main",
+			Raw: map[string]interface{}{"sub": "repo:myorg/legacy:ref:refs/heads/main"}}, ReasonRepoDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := e.Check(&tt.claims)
+			if d.Reason != tt.wantReason {
+				t.Fatalf("expected %s, got %+v", tt.wantReason, d)
+			}
+			if d.Reason == ReasonSubjectNotAllowed && d.RuleID != "file:subjects" {
+				t.Errorf("expected rule file:subjects, got %q", d.RuleID)
+			}
+		})
+	}
+
+	t.Run("invalid pattern is rejected at load", func(t *testing.T) {
+		_, err := LoadFile(writePolicyFile(t, `{"subjects": {"deny": ["repo:myorg/[abc"]}}`))
+		if err == nil || !strings.Contains(err.Error(), "subjects: invalid subject pattern") {
+			t.Errorf("expected invalid subject pattern error, got %v", err)
+		}
+	})
+
+	t.Run("uncompiled rules deny", func(t *testing.T) {
+		e := NewEnforcer(false, "main", nil, nil, WithFile(&File{Subjects: SubjectRules{Allow: []string{"repo:myorg/*"}}}))
+		d := e.Check(&types.VerifiedClaims{Repository: "myorg/robot", Ref: "refs/heads/main", Raw: map[string]interface{}{"sub": "repo:myorg/robot:ref:refs/heads/main"}})
+		if d.Allowed {
+			t.Error("expected uncompiled subject rules to deny")
+		}
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"sync"
 
+	"github.com/robohub/auth-service/internal/clock"
 	"golang.org/x/time/rate"
 )
 
@@ -13,21 +14,37 @@ type Limiter struct {
 	limiters map[string]*rate.Limiter
 	rps      rate.Limit
 	burst    int
+	clock    clock.Clock
+}
+
+// Option configures optional Limiter behavior
+type Option func(*Limiter)
+
+// WithClock sets the time source used for token bucket refills
+func WithClock(c clock.Clock) Option {
+	return func(l *Limiter) {
+		l.clock = c
+	}
 }
 
 // NewLimiter creates a new rate limiter
-func NewLimiter(rps float64, burst int) *Limiter {
-	return &Limiter{
+func NewLimiter(rps float64, burst int, opts ...Option) *Limiter {
+	l := &Limiter{
 		limiters: make(map[string]*rate.Limiter),
 		rps:      rate.Limit(rps),
 		burst:    burst,
+		clock:    clock.Real{},
+	}
+	for _, opt := range opts {
+		opt(l)
 	}
+	return l
 }
 
 // Allow checks if a request for the given repository is allowed
 func (l *Limiter) Allow(repository string) bool {
 	limiter := l.getLimiter(repository)
-	return limiter.Allow()
+	return limiter.AllowN(l.clock.Now(), 1)
 }
 
 // Wait waits until a request for the given repository is allowed
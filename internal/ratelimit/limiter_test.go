@@ -4,6 +4,8 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/robohub/auth-service/internal/testutil"
 )
 
 func TestLimiter_Allow(t *testing.T) {
@@ -32,7 +34,8 @@ func TestLimiter_Allow(t *testing.T) {
 	})
 
 	t.Run("rate refill", func(t *testing.T) {
-		limiter := NewLimiter(10.0, 1) // 10 requests per second
+		clk := testutil.NewFakeClock(time.Now())
+		limiter := NewLimiter(10.0, 1, WithClock(clk)) // 10 requests per second
 		repo := "test/repo"
 
 		// Use up the burst
@@ -45,8 +48,13 @@ func TestLimiter_Allow(t *testing.T) {
 			t.Error("expected second request to be denied immediately")
 		}
 
-		// Wait for token refill (100ms for 10 RPS = 1 token)
-		time.Sleep(150 * time.Millisecond)
+		// Not yet refilled just short of 100ms (10 RPS = 1 token)
+		clk.Advance(99 * time.Millisecond)
+		if limiter.Allow(repo) {
+			t.Error("expected request before refill to be denied")
+		}
+
+		clk.Advance(1 * time.Millisecond)
 
 		// Now should be allowed again
 		if !limiter.Allow(repo) {
@@ -148,7 +156,8 @@ func TestLimiter_GetLimiterCount(t *testing.T) {
 }
 
 func TestLimiter_HighRPS(t *testing.T) {
-	limiter := NewLimiter(100.0, 10)
+	clk := testutil.NewFakeClock(time.Now())
+	limiter := NewLimiter(100.0, 10, WithClock(clk))
 	repo := "test/repo"
 
 	// Use up burst
@@ -158,8 +167,12 @@ func TestLimiter_HighRPS(t *testing.T) {
 		}
 	}
 
-	// Wait a bit for refill (10ms should give us ~1 token at 100 RPS)
-	time.Sleep(20 * time.Millisecond)
+	if limiter.Allow(repo) {
+		t.Error("expected request beyond burst to be denied")
+	}
+
+	// 10ms gives us exactly 1 token at 100 RPS
+	clk.Advance(10 * time.Millisecond)
 
 	// Should be allowed again
 	if !limiter.Allow(repo) {
@@ -0,0 +1,94 @@
+// Package replay records the OIDC tokens already exchanged, so that each is
+// exchanged for an access token only once
+package replay
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/robohub/auth-service/internal/clock"
+)
+
+// sweepInterval is how often MemoryStore drops expired entries
+const sweepInterval = time.Minute
+
+// Store remembers keys until they expire. The in-memory store covers a
+// single replica; deployments with several replicas need a shared
+// implementation, such as Redis SET with NX and PXAT.
+type Store interface {
+	// MarkUsed records key until expiresAt and reports whether this is its
+	// first use. A key recorded earlier that has not expired is not a first
+	// use, and recording it again leaves its expiry alone.
+	MarkUsed(ctx context.Context, key string, expiresAt time.Time) (bool, error)
+}
+
+// Key identifies an OIDC token by its issuer and jti, or by the token's
+// SHA-256 when it has no jti
+func Key(issuer, jti, token string) string {
+	if jti == "" {
+		sum := sha256.Sum256([]byte(token))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+	return "jti:" + issuer + "#" + jti
+}
+
+// MemoryStore is a Store held in memory
+type MemoryStore struct {
+	mu        sync.Mutex
+	expiry    map[string]time.Time
+	lastSweep time.Time
+	clock     clock.Clock
+}
+
+// Option configures optional MemoryStore behavior
+type Option func(*MemoryStore)
+
+// WithClock sets the time source used to expire entries
+func WithClock(c clock.Clock) Option {
+	return func(s *MemoryStore) {
+		s.clock = c
+	}
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore(opts ...Option) *MemoryStore {
+	s := &MemoryStore{expiry: make(map[string]time.Time), clock: clock.Real{}}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.lastSweep = s.clock.Now()
+	return s
+}
+
+// MarkUsed implements Store
+func (s *MemoryStore) MarkUsed(_ context.Context, key string, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		for k, exp := range s.expiry {
+			if !now.Before(exp) {
+				delete(s.expiry, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	if exp, ok := s.expiry[key]; ok && now.Before(exp) {
+		return false, nil
+	}
+	s.expiry[key] = expiresAt
+	return true, nil
+}
+
+// Len returns the number of entries held, expired ones included until the
+// next sweep (useful for testing)
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.expiry)
+}
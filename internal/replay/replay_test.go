@@ -0,0 +1,65 @@
+package replay
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/robohub/auth-service/internal/testutil"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	clk := testutil.NewFakeClock(time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC))
+	store := NewMemoryStore(WithClock(clk))
+	markUsed := func(t *testing.T, key string, expiresAt time.Time) bool {
+		t.Helper()
+		first, err := store.MarkUsed(ctx, key, expiresAt)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return first
+	}
+
+	exp := clk.Now().Add(5 * time.Minute)
+	if !markUsed(t, "a", exp) {
+		t.Error("expected the first use of a key to be allowed")
+	}
+	if markUsed(t, "a", exp) {
+		t.Error("expected the second use of a key to be rejected")
+	}
+	if !markUsed(t, "b", exp) {
+		t.Error("expected another key to be allowed")
+	}
+
+	clk.Advance(5 * time.Minute)
+	if !markUsed(t, "a", clk.Now().Add(5*time.Minute)) {
+		t.Error("expected an expired key to be allowed again")
+	}
+	if got := store.Len(); got != 1 {
+		t.Errorf("expected expired entries to be swept, got %d entries", got)
+	}
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		a, b   [3]string
+		differ bool
+	}{
+		{"same jti", [3]string{"https://issuer", "jti-1", "token-1"}, [3]string{"https://issuer", "jti-1", "token-2"}, false},
+		{"different jti", [3]string{"https://issuer", "jti-1", "token"}, [3]string{"https://issuer", "jti-2", "token"}, true},
+		{"different issuer", [3]string{"https://one", "jti-1", "token"}, [3]string{"https://two", "jti-1", "token"}, true},
+		{"no jti, same token", [3]string{"https://issuer", "", "token"}, [3]string{"https://issuer", "", "token"}, false},
+		{"no jti, different token", [3]string{"https://issuer", "", "token-1"}, [3]string{"https://issuer", "", "token-2"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := Key(tt.a[0], tt.a[1], tt.a[2]), Key(tt.b[0], tt.b[1], tt.b[2])
+			if (a != b) != tt.differ {
+				t.Errorf("expected keys to differ=%v, got %q and %q", tt.differ, a, b)
+			}
+		})
+	}
+}
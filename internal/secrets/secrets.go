@@ -0,0 +1,98 @@
+// Package secrets reads the token signing secret or key from secret stores,
+// so that it never has to be in the service's environment
+package secrets
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/robohub/auth-service/internal/clock"
+)
+
+// Provider reads the current signing material from a secret store: the HMAC
+// secret, or the PEM private key for asymmetric signing
+type Provider interface {
+	Fetch(ctx context.Context) ([]byte, error)
+}
+
+// Watcher fetches the signing material from a Provider again every interval,
+// so that a rotation in the store is picked up without a restart
+type Watcher struct {
+	provider Provider
+	apply    func(value []byte) error
+	logger   *slog.Logger
+	timeout  time.Duration
+	clock    clock.Clock
+
+	refreshes   atomic.Uint64
+	failures    atomic.Uint64
+	lastSuccess atomic.Int64
+}
+
+// WatcherStats are the Watcher's refresh counts, for metrics and alerts
+type WatcherStats struct {
+	Refreshes uint64
+	Failures  uint64
+
+	// SinceLastSuccess is the time since the last successful refresh, or
+	// since the watcher was created before one
+	SinceLastSuccess time.Duration
+}
+
+// NewWatcher creates a watcher that passes the material provider returns to
+// apply, which swaps it in, allowing each fetch timeout
+func NewWatcher(provider Provider, apply func(value []byte) error, timeout time.Duration, logger *slog.Logger) *Watcher {
+	w := &Watcher{provider: provider, apply: apply, logger: logger, timeout: timeout, clock: clock.Real{}}
+	w.lastSuccess.Store(w.clock.Now().UnixNano())
+	return w
+}
+
+// Refresh fetches and applies the current signing material once. On failure
+// the material in use stays in place.
+func (w *Watcher) Refresh(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+
+	w.refreshes.Add(1)
+	value, err := w.provider.Fetch(ctx)
+	if err == nil {
+		err = w.apply(value)
+	}
+	if err != nil {
+		w.failures.Add(1)
+		return err
+	}
+	w.lastSuccess.Store(w.clock.Now().UnixNano())
+	return nil
+}
+
+// Run refreshes the signing material every interval until ctx is done.
+// Failures are logged as errors and counted, keeping the current material
+// until a refresh succeeds.
+func (w *Watcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := w.Refresh(ctx); err != nil {
+			w.logger.Error("failed to refresh signing key, keeping the current key",
+				"error", err, "failures", w.failures.Load(), "since_last_success", w.Stats().SinceLastSuccess)
+		}
+	}
+}
+
+// Stats returns the refresh counts
+func (w *Watcher) Stats() WatcherStats {
+	return WatcherStats{
+		Refreshes:        w.refreshes.Load(),
+		Failures:         w.failures.Load(),
+		SinceLastSuccess: w.clock.Now().Sub(time.Unix(0, w.lastSuccess.Load())),
+	}
+}
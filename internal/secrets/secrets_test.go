@@ -0,0 +1,102 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robohub/auth-service/internal/testutil"
+)
+
+// fakeProvider returns value, or err when set
+type fakeProvider struct {
+	mu    sync.Mutex
+	value string
+	err   error
+}
+
+func (p *fakeProvider) Fetch(context.Context) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return []byte(p.value), p.err
+}
+
+func (p *fakeProvider) set(value string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.value, p.err = value, err
+}
+
+func TestWatcher(t *testing.T) {
+	provider := &fakeProvider{value: "first"}
+	var mu sync.Mutex
+	var applied []string
+	current := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(applied) == 0 {
+			return ""
+		}
+		return applied[len(applied)-1]
+	}
+	apply := func(value []byte) error {
+		if string(value) == "unusable" {
+			return errors.New("unusable key")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		applied = append(applied, string(value))
+		return nil
+	}
+
+	clk := testutil.NewFakeClock(time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC))
+	w := NewWatcher(provider, apply, time.Second, slog.New(slog.NewTextHandler(os.Stderr, nil)))
+	w.clock = clk
+	w.lastSuccess.Store(clk.Now().UnixNano())
+
+	if err := w.Refresh(context.Background()); err != nil || current() != "first" {
+		t.Fatalf("expected the first value to be applied, got %q, %v", current(), err)
+	}
+
+	clk.Advance(time.Minute)
+	provider.set("", errors.New("vault sealed"))
+	if err := w.Refresh(context.Background()); err == nil {
+		t.Error("expected the fetch error")
+	}
+	provider.set("unusable", nil)
+	if err := w.Refresh(context.Background()); err == nil {
+		t.Error("expected the apply error")
+	}
+	if current() != "first" {
+		t.Errorf("expected failed refreshes to keep the current value, got %q", current())
+	}
+	if stats := w.Stats(); stats.Refreshes != 3 || stats.Failures != 2 || stats.SinceLastSuccess != time.Minute {
+		t.Errorf("expected 3 refreshes, 2 failures and a minute since success, got %+v", stats)
+	}
+
+	t.Run("run", func(t *testing.T) {
+		provider.set("second", nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			w.Run(ctx, 10*time.Millisecond)
+		}()
+		defer func() {
+			cancel()
+			<-done
+		}()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for current() != "second" {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for the watcher to refresh")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
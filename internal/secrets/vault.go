@@ -0,0 +1,202 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robohub/auth-service/internal/clock"
+)
+
+// maxVaultResponseSize bounds the Vault responses read
+const maxVaultResponseSize = 1 << 20
+
+// VaultConfig locates the signing material in Vault and how to log in
+type VaultConfig struct {
+	// Address is the Vault server's URL, e.g. https://vault.example.com:8200
+	Address string
+
+	// Path is the API path of the secret below /v1, e.g.
+	// secret/data/robohub-auth for a KV version 2 secret, and Field the key
+	// holding the signing material in it
+	Path  string
+	Field string
+
+	// Namespace is the Vault Enterprise namespace, if any
+	Namespace string
+
+	// Token authenticates with a Vault token. Without one, the service
+	// account token at KubernetesTokenFile logs in as KubernetesRole with the
+	// Kubernetes auth method mounted at KubernetesMount.
+	Token               string
+	KubernetesRole      string
+	KubernetesMount     string
+	KubernetesTokenFile string
+}
+
+// Vault reads the signing material from a Vault KV secret, version 1 or 2
+type Vault struct {
+	config     VaultConfig
+	httpClient *http.Client
+	clock      clock.Clock
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewVault creates a Vault provider
+func NewVault(config VaultConfig) *Vault {
+	config.Address = strings.TrimSuffix(config.Address, "/")
+	config.Path = strings.Trim(config.Path, "/")
+	return &Vault{config: config, httpClient: &http.Client{}, clock: clock.Real{}}
+}
+
+// Fetch reads the secret's field. With Kubernetes auth the Vault token is
+// reused until three quarters of its lease have passed, or Vault rejects it.
+func (v *Vault) Fetch(ctx context.Context) ([]byte, error) {
+	token, err := v.authToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	value, err := v.read(ctx, token)
+	if errors.Is(err, errVaultForbidden) && v.config.Token == "" {
+		// The token was revoked or its lease ended early; log in again
+		v.mu.Lock()
+		v.token = ""
+		v.mu.Unlock()
+		if token, err = v.authToken(ctx); err != nil {
+			return nil, err
+		}
+		value, err = v.read(ctx, token)
+	}
+	return value, err
+}
+
+// errVaultForbidden is Vault's answer to unknown and expired tokens
+var errVaultForbidden = errors.New("unexpected status 403")
+
+func (v *Vault) read(ctx context.Context, token string) ([]byte, error) {
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := v.do(ctx, http.MethodGet, v.config.Path, token, nil, &secret); err != nil {
+		return nil, err
+	}
+
+	// KV version 2 nests the fields under data next to the metadata
+	fields := secret.Data
+	if _, ok := fields["metadata"]; ok && fields["data"] != nil {
+		fields = nil
+		if err := json.Unmarshal(secret.Data["data"], &fields); err != nil {
+			return nil, fmt.Errorf("invalid Vault secret %s: %w", v.config.Path, err)
+		}
+	}
+	raw, ok := fields[v.config.Field]
+	if !ok {
+		return nil, fmt.Errorf("Vault secret %s has no field %q", v.config.Path, v.config.Field)
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil || value == "" {
+		return nil, fmt.Errorf("field %q of Vault secret %s is not a non-empty string", v.config.Field, v.config.Path)
+	}
+	return []byte(value), nil
+}
+
+// authToken returns the Vault token, logging in with Kubernetes auth when
+// there is no configured token or the last one is due for renewal
+func (v *Vault) authToken(ctx context.Context) (string, error) {
+	if v.config.Token != "" {
+		return v.config.Token, nil
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.token != "" && (v.tokenExpiry.IsZero() || v.clock.Now().Before(v.tokenExpiry)) {
+		return v.token, nil
+	}
+
+	jwt, err := os.ReadFile(v.config.KubernetesTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read Kubernetes service account token: %w", err)
+	}
+	login, err := json.Marshal(map[string]string{"role": v.config.KubernetesRole, "jwt": strings.TrimSpace(string(jwt))})
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	if err := v.do(ctx, http.MethodPost, "auth/"+strings.Trim(v.config.KubernetesMount, "/")+"/login", "", login, &resp); err != nil {
+		return "", fmt.Errorf("Vault Kubernetes login as %s failed: %w", v.config.KubernetesRole, err)
+	}
+	if resp.Auth.ClientToken == "" {
+		return "", fmt.Errorf("Vault Kubernetes login as %s returned no token", v.config.KubernetesRole)
+	}
+
+	v.token, v.tokenExpiry = resp.Auth.ClientToken, time.Time{}
+	if lease := time.Duration(resp.Auth.LeaseDuration) * time.Second; lease > 0 {
+		v.tokenExpiry = v.clock.Now().Add(lease * 3 / 4)
+	}
+	return v.token, nil
+}
+
+// do sends a Vault API request and decodes the response into out
+func (v *Vault) do(ctx context.Context, method, path, token string, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, v.config.Address+"/v1/"+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if v.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.config.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("Vault request for %s timed out", path)
+		}
+		return fmt.Errorf("Vault request for %s failed: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxVaultResponseSize))
+	if err != nil {
+		return fmt.Errorf("failed to read Vault response for %s: %w", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.Unmarshal(data, &vaultErr)
+		err := fmt.Errorf("unexpected status %d", resp.StatusCode)
+		if resp.StatusCode == http.StatusForbidden {
+			err = errVaultForbidden
+		}
+		if len(vaultErr.Errors) > 0 {
+			return fmt.Errorf("Vault request for %s: %w: %s", path, err, strings.Join(vaultErr.Errors, "; "))
+		}
+		return fmt.Errorf("Vault request for %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("invalid Vault response for %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/robohub/auth-service/internal/testutil"
+)
+
+// stubVault serves a KV version 2 secret and the Kubernetes auth login
+type stubVault struct {
+	*httptest.Server
+
+	secret    atomic.Value
+	logins    atomic.Int32
+	validJWT  string
+	lease     int
+	namespace atomic.Value
+
+	// tokens are the client tokens the stub accepts
+	tokens sync.Map
+}
+
+func newStubVault(t *testing.T) *stubVault {
+	t.Helper()
+
+	v := &stubVault{validJWT: "service-account-jwt", lease: 3600}
+	v.secret.Store("initial-secret")
+	v.tokens.Store("root-token", true)
+	v.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v.namespace.Store(r.Header.Get("X-Vault-Namespace"))
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/auth/kubernetes/login":
+			var login struct {
+				Role string `json:"role"`
+				JWT  string `json:"jwt"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&login); err != nil || login.Role != "robohub-auth" || login.JWT != v.validJWT {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"errors": ["invalid role or service account token"]}`))
+				return
+			}
+			n := v.logins.Add(1)
+			token := "k8s-token-" + string(rune('0'+n))
+			v.tokens.Store(token, true)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"auth": map[string]interface{}{"client_token": token, "lease_duration": v.lease},
+			})
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/secret/data/robohub-auth":
+			if _, ok := v.tokens.Load(r.Header.Get("X-Vault-Token")); !ok {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"errors": ["permission denied"]}`))
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{
+					"data":     map[string]interface{}{"jwt_secret": v.secret.Load()},
+					"metadata": map[string]interface{}{"version": 3},
+				},
+			})
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/kv/robohub-auth":
+			if _, ok := v.tokens.Load(r.Header.Get("X-Vault-Token")); !ok {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{"jwt_secret": v.secret.Load()},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors": []}`))
+		}
+	}))
+	t.Cleanup(v.Close)
+	return v
+}
+
+func TestVault_Fetch(t *testing.T) {
+	stub := newStubVault(t)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("service-account-jwt\n"), 0o600); err != nil {
+		t.Fatalf("failed to write service account token: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		config        VaultConfig
+		errorContains string
+	}{
+		{"token auth, KV version 2", VaultConfig{Path: "secret/data/robohub-auth", Field: "jwt_secret", Token: "root-token"}, ""},
+		{"token auth, KV version 1", VaultConfig{Path: "/kv/robohub-auth/", Field: "jwt_secret", Token: "root-token"}, ""},
+		{"kubernetes auth", VaultConfig{Path: "secret/data/robohub-auth", Field: "jwt_secret",
+			KubernetesRole: "robohub-auth", KubernetesMount: "kubernetes", KubernetesTokenFile: tokenFile}, ""},
+		{"wrong token", VaultConfig{Path: "secret/data/robohub-auth", Field: "jwt_secret", Token: "other-token"},
+			"status 403"},
+		{"missing field", VaultConfig{Path: "secret/data/robohub-auth", Field: "private_key", Token: "root-token"},
+			`has no field "private_key"`},
+		{"missing secret", VaultConfig{Path: "secret/data/other", Field: "jwt_secret", Token: "root-token"},
+			"unexpected status 404"},
+		{"wrong role", VaultConfig{Path: "secret/data/robohub-auth", Field: "jwt_secret",
+			KubernetesRole: "other", KubernetesMount: "kubernetes", KubernetesTokenFile: tokenFile}, "invalid role or service account token"},
+		{"missing service account token", VaultConfig{Path: "secret/data/robohub-auth", Field: "jwt_secret",
+			KubernetesRole: "robohub-auth", KubernetesMount: "kubernetes", KubernetesTokenFile: tokenFile + ".missing"}, "service account token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Address = stub.URL + "/"
+			value, err := NewVault(tt.config).Fetch(context.Background())
+			if tt.errorContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("expected error to contain %q, got %v", tt.errorContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(value) != "initial-secret" {
+				t.Errorf("expected initial-secret, got %q", value)
+			}
+		})
+	}
+
+	t.Run("namespace", func(t *testing.T) {
+		vault := NewVault(VaultConfig{Address: stub.URL, Path: "secret/data/robohub-auth", Field: "jwt_secret", Token: "root-token", Namespace: "platform"})
+		if _, err := vault.Fetch(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := stub.namespace.Load(); got != "platform" {
+			t.Errorf("expected namespace platform, got %v", got)
+		}
+	})
+
+	t.Run("kubernetes token reuse", func(t *testing.T) {
+		stub.logins.Store(0)
+		clk := testutil.NewFakeClock(time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC))
+		vault := NewVault(VaultConfig{Address: stub.URL, Path: "secret/data/robohub-auth", Field: "jwt_secret",
+			KubernetesRole: "robohub-auth", KubernetesMount: "kubernetes", KubernetesTokenFile: tokenFile})
+		vault.clock = clk
+
+		fetch := func(t *testing.T) {
+			t.Helper()
+			if _, err := vault.Fetch(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		fetch(t)
+		fetch(t)
+		if got := stub.logins.Load(); got != 1 {
+			t.Errorf("expected the token to be reused, got %d logins", got)
+		}
+
+		// Three quarters of the hour lease
+		clk.Advance(45 * time.Minute)
+		fetch(t)
+		if got := stub.logins.Load(); got != 2 {
+			t.Errorf("expected a new login once the lease is mostly over, got %d logins", got)
+		}
+
+		// A revoked token is replaced
+		stub.tokens.Range(func(key, _ interface{}) bool {
+			if key != "root-token" {
+				stub.tokens.Delete(key)
+			}
+			return true
+		})
+		fetch(t)
+		if got := stub.logins.Load(); got != 3 {
+			t.Errorf("expected a new login after the token was revoked, got %d logins", got)
+		}
+	})
+}
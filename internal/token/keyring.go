@@ -0,0 +1,242 @@
+package token
+
+import (
+	"crypto"
+	"crypto/subtle"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signingKey is a key of the Minter's keyring
+type signingKey struct {
+	kid    string
+	method jwt.SigningMethod
+	sign   interface{}
+	verify interface{}
+
+	// lastExpiry is the latest expiry, in Unix seconds, of the tokens the
+	// key signed
+	lastExpiry atomic.Int64
+}
+
+func newSigningKey(key crypto.Signer, kid string) *signingKey {
+	if kid == "" {
+		kid = KeyID(key.Public())
+	}
+	return &signingKey{kid: kid, method: SigningMethod(key), sign: key, verify: key.Public()}
+}
+
+// newSecretKey returns a shared HS256 secret, which tokens do not name
+func newSecretKey(secret string) *signingKey {
+	return &signingKey{method: jwt.SigningMethodHS256, sign: []byte(secret), verify: []byte(secret)}
+}
+
+// shared reports whether the key is a shared secret
+func (k *signingKey) shared() bool {
+	_, ok := k.sign.([]byte)
+	return ok
+}
+
+// same reports whether k and other are the same key under the same kid
+func (k *signingKey) same(other *signingKey) bool {
+	if k.kid != other.kid || k.shared() != other.shared() {
+		return false
+	}
+	if k.shared() {
+		return subtle.ConstantTimeCompare(k.sign.([]byte), other.sign.([]byte)) == 1
+	}
+	key, ok := k.verify.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(other.verify)
+}
+
+// signed records that the key signed a token expiring at exp
+func (k *signingKey) signed(exp time.Time) {
+	for {
+		last := k.lastExpiry.Load()
+		if exp.Unix() <= last || k.lastExpiry.CompareAndSwap(last, exp.Unix()) {
+			return
+		}
+	}
+}
+
+// previousKey is a key rotated out of signing, still validating the tokens
+// it signed until retiresAt
+type previousKey struct {
+	*signingKey
+	retiresAt time.Time
+}
+
+// keyring is the key new tokens are signed with and the previous keys. It is
+// never modified, but replaced by Rotate.
+type keyring struct {
+	active   *signingKey
+	previous []previousKey
+}
+
+// verificationKeys returns the keys that may have signed a token naming kid
+// and alg in its header: the key kid names, or any shared secret, which
+// tokens do not name. Tokens without a kid predate key IDs and are checked
+// against the active key too.
+func (r *keyring) verificationKeys(kid interface{}, alg string, now time.Time) (interface{}, error) {
+	var keys []jwt.VerificationKey
+	named := false
+	for i := -1; i < len(r.previous); i++ {
+		key := r.active
+		if i >= 0 {
+			if !now.Before(r.previous[i].retiresAt) {
+				continue
+			}
+			key = r.previous[i].signingKey
+		}
+		if key.kid != "" && kid != key.kid && (kid != nil || i >= 0) {
+			continue
+		}
+		named = true
+		// Only the key's own algorithm is accepted; reject other key
+		// sizes, algorithm confusion and none outright
+		if alg == key.method.Alg() {
+			keys = append(keys, key.verify)
+		}
+	}
+	switch {
+	case !named:
+		return nil, fmt.Errorf("unknown key id %v", kid)
+	case len(keys) == 0:
+		return nil, fmt.Errorf("unexpected signing method: %v", alg)
+	case len(keys) == 1:
+		return keys[0], nil
+	}
+	return jwt.VerificationKeySet{Keys: keys}, nil
+}
+
+// algorithms returns the algorithms of the keyring's keys
+func (r *keyring) algorithms() []string {
+	algs := []string{r.active.method.Alg()}
+	for _, key := range r.previous {
+		algs = append(algs, key.method.Alg())
+	}
+	return algs
+}
+
+// WithKeyRetention keeps a key validating tokens for retention after Rotate
+// replaces it, which should be the longest lifetime a token can be granted.
+// A key is kept longer when it signed a token that expires later. The
+// default is the minter's TTL.
+func WithKeyRetention(retention time.Duration) Option {
+	return func(m *Minter) {
+		m.retention = retention
+	}
+}
+
+// Rotate makes key the one new tokens are signed with, naming it kid, or
+// KeyID when kid is empty. The replaced key keeps validating the tokens it
+// signed for the WithKeyRetention period, and rotating back to it before
+// then makes it the signing key again. Rotate reports false and changes
+// nothing when key already signs tokens.
+func (m *Minter) Rotate(key crypto.Signer, kid string) (bool, error) {
+	next := newSigningKey(key, kid)
+	if next.method == nil {
+		return false, fmt.Errorf("unsupported signing key %T", key)
+	}
+	return m.rotate(next)
+}
+
+// RotateSecret makes secret the shared secret new tokens are signed with,
+// as Rotate does for signing keys; the replaced secret keeps validating
+// tokens for the WithKeyRetention period
+func (m *Minter) RotateSecret(secret string) (bool, error) {
+	if secret == "" {
+		return false, fmt.Errorf("empty secret")
+	}
+	return m.rotate(newSecretKey(secret))
+}
+
+func (m *Minter) rotate(next *signingKey) (bool, error) {
+	m.rotateMu.Lock()
+	defer m.rotateMu.Unlock()
+
+	ring := m.keys.Load()
+	switch {
+	case ring.active.shared() && !next.shared():
+		return false, fmt.Errorf("tokens are signed with a shared secret, which cannot be rotated to a signing key")
+	case !ring.active.shared() && next.shared():
+		return false, fmt.Errorf("tokens are signed with a signing key, which cannot be rotated to a shared secret")
+	case next.same(ring.active):
+		return false, nil
+	case next.kid != "" && next.kid == ring.active.kid:
+		return false, fmt.Errorf("key id %s is already in use by the signing key", next.kid)
+	}
+
+	now := m.clock.Now()
+	retiresAt := now.Add(m.retention)
+	if last := time.Unix(ring.active.lastExpiry.Load(), 0); last.After(retiresAt) {
+		retiresAt = last
+	}
+	rotated := &keyring{active: next}
+	if ring.active.method != nil {
+		rotated.previous = append(rotated.previous, previousKey{ring.active, retiresAt})
+	}
+	for _, previous := range ring.previous {
+		switch {
+		case !now.Before(previous.retiresAt):
+			continue
+		case previous.same(next):
+			// Rotating back keeps the expiries of the tokens it signed
+			rotated.active = previous.signingKey
+			continue
+		case next.kid != "" && previous.kid == next.kid:
+			return false, fmt.Errorf("key id %s is already in use by a previous key", next.kid)
+		}
+		rotated.previous = append(rotated.previous, previous)
+	}
+	m.keys.Store(rotated)
+	return true, nil
+}
+
+// Key describes a key of the Minter's keyring
+type Key struct {
+	ID        string
+	Algorithm string
+	PublicKey crypto.PublicKey
+
+	// Active is set for the key new tokens are signed with
+	Active bool
+
+	// RetiresAt is when a previous key stops validating tokens
+	RetiresAt time.Time
+}
+
+// Keys returns the signing key followed by the previous keys that still
+// validate tokens. A minter using a shared secret returns no keys.
+func (m *Minter) Keys() []Key {
+	ring := m.keys.Load()
+	if ring.active.shared() || ring.active.method == nil {
+		return nil
+	}
+	keys := []Key{{ID: ring.active.kid, Algorithm: ring.active.method.Alg(), PublicKey: ring.active.verify, Active: true}}
+	now := m.clock.Now()
+	for _, key := range ring.previous {
+		if now.Before(key.retiresAt) {
+			keys = append(keys, Key{ID: key.kid, Algorithm: key.method.Alg(), PublicKey: key.verify, RetiresAt: key.retiresAt})
+		}
+	}
+	return keys
+}
+
+// JWKS returns the public keys of Keys, which services validating tokens
+// need to accept both new tokens and those signed before a rotation
+func (m *Minter) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range m.Keys() {
+		jwk, ok := publicJWK(key.PublicKey)
+		if !ok {
+			continue
+		}
+		jwk.Kid, jwk.Alg, jwk.Use = key.ID, key.Algorithm, "sig"
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
@@ -0,0 +1,220 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/robohub/auth-service/internal/testutil"
+	"github.com/robohub/auth-service/internal/types"
+)
+
+func TestMinter_Rotate(t *testing.T) {
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate EC key: %v", err)
+	}
+	_, newKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %v", err)
+	}
+	claims := &types.VerifiedClaims{Repository: "owner/repo", Ref: "refs/heads/main"}
+	start := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	kid := func(t *testing.T, tokenString string) interface{} {
+		t.Helper()
+		parsed, _, err := jwt.NewParser().ParseUnverified(tokenString, jwt.MapClaims{})
+		if err != nil {
+			t.Fatalf("failed to parse token: %v", err)
+		}
+		return parsed.Header["kid"]
+	}
+
+	t.Run("tokens signed before the rotation still validate", func(t *testing.T) {
+		clk := testutil.NewFakeClock(start)
+		minter := NewMinter("", 10*time.Minute, WithClock(clk), WithSigningKey(oldKey, ""))
+		before, _, err := minter.Mint(claims)
+		if err != nil {
+			t.Fatalf("failed to mint token: %v", err)
+		}
+
+		clk.Advance(time.Minute)
+		if rotated, err := minter.Rotate(newKey, ""); err != nil || !rotated {
+			t.Fatalf("expected the key to be rotated, got %v, %v", rotated, err)
+		}
+		after, _, err := minter.Mint(claims)
+		if err != nil {
+			t.Fatalf("failed to mint token: %v", err)
+		}
+		if got := kid(t, after); got != KeyID(newKey.Public()) {
+			t.Errorf("expected new tokens to name the new key, got kid %v", got)
+		}
+
+		clk.Advance(5 * time.Minute)
+		for name, tokenString := range map[string]string{"before": before, "after": after} {
+			if _, err := minter.Validate(tokenString); err != nil {
+				t.Errorf("expected the token minted %s the rotation to validate, got %v", name, err)
+			}
+		}
+
+		jwks := minter.JWKS()
+		if len(jwks.Keys) != 2 || jwks.Keys[0].Kid != KeyID(newKey.Public()) || jwks.Keys[0].Alg != "EdDSA" ||
+			jwks.Keys[1].Kid != KeyID(&oldKey.PublicKey) || jwks.Keys[1].Alg != "ES256" {
+			t.Errorf("expected the JWKS to hold the new and previous keys, got %+v", jwks.Keys)
+		}
+	})
+
+	t.Run("tokens signed with a retired key are rejected", func(t *testing.T) {
+		clk := testutil.NewFakeClock(start)
+		minter := NewMinter("", 10*time.Minute, WithClock(clk), WithSigningKey(oldKey, ""))
+		// Another replica signed a long-lived token with the old key
+		replica := NewMinter("", time.Hour, WithClock(clk), WithSigningKey(oldKey, ""))
+		tokenString, _, err := replica.Mint(claims)
+		if err != nil {
+			t.Fatalf("failed to mint token: %v", err)
+		}
+
+		if _, err := minter.Rotate(newKey, ""); err != nil {
+			t.Fatalf("failed to rotate: %v", err)
+		}
+		clk.Advance(10 * time.Minute)
+		_, err = minter.Validate(tokenString)
+		if err == nil || !strings.Contains(err.Error(), "unknown key id") {
+			t.Errorf("expected the retired key to be unknown, got %v", err)
+		}
+		if keys := minter.Keys(); len(keys) != 1 || !keys[0].Active {
+			t.Errorf("expected only the signing key to remain, got %+v", keys)
+		}
+	})
+
+	t.Run("keys are kept until the tokens they signed expire", func(t *testing.T) {
+		clk := testutil.NewFakeClock(start)
+		minter := NewMinter("", 10*time.Minute, WithClock(clk), WithSigningKey(oldKey, ""))
+		tokenString, exp, err := minter.Mint(claims, WithTTL(time.Hour))
+		if err != nil {
+			t.Fatalf("failed to mint token: %v", err)
+		}
+		if _, err := minter.Rotate(newKey, ""); err != nil {
+			t.Fatalf("failed to rotate: %v", err)
+		}
+		clk.Advance(59 * time.Minute)
+		if _, err := minter.Validate(tokenString); err != nil {
+			t.Errorf("expected the token to validate until it expires, got %v", err)
+		}
+		if keys := minter.Keys(); len(keys) != 2 || !keys[1].RetiresAt.Equal(exp.Truncate(time.Second)) {
+			t.Errorf("expected the previous key to retire at %v, got %+v", exp, keys)
+		}
+	})
+
+	t.Run("retention", func(t *testing.T) {
+		clk := testutil.NewFakeClock(start)
+		minter := NewMinter("", 10*time.Minute, WithClock(clk), WithSigningKey(oldKey, ""), WithKeyRetention(time.Hour))
+		if _, err := minter.Rotate(newKey, ""); err != nil {
+			t.Fatalf("failed to rotate: %v", err)
+		}
+		if keys := minter.Keys(); len(keys) != 2 || !keys[1].RetiresAt.Equal(start.Add(time.Hour)) {
+			t.Errorf("expected the previous key to retire after an hour, got %+v", keys)
+		}
+	})
+
+	t.Run("same key", func(t *testing.T) {
+		minter := NewMinter("", 10*time.Minute, WithSigningKey(oldKey, ""))
+		if rotated, err := minter.Rotate(oldKey, ""); err != nil || rotated {
+			t.Errorf("expected rotating to the signing key to change nothing, got %v, %v", rotated, err)
+		}
+		if keys := minter.Keys(); len(keys) != 1 {
+			t.Errorf("expected one key, got %+v", keys)
+		}
+	})
+
+	t.Run("rotating back", func(t *testing.T) {
+		minter := NewMinter("", 10*time.Minute, WithSigningKey(oldKey, ""))
+		if _, err := minter.Rotate(newKey, ""); err != nil {
+			t.Fatalf("failed to rotate: %v", err)
+		}
+		if rotated, err := minter.Rotate(oldKey, ""); err != nil || !rotated {
+			t.Fatalf("expected the key to be rotated back, got %v, %v", rotated, err)
+		}
+		keys := minter.Keys()
+		if len(keys) != 2 || keys[0].ID != KeyID(&oldKey.PublicKey) || keys[1].ID != KeyID(newKey.Public()) {
+			t.Errorf("expected the old key to sign and the new key to be previous, got %+v", keys)
+		}
+	})
+
+	t.Run("key id in use", func(t *testing.T) {
+		minter := NewMinter("", 10*time.Minute, WithSigningKey(oldKey, "robohub-1"))
+		if _, err := minter.Rotate(newKey, "robohub-1"); err == nil || !strings.Contains(err.Error(), "already in use") {
+			t.Errorf("expected error for a reused key id, got %v", err)
+		}
+	})
+
+	t.Run("shared secret", func(t *testing.T) {
+		minter := NewMinter("test-secret", 10*time.Minute)
+		if _, err := minter.Rotate(newKey, ""); err == nil {
+			t.Error("expected error rotating a shared secret")
+		}
+		if jwks := minter.JWKS(); len(jwks.Keys) != 0 {
+			t.Errorf("expected no keys for a shared secret, got %+v", jwks.Keys)
+		}
+	})
+}
+
+func TestMinter_RotateSecret(t *testing.T) {
+	claims := &types.VerifiedClaims{Repository: "owner/repo", Ref: "refs/heads/main"}
+	clk := testutil.NewFakeClock(time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC))
+	minter := NewMinter("old-secret", 10*time.Minute, WithClock(clk), WithKeyRetention(time.Hour))
+	// Another replica still signs with the old secret
+	replica := NewMinter("old-secret", 10*time.Minute, WithClock(clk))
+
+	if rotated, err := minter.RotateSecret("old-secret"); err != nil || rotated {
+		t.Errorf("expected rotating to the same secret to change nothing, got %v, %v", rotated, err)
+	}
+	if rotated, err := minter.RotateSecret("new-secret"); err != nil || !rotated {
+		t.Fatalf("expected the secret to be rotated, got %v, %v", rotated, err)
+	}
+
+	clk.Advance(30 * time.Minute)
+	before, _, err := replica.Mint(claims)
+	if err != nil {
+		t.Fatalf("failed to mint token: %v", err)
+	}
+	after, _, err := minter.Mint(claims)
+	if err != nil {
+		t.Fatalf("failed to mint token: %v", err)
+	}
+	if _, err := minter.Validate(before); err != nil {
+		t.Errorf("expected the old secret to validate during the grace period, got %v", err)
+	}
+	if _, err := minter.Validate(after); err != nil {
+		t.Errorf("expected the new secret to validate, got %v", err)
+	}
+	if _, err := replica.Validate(after); err == nil {
+		t.Error("expected tokens to be signed with the new secret")
+	}
+
+	clk.Advance(31 * time.Minute)
+	before, _, err = replica.Mint(claims)
+	if err != nil {
+		t.Fatalf("failed to mint token: %v", err)
+	}
+	if _, err := minter.Validate(before); err == nil {
+		t.Error("expected the old secret to be rejected after the grace period")
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		if _, err := minter.RotateSecret(""); err == nil {
+			t.Error("expected error for an empty secret")
+		}
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate EC key: %v", err)
+		}
+		if _, err := NewMinter("", 10*time.Minute, WithSigningKey(key, "")).RotateSecret("new-secret"); err == nil {
+			t.Error("expected error rotating a signing key to a shared secret")
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SigningMethod returns the algorithm tokens are signed with using key, or
+// nil for keys other than RSA, P-256 ECDSA and Ed25519. Keys held elsewhere,
+// such as in a KMS, are recognised by their public key.
+func SigningMethod(key crypto.Signer) jwt.SigningMethod {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		if key.Curve == elliptic.P256() {
+			return jwt.SigningMethodES256
+		}
+		return nil
+	case ed25519.PrivateKey:
+		return jwt.SigningMethodEdDSA
+	}
+
+	switch public := key.Public().(type) {
+	case *rsa.PublicKey:
+		return signerMethod{jwt.SigningMethodRS256}
+	case *ecdsa.PublicKey:
+		if public.Curve == elliptic.P256() {
+			return signerMethod{jwt.SigningMethodES256}
+		}
+	case ed25519.PublicKey:
+		// The EdDSA method signs with any crypto.Signer
+		return jwt.SigningMethodEdDSA
+	}
+	return nil
+}
+
+// signerMethod signs with a crypto.Signer other than an in-memory private
+// key, which the RS256 and ES256 methods of the jwt package do not accept
+type signerMethod struct {
+	jwt.SigningMethod
+}
+
+func (m signerMethod) Sign(signingString string, key interface{}) ([]byte, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s sign expects crypto.Signer, got %T", m.Alg(), key)
+	}
+	digest := sha256.Sum256([]byte(signingString))
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	if m.Alg() != jwt.SigningMethodES256.Alg() {
+		return sig, nil
+	}
+
+	// crypto.Signer returns an ASN.1 ECDSA signature, while JWS wants the
+	// fixed-size concatenation of r and s
+	var parsed struct{ R, S *big.Int }
+	if rest, err := asn1.Unmarshal(sig, &parsed); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("invalid ECDSA signature")
+	}
+	if parsed.R.Sign() <= 0 || parsed.S.Sign() <= 0 || parsed.R.BitLen() > 256 || parsed.S.BitLen() > 256 {
+		return nil, fmt.Errorf("invalid ECDSA signature")
+	}
+	out := make([]byte, 64)
+	parsed.R.FillBytes(out[:32])
+	parsed.S.FillBytes(out[32:])
+	return out, nil
+}
+
+// JWK is the JSON Web Key form of a public key. Its members are in
+// lexicographic order, as RFC 7638 thumbprints need.
+type JWK struct {
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	E   string `json:"e,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Kty string `json:"kty"`
+	N   string `json:"n,omitempty"`
+	Use string `json:"use,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set, as served to services validating tokens
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// publicJWK returns the required members of the JWK of an RSA, ECDSA or
+// Ed25519 public key
+func publicJWK(key crypto.PublicKey) (JWK, bool) {
+	encode := base64.RawURLEncoding.EncodeToString
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return JWK{Kty: "RSA", E: encode(big.NewInt(int64(key.E)).Bytes()), N: encode(key.N.Bytes())}, true
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		return JWK{Kty: "EC", Crv: key.Curve.Params().Name,
+			X: encode(key.X.FillBytes(make([]byte, size))), Y: encode(key.Y.FillBytes(make([]byte, size)))}, true
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Crv: "Ed25519", X: encode(key)}, true
+	}
+	return JWK{}, false
+}
+
+// KeyID returns the RFC 7638 JWK thumbprint of an RSA, ECDSA or Ed25519
+// public key, a stable kid derived from the key itself. It returns "" for
+// other keys.
+func KeyID(key crypto.PublicKey) string {
+	jwk, ok := publicJWK(key)
+	if !ok {
+		return ""
+	}
+	data, _ := json.Marshal(jwk)
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -1,37 +1,258 @@
 package token
 
 import (
+	"crypto"
+	"errors"
 	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/robohub/auth-service/internal/clock"
 	"github.com/robohub/auth-service/internal/types"
 )
 
-// Minter creates RoboHub access tokens
+// Default iss and aud claims of minted tokens
+const (
+	DefaultIssuer   = "robohub-auth"
+	DefaultAudience = "robohub-api"
+)
+
+// Minter creates RoboHub access tokens, signed with HS256 and a shared
+// secret unless WithSigningKey is given
 type Minter struct {
-	secret []byte
-	ttl    time.Duration
+	keys      atomic.Pointer[keyring]
+	rotateMu  sync.Mutex
+	retention time.Duration
+	ttl       time.Duration
+	issuer    string
+	audience  string
+	scopes    []string
+	clock     clock.Clock
+
+	// Reports whether the token with a jti has been revoked, when set
+	revoked func(jti string) bool
+}
+
+// Errors Validate returns, for telling apart with errors.Is a token the
+// client should replace from one that should not exist
+var (
+	// ErrTokenExpired is returned for a token past its exp; the client
+	// should exchange a new OIDC token
+	ErrTokenExpired = errors.New("token expired")
+
+	// ErrTokenSignature is returned for a token whose signature does not
+	// verify with any of the minter's keys, including one signed with
+	// another algorithm or naming an unknown key
+	ErrTokenSignature = errors.New("invalid token signature")
+
+	// ErrTokenMalformed is returned for a token that does not decode, or is
+	// signed by the minter's key but has the wrong iss or aud or lacks a
+	// required claim
+	ErrTokenMalformed = errors.New("malformed token")
+
+	// ErrTokenRevoked is returned for a valid token WithRevocationCheck
+	// reports as revoked
+	ErrTokenRevoked = errors.New("token revoked")
+)
+
+// reasons are the stable codes of the Validate errors, which /auth/validate
+// reports in the details of its 401 responses
+var reasons = []struct {
+	err    error
+	reason string
+}{
+	{ErrTokenExpired, "token_expired"},
+	{ErrTokenRevoked, "token_revoked"},
+	{ErrTokenSignature, "invalid_signature"},
+	{ErrTokenMalformed, "malformed_token"},
+}
+
+// Reason returns the stable code of a Validate error, such as token_expired,
+// or "" for other errors
+func Reason(err error) string {
+	for _, r := range reasons {
+		if errors.Is(err, r.err) {
+			return r.reason
+		}
+	}
+	return ""
+}
+
+// ReasonError returns the Validate error a stable code stands for, or nil
+// for unknown codes
+func ReasonError(reason string) error {
+	for _, r := range reasons {
+		if r.reason == reason {
+			return r.err
+		}
+	}
+	return nil
+}
+
+// Option configures optional Minter behavior
+type Option func(*Minter)
+
+// WithClock sets the time source used for issuing and validating tokens
+func WithClock(c clock.Clock) Option {
+	return func(m *Minter) {
+		m.clock = c
+	}
+}
+
+// WithRevocationCheck makes Validate reject the tokens whose jti revoked
+// reports, with ErrTokenRevoked
+func WithRevocationCheck(revoked func(jti string) bool) Option {
+	return func(m *Minter) {
+		m.revoked = revoked
+	}
+}
+
+// WithIssuer sets the iss claim of minted tokens, which Validate requires,
+// e.g. to keep staging tokens out of production. The default is
+// DefaultIssuer.
+func WithIssuer(issuer string) Option {
+	return func(m *Minter) {
+		m.issuer = issuer
+	}
+}
+
+// WithAudience sets the aud claim of minted tokens, which Validate requires.
+// The default is DefaultAudience.
+func WithAudience(audience string) Option {
+	return func(m *Minter) {
+		m.audience = audience
+	}
+}
+
+// WithDefaultScopes sets the scopes of tokens minted without WithScopes. The
+// default is ingest:build.
+func WithDefaultScopes(scopes ...string) Option {
+	return func(m *Minter) {
+		m.scopes = scopes
+	}
+}
+
+// WithSigningKey signs tokens with key instead of the shared secret, naming
+// it in the kid header; an empty kid uses KeyID. The algorithm follows the
+// key: RS256 for RSA, ES256 for P-256 ECDSA and EdDSA for Ed25519 keys, and
+// Mint fails for others. Validate then needs only the public half of key,
+// and Rotate can replace it.
+func WithSigningKey(key crypto.Signer, kid string) Option {
+	return func(m *Minter) {
+		m.keys.Store(&keyring{active: newSigningKey(key, kid)})
+	}
+}
+
+// MintOption adjusts a single minted token
+type MintOption func(*mintSettings)
+
+type mintSettings struct {
+	ttl           time.Duration
+	scopes        []string
+	policyVersion string
+	extraClaims   map[string]interface{}
+}
+
+// reservedClaims are the claims Mint sets itself, which WithExtraClaims
+// cannot replace
+var reservedClaims = []string{
+	"iss", "sub", "aud", "iat", "nbf", "exp", "jti",
+	"repo", "ref", "actor", "run_id", "scopes",
+	"repository_owner", "repository_id", "run_attempt", "actor_id", "sha", "environment", "policy_version",
+	"workflow", "event_name",
+}
+
+// WithTTL overrides the minter's TTL for one token
+func WithTTL(ttl time.Duration) MintOption {
+	return func(s *mintSettings) {
+		s.ttl = ttl
+	}
+}
+
+// WithScopes overrides the minter's default scopes for one token
+func WithScopes(scopes ...string) MintOption {
+	return func(s *mintSettings) {
+		s.scopes = scopes
+	}
+}
+
+// WithPolicyVersion records the version of the policy that allowed the token
+// in its policy_version claim
+func WithPolicyVersion(version string) MintOption {
+	return func(s *mintSettings) {
+		s.policyVersion = version
+	}
+}
+
+// WithExtraClaims adds claims to one token. Mint fails when one of them is a
+// claim it sets itself, such as iss or scopes.
+func WithExtraClaims(claims map[string]interface{}) MintOption {
+	return func(s *mintSettings) {
+		if s.extraClaims == nil {
+			s.extraClaims = make(map[string]interface{}, len(claims))
+		}
+		for name, value := range claims {
+			s.extraClaims[name] = value
+		}
+	}
 }
 
 // NewMinter creates a new token minter
-func NewMinter(secret string, ttl time.Duration) *Minter {
-	return &Minter{
-		secret: []byte(secret),
-		ttl:    ttl,
+func NewMinter(secret string, ttl time.Duration, opts ...Option) *Minter {
+	m := &Minter{
+		retention: ttl,
+		ttl:       ttl,
+		issuer:    DefaultIssuer,
+		audience:  DefaultAudience,
+		scopes:    []string{"ingest:build"},
+		clock:     clock.Real{},
 	}
+	m.keys.Store(&keyring{active: newSecretKey(secret)})
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// TTL returns the lifetime of tokens minted without WithTTL
+func (m *Minter) TTL() time.Duration {
+	return m.ttl
+}
+
+// DefaultScopes returns the scopes of tokens minted without WithScopes
+func (m *Minter) DefaultScopes() []string {
+	return slices.Clone(m.scopes)
 }
 
-// Mint creates a new RoboHub access token
-func (m *Minter) Mint(claims *types.VerifiedClaims) (string, time.Time, error) {
-	now := time.Now()
-	exp := now.Add(m.ttl)
+// Mint creates a new RoboHub access token. Options take precedence over the
+// minter's defaults, and without any the token gets the default TTL and
+// scopes.
+func (m *Minter) Mint(claims *types.VerifiedClaims, opts ...MintOption) (string, time.Time, error) {
+	settings := mintSettings{ttl: m.ttl, scopes: m.scopes}
+	for _, opt := range opts {
+		opt(&settings)
+	}
+	if settings.scopes == nil {
+		// An empty list rather than null, which Validate would reject
+		settings.scopes = []string{}
+	}
+	for name := range settings.extraClaims {
+		if slices.Contains(reservedClaims, name) {
+			return "", time.Time{}, fmt.Errorf("extra claim %s is set by the minter", name)
+		}
+	}
+
+	now := m.clock.Now()
+	exp := now.Add(settings.ttl)
 
 	tokenClaims := jwt.MapClaims{
-		"iss":    "robohub-auth",
+		"iss":    m.issuer,
 		"sub":    fmt.Sprintf("repo:%s", claims.Repository),
-		"aud":    "robohub-api",
+		"aud":    m.audience,
 		"iat":    now.Unix(),
 		"exp":    exp.Unix(),
 		"jti":    uuid.New().String(),
@@ -39,42 +260,151 @@ func (m *Minter) Mint(claims *types.VerifiedClaims) (string, time.Time, error) {
 		"ref":    claims.Ref,
 		"actor":  claims.Actor,
 		"run_id": claims.RunID,
-		"scopes": []string{"ingest:build"},
+		"scopes": settings.scopes,
+	}
+	if claims.Owner != "" {
+		tokenClaims["repository_owner"] = claims.Owner
+	}
+	if claims.RepositoryID != "" {
+		tokenClaims["repository_id"] = claims.RepositoryID
+	}
+	if claims.RunAttempt != "" {
+		tokenClaims["run_attempt"] = claims.RunAttempt
+	}
+	if claims.ActorID != "" {
+		tokenClaims["actor_id"] = claims.ActorID
+	}
+	if claims.SHA != "" {
+		tokenClaims["sha"] = claims.SHA
+	}
+	if claims.Environment != "" {
+		tokenClaims["environment"] = claims.Environment
+	}
+	if claims.Workflow != "" {
+		tokenClaims["workflow"] = claims.Workflow
+	}
+	if claims.EventName != "" {
+		tokenClaims["event_name"] = claims.EventName
+	}
+	if settings.policyVersion != "" {
+		tokenClaims["policy_version"] = settings.policyVersion
+	}
+	for name, value := range settings.extraClaims {
+		tokenClaims[name] = value
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, tokenClaims)
-	tokenString, err := token.SignedString(m.secret)
+	key := m.keys.Load().active
+	if key.method == nil {
+		return "", time.Time{}, fmt.Errorf("unsupported signing key %T", key.sign)
+	}
+	token := jwt.NewWithClaims(key.method, tokenClaims)
+	if key.kid != "" {
+		token.Header["kid"] = key.kid
+	}
+	tokenString, err := token.SignedString(key.sign)
 	if err != nil {
 		return "", time.Time{}, fmt.Errorf("failed to sign token: %w", err)
 	}
+	key.signed(exp)
 
 	return tokenString, exp, nil
 }
 
-// Validate validates and parses a RoboHub access token
-func (m *Minter) Validate(tokenString string) (*types.RoboHubClaims, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Verify signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return m.secret, nil
-	})
+// requiredStringClaims are the string claims Validate insists on beyond iss
+// and aud; exp, iat and scopes are checked for their own types
+var requiredStringClaims = []string{"jti", "repo"}
 
+// Validate validates and parses a RoboHub access token, signed with the
+// signing key or a previous key that has not yet retired for the minter's
+// issuer and audience. The token must carry exp, iat, jti, repo and scopes,
+// and the error names the first check that failed. Errors about the token
+// wrap ErrTokenExpired, ErrTokenSignature, ErrTokenMalformed or
+// ErrTokenRevoked.
+func (m *Minter) Validate(tokenString string) (*types.RoboHubClaims, error) {
+	ring := m.keys.Load()
+	if ring.active.method == nil {
+		return nil, fmt.Errorf("unsupported signing key %T", ring.active.sign)
+	}
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		alg, _ := token.Header["alg"].(string)
+		return ring.verificationKeys(token.Header["kid"], alg, m.clock.Now())
+	}
+	parsed, err := validate(tokenString, keyFunc, m.issuer, m.audience,
+		jwt.WithValidMethods(ring.algorithms()), jwt.WithTimeFunc(m.clock.Now))
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse token: %w", err)
+		return nil, err
+	}
+	if m.revoked != nil && m.revoked(parsed.JTI) {
+		return nil, fmt.Errorf("%w: jti %s", ErrTokenRevoked, parsed.JTI)
+	}
+	return parsed, nil
+}
+
+// ValidateToken validates and parses a RoboHub access token with the checks
+// of Minter.Validate, taking the key from keyFunc and allowing only methods,
+// for validators that hold only the public keys
+func ValidateToken(tokenString string, keyFunc jwt.Keyfunc, methods []string, issuer, audience string) (*types.RoboHubClaims, error) {
+	return validate(tokenString, keyFunc, issuer, audience, jwt.WithValidMethods(methods))
+}
+
+func validate(tokenString string, keyFunc jwt.Keyfunc, issuer, audience string, opts ...jwt.ParserOption) (*types.RoboHubClaims, error) {
+	token, err := jwt.Parse(tokenString, keyFunc, append(opts, jwt.WithIssuer(issuer), jwt.WithAudience(audience))...)
+
+	// A wrong issuer or audience outranks expiry: an expired token that was
+	// never ours is still worth an alert
+	switch {
+	case err == nil:
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		return nil, fmt.Errorf("%w: %w", ErrTokenSignature, err)
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		iss, _ := token.Claims.GetIssuer()
+		return nil, fmt.Errorf("%w: %w: issuer is %q, want %q", ErrTokenMalformed, jwt.ErrTokenInvalidIssuer, iss, issuer)
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		aud, _ := token.Claims.GetAudience()
+		return nil, fmt.Errorf("%w: %w: audience is %q, want %q", ErrTokenMalformed, jwt.ErrTokenInvalidAudience, []string(aud), audience)
+	case errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+		return nil, fmt.Errorf("%w: %w", ErrTokenMalformed, err)
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return nil, fmt.Errorf("%w: %w", ErrTokenExpired, err)
+	default:
+		return nil, fmt.Errorf("%w: failed to parse token: %w", ErrTokenMalformed, err)
 	}
 
 	if !token.Valid {
-		return nil, fmt.Errorf("invalid token")
+		return nil, fmt.Errorf("%w: invalid token", ErrTokenMalformed)
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return nil, fmt.Errorf("invalid claims format")
+		return nil, fmt.Errorf("%w: invalid claims format", ErrTokenMalformed)
 	}
+	if err := checkRequiredClaims(claims); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTokenMalformed, err)
+	}
+	return ParseClaims(claims), nil
+}
 
-	// Extract claims
+// checkRequiredClaims reports the first claim Validate requires that is
+// missing, empty or of the wrong type
+func checkRequiredClaims(claims jwt.MapClaims) error {
+	for _, name := range []string{"exp", "iat"} {
+		if _, ok := claims[name].(float64); !ok {
+			return fmt.Errorf("%w: %s must be a number", jwt.ErrTokenRequiredClaimMissing, name)
+		}
+	}
+	for _, name := range requiredStringClaims {
+		if value, _ := claims[name].(string); value == "" {
+			return fmt.Errorf("%w: %s must be a non-empty string", jwt.ErrTokenRequiredClaimMissing, name)
+		}
+	}
+	if _, ok := claims["scopes"].([]interface{}); !ok {
+		return fmt.Errorf("%w: scopes must be a list", jwt.ErrTokenRequiredClaimMissing)
+	}
+	return nil
+}
+
+// ParseClaims extracts RoboHub claims from a verified token's claim set
+func ParseClaims(claims jwt.MapClaims) *types.RoboHubClaims {
 	robohubClaims := &types.RoboHubClaims{}
 
 	if iss, ok := claims["iss"].(string); ok {
@@ -107,6 +437,33 @@ func (m *Minter) Validate(tokenString string) (*types.RoboHubClaims, error) {
 	if runID, ok := claims["run_id"].(string); ok {
 		robohubClaims.RunID = runID
 	}
+	if owner, ok := claims["repository_owner"].(string); ok {
+		robohubClaims.Owner = owner
+	}
+	if repositoryID, ok := claims["repository_id"].(string); ok {
+		robohubClaims.RepositoryID = repositoryID
+	}
+	if runAttempt, ok := claims["run_attempt"].(string); ok {
+		robohubClaims.RunAttempt = runAttempt
+	}
+	if actorID, ok := claims["actor_id"].(string); ok {
+		robohubClaims.ActorID = actorID
+	}
+	if sha, ok := claims["sha"].(string); ok {
+		robohubClaims.SHA = sha
+	}
+	if environment, ok := claims["environment"].(string); ok {
+		robohubClaims.Environment = environment
+	}
+	if workflow, ok := claims["workflow"].(string); ok {
+		robohubClaims.Workflow = workflow
+	}
+	if eventName, ok := claims["event_name"].(string); ok {
+		robohubClaims.EventName = eventName
+	}
+	if policyVersion, ok := claims["policy_version"].(string); ok {
+		robohubClaims.PolicyVersion = policyVersion
+	}
 	if scopes, ok := claims["scopes"].([]interface{}); ok {
 		robohubClaims.Scopes = make([]string, 0, len(scopes))
 		for _, scope := range scopes {
@@ -116,5 +473,5 @@ func (m *Minter) Validate(tokenString string) (*types.RoboHubClaims, error) {
 		}
 	}
 
-	return robohubClaims, nil
+	return robohubClaims
 }
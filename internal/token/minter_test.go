@@ -1,9 +1,22 @@
 package token
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/robohub/auth-service/internal/testutil"
 	"github.com/robohub/auth-service/internal/types"
 )
 
@@ -76,6 +89,153 @@ func TestMinter_Mint(t *testing.T) {
 	}
 }
 
+func TestMinter_Mint_Environment(t *testing.T) {
+	minter := NewMinter("test-secret", 10*time.Minute)
+
+	tests := []struct {
+		name        string
+		environment string
+	}{
+		{"with environment", "production"},
+		{"without environment", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenString, _, err := minter.Mint(&types.VerifiedClaims{
+				Repository:  "owner/repo",
+				Ref:         "refs/heads/main",
+				Actor:       "testuser",
+				RunID:       "123456789",
+				Environment: tt.environment,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			claims := jwt.MapClaims{}
+			if _, _, err := jwt.NewParser().ParseUnverified(tokenString, claims); err != nil {
+				t.Fatalf("failed to parse token: %v", err)
+			}
+			if _, ok := claims["environment"]; ok != (tt.environment != "") {
+				t.Errorf("expected environment claim present=%v, got %v", tt.environment != "", claims["environment"])
+			}
+
+			parsed, err := minter.Validate(tokenString)
+			if err != nil {
+				t.Fatalf("failed to validate token: %v", err)
+			}
+			if parsed.Environment != tt.environment {
+				t.Errorf("expected environment %q, got %q", tt.environment, parsed.Environment)
+			}
+		})
+	}
+}
+
+func TestMinter_Mint_Provenance(t *testing.T) {
+	minter := NewMinter("test-secret", 10*time.Minute)
+
+	tokenString, _, err := minter.Mint(&types.VerifiedClaims{
+		Repository:   "owner/repo",
+		Owner:        "owner",
+		RepositoryID: "123456789",
+		Ref:          "refs/heads/main",
+		SHA:          "ffac537e6cbbf934b08745a378932722df287a53",
+		Actor:        "testuser",
+		ActorID:      "1234567",
+		RunID:        "123456789",
+		RunAttempt:   "2",
+		Workflow:     "owner/repo/.github/workflows/build.yml@refs/heads/main",
+		EventName:    "push",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	parsed, err := minter.Validate(tokenString)
+	if err != nil {
+		t.Fatalf("failed to validate token: %v", err)
+	}
+	if parsed.Owner != "owner" {
+		t.Errorf("expected repository_owner owner, got %s", parsed.Owner)
+	}
+	if parsed.RepositoryID != "123456789" {
+		t.Errorf("expected repository_id 123456789, got %s", parsed.RepositoryID)
+	}
+	if parsed.SHA != "ffac537e6cbbf934b08745a378932722df287a53" {
+		t.Errorf("unexpected sha: %s", parsed.SHA)
+	}
+	if parsed.ActorID != "1234567" {
+		t.Errorf("expected actor_id 1234567, got %s", parsed.ActorID)
+	}
+	if parsed.RunAttempt != "2" {
+		t.Errorf("expected run_attempt 2, got %s", parsed.RunAttempt)
+	}
+	if parsed.Workflow != "owner/repo/.github/workflows/build.yml@refs/heads/main" {
+		t.Errorf("unexpected workflow: %s", parsed.Workflow)
+	}
+	if parsed.EventName != "push" {
+		t.Errorf("expected event_name push, got %s", parsed.EventName)
+	}
+
+	t.Run("tokens without provenance claims", func(t *testing.T) {
+		// Tokens minted before the claims were added, or for providers
+		// without them, leave the fields empty
+		tokenString, _, err := minter.Mint(&types.VerifiedClaims{Repository: "owner/repo", Ref: "refs/heads/main"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		claims := jwt.MapClaims{}
+		if _, _, err := jwt.NewParser().ParseUnverified(tokenString, claims); err != nil {
+			t.Fatalf("failed to parse token: %v", err)
+		}
+		for _, name := range []string{"sha", "workflow", "event_name"} {
+			if _, ok := claims[name]; ok {
+				t.Errorf("expected no %s claim, got %v", name, claims[name])
+			}
+		}
+		parsed, err := minter.Validate(tokenString)
+		if err != nil {
+			t.Fatalf("failed to validate token: %v", err)
+		}
+		if parsed.SHA != "" || parsed.Workflow != "" || parsed.EventName != "" {
+			t.Errorf("expected empty provenance, got %+v", parsed)
+		}
+	})
+}
+
+func TestMinter_Mint_IgnoresRawClaims(t *testing.T) {
+	minter := NewMinter("test-secret", 10*time.Minute)
+
+	tokenString, _, err := minter.Mint(&types.VerifiedClaims{
+		Repository: "owner/repo",
+		Ref:        "refs/heads/main",
+		Raw: map[string]interface{}{
+			"repository":       "owner/repo",
+			"job_workflow_sha": "9f2c5e1",
+			"scopes":           []string{"admin"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, claims); err != nil {
+		t.Fatalf("failed to parse token: %v", err)
+	}
+	if _, ok := claims["job_workflow_sha"]; ok {
+		t.Error("expected raw claims not to be copied into the token")
+	}
+	if _, ok := claims["repository"]; ok {
+		t.Error("expected raw claims not to be copied into the token")
+	}
+	scopes, _ := claims["scopes"].([]interface{})
+	if len(scopes) != 1 || scopes[0] != "ingest:build" {
+		t.Errorf("expected scopes [ingest:build], got %v", claims["scopes"])
+	}
+}
+
 func TestMinter_Validate(t *testing.T) {
 	minter := NewMinter("test-secret", 10*time.Minute)
 
@@ -120,19 +280,382 @@ func TestMinter_Validate(t *testing.T) {
 	})
 
 	t.Run("expired token", func(t *testing.T) {
-		shortMinter := NewMinter("test-secret", 1*time.Nanosecond)
+		clk := testutil.NewFakeClock(time.Now())
+		shortMinter := NewMinter("test-secret", 1*time.Minute, WithClock(clk))
 		expiredToken, _, err := shortMinter.Mint(claims)
 		if err != nil {
 			t.Fatalf("failed to mint token: %v", err)
 		}
 
-		time.Sleep(10 * time.Millisecond)
+		clk.Advance(1 * time.Minute)
 
 		_, err = shortMinter.Validate(expiredToken)
 		if err == nil {
 			t.Error("expected error for expired token")
 		}
 	})
+
+	t.Run("token valid until expiry", func(t *testing.T) {
+		clk := testutil.NewFakeClock(time.Now())
+		shortMinter := NewMinter("test-secret", 1*time.Minute, WithClock(clk))
+		tokenString, _, err := shortMinter.Mint(claims)
+		if err != nil {
+			t.Fatalf("failed to mint token: %v", err)
+		}
+
+		clk.Advance(59 * time.Second)
+
+		if _, err := shortMinter.Validate(tokenString); err != nil {
+			t.Errorf("expected token to be valid before expiry, got %v", err)
+		}
+	})
+
+	t.Run("disallowed algorithm", func(t *testing.T) {
+		tokenClaims := jwt.MapClaims{
+			"iss": "robohub-auth",
+			"aud": "robohub-api",
+			"exp": time.Now().Add(time.Minute).Unix(),
+		}
+		tests := []struct {
+			name   string
+			method jwt.SigningMethod
+			key    interface{}
+		}{
+			{"HS384", jwt.SigningMethodHS384, []byte("test-secret")},
+			{"HS512", jwt.SigningMethodHS512, []byte("test-secret")},
+			{"none", jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType},
+		}
+		for _, tt := range tests {
+			forged, err := jwt.NewWithClaims(tt.method, tokenClaims).SignedString(tt.key)
+			if err != nil {
+				t.Fatalf("failed to sign token: %v", err)
+			}
+			if _, err := minter.Validate(forged); err == nil {
+				t.Errorf("expected error for %s token", tt.name)
+			}
+		}
+	})
+
+	t.Run("claims", func(t *testing.T) {
+		// Tokens signed with the minter's secret but not minted by it
+		valid := func() jwt.MapClaims {
+			return jwt.MapClaims{
+				"iss":    DefaultIssuer,
+				"aud":    DefaultAudience,
+				"iat":    time.Now().Unix(),
+				"exp":    time.Now().Add(time.Minute).Unix(),
+				"jti":    "a4f1c0de-6f53-4a9a-9d4b-3b0f3c1e2d7a",
+				"repo":   "owner/repo",
+				"scopes": []string{"ingest:build"},
+			}
+		}
+		tests := []struct {
+			name          string
+			modify        func(jwt.MapClaims)
+			errorContains string
+		}{
+			{"complete", func(jwt.MapClaims) {}, ""},
+			{"no scopes granted", func(c jwt.MapClaims) { c["scopes"] = []string{} }, ""},
+			{"foreign issuer", func(c jwt.MapClaims) { c["iss"] = "https://token.actions.githubusercontent.com" },
+				`issuer is "https://token.actions.githubusercontent.com", want "robohub-auth"`},
+			{"missing issuer", func(c jwt.MapClaims) { delete(c, "iss") }, "iss claim is required"},
+			{"foreign audience", func(c jwt.MapClaims) { c["aud"] = []string{"robohub", "sts.amazonaws.com"} },
+				`audience is ["robohub" "sts.amazonaws.com"], want "robohub-api"`},
+			{"missing exp", func(c jwt.MapClaims) { delete(c, "exp") }, "exp must be a number"},
+			{"missing iat", func(c jwt.MapClaims) { delete(c, "iat") }, "iat must be a number"},
+			{"missing jti", func(c jwt.MapClaims) { delete(c, "jti") }, "jti must be a non-empty string"},
+			{"empty repo", func(c jwt.MapClaims) { c["repo"] = "" }, "repo must be a non-empty string"},
+			{"missing scopes", func(c jwt.MapClaims) { delete(c, "scopes") }, "scopes must be a list"},
+			{"scopes as a string", func(c jwt.MapClaims) { c["scopes"] = "ingest:build" }, "scopes must be a list"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tokenClaims := valid()
+				tt.modify(tokenClaims)
+				tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, tokenClaims).SignedString([]byte("test-secret"))
+				if err != nil {
+					t.Fatalf("failed to sign token: %v", err)
+				}
+				_, err = minter.Validate(tokenString)
+				if tt.errorContains == "" {
+					if err != nil {
+						t.Errorf("unexpected error: %v", err)
+					}
+					return
+				}
+				if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("expected error to contain %q, got %v", tt.errorContains, err)
+				}
+			})
+		}
+	})
+
+	t.Run("minted without scopes", func(t *testing.T) {
+		tokenString, _, err := minter.Mint(claims, WithScopes())
+		if err != nil {
+			t.Fatalf("failed to mint token: %v", err)
+		}
+		parsed, err := minter.Validate(tokenString)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if parsed.Scopes == nil || len(parsed.Scopes) != 0 {
+			t.Errorf("expected an empty scope list, got %#v", parsed.Scopes)
+		}
+	})
+}
+
+func TestMinter_ValidateErrors(t *testing.T) {
+	claims := &types.VerifiedClaims{Repository: "owner/repo", Ref: "refs/heads/main"}
+	clk := testutil.NewFakeClock(time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC))
+	revokedJTIs := map[string]bool{}
+	minter := NewMinter("test-secret", 10*time.Minute, WithClock(clk),
+		WithRevocationCheck(func(jti string) bool { return revokedJTIs[jti] }))
+	mint := func(t *testing.T, m *Minter) string {
+		t.Helper()
+		tokenString, _, err := m.Mint(claims)
+		if err != nil {
+			t.Fatalf("failed to mint token: %v", err)
+		}
+		return tokenString
+	}
+
+	expired := mint(t, NewMinter("test-secret", time.Minute, WithClock(testutil.NewFakeClock(clk.Now().Add(-time.Hour)))))
+	otherSecret := mint(t, NewMinter("other-secret", 10*time.Minute, WithClock(clk)))
+	foreignIssuer := mint(t, NewMinter("test-secret", 10*time.Minute, WithClock(clk), WithIssuer("someone-else")))
+	// Expired as well as foreign, which is reported as malformed
+	expiredForeign := mint(t, NewMinter("test-secret", time.Minute, WithClock(testutil.NewFakeClock(clk.Now().Add(-time.Hour))),
+		WithAudience("robohub-console")))
+	bare, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"iss": DefaultIssuer, "aud": DefaultAudience}).SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	alg, err := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{"iss": DefaultIssuer, "aud": DefaultAudience}).SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	revoked := mint(t, minter)
+	parsed, err := minter.Validate(revoked)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	revokedJTIs[parsed.JTI] = true
+
+	tests := []struct {
+		name        string
+		tokenString string
+		want        error
+	}{
+		{"expired", expired, ErrTokenExpired},
+		{"signed with another secret", otherSecret, ErrTokenSignature},
+		{"another algorithm", alg, ErrTokenSignature},
+		{"not a JWT", "not-a-token", ErrTokenMalformed},
+		{"garbled payload", "eyJhbGciOiJIUzI1NiJ9.bm90IGpzb24.c2ln", ErrTokenMalformed},
+		{"foreign issuer", foreignIssuer, ErrTokenMalformed},
+		{"expired foreign audience", expiredForeign, ErrTokenMalformed},
+		{"missing claims", bare, ErrTokenMalformed},
+		{"revoked", revoked, ErrTokenRevoked},
+	}
+
+	sentinels := []error{ErrTokenExpired, ErrTokenSignature, ErrTokenMalformed, ErrTokenRevoked}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := minter.Validate(tt.tokenString)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+			for _, sentinel := range sentinels {
+				if sentinel != tt.want && errors.Is(err, sentinel) {
+					t.Errorf("expected only %v, got %v as well", tt.want, sentinel)
+				}
+			}
+		})
+	}
+
+	if _, err := minter.Validate(mint(t, minter)); err != nil {
+		t.Errorf("expected other tokens to stay valid, got %v", err)
+	}
+
+	t.Run("reasons", func(t *testing.T) {
+		for _, sentinel := range sentinels {
+			reason := Reason(fmt.Errorf("wrapped: %w", sentinel))
+			if reason == "" || ReasonError(reason) != sentinel {
+				t.Errorf("expected %v to round-trip through its reason, got %q", sentinel, reason)
+			}
+		}
+		if reason := Reason(errors.New("other")); reason != "" {
+			t.Errorf("expected no reason for other errors, got %q", reason)
+		}
+		if err := ReasonError("invalid_token"); err != nil {
+			t.Errorf("expected no error for an unknown reason, got %v", err)
+		}
+	})
+}
+
+func TestMinter_IssuerAndAudience(t *testing.T) {
+	claims := &types.VerifiedClaims{Repository: "owner/repo", Ref: "refs/heads/main"}
+	staging := NewMinter("shared-secret", 10*time.Minute, WithIssuer("robohub-auth-staging"), WithAudience("robohub-api-staging"))
+	prod := NewMinter("shared-secret", 10*time.Minute)
+
+	stagingToken, _, err := staging.Mint(claims)
+	if err != nil {
+		t.Fatalf("failed to mint token: %v", err)
+	}
+	parsed, err := staging.Validate(stagingToken)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parsed.Issuer != "robohub-auth-staging" || parsed.Audience != "robohub-api-staging" {
+		t.Errorf("expected the staging issuer and audience, got %s, %s", parsed.Issuer, parsed.Audience)
+	}
+
+	prodToken, _, err := prod.Mint(claims)
+	if err != nil {
+		t.Fatalf("failed to mint token: %v", err)
+	}
+	parsed, err = prod.Validate(prodToken)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parsed.Issuer != DefaultIssuer || parsed.Audience != DefaultAudience {
+		t.Errorf("expected the default issuer and audience, got %s, %s", parsed.Issuer, parsed.Audience)
+	}
+
+	// Tokens do not cross environments even with the same secret
+	if _, err := prod.Validate(stagingToken); err == nil {
+		t.Error("expected the production minter to reject a staging token")
+	}
+	if _, err := staging.Validate(prodToken); err == nil {
+		t.Error("expected the staging minter to reject a production token")
+	}
+	otherAudience := NewMinter("shared-secret", 10*time.Minute, WithAudience("robohub-console"))
+	if _, err := otherAudience.Validate(prodToken); err == nil || !strings.Contains(err.Error(), "aud") {
+		t.Errorf("expected an audience error, got %v", err)
+	}
+}
+
+// remoteSigner hides the private key behind crypto.Signer, as a KMS does
+type remoteSigner struct {
+	signer crypto.Signer
+	err    error
+}
+
+func (s remoteSigner) Public() crypto.PublicKey { return s.signer.Public() }
+
+func (s remoteSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.signer.Sign(rand, digest, opts)
+}
+
+func TestMinter_SigningKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate EC key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %v", err)
+	}
+	keys := []struct {
+		name string
+		alg  string
+		key  crypto.Signer
+	}{
+		{"RS256", "RS256", rsaKey},
+		{"ES256", "ES256", ecKey},
+		{"EdDSA", "EdDSA", edKey},
+		{"RS256 remote key", "RS256", remoteSigner{signer: rsaKey}},
+		{"ES256 remote key", "ES256", remoteSigner{signer: ecKey}},
+		{"EdDSA remote key", "EdDSA", remoteSigner{signer: edKey}},
+	}
+
+	for i, tt := range keys {
+		otherKey := keys[(i+1)%3].key
+		t.Run(tt.name, func(t *testing.T) {
+			minter := NewMinter("", 10*time.Minute, WithSigningKey(tt.key, ""))
+			tokenString, _, err := minter.Mint(&types.VerifiedClaims{Repository: "owner/repo", Ref: "refs/heads/main"})
+			if err != nil {
+				t.Fatalf("failed to mint token: %v", err)
+			}
+
+			kid := KeyID(tt.key.Public())
+			parsed, _, err := jwt.NewParser().ParseUnverified(tokenString, jwt.MapClaims{})
+			if err != nil {
+				t.Fatalf("failed to parse token: %v", err)
+			}
+			if kid == "" || parsed.Header["alg"] != tt.alg || parsed.Header["kid"] != kid {
+				t.Errorf("expected %s with kid %s, got %v", tt.alg, kid, parsed.Header)
+			}
+
+			// Resource servers only hold the public key
+			parsed, err = jwt.Parse(tokenString, func(*jwt.Token) (interface{}, error) {
+				return tt.key.Public(), nil
+			}, jwt.WithValidMethods([]string{tt.alg}))
+			if err != nil {
+				t.Fatalf("failed to validate with the public key: %v", err)
+			}
+			if claims := ParseClaims(parsed.Claims.(jwt.MapClaims)); claims.Repo != "owner/repo" {
+				t.Errorf("expected repo owner/repo, got %s", claims.Repo)
+			}
+			if _, err := minter.Validate(tokenString); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			if _, err := NewMinter("", 10*time.Minute, WithSigningKey(otherKey, kid)).Validate(tokenString); err == nil {
+				t.Error("expected error for a token signed with another key")
+			}
+			if _, err := NewMinter("", 10*time.Minute, WithSigningKey(tt.key, "other")).Validate(tokenString); err == nil {
+				t.Error("expected error for a token naming another kid")
+			}
+		})
+	}
+
+	t.Run("remote signing error", func(t *testing.T) {
+		minter := NewMinter("", 10*time.Minute, WithSigningKey(remoteSigner{signer: ecKey, err: errors.New("kms unavailable")}, ""))
+		if _, _, err := minter.Mint(&types.VerifiedClaims{Repository: "owner/repo"}); err == nil || !strings.Contains(err.Error(), "kms unavailable") {
+			t.Errorf("expected the signing error, got %v", err)
+		}
+	})
+
+	t.Run("HS256 token", func(t *testing.T) {
+		hmacToken, _, err := NewMinter("test-secret", 10*time.Minute).Mint(&types.VerifiedClaims{Repository: "owner/repo"})
+		if err != nil {
+			t.Fatalf("failed to mint token: %v", err)
+		}
+		for _, tt := range keys {
+			if _, err := NewMinter("", 10*time.Minute, WithSigningKey(tt.key, "")).Validate(hmacToken); err == nil {
+				t.Errorf("expected an %s minter to reject HS256 tokens", tt.alg)
+			}
+		}
+	})
+
+	t.Run("explicit kid", func(t *testing.T) {
+		tokenString, _, err := NewMinter("", 10*time.Minute, WithSigningKey(rsaKey, "robohub-2026-10")).Mint(&types.VerifiedClaims{Repository: "owner/repo"})
+		if err != nil {
+			t.Fatalf("failed to mint token: %v", err)
+		}
+		parsed, _, _ := jwt.NewParser().ParseUnverified(tokenString, jwt.MapClaims{})
+		if parsed.Header["kid"] != "robohub-2026-10" {
+			t.Errorf("expected kid robohub-2026-10, got %v", parsed.Header["kid"])
+		}
+	})
+
+	t.Run("unsupported key", func(t *testing.T) {
+		p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate EC key: %v", err)
+		}
+		if _, _, err := NewMinter("", 10*time.Minute, WithSigningKey(p384Key, "")).Mint(&types.VerifiedClaims{Repository: "owner/repo"}); err == nil {
+			t.Error("expected error minting with a P-384 key")
+		}
+	})
 }
 
 func TestMinter_TTL(t *testing.T) {
@@ -161,4 +684,140 @@ func TestMinter_TTL(t *testing.T) {
 	if exp.Before(expectedExp) || exp.After(after.Add(ttl)) {
 		t.Errorf("expiration time out of expected range: got %v, expected around %v", exp, expectedExp)
 	}
+
+	t.Run("fake clock", func(t *testing.T) {
+		now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+		clockedMinter := NewMinter("test-secret", ttl, WithClock(testutil.NewFakeClock(now)))
+
+		_, exp, err := clockedMinter.Mint(claims)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !exp.Equal(now.Add(ttl)) {
+			t.Errorf("expected expiration %v, got %v", now.Add(ttl), exp)
+		}
+	})
+}
+
+func TestMinter_MintOptions(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	minter := NewMinter("test-secret", 10*time.Minute, WithClock(testutil.NewFakeClock(now)))
+	claims := &types.VerifiedClaims{
+		Repository: "owner/repo",
+		Ref:        "refs/heads/main",
+		Actor:      "testuser",
+		RunID:      "123456789",
+	}
+
+	tokenString, exp, err := minter.Mint(claims, WithTTL(time.Minute), WithScopes("ingest:build", "deploy:robot"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !exp.Equal(now.Add(time.Minute)) {
+		t.Errorf("expected expiration %v, got %v", now.Add(time.Minute), exp)
+	}
+
+	parsed, err := minter.Validate(tokenString)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(parsed.Scopes) != 2 || parsed.Scopes[1] != "deploy:robot" {
+		t.Errorf("unexpected scopes: %v", parsed.Scopes)
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		tokenString, exp, err := minter.Mint(claims)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !exp.Equal(now.Add(10 * time.Minute)) {
+			t.Errorf("expected expiration %v, got %v", now.Add(10*time.Minute), exp)
+		}
+		parsed, err := minter.Validate(tokenString)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(parsed.Scopes) != 1 || parsed.Scopes[0] != "ingest:build" {
+			t.Errorf("unexpected scopes: %v", parsed.Scopes)
+		}
+	})
+
+	t.Run("extra claims", func(t *testing.T) {
+		tokenString, _, err := minter.Mint(claims, WithExtraClaims(map[string]interface{}{"build_target": "robot-arm"}),
+			WithExtraClaims(map[string]interface{}{"fleet": "lab"}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		parsed, _, err := jwt.NewParser().ParseUnverified(tokenString, jwt.MapClaims{})
+		if err != nil {
+			t.Fatalf("failed to parse token: %v", err)
+		}
+		mapClaims := parsed.Claims.(jwt.MapClaims)
+		if mapClaims["build_target"] != "robot-arm" || mapClaims["fleet"] != "lab" {
+			t.Errorf("expected the extra claims, got %v", mapClaims)
+		}
+
+		for _, name := range []string{"iss", "scopes", "exp", "policy_version"} {
+			_, _, err := minter.Mint(claims, WithExtraClaims(map[string]interface{}{name: "forged"}))
+			if err == nil || !strings.Contains(err.Error(), "set by the minter") {
+				t.Errorf("expected extra claim %s to be rejected, got %v", name, err)
+			}
+		}
+	})
+
+	t.Run("precedence", func(t *testing.T) {
+		minter := NewMinter("test-secret", 10*time.Minute, WithClock(testutil.NewFakeClock(now)), WithDefaultScopes("ingest:docs"))
+		tests := []struct {
+			name       string
+			opts       []MintOption
+			wantTTL    time.Duration
+			wantScopes []string
+		}{
+			{"minter defaults", nil, 10 * time.Minute, []string{"ingest:docs"}},
+			{"request TTL", []MintOption{WithTTL(2 * time.Minute)}, 2 * time.Minute, []string{"ingest:docs"}},
+			{"request scopes", []MintOption{WithScopes("artifact:sign")}, 10 * time.Minute, []string{"artifact:sign"}},
+			{"last option wins", []MintOption{WithTTL(2 * time.Minute), WithTTL(time.Minute)}, time.Minute, []string{"ingest:docs"}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tokenString, exp, err := minter.Mint(claims, tt.opts...)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				parsed, err := minter.Validate(tokenString)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !exp.Equal(now.Add(tt.wantTTL)) || !reflect.DeepEqual(parsed.Scopes, tt.wantScopes) {
+					t.Errorf("expected %v and %v, got %v and %v", tt.wantTTL, tt.wantScopes, exp.Sub(now), parsed.Scopes)
+				}
+			})
+		}
+	})
+
+	t.Run("default scopes", func(t *testing.T) {
+		minter := NewMinter("test-secret", 10*time.Minute, WithDefaultScopes("ingest:docs", "ingest:telemetry"))
+		if got := minter.DefaultScopes(); !reflect.DeepEqual(got, []string{"ingest:docs", "ingest:telemetry"}) {
+			t.Errorf("unexpected default scopes: %v", got)
+		}
+		for _, tt := range []struct {
+			opts []MintOption
+			want []string
+		}{
+			{nil, []string{"ingest:docs", "ingest:telemetry"}},
+			{[]MintOption{WithScopes("deploy:robot")}, []string{"deploy:robot"}},
+		} {
+			tokenString, _, err := minter.Mint(claims, tt.opts...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			parsed, err := minter.Validate(tokenString)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(parsed.Scopes, tt.want) {
+				t.Errorf("expected scopes %v, got %v", tt.want, parsed.Scopes)
+			}
+		}
+	})
 }
@@ -5,6 +5,17 @@ import "time"
 // AuthRequest represents the incoming OIDC token exchange request
 type AuthRequest struct {
 	OIDCToken string `json:"oidc_token"`
+
+	// Provider selects the verifier for the token's issuer; empty means github
+	Provider string `json:"provider,omitempty"`
+
+	// Scopes narrows the token to some of the scopes the policy allows;
+	// empty means all of them
+	Scopes []string `json:"scopes,omitempty"`
+
+	// TTLSeconds shortens the token's lifetime below what the policy
+	// allows; nil keeps it
+	TTLSeconds *int `json:"ttl_seconds,omitempty"`
 }
 
 // AuthResponse represents the successful token exchange response
@@ -14,62 +25,215 @@ type AuthResponse struct {
 	TokenType   string         `json:"token_type"`
 	IssuedAt    string         `json:"issued_at"`
 	Subject     SubjectDetails `json:"subject"`
+
+	// Scope is the token's scopes, space-delimited as in OAuth 2.0
+	Scope string `json:"scope"`
+
+	// Warnings tell the caller about problems that did not block the
+	// exchange, such as a denial in policy dry-run mode
+	Warnings []string `json:"warnings,omitempty"`
+
+	// ScopeReduction is set when the token was issued with reduced scopes
+	// instead of being denied, as for a feature branch
+	ScopeReduction *ScopeReduction `json:"scope_reduction,omitempty"`
+}
+
+// ScopeReduction names the scopes a token was issued with after a policy
+// denial was downgraded to reduced scopes, the scopes it was denied, and the
+// denial
+type ScopeReduction struct {
+	Scopes   []string `json:"scopes"`
+	Withheld []string `json:"withheld_scopes,omitempty"`
+	Reason   string   `json:"reason"`
+	RuleID   string   `json:"rule_id"`
+	Message  string   `json:"message"`
 }
 
 // SubjectDetails contains the GitHub Actions context
 type SubjectDetails struct {
-	Provider   string `json:"provider"`
-	Repository string `json:"repository"`
-	Ref        string `json:"ref"`
-	Workflow   string `json:"workflow"`
-	RunID      string `json:"run_id"`
-	Actor      string `json:"actor"`
+	Provider       string `json:"provider"`
+	Repository     string `json:"repository"`
+	Ref            string `json:"ref"`
+	RefType        string `json:"ref_type,omitempty"`
+	BaseRef        string `json:"base_ref,omitempty"`
+	HeadRef        string `json:"head_ref,omitempty"`
+	Workflow       string `json:"workflow"`
+	WorkflowRef    string `json:"workflow_ref,omitempty"`
+	JobWorkflowRef string `json:"job_workflow_ref,omitempty"`
+	RunID          string `json:"run_id"`
+	Actor          string `json:"actor"`
+	Environment    string `json:"environment,omitempty"`
+	EventName      string `json:"event_name,omitempty"`
+	SHA            string `json:"sha,omitempty"`
 }
 
 // ErrorResponse represents an error response
 type ErrorResponse struct {
-	Error   string `json:"error"`
+	Error   string        `json:"error"`
+	Message string        `json:"message,omitempty"`
+	Details *ErrorDetails `json:"details,omitempty"`
+}
+
+// ErrorDetails identifies the policy rule behind a policy_violation with
+// stable codes
+type ErrorDetails struct {
+	Reason string `json:"reason"`
+	RuleID string `json:"rule_id"`
+}
+
+// PolicyCheckRequest describes a hypothetical token for the policy check
+// admin endpoint. Fields mirror the verified OIDC claims.
+type PolicyCheckRequest struct {
+	Provider          string                 `json:"provider,omitempty"`
+	Repository        string                 `json:"repository"`
+	Owner             string                 `json:"repository_owner,omitempty"`
+	RepositoryID      string                 `json:"repository_id,omitempty"`
+	Visibility        string                 `json:"repository_visibility,omitempty"`
+	Enterprise        string                 `json:"enterprise,omitempty"`
+	Ref               string                 `json:"ref"`
+	RefType           string                 `json:"ref_type,omitempty"`
+	BaseRef           string                 `json:"base_ref,omitempty"`
+	HeadRef           string                 `json:"head_ref,omitempty"`
+	SHA               string                 `json:"sha,omitempty"`
+	Actor             string                 `json:"actor,omitempty"`
+	EventName         string                 `json:"event_name,omitempty"`
+	Environment       string                 `json:"environment,omitempty"`
+	RunnerEnvironment string                 `json:"runner_environment,omitempty"`
+	WorkflowRef       string                 `json:"workflow_ref,omitempty"`
+	JobWorkflowRef    string                 `json:"job_workflow_ref,omitempty"`
+	Claims            map[string]interface{} `json:"claims,omitempty"`
+}
+
+// PolicyCheckResponse is the policy decision for a PolicyCheckRequest
+type PolicyCheckResponse struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason"`
+	RuleID  string `json:"rule_id,omitempty"`
 	Message string `json:"message,omitempty"`
+
+	// Scopes and TTLSeconds are what an allowed token would be granted;
+	// a zero TTL means the default token TTL
+	Scopes     []string `json:"scopes,omitempty"`
+	TTLSeconds int      `json:"ttl_seconds,omitempty"`
+
+	// ScopeReduction is set when the token would be issued with reduced
+	// scopes instead of being denied
+	ScopeReduction *ScopeReduction `json:"scope_reduction,omitempty"`
+
+	// PolicyHash identifies the policy file the decision was made with
+	PolicyHash string `json:"policy_hash,omitempty"`
+
+	// PolicyVersion identifies the policy the decision was made with: the
+	// policy file's version or hash, else that of the environment settings
+	PolicyVersion string `json:"policy_version,omitempty"`
+}
+
+// KeyRotationResponse reports the signing keys after a rotation
+type KeyRotationResponse struct {
+	// Rotated is false when the key read was already the signing key
+	Rotated bool             `json:"rotated"`
+	KeyID   string           `json:"kid"`
+	Keys    []SigningKeyInfo `json:"keys"`
+}
+
+// SigningKeyInfo describes a key RoboHub access tokens are validated with
+type SigningKeyInfo struct {
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Active    bool   `json:"active"`
+
+	// RetiresAt is when a previous key stops validating tokens
+	RetiresAt *time.Time `json:"retires_at,omitempty"`
 }
 
 // GitHubOIDCClaims represents the claims extracted from a GitHub Actions OIDC token
 type GitHubOIDCClaims struct {
-	Issuer         string `json:"iss"`
-	Subject        string `json:"sub"`
-	Audience       string `json:"aud"`
-	ExpiresAt      int64  `json:"exp"`
-	NotBefore      int64  `json:"nbf"`
-	IssuedAt       int64  `json:"iat"`
-	Repository     string `json:"repository"`
-	Ref            string `json:"ref"`
-	Actor          string `json:"actor"`
-	RunID          string `json:"run_id"`
-	WorkflowRef    string `json:"workflow_ref"`
-	JobWorkflowRef string `json:"job_workflow_ref"`
+	Issuer          string `json:"iss"`
+	Subject         string `json:"sub"`
+	Audience        string `json:"aud"`
+	ExpiresAt       int64  `json:"exp"`
+	NotBefore       int64  `json:"nbf"`
+	IssuedAt        int64  `json:"iat"`
+	Repository      string `json:"repository"`
+	Ref             string `json:"ref"`
+	RefType         string `json:"ref_type"`
+	BaseRef         string `json:"base_ref"`
+	HeadRef         string `json:"head_ref"`
+	Actor           string `json:"actor"`
+	RunID           string `json:"run_id"`
+	RunAttempt      string `json:"run_attempt"`
+	WorkflowRef     string `json:"workflow_ref"`
+	JobWorkflowRef  string `json:"job_workflow_ref"`
+	Environment     string `json:"environment"`
+	EventName       string `json:"event_name"`
+	RepositoryOwner string `json:"repository_owner"`
+	RepositoryID    string `json:"repository_id"`
+	SHA             string `json:"sha"`
+	ActorID         string `json:"actor_id"`
+	Visibility      string `json:"repository_visibility"`
+	Enterprise      string `json:"enterprise"`
 }
 
 // RoboHubClaims represents the claims in a RoboHub access token
 type RoboHubClaims struct {
-	Issuer    string   `json:"iss"`
-	Subject   string   `json:"sub"`
-	Audience  string   `json:"aud"`
-	IssuedAt  int64    `json:"iat"`
-	ExpiresAt int64    `json:"exp"`
-	JTI       string   `json:"jti"`
-	Repo      string   `json:"repo"`
-	Ref       string   `json:"ref"`
-	Actor     string   `json:"actor"`
-	RunID     string   `json:"run_id"`
-	Scopes    []string `json:"scopes"`
+	Issuer       string   `json:"iss"`
+	Subject      string   `json:"sub"`
+	Audience     string   `json:"aud"`
+	IssuedAt     int64    `json:"iat"`
+	ExpiresAt    int64    `json:"exp"`
+	JTI          string   `json:"jti"`
+	Repo         string   `json:"repo"`
+	Ref          string   `json:"ref"`
+	Actor        string   `json:"actor"`
+	ActorID      string   `json:"actor_id,omitempty"`
+	RunID        string   `json:"run_id"`
+	RunAttempt   string   `json:"run_attempt,omitempty"`
+	Owner        string   `json:"repository_owner,omitempty"`
+	RepositoryID string   `json:"repository_id,omitempty"`
+	Environment  string   `json:"environment,omitempty"`
+	SHA          string   `json:"sha,omitempty"`
+	Workflow     string   `json:"workflow,omitempty"`
+	EventName    string   `json:"event_name,omitempty"`
+	Scopes       []string `json:"scopes"`
+
+	// PolicyVersion identifies the policy that allowed the token
+	PolicyVersion string `json:"policy_version,omitempty"`
 }
 
 // VerifiedClaims represents verified OIDC claims
 type VerifiedClaims struct {
-	Repository string
-	Ref        string
-	Actor      string
-	RunID      string
-	Workflow   string
-	IssuedAt   time.Time
-	ExpiresAt  time.Time
+	Provider          string
+	Issuer            string
+	Audience          string
+	Repository        string
+	Owner             string
+	RepositoryID      string
+	Visibility        string
+	Enterprise        string
+	Ref               string
+	RefType           string
+	BaseRef           string
+	HeadRef           string
+	SHA               string
+	Actor             string
+	ActorID           string
+	RunID             string
+	RunAttempt        string
+	Workflow          string
+	WorkflowRef       string
+	JobWorkflowRef    string
+	ReusableWorkflow  bool
+	RunnerEnvironment string
+	Environment       string
+	EventName         string
+	IssuedAt          time.Time
+	ExpiresAt         time.Time
+
+	// SubjectTemplate is the sub claim format the token matched, such as
+	// repo:context for GitHub's default
+	SubjectTemplate string
+
+	// Raw is the full validated claim set, for policy and auditing. It is
+	// never copied into minted tokens wholesale.
+	Raw map[string]interface{}
 }
@@ -0,0 +1,35 @@
+package middleware_test
+
+import (
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/robohub/auth-service/pkg/middleware"
+)
+
+// Example shows a downstream service protecting its routes with RoboHub
+// access tokens: every route needs a valid token and the upload route also
+// needs the ingest:build scope.
+func Example() {
+	validator := middleware.NewHMACValidator(os.Getenv("ROBOHUB_JWT_SECRET"))
+	requireToken := middleware.RequireToken(validator, middleware.Options{
+		Logger: slog.Default(),
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := middleware.ClaimsFromContext(r.Context())
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"repository": claims.Repo,
+			"run_id":     claims.RunID,
+		})
+	})
+	mux.Handle("/builds", middleware.RequireScopes("ingest:build")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})))
+
+	log.Fatal(http.ListenAndServe(":8081", requireToken(mux)))
+}
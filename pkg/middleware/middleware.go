@@ -0,0 +1,166 @@
+// Package middleware provides HTTP middleware for services that accept
+// RoboHub access tokens. It extracts the Bearer token, validates it with a
+// pluggable Validator, enforces required scopes and exposes the parsed claims
+// through the request context.
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/robohub/auth-service/internal/token"
+	"github.com/robohub/auth-service/internal/types"
+)
+
+// Claims are the claims carried by a validated RoboHub access token
+type Claims = types.RoboHubClaims
+
+// Options configures RequireToken
+type Options struct {
+	// Scopes lists the scopes a token must carry; all of them are required
+	Scopes []string
+
+	// Logger receives validation failures. Nil disables logging.
+	Logger *slog.Logger
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying claims. It is useful for
+// testing handlers that sit behind RequireToken.
+func NewContext(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, claims)
+}
+
+// ClaimsFromContext returns the claims injected by RequireToken
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(*Claims)
+	return claims, ok && claims != nil
+}
+
+// RequireToken returns middleware that rejects requests without a valid
+// RoboHub access token carrying opts.Scopes. Missing or invalid tokens get a
+// 401 and tokens lacking a scope get a 403, both in the standard error shape.
+// The 401 for an invalid token names the failed check in its details, and
+// tells an expired token apart in the WWW-Authenticate header too, so that
+// clients know to exchange a new one.
+func RequireToken(validator Validator, opts Options) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			tokenString, ok := BearerToken(r)
+			if !ok {
+				respondUnauthorized(w, "invalid_request", "missing bearer token")
+				return
+			}
+
+			claims, err := validator.Validate(ctx, tokenString)
+			if err != nil {
+				reason := token.Reason(err)
+				if opts.Logger != nil {
+					opts.Logger.WarnContext(ctx, "rejected access token", "reason", reason, "error", err)
+				}
+				respondInvalidToken(w, reason)
+				return
+			}
+
+			if missing := missingScope(claims.Scopes, opts.Scopes); missing != "" {
+				if opts.Logger != nil {
+					opts.Logger.WarnContext(ctx, "access token lacks required scope",
+						"repository", claims.Repo,
+						"scope", missing,
+					)
+				}
+				respondError(w, http.StatusForbidden, "insufficient_scope", "access token is missing required scope "+missing)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(NewContext(ctx, claims)))
+		})
+	}
+}
+
+// RequireScopes returns middleware enforcing additional scopes on routes
+// already behind RequireToken. Requests without claims in the context get a
+// 401.
+func RequireScopes(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				respondUnauthorized(w, "invalid_request", "missing bearer token")
+				return
+			}
+
+			if missing := missingScope(claims.Scopes, scopes); missing != "" {
+				respondError(w, http.StatusForbidden, "insufficient_scope", "access token is missing required scope "+missing)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// BearerToken extracts the token from an "Authorization: Bearer" header
+func BearerToken(r *http.Request) (string, bool) {
+	scheme, tokenString, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || tokenString == "" {
+		return "", false
+	}
+	return tokenString, true
+}
+
+// missingScope returns the first required scope not present in granted
+func missingScope(granted, required []string) string {
+	have := make(map[string]bool, len(granted))
+	for _, scope := range granted {
+		have[scope] = true
+	}
+	for _, scope := range required {
+		if !have[scope] {
+			return scope
+		}
+	}
+	return ""
+}
+
+func respondUnauthorized(w http.ResponseWriter, errorCode, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="`+errorCode+`"`)
+	respondError(w, http.StatusUnauthorized, errorCode, message)
+}
+
+// respondInvalidToken rejects a token that failed validation for reason, a
+// code of token.Reason or "" when unknown
+func respondInvalidToken(w http.ResponseWriter, reason string) {
+	challenge := `Bearer error="invalid_token"`
+	message := "access token is invalid"
+	if reason == "token_expired" {
+		challenge += `, error_description="The access token expired"`
+		message = "access token has expired"
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+
+	resp := types.ErrorResponse{Error: "invalid_token", Message: message}
+	if reason != "" {
+		resp.Details = &types.ErrorDetails{Reason: reason}
+	}
+	writeJSON(w, http.StatusUnauthorized, resp)
+}
+
+func respondError(w http.ResponseWriter, status int, errorCode, message string) {
+	writeJSON(w, status, types.ErrorResponse{
+		Error:   errorCode,
+		Message: message,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, resp types.ErrorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
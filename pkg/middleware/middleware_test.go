@@ -0,0 +1,206 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/robohub/auth-service/internal/testutil"
+	"github.com/robohub/auth-service/internal/token"
+	"github.com/robohub/auth-service/internal/types"
+)
+
+func mintTestToken(t *testing.T, secret string) string {
+	t.Helper()
+
+	minter := token.NewMinter(secret, 10*time.Minute)
+	tokenString, _, err := minter.Mint(&types.VerifiedClaims{
+		Repository: "owner/repo",
+		Ref:        "refs/heads/main",
+		Actor:      "testuser",
+		RunID:      "123456789",
+	})
+	if err != nil {
+		t.Fatalf("failed to mint token: %v", err)
+	}
+	return tokenString
+}
+
+func TestRequireToken(t *testing.T) {
+	validator := NewHMACValidator("test-secret")
+	validToken := mintTestToken(t, "test-secret")
+
+	tests := []struct {
+		name       string
+		header     string
+		scopes     []string
+		wantStatus int
+		wantError  string
+	}{
+		{"valid token", "Bearer " + validToken, nil, http.StatusOK, ""},
+		{"valid token with granted scope", "Bearer " + validToken, []string{"ingest:build"}, http.StatusOK, ""},
+		{"lowercase scheme", "bearer " + validToken, nil, http.StatusOK, ""},
+		{"missing header", "", nil, http.StatusUnauthorized, "invalid_request"},
+		{"wrong scheme", "Basic " + validToken, nil, http.StatusUnauthorized, "invalid_request"},
+		{"empty token", "Bearer ", nil, http.StatusUnauthorized, "invalid_request"},
+		{"garbage token", "Bearer not.a.token", nil, http.StatusUnauthorized, "invalid_token"},
+		{"foreign secret", "Bearer " + mintTestToken(t, "other-secret"), nil, http.StatusUnauthorized, "invalid_token"},
+		{"missing scope", "Bearer " + validToken, []string{"ingest:build", "artifact:sign"}, http.StatusForbidden, "insufficient_scope"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotClaims *Claims
+			handler := RequireToken(validator, Options{Scopes: tt.scopes})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotClaims, _ = ClaimsFromContext(r.Context())
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+
+			if tt.wantError == "" {
+				if gotClaims == nil || gotClaims.Repo != "owner/repo" {
+					t.Errorf("expected claims for owner/repo in context, got %+v", gotClaims)
+				}
+				return
+			}
+
+			var errResp types.ErrorResponse
+			if err := json.NewDecoder(w.Body).Decode(&errResp); err != nil {
+				t.Fatalf("failed to decode error response: %v", err)
+			}
+			if errResp.Error != tt.wantError {
+				t.Errorf("expected error %q, got %q", tt.wantError, errResp.Error)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected WWW-Authenticate header on 401")
+			}
+		})
+	}
+}
+
+func TestRequireScopes(t *testing.T) {
+	validToken := mintTestToken(t, "test-secret")
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	t.Run("per-route scopes", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mux.Handle("/build", RequireScopes("ingest:build")(ok))
+		mux.Handle("/sign", RequireScopes("artifact:sign")(ok))
+		handler := RequireToken(NewHMACValidator("test-secret"), Options{})(mux)
+
+		for path, want := range map[string]int{"/build": http.StatusOK, "/sign": http.StatusForbidden} {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.Header.Set("Authorization", "Bearer "+validToken)
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != want {
+				t.Errorf("%s: expected status %d, got %d", path, want, w.Code)
+			}
+		}
+	})
+
+	t.Run("without RequireToken", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		RequireScopes("ingest:build")(ok).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("expected status 401, got %d", w.Code)
+		}
+	})
+}
+
+func TestRequireToken_Reasons(t *testing.T) {
+	expiredMinter := token.NewMinter("test-secret", time.Minute, token.WithClock(testutil.NewFakeClock(time.Now().Add(-time.Hour))))
+	expired, _, err := expiredMinter.Mint(&types.VerifiedClaims{Repository: "owner/repo", Ref: "refs/heads/main"})
+	if err != nil {
+		t.Fatalf("failed to mint token: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		token         string
+		wantReason    string
+		wantChallenge string
+	}{
+		{"expired", expired, "token_expired", `Bearer error="invalid_token", error_description="The access token expired"`},
+		{"forged", mintTestToken(t, "other-secret"), "invalid_signature", `Bearer error="invalid_token"`},
+		{"garbage", "not.a.token", "malformed_token", `Bearer error="invalid_token"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := RequireToken(NewHMACValidator("test-secret"), Options{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				t.Error("handler should not be called")
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != http.StatusUnauthorized {
+				t.Fatalf("expected status 401, got %d", w.Code)
+			}
+			if got := w.Header().Get("WWW-Authenticate"); got != tt.wantChallenge {
+				t.Errorf("expected challenge %s, got %s", tt.wantChallenge, got)
+			}
+			var errResp types.ErrorResponse
+			if err := json.NewDecoder(w.Body).Decode(&errResp); err != nil {
+				t.Fatalf("failed to decode error response: %v", err)
+			}
+			if errResp.Error != "invalid_token" || errResp.Details == nil || errResp.Details.Reason != tt.wantReason {
+				t.Errorf("expected invalid_token with reason %s, got %+v", tt.wantReason, errResp)
+			}
+		})
+	}
+}
+
+func TestRequireToken_ValidatorError(t *testing.T) {
+	validator := ValidatorFunc(func(ctx context.Context, token string) (*Claims, error) {
+		return nil, fmt.Errorf("backend unavailable")
+	})
+	handler := RequireToken(validator, Options{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler should not be called")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %d", w.Code)
+	}
+}
+
+func TestClaimsFromContext(t *testing.T) {
+	if _, ok := ClaimsFromContext(context.Background()); ok {
+		t.Error("expected no claims in empty context")
+	}
+
+	ctx := NewContext(context.Background(), &Claims{Repo: "owner/repo"})
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok || claims.Repo != "owner/repo" {
+		t.Errorf("expected claims for owner/repo, got %+v", claims)
+	}
+}
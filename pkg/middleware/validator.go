@@ -0,0 +1,196 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/robohub/auth-service/internal/oidc"
+	"github.com/robohub/auth-service/internal/token"
+	"github.com/robohub/auth-service/internal/types"
+)
+
+// Validator validates a RoboHub access token and returns its claims
+type Validator interface {
+	Validate(ctx context.Context, token string) (*Claims, error)
+}
+
+// ValidatorFunc adapts a function to the Validator interface
+type ValidatorFunc func(ctx context.Context, token string) (*Claims, error)
+
+// Validate implements the Validator interface
+func (f ValidatorFunc) Validate(ctx context.Context, token string) (*Claims, error) {
+	return f(ctx, token)
+}
+
+// validatorSettings are the iss and aud claims tokens must carry
+type validatorSettings struct {
+	issuer   string
+	audience string
+}
+
+// ValidatorOption configures the issuer and audience a validator accepts
+type ValidatorOption func(*validatorSettings)
+
+// WithIssuer sets the iss claim tokens must carry, the auth service's
+// ROBOHUB_TOKEN_ISSUER. The default is token.DefaultIssuer.
+func WithIssuer(issuer string) ValidatorOption {
+	return func(s *validatorSettings) {
+		s.issuer = issuer
+	}
+}
+
+// WithAudience sets the aud claim tokens must carry, the auth service's
+// ROBOHUB_TOKEN_AUDIENCE. The default is token.DefaultAudience.
+func WithAudience(audience string) ValidatorOption {
+	return func(s *validatorSettings) {
+		s.audience = audience
+	}
+}
+
+func newValidatorSettings(opts []ValidatorOption) validatorSettings {
+	settings := validatorSettings{issuer: token.DefaultIssuer, audience: token.DefaultAudience}
+	for _, opt := range opts {
+		opt(&settings)
+	}
+	return settings
+}
+
+// HMACValidator validates tokens signed with the shared RoboHub HMAC secret
+type HMACValidator struct {
+	minter *token.Minter
+}
+
+// NewHMACValidator creates a validator for HS256 tokens signed with secret
+func NewHMACValidator(secret string, opts ...ValidatorOption) *HMACValidator {
+	settings := newValidatorSettings(opts)
+	return &HMACValidator{minter: token.NewMinter(secret, 0,
+		token.WithIssuer(settings.issuer), token.WithAudience(settings.audience))}
+}
+
+// Validate implements the Validator interface
+func (v *HMACValidator) Validate(_ context.Context, tokenString string) (*Claims, error) {
+	return v.minter.Validate(tokenString)
+}
+
+// PublicKeyValidator validates tokens signed with an asymmetric key
+type PublicKeyValidator struct {
+	key      crypto.PublicKey
+	methods  []string
+	settings validatorSettings
+}
+
+// NewPublicKeyValidator creates a validator for tokens signed by the private
+// half of key. RSA, ECDSA and Ed25519 public keys are supported.
+func NewPublicKeyValidator(key crypto.PublicKey, opts ...ValidatorOption) (*PublicKeyValidator, error) {
+	methods, err := methodsForKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &PublicKeyValidator{key: key, methods: methods, settings: newValidatorSettings(opts)}, nil
+}
+
+// Validate implements the Validator interface
+func (v *PublicKeyValidator) Validate(_ context.Context, tokenString string) (*Claims, error) {
+	return token.ValidateToken(tokenString, func(*jwt.Token) (interface{}, error) {
+		return v.key, nil
+	}, v.methods, v.settings.issuer, v.settings.audience)
+}
+
+// JWKSValidator validates tokens against keys published at a JWKS URL,
+// selecting the key by the token's kid header
+type JWKSValidator struct {
+	cache    *oidc.JWKSCache
+	settings validatorSettings
+}
+
+// NewJWKSValidator creates a validator backed by the JWKS at url, caching
+// keys for ttl
+func NewJWKSValidator(url string, ttl time.Duration, opts ...ValidatorOption) *JWKSValidator {
+	return &JWKSValidator{cache: oidc.NewJWKSCache(url, ttl), settings: newValidatorSettings(opts)}
+}
+
+// Validate implements the Validator interface
+func (v *JWKSValidator) Validate(ctx context.Context, tokenString string) (*Claims, error) {
+	return token.ValidateToken(tokenString, func(t *jwt.Token) (interface{}, error) {
+		kid, ok := t.Header["kid"].(string)
+		if !ok {
+			return nil, fmt.Errorf("missing or invalid kid in token header")
+		}
+		return v.cache.GetKey(ctx, kid)
+	}, []string{"RS256", "RS384", "RS512", "ES256", "ES384", "EdDSA"}, v.settings.issuer, v.settings.audience)
+}
+
+// RemoteValidator validates tokens by calling the auth service's
+// /auth/validate endpoint
+type RemoteValidator struct {
+	endpoint   string
+	httpClient *http.Client
+}
+
+// NewRemoteValidator creates a validator that posts tokens to endpoint, e.g.
+// https://auth.example.com/auth/validate. A nil client uses a client with a
+// 10 second timeout.
+func NewRemoteValidator(endpoint string, client *http.Client) *RemoteValidator {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &RemoteValidator{endpoint: endpoint, httpClient: client}
+}
+
+// Validate implements the Validator interface
+func (v *RemoteValidator) Validate(ctx context.Context, tokenString string) (*Claims, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+tokenString)
+
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call validate endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		// The details name the failed check with the codes of token.Reason
+		var errResp types.ErrorResponse
+		_ = json.NewDecoder(resp.Body).Decode(&errResp)
+		if errResp.Details != nil {
+			if err := token.ReasonError(errResp.Details.Reason); err != nil {
+				return nil, fmt.Errorf("%w: token rejected by auth service: %s", err, errResp.Message)
+			}
+		}
+		return nil, fmt.Errorf("token rejected by auth service")
+	default:
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var claims Claims
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("failed to decode claims: %w", err)
+	}
+	return &claims, nil
+}
+
+func methodsForKey(key crypto.PublicKey) ([]string, error) {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return []string{"RS256", "RS384", "RS512"}, nil
+	case *ecdsa.PublicKey:
+		return []string{"ES256", "ES384", "ES512"}, nil
+	case ed25519.PublicKey:
+		return []string{"EdDSA"}, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+}
@@ -0,0 +1,318 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/robohub/auth-service/internal/token"
+	"github.com/robohub/auth-service/internal/types"
+)
+
+func signedClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":    "robohub-auth",
+		"sub":    "repo:owner/repo",
+		"aud":    "robohub-api",
+		"iat":    now.Unix(),
+		"exp":    now.Add(10 * time.Minute).Unix(),
+		"jti":    "test-jti",
+		"repo":   "owner/repo",
+		"scopes": []string{"ingest:build"},
+	}
+}
+
+func TestHMACValidator(t *testing.T) {
+	validator := NewHMACValidator("test-secret")
+
+	claims, err := validator.Validate(context.Background(), mintTestToken(t, "test-secret"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claims.Repo != "owner/repo" {
+		t.Errorf("expected repo owner/repo, got %s", claims.Repo)
+	}
+
+	if _, err := validator.Validate(context.Background(), mintTestToken(t, "wrong-secret")); err == nil {
+		t.Error("expected error for token signed with another secret")
+	}
+
+	t.Run("issuer and audience", func(t *testing.T) {
+		minter := token.NewMinter("test-secret", 10*time.Minute, token.WithIssuer("prod-robohub"), token.WithAudience("prod-api"))
+		tokenString, _, err := minter.Mint(&types.VerifiedClaims{Repository: "owner/repo", Ref: "refs/heads/main"})
+		if err != nil {
+			t.Fatalf("failed to mint token: %v", err)
+		}
+		if _, err := NewHMACValidator("test-secret", WithIssuer("prod-robohub"), WithAudience("prod-api")).Validate(context.Background(), tokenString); err != nil {
+			t.Errorf("expected the configured issuer and audience to be accepted, got %v", err)
+		}
+		if _, err := validator.Validate(context.Background(), tokenString); err == nil {
+			t.Error("expected the default issuer and audience to reject the token")
+		}
+	})
+}
+
+func TestPublicKeyValidator(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate EC key: %v", err)
+	}
+
+	rsaToken, _ := jwt.NewWithClaims(jwt.SigningMethodRS256, signedClaims()).SignedString(rsaKey)
+	ecToken, _ := jwt.NewWithClaims(jwt.SigningMethodES256, signedClaims()).SignedString(ecKey)
+
+	t.Run("rsa", func(t *testing.T) {
+		validator, err := NewPublicKeyValidator(&rsaKey.PublicKey)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		claims, err := validator.Validate(context.Background(), rsaToken)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(claims.Scopes) != 1 || claims.Scopes[0] != "ingest:build" {
+			t.Errorf("unexpected scopes: %v", claims.Scopes)
+		}
+		if _, err := validator.Validate(context.Background(), ecToken); err == nil {
+			t.Error("expected error for token signed with a different algorithm")
+		}
+	})
+
+	t.Run("robohub rs256 tokens", func(t *testing.T) {
+		minted, _, err := token.NewMinter("", time.Minute, token.WithSigningKey(rsaKey, "")).Mint(&types.VerifiedClaims{Repository: "owner/repo"})
+		if err != nil {
+			t.Fatalf("failed to mint token: %v", err)
+		}
+		validator, err := NewPublicKeyValidator(&rsaKey.PublicKey)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		claims, err := validator.Validate(context.Background(), minted)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if claims.Repo != "owner/repo" {
+			t.Errorf("expected repo owner/repo, got %s", claims.Repo)
+		}
+	})
+
+	t.Run("ecdsa", func(t *testing.T) {
+		validator, err := NewPublicKeyValidator(&ecKey.PublicKey)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := validator.Validate(context.Background(), ecToken); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("claims", func(t *testing.T) {
+		sign := func(t *testing.T, modify func(jwt.MapClaims)) string {
+			t.Helper()
+			claims := signedClaims()
+			modify(claims)
+			tokenString, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(ecKey)
+			if err != nil {
+				t.Fatalf("failed to sign token: %v", err)
+			}
+			return tokenString
+		}
+		validator, err := NewPublicKeyValidator(&ecKey.PublicKey)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		tests := []struct {
+			name          string
+			modify        func(jwt.MapClaims)
+			errorContains string
+		}{
+			{"foreign issuer", func(c jwt.MapClaims) {
+				c["iss"], c["aud"] = "someone-else", "other"
+				delete(c, "exp")
+			}, `issuer is "someone-else"`},
+			{"foreign audience", func(c jwt.MapClaims) { c["aud"] = "other" }, `audience is ["other"]`},
+			{"missing exp", func(c jwt.MapClaims) { delete(c, "exp") }, "exp must be a number"},
+			{"missing jti", func(c jwt.MapClaims) { delete(c, "jti") }, "jti must be a non-empty string"},
+			{"missing repo", func(c jwt.MapClaims) { delete(c, "repo") }, "repo must be a non-empty string"},
+			{"missing scopes", func(c jwt.MapClaims) { delete(c, "scopes") }, "scopes must be a list"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := validator.Validate(context.Background(), sign(t, tt.modify))
+				if !errors.Is(err, token.ErrTokenMalformed) || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("expected a malformed token error containing %q, got %v", tt.errorContains, err)
+				}
+			})
+		}
+
+		t.Run("configured issuer and audience", func(t *testing.T) {
+			validator, err := NewPublicKeyValidator(&ecKey.PublicKey, WithIssuer("someone-else"), WithAudience("other"))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			tokenString := sign(t, func(c jwt.MapClaims) { c["iss"], c["aud"] = "someone-else", "other" })
+			if _, err := validator.Validate(context.Background(), tokenString); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if _, err := validator.Validate(context.Background(), ecToken); err == nil {
+				t.Error("expected the default issuer to be rejected")
+			}
+		})
+	})
+
+	t.Run("unsupported key", func(t *testing.T) {
+		if _, err := NewPublicKeyValidator("not a key"); err == nil {
+			t.Error("expected error for unsupported key type")
+		}
+	})
+}
+
+func TestJWKSValidator(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "robohub-1",
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	defer server.Close()
+
+	validator := NewJWKSValidator(server.URL, time.Hour)
+
+	signed := jwt.NewWithClaims(jwt.SigningMethodRS256, signedClaims())
+	signed.Header["kid"] = "robohub-1"
+	tokenString, _ := signed.SignedString(key)
+
+	claims, err := validator.Validate(context.Background(), tokenString)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claims.Repo != "owner/repo" {
+		t.Errorf("expected repo owner/repo, got %s", claims.Repo)
+	}
+
+	signed.Header["kid"] = "unknown"
+	tokenString, _ = signed.SignedString(key)
+	if _, err := validator.Validate(context.Background(), tokenString); err == nil {
+		t.Error("expected error for unknown kid")
+	}
+
+	foreignClaims := signedClaims()
+	foreignClaims["iss"] = "someone-else"
+	foreign := jwt.NewWithClaims(jwt.SigningMethodRS256, foreignClaims)
+	foreign.Header["kid"] = "robohub-1"
+	tokenString, _ = foreign.SignedString(key)
+	if _, err := validator.Validate(context.Background(), tokenString); !errors.Is(err, token.ErrTokenMalformed) {
+		t.Errorf("expected a foreign issuer to be rejected, got %v", err)
+	}
+}
+
+func TestJWKSValidator_EdDSA(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	minter := token.NewMinter("", 10*time.Minute, token.WithSigningKey(key, ""))
+
+	// The auth service's own JWKS endpoint
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(minter.JWKS())
+	}))
+	defer server.Close()
+
+	tokenString, _, err := minter.Mint(&types.VerifiedClaims{Repository: "owner/repo", Ref: "refs/heads/main"})
+	if err != nil {
+		t.Fatalf("failed to mint token: %v", err)
+	}
+	claims, err := NewJWKSValidator(server.URL, time.Hour).Validate(context.Background(), tokenString)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claims.Repo != "owner/repo" {
+		t.Errorf("expected repo owner/repo, got %s", claims.Repo)
+	}
+}
+
+func TestRemoteValidator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/auth/validate" {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.Header.Get("Authorization") {
+		case "Bearer good":
+			_ = json.NewEncoder(w).Encode(types.RoboHubClaims{Repo: "owner/repo", Scopes: []string{"ingest:build"}})
+		case "Bearer broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "Bearer expired", "Bearer forged", "Bearer malformed", "Bearer revoked":
+			reasons := map[string]string{"expired": "token_expired", "forged": "invalid_signature", "malformed": "malformed_token", "revoked": "token_revoked"}
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(types.ErrorResponse{
+				Error:   "invalid_token",
+				Details: &types.ErrorDetails{Reason: reasons[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]},
+			})
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(types.ErrorResponse{Error: "invalid_token"})
+		}
+	}))
+	defer server.Close()
+
+	validator := NewRemoteValidator(server.URL+"/auth/validate", nil)
+
+	claims, err := validator.Validate(context.Background(), "good")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claims.Repo != "owner/repo" {
+		t.Errorf("expected repo owner/repo, got %s", claims.Repo)
+	}
+
+	if _, err := validator.Validate(context.Background(), "bad"); err == nil {
+		t.Error("expected error for rejected token")
+	}
+	if _, err := validator.Validate(context.Background(), "broken"); err == nil {
+		t.Error("expected error for server failure")
+	}
+
+	for tokenString, want := range map[string]error{
+		"expired":   token.ErrTokenExpired,
+		"forged":    token.ErrTokenSignature,
+		"malformed": token.ErrTokenMalformed,
+		"revoked":   token.ErrTokenRevoked,
+	} {
+		if _, err := validator.Validate(context.Background(), tokenString); !errors.Is(err, want) {
+			t.Errorf("%s: expected %v, got %v", tokenString, want, err)
+		}
+	}
+	if _, err := validator.Validate(context.Background(), "bad"); errors.Is(err, token.ErrTokenMalformed) || errors.Is(err, token.ErrTokenExpired) {
+		t.Errorf("expected a rejection without details to match no reason, got %v", err)
+	}
+}